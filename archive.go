@@ -0,0 +1,208 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// Check whether the file is a ZIP archive by its extension.
+func isZip(p string) bool {
+	return strings.EqualFold(filepath.Ext(p), ".zip")
+}
+
+// A ZIP archive with the local copies of its files being processed.  The
+// archive is rewritten once all of them are processed, or when the program
+// stops before that, if any is modified; the other entries are copied as is.
+type archive struct {
+	path string
+	dir  string // the local copies
+	mu   sync.Mutex
+	left int  // the files not processed yet
+	done bool // rewritten if needed, see finish
+	// The local copies of the modified files, by the names of the entries.
+	changed map[string]string
+}
+
+// A file of the archive with its local copy.
+type archiveEntry struct {
+	arc   *archive
+	entry string // the name in the archive
+	hash  string // of the local copy when it was made, see hashFile
+}
+
+func (e *archiveEntry) String() string {
+	return e.arc.path + ":" + e.entry
+}
+
+// The local copy is made when the archive is queued.
+func (e *archiveEntry) fetch(local string) error {
+	return nil
+}
+
+// Record whether the local copy has been modified, rewriting the archive
+// once it is the last file processed.
+func (e *archiveEntry) store(local string) (bool, error) {
+	a := e.arc
+	hash, err := hashFile(local)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err == nil && hash != e.hash {
+		a.changed[e.entry] = local
+	}
+	a.left--
+	if a.left > 0 {
+		return false, err
+	}
+	written, ferr := a.finish()
+	if err == nil {
+		err = ferr
+	}
+	return written, err
+}
+
+// Rewrite the archive with the modified files, if any and not done yet,
+// and remove the local copies.  The caller holds the lock.
+func (a *archive) finish() (bool, error) {
+	if a.done {
+		return false, nil
+	}
+	a.done = true
+	defer os.RemoveAll(a.dir)
+	if len(a.changed) == 0 {
+		return false, nil
+	}
+	return true, a.rewrite()
+}
+
+// Write the archive with the modified files atomically.
+func (a *archive) rewrite() error {
+	return fixtag.ReplaceFile(a.path, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		r, err := zip.NewReader(orig, st.Size())
+		if err != nil {
+			return err
+		}
+		zw := zip.NewWriter(w)
+		if err := zw.SetComment(r.Comment); err != nil {
+			return err
+		}
+		for _, zf := range r.File {
+			local, ok := a.changed[zf.Name]
+			if !ok {
+				if err := zw.Copy(zf); err != nil {
+					return fmt.Errorf("%s: %v", zf.Name, err)
+				}
+				continue
+			}
+			if err := copyEntry(zw, zf.FileHeader, local); err != nil {
+				return fmt.Errorf("%s: %v", zf.Name, err)
+			}
+		}
+		return zw.Close()
+	})
+}
+
+// Write the local file into the archive, with the header of the original
+// entry but the time of the modification.
+func copyEntry(w *zip.Writer, hdr zip.FileHeader, local string) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	hdr.Modified = time.Now()
+	// The extra fields may describe the original contents, e.g. the time.
+	hdr.Extra = nil
+	dst, err := w.CreateHeader(&hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+// Queue the files of the archive with the matching extensions, copying them
+// into the staging directory first.
+func (s *fileSource) queueZip(p string, queue chan<- job) {
+	r, err := zip.OpenReader(p)
+	if err != nil {
+		queue <- job{path: p, err: err}
+		return
+	}
+	if s.stageDir == "" {
+		if s.stageDir, err = os.MkdirTemp("", "fix-mp3-tag-"); err != nil {
+			r.Close()
+			queue <- job{path: p, err: err}
+			return
+		}
+	}
+	dir, err := os.MkdirTemp(s.stageDir, "zip-")
+	if err != nil {
+		r.Close()
+		queue <- job{path: p, err: err}
+		return
+	}
+	a := &archive{path: p, dir: dir, changed: make(map[string]string)}
+	// All files are copied before any is processed, so that the archive is
+	// not rewritten too early.
+	var jobs []job
+	for i, zf := range r.File {
+		if zf.FileInfo().IsDir() || !hasExtension(zf.Name, s.extensions) {
+			continue
+		}
+		e := &archiveEntry{arc: a, entry: zf.Name}
+		// The names in the archive may be anything, e.g. "../x.mp3".
+		local := filepath.Join(dir, strconv.Itoa(i), path.Base(zf.Name))
+		if err := extractEntry(zf, local); err != nil {
+			jobs = append(jobs, job{path: e.String(), err: err})
+			continue
+		}
+		if e.hash, err = hashFile(local); err != nil {
+			jobs = append(jobs, job{path: e.String(), err: err})
+			continue
+		}
+		jobs = append(jobs, job{path: local, staged: e})
+		a.left++
+	}
+	r.Close()
+	if a.left == 0 {
+		os.RemoveAll(dir)
+	} else {
+		s.mu.Lock()
+		s.archives = append(s.archives, a)
+		s.mu.Unlock()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+}
+
+// Copy the file of the archive into the local path.
+func extractEntry(zf *zip.File, local string) error {
+	src, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The default suffix of backup files.
+const defaultBackupSuffix = ".bak"
+
+// Where to back up the original files before writing.
+// Either the suffix or the directory is set, or none when backups are disabled.
+type backupFlag struct {
+	suffix string
+	dir    string
+}
+
+func (b *backupFlag) String() string {
+	if b.dir != "" {
+		return b.dir
+	}
+	return b.suffix
+}
+
+// The value is treated as a directory if it contains a path separator
+// or names an existing directory, otherwise it is a suffix.
+func (b *backupFlag) Set(value string) error {
+	*b = backupFlag{}
+	switch value {
+	case "false":
+		return nil
+	case "true", "":
+		b.suffix = defaultBackupSuffix
+		return nil
+	}
+	if strings.ContainsRune(value, filepath.Separator) || strings.ContainsRune(value, '/') {
+		b.dir = value
+		return nil
+	}
+	if st, err := os.Stat(value); err == nil && st.IsDir() {
+		b.dir = value
+		return nil
+	}
+	b.suffix = value
+	return nil
+}
+
+// Allow -backup without a value.
+func (b *backupFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (b *backupFlag) enabled() bool {
+	return b.suffix != "" || b.dir != ""
+}
+
+// Get the path of the backup for the file.
+// In the directory mode the absolute path of the file is mirrored under the directory.
+func (b *backupFlag) backupPath(path string) (string, error) {
+	if b.dir == "" {
+		return path + b.suffix, nil
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	abs = abs[len(filepath.VolumeName(abs)):]
+	return filepath.Join(b.dir, abs), nil
+}
+
+// The most backups of a file, see backup.
+const maxBackups = 1000
+
+// Copy the file to its backup location, returning the path of the backup.
+// An existing backup is never overwritten, as it may be the only original
+// copy: the later backups of the file are numbered instead, e.g. song.mp3.bak,
+// song.mp3.bak.1, song.mp3.bak.2.
+func (b *backupFlag) backup(path string) (string, error) {
+	base, err := b.backupPath(path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return "", err
+	}
+	dst := base
+	for n := 1; ; n++ {
+		err = copyFile(path, dst)
+		if !errors.Is(err, fs.ErrExist) || n == maxBackups {
+			break
+		}
+		dst = base + "." + strconv.Itoa(n)
+	}
+	if err != nil {
+		return "", fmt.Errorf("backup to %q: %w", dst, err)
+	}
+	return dst, nil
+}
+
+// Restore the file from its backup made by backup, e.g. if the written file
+// is broken.  The backup is kept.
+func (b *backupFlag) restore(path, src string) error {
+	tmp := path + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		return fmt.Errorf("restore from %q: %w", src, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Copy the file contents, mode and modification time.  The destination must not exist.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupNumbered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	b := &backupFlag{suffix: defaultBackupSuffix}
+	for i, want := range []string{path + ".bak", path + ".bak.1", path + ".bak.2"} {
+		data := []byte{byte('a' + i)}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		dst, err := b.backup(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst != want {
+			t.Errorf("backup() = %q, want %q", dst, want)
+		}
+		if got, err := os.ReadFile(dst); err != nil || string(got) != string(data) {
+			t.Errorf("the backup %q = %q, %v, want %q", dst, got, err, data)
+		}
+	}
+	// The first backup is the original.
+	if got, err := os.ReadFile(path + ".bak"); err != nil || string(got) != "a" {
+		t.Errorf("the first backup = %q, %v", got, err)
+	}
+	if err := os.WriteFile(path, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.restore(path, path+".bak.1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "b" {
+		t.Errorf("the restored file = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The totals of a phase of the benchmark.
+type benchPhase struct {
+	files  int
+	bytes  int64 // of the files
+	frames int   // converted, or considered for the conversion
+	wall   time.Duration
+	busy   time.Duration // of all workers together
+	failed int
+}
+
+// Run the function for every file with the given number of workers, timing
+// every call.  The function returns the number of frames of the file.
+func benchRun(files []string, workers int, fn func(path string) (int, error)) *benchPhase {
+	ph := &benchPhase{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	queue := make(chan string)
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				t := time.Now()
+				n, err := fn(path)
+				d := time.Since(t)
+				st, serr := os.Stat(path)
+				mu.Lock()
+				ph.busy += d
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: failed: %v\n", path, err)
+					ph.failed++
+				} else {
+					ph.files++
+					ph.frames += n
+					if serr == nil {
+						ph.bytes += st.Size()
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range files {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+	ph.wall = time.Since(start)
+	return ph
+}
+
+// Get the rate of the count a second of the duration, 0 if it is too short.
+func perSecond(n float64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return n / d.Seconds()
+}
+
+// Get the average duration of the count, 0 for no count.
+func average(d time.Duration, n int) time.Duration {
+	if n == 0 {
+		return 0
+	}
+	return d / time.Duration(n)
+}
+
+// Measure the speed of reading, converting and writing the tags of the files:
+// the tags are read first, then converted, and then the converted ones are
+// written into the copies of the files, which are removed.  The files are
+// never written.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	workers := fs.Int("j", 1, "Number of files to process in parallel, 0 means the number of CPUs")
+	exts := fs.String("ext", strings.Join(fixtag.Extensions(), ","), "Comma-separated list of file extensions to measure")
+	langs := fs.String("lang", "ru", "Comma-separated list of the languages of the tags")
+	tmpDir := fs.String("tmp", "", "Write the copies of the files into this directory, on the same disk as the files for the real write time; the system temporary directory by default")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] <directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *workers <= 0 {
+		*workers = runtime.NumCPU()
+	}
+	fixer, err := fixtag.New(fixtag.Options{Languages: parseList(*langs)})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	src := &fileSource{recursive: true, extensions: parseExtensions(*exts)}
+	queue := make(chan job)
+	go src.queueFiles(fs.Args(), queue)
+	var files []string
+	failed := false
+	for j := range queue {
+		j.fetch()
+		if j.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", j.path, j.err)
+			failed = true
+			continue
+		}
+		files = append(files, j.path)
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(os.Stderr, "no files to measure")
+		os.Exit(1)
+	}
+
+	scan := benchRun(files, *workers, func(path string) (int, error) {
+		frames, err := fixtag.ReadFrames(path)
+		return len(frames), err
+	})
+	var mu sync.Mutex
+	plans := make(map[string]*fixtag.FilePlan)
+	convert := benchRun(files, *workers, func(path string) (int, error) {
+		res, err := fixer.Plan(path)
+		if err != nil {
+			return 0, err
+		}
+		if fp := res.Plan(); len(fp.Frames) > 0 {
+			mu.Lock()
+			plans[path] = fp
+			mu.Unlock()
+		}
+		return len(res.Fields()), nil
+	})
+	var changed []string
+	for _, path := range files {
+		if plans[path] != nil {
+			changed = append(changed, path)
+		}
+	}
+	dir, err := os.MkdirTemp(*tmpDir, "fix-mp3-tag-bench-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	var copying time.Duration
+	copies, inPlace := 0, 0
+	write := benchRun(changed, *workers, func(path string) (int, error) {
+		// The copy is not timed.
+		t := time.Now()
+		fp := *plans[path]
+		mu.Lock()
+		copies++
+		fp.File = filepath.Join(dir, fmt.Sprintf("%d%s", copies, filepath.Ext(path)))
+		mu.Unlock()
+		defer os.Remove(fp.File)
+		if err := copyFile(path, fp.File); err != nil {
+			return 0, err
+		}
+		d := time.Since(t)
+		err := fixer.Apply(&fp)
+		mu.Lock()
+		copying += d
+		if fp.InPlace {
+			inPlace++
+		}
+		mu.Unlock()
+		return len(fp.Frames), err
+	})
+	write.busy -= copying
+	os.RemoveAll(dir)
+	src.cleanup()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "files:\t%d, %.1f MB, %d workers\n", scan.files, float64(scan.bytes)/1e6, *workers)
+	fmt.Fprintf(tw, "scan:\t%v\t%.0f files/s\t%.1f MB/s\t%v/file\n", scan.wall.Round(time.Millisecond),
+		perSecond(float64(scan.files), scan.wall), perSecond(float64(scan.bytes)/1e6, scan.wall), average(scan.busy, scan.files).Round(time.Microsecond))
+	// The tags have been read by the scan, the reading is not counted twice.
+	busy := convert.busy - scan.busy
+	if busy < 0 {
+		busy = 0
+	}
+	fmt.Fprintf(tw, "convert:\t%v\t%d frames\t%.0f frames/s\t%v/frame\n", convert.wall.Round(time.Millisecond),
+		convert.frames, perSecond(float64(convert.frames), convert.wall), average(busy, convert.frames).Round(10*time.Nanosecond))
+	// The copies are made by the workers too, so the time of the writes is
+	// the time of every worker.
+	fmt.Fprintf(tw, "write:\t%v\t%d files\t%.1f MB/s\t%v/file, %d in place\n", (write.busy / time.Duration(*workers)).Round(time.Millisecond),
+		write.files, perSecond(float64(write.bytes)/1e6, write.busy), average(write.busy, write.files).Round(time.Microsecond), inPlace)
+	tw.Flush()
+	if failed || scan.failed > 0 || convert.failed > 0 || write.failed > 0 {
+		os.Exit(1)
+	}
+}
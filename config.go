@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The file of the default options, relative to the user config directory.
+const defaultConfig = "fix-mp3-tag/config.toml"
+
+// Get the path of the config file, the default one if it exists, or empty.
+func configPath(path string) string {
+	if path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	path = filepath.Join(dir, defaultConfig)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Load the defaults of the flags not given on the command line from the
+// TOML file.  The keys are the names of the flags, e.g.
+//
+//	t = 0.9
+//	lang = "ru,uk"
+//	chain = ["iso8859-1>win1251"]
+//	backup = "/var/backups/music"
+//
+// The lists are for the flags which may be repeated.
+func loadConfig(path string) error {
+	var values map[string]interface{}
+	if _, err := toml.DecodeFile(path, &values); err != nil {
+		return err
+	}
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flag.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if given[name] {
+			continue
+		}
+		list, ok := values[name].([]interface{})
+		if !ok {
+			list = []interface{}{values[name]}
+		}
+		for _, v := range list {
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = v
+			case bool:
+				s = strconv.FormatBool(v)
+			case int64:
+				s = strconv.FormatInt(v, 10)
+			case float64:
+				s = strconv.FormatFloat(v, 'g', -1, 64)
+			default:
+				return fmt.Errorf("option %q: unsupported value %v", name, v)
+			}
+			if err := flag.Set(name, s); err != nil {
+				return fmt.Errorf("option %q: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// The name of the files overriding the options for their directories.
+const dirConfig = ".fixtagrc"
+
+// The options of a directory, given in the TOML file, e.g.
+//
+//	lang = "uk"
+//	chain = ["cp866"]
+//	t = 0.8
+type dirOptions struct {
+	Lang  string   `toml:"lang"`
+	Chain []string `toml:"chain"`
+	T     float64  `toml:"t"`
+}
+
+// The fixers of the directories, with the options overridden by the
+// .fixtagrc files of the directory and of its parents, the inner ones
+// taking precedence.  It is safe for concurrent use.
+type dirFixers struct {
+	mu   sync.Mutex
+	dirs map[string]*dirFixer // by the absolute paths, "" for the defaults
+}
+
+// The fixer of a directory.
+type dirFixer struct {
+	opts  fixtag.Options
+	fixer *fixtag.Fixer
+	err   error
+	// The fingerprint of the contents of the .fixtagrc files, empty if none.
+	hash string
+}
+
+func newDirFixers(opts fixtag.Options, fixer *fixtag.Fixer) *dirFixers {
+	return &dirFixers{dirs: map[string]*dirFixer{"": {opts: opts, fixer: fixer}}}
+}
+
+// Get the fixer of the file.
+func (d *dirFixers) fixer(path string) (*fixtag.Fixer, error) {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	df := d.get(dir)
+	return df.fixer, df.err
+}
+
+// Get the fingerprint of the .fixtagrc files of the file, empty if none.
+func (d *dirFixers) options(path string) string {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.get(dir).hash
+}
+
+// Get the fixer of the absolute directory, reading its .fixtagrc once.
+func (d *dirFixers) get(dir string) *dirFixer {
+	if df, ok := d.dirs[dir]; ok {
+		return df
+	}
+	parent := filepath.Dir(dir)
+	if parent == dir {
+		parent = ""
+	}
+	df := d.get(parent)
+	if df.err == nil {
+		path := filepath.Join(dir, dirConfig)
+		if _, err := os.Stat(path); err == nil {
+			df = newDirFixer(df.opts, df.hash, path)
+		}
+	}
+	d.dirs[dir] = df
+	return df
+}
+
+// Make the fixer of the directory with the options of its parent overridden
+// by the file, the parent having the fingerprint given.
+func newDirFixer(opts fixtag.Options, hash, path string) *dirFixer {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return &dirFixer{err: err}
+	}
+	var do dirOptions
+	md, err := toml.Decode(string(data), &do)
+	if err == nil && len(md.Undecoded()) > 0 {
+		err = fmt.Errorf("unknown option %q", md.Undecoded()[0].String())
+	}
+	if err != nil {
+		return &dirFixer{err: fmt.Errorf("%s: %v", path, err)}
+	}
+	if md.IsDefined("lang") {
+		opts.Languages = parseList(do.Lang)
+		// The charsets of the languages are tried.
+		opts.Charsets = nil
+	}
+	if md.IsDefined("chain") {
+		opts.Chains = do.Chain
+	}
+	if md.IsDefined("t") {
+		opts.Threshold = do.T
+	}
+	fixer, err := fixtag.New(opts)
+	if err != nil {
+		return &dirFixer{err: fmt.Errorf("%s: %v", path, err)}
+	}
+	sum := sha256.Sum256(append([]byte(hash+"\x00"), data...))
+	return &dirFixer{opts: opts, fixer: fixer, hash: hex.EncodeToString(sum[:8])}
+}
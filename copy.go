@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The copy subcommand: copy the text frames of a correctly tagged file,
+// e.g. a duplicate, onto a broken one, see fixtag.CopyTags.
+func runCopy(args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	from := fs.String("from", "", "The file to copy the frames from")
+	to := fs.String("to", "", "The file to copy the frames to")
+	frames := fs.String("frames", "", "Comma-separated list of the only frames of the -from file to copy, e.g. \"TIT2,TPE1,TALB\"; all text frames if empty")
+	write := fs.Bool("w", false, "Write the copied frames, otherwise only show them")
+	verbose := fs.Int("v", 0, "Increase verbosity")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s copy [flags] -from <file> -to <file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *from == "" || *to == "" || fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	log := newLogger(slogLevel(*verbose), logPlain, *to)
+	fixer, err := fixtag.New(fixtag.Options{Logger: log})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	p, err := fixer.CopyTags(*from, *to, parseList(*frames))
+	os.Stdout.Write(log.buf.Bytes())
+	log.buf.Reset()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed: %v\n", err)
+		os.Exit(1)
+	}
+	if len(p.Frames) == 0 {
+		fmt.Printf("%s: nothing to copy\n", *to)
+		return
+	}
+	fmt.Printf("%s:\n", *to)
+	for _, fp := range p.Frames {
+		orig, _ := fp.Original.TextFrame()
+		fmt.Printf(" frame %s[%d]: %q => %q\n", fp.ID, fp.Index, orig.Text, fp.Text)
+	}
+	if !*write {
+		return
+	}
+	err = fixer.Apply(p)
+	os.Stdout.Write(log.buf.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed: %v\n", *to, err)
+		os.Exit(1)
+	}
+}
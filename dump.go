@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The frames of a file in the JSON output of the dump subcommand.
+type dumpedFile struct {
+	File   string             `json:"file"`
+	Frames []fixtag.FrameInfo `json:"frames"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// Print every frame of the files as stored, for diagnosing the conversion.
+func runDump(args []string) {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Print the frames in JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dump [flags] <file>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	var out []dumpedFile
+	for _, path := range fs.Args() {
+		frames, err := fixtag.ReadFrames(path)
+		if err != nil {
+			failed = true
+		}
+		if *asJSON {
+			d := dumpedFile{File: path, Frames: frames}
+			if err != nil {
+				d.Error = err.Error()
+			}
+			out = append(out, d)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", path, err)
+			continue
+		}
+		fmt.Printf("%s:\n", path)
+		for _, fi := range frames {
+			printFrame(fi)
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Print the frame on a line, with its text in hex on the next one.
+func printFrame(fi fixtag.FrameInfo) {
+	name := fi.ID
+	if fi.Index > 0 {
+		name = fmt.Sprintf("%s[%d]", fi.ID, fi.Index)
+	}
+	if fi.Binary {
+		fmt.Printf("  %-8s binary, %s\n", name, fi.Description)
+		return
+	}
+	var parts []string
+	if fi.Encoding != "" {
+		parts = append(parts, fi.Encoding)
+	}
+	if fi.Language != "" {
+		parts = append(parts, fmt.Sprintf("lang %q", fi.Language))
+	}
+	if fi.Description != "" {
+		parts = append(parts, fmt.Sprintf("desc %q", fi.Description))
+	}
+	parts = append(parts, fmt.Sprintf("%d bytes", fi.Size))
+	fmt.Printf("  %-8s %s: %q\n", name, strings.Join(parts, ", "), fi.Text)
+	fmt.Printf("  %-8s [%s]\n", "", spacedHex(fi.TextHex))
+}
+
+// Separate the bytes of the hex string with spaces, e.g. "cf e0".
+func spacedHex(h string) string {
+	var out []byte
+	for i := 0; i+1 < len(h); i += 2 {
+		if i > 0 {
+			out = append(out, ' ')
+		}
+		out = append(out, h[i], h[i+1])
+	}
+	return string(out)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The columns of the CSV export, as the fields of fixtag.ExportedFrame.
+var exportColumns = []string{"file", "frame", "index", "description", "text"}
+
+// Write the exported frames in JSON or, if asCSV, in CSV with the header.
+func writeExport(w io.Writer, frames []fixtag.ExportedFrame, asCSV bool) error {
+	if !asCSV {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(frames)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(exportColumns)
+	for _, e := range frames {
+		cw.Write([]string{e.File, e.Key, strconv.Itoa(e.Index), e.Description, e.Text})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read the exported frames in JSON or, if asCSV, in CSV with the header.
+// The columns of the CSV may be in any order, and the ones not known are
+// ignored, e.g. added in the spreadsheet.
+func readExport(r io.Reader, asCSV bool) ([]fixtag.ExportedFrame, error) {
+	var frames []fixtag.ExportedFrame
+	if !asCSV {
+		err := json.NewDecoder(r).Decode(&frames)
+		return frames, err
+	}
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"file", "frame", "text"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("no %q column", name)
+		}
+	}
+	value := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	for n, row := range rows[1:] {
+		e := fixtag.ExportedFrame{
+			File:        value(row, "file"),
+			Key:         value(row, "frame"),
+			Description: value(row, "description"),
+			Text:        value(row, "text"),
+		}
+		if s := value(row, "index"); s != "" {
+			if e.Index, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("line %d: invalid index %q", n+2, s)
+			}
+		}
+		frames = append(frames, e)
+	}
+	return frames, nil
+}
+
+// Export the text frames of the files, to be edited by hand and imported
+// back, see runImport.
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "Export directories recursively")
+	asCSV := fs.Bool("csv", false, "Export in CSV, one row per frame, instead of JSON")
+	output := fs.String("o", "", "Write the export into this file instead of stdout")
+	exts := fs.String("ext", strings.Join(fixtag.Extensions(), ","), "Comma-separated list of file extensions to export in recursive mode")
+	var excludes patternList
+	fs.Var(&excludes, "exclude", "Skip files and directories matching the pattern, e.g. \"*/Podcasts/*\"; may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] <file or directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	src := &fileSource{
+		recursive:  *recursive,
+		extensions: parseExtensions(*exts),
+		excludes:   excludes,
+	}
+	queue := make(chan job)
+	go src.queueFiles(fs.Args(), queue)
+	failed := false
+	out := []fixtag.ExportedFrame{}
+	for j := range queue {
+		if j.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", j.path, j.err)
+			failed = true
+			continue
+		}
+		if j.staged != nil {
+			// The frames could not be imported back into a local copy.
+			fmt.Fprintf(os.Stderr, "%s: skipped, only the local files are exported\n", j.staged)
+			continue
+		}
+		frames, err := fixtag.ExportTags(j.path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", j.path, err)
+			failed = true
+			continue
+		}
+		out = append(out, frames...)
+	}
+	src.cleanup()
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create the export: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := writeExport(w, out, *asCSV); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the export: %v\n", err)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Make the plan of writing the frames edited in the export back into the
+// files, see fixtag.ImportTags.  The plan is written with -apply, so that
+// it is backed up and journaled like any other.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	asCSV := fs.Bool("csv", false, "Read the export in CSV instead of JSON; implied by the .csv extension")
+	planPath := fs.String("plan", "", "Save the changes into this plan file, to be written with -apply")
+	verbose := fs.Int("v", 0, "Increase verbosity")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s import [flags] -plan <plan file> <export file>\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *planPath == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	path := fs.Arg(0)
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read the export: %v\n", err)
+		os.Exit(1)
+	}
+	frames, err := readExport(f, *asCSV || hasExtension(path, []string{".csv"}))
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read the export: %v\n", err)
+		os.Exit(1)
+	}
+	// The files are imported in the order of the export.
+	var files []string
+	byFile := make(map[string][]fixtag.ExportedFrame)
+	for _, e := range frames {
+		if _, ok := byFile[e.File]; !ok {
+			files = append(files, e.File)
+		}
+		byFile[e.File] = append(byFile[e.File], e)
+	}
+
+	failed := false
+	p := newPlan()
+	for _, file := range files {
+		log := newLogger(slogLevel(*verbose), logPlain, file)
+		fixer, err := fixtag.New(fixtag.Options{Logger: log})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		fp, err := fixer.ImportTags(file, byFile[file])
+		os.Stdout.Write(log.buf.Bytes())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", file, err)
+			failed = true
+			continue
+		}
+		if len(fp.Frames) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", file)
+		for _, fr := range fp.Frames {
+			orig, _ := fr.Original.TextFrame()
+			fmt.Printf(" frame %s[%d]: %q => %q\n", fr.ID, fr.Index, orig.Text, fr.Text)
+		}
+		p.add(fp)
+	}
+	if err := p.save(*planPath); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save the plan: %v\n", err)
+		os.Exit(1)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// Check whether the file name has one of the extensions to process.
+func hasExtension(path string, extensions []string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range extensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse the comma-separated list of extensions, adding the leading dot if missing.
+func parseExtensions(list string) []string {
+	var out []string
+	for _, e := range strings.Split(list, ",") {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// A list of patterns given with a repeated flag.
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	if _, err := path.Match(value, ""); err != nil {
+		return err
+	}
+	*p = append(*p, value)
+	return nil
+}
+
+// Check whether the string has any of the glob special characters.
+func hasMeta(s string) bool {
+	return strings.ContainsAny(s, "*?[")
+}
+
+// Match the path segments against the pattern segments.
+// The "**" segment matches any number of path segments, including none.
+func matchSegments(pattern, segs []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segs); i++ {
+				if matchSegments(pattern[1:], segs[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segs) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segs[0]); !ok {
+			return false
+		}
+		pattern, segs = pattern[1:], segs[1:]
+	}
+	return len(segs) == 0
+}
+
+// Split the slash-separated path into segments.
+func splitPath(p string) []string {
+	return strings.Split(filepath.ToSlash(p), "/")
+}
+
+// Check whether the path matches the glob pattern as a whole.
+func matchGlob(pattern, p string) bool {
+	return matchSegments(splitPath(pattern), splitPath(filepath.Clean(p)))
+}
+
+// Check whether any trailing part of the path matches the pattern,
+// e.g. "*/Podcasts/*" matches "/music/Podcasts/episode.mp3".
+func matchTail(pattern, p string) bool {
+	pat := splitPath(pattern)
+	segs := splitPath(filepath.Clean(p))
+	for i := range segs {
+		if matchSegments(pat, segs[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+var errNoMatch = errors.New("no files match the pattern")
+
+// A file to process.  If the file could not be enumerated, err is set.
+// If the plan is set, the file is processed according to it.
+// If the result is set, the file has been converted already, see -album.
+type job struct {
+	path   string
+	root   string // the walked directory the file is found in, if any
+	err    error
+	plan   *fixtag.FilePlan
+	result *fixtag.Result
+	log    *logger // the output of the conversion, with the result
+	// The file has not changed since it was processed, see stateDB.
+	unchanged bool
+	// The file is a local copy, e.g. of a remote file, nil otherwise.
+	staged stagedFile
+}
+
+// A local copy of a file which is not processed in place, see queueRemote
+// and queueZip.
+type stagedFile interface {
+	// The name of the original file for the output.
+	String() string
+	// Make the local copy, if not made yet.
+	fetch(local string) error
+	// Write the local copy back if it has been modified, then remove it.
+	// Whether the original has been written is returned.
+	store(local string) (bool, error)
+}
+
+// Make the local copy of the staged file, if any, before it is processed.
+// If it cannot be made, the job is the error of the original file.
+func (j *job) fetch() {
+	if j.err != nil || j.staged == nil {
+		return
+	}
+	if j.err = j.staged.fetch(j.path); j.err != nil {
+		j.path, j.staged = j.staged.String(), nil
+	}
+}
+
+// Get the hash of the contents of the file, to tell whether the local copy
+// has been modified: its size and modification time may be kept by the
+// write, e.g. with -preserve-mtime.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// The source of files to process, from the command line arguments.
+type fileSource struct {
+	recursive  bool
+	extensions []string
+	excludes   patternList
+	filesFrom  string // the file with the list of files, "-" for stdin
+	zips       bool   // process the files in the ZIP archives
+	stageDir   string // the local copies of the remote and archived files
+	// Why the remote files cannot be processed, e.g. the options need the
+	// files in place, nil if they can.
+	stagedErr error
+	mu        sync.Mutex
+	archives  []*archive // being processed, see cleanup
+	remotes   []remoteFS // connected, see cleanup
+}
+
+// Check whether the path is excluded by any of the patterns.
+// The patterns are matched against the absolute path.
+func (s *fileSource) excluded(p string) bool {
+	if len(s.excludes) == 0 {
+		return false
+	}
+	if isRemote(p) {
+		// The URL is matched as is.
+	} else if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	for _, pattern := range s.excludes {
+		if matchTail(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walk the directory tree and queue every file with a matching extension.
+// Errors for individual entries are queued as well, but do not stop the walk.
+func (s *fileSource) walkDir(root string, queue chan<- job) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			queue <- job{path: path, err: err}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if s.excluded(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() && s.zips && isZip(path) {
+			s.queueZip(path, queue)
+			return nil
+		}
+		if d.IsDir() || !hasExtension(path, s.extensions) {
+			return nil
+		}
+		queue <- job{path: path, root: root}
+		return nil
+	})
+}
+
+// Expand the glob pattern, which may contain "**" to match any number of
+// directories.  The pattern is expanded internally, as the shell may not do that,
+// e.g. on Windows.  The matching directories are walked in the recursive mode.
+func (s *fileSource) expandGlob(pattern string, queue chan<- job) {
+	pattern = path.Clean(filepath.ToSlash(pattern))
+	// Walk from the longest leading part without special characters.
+	segs := strings.Split(pattern, "/")
+	n := 0
+	for n < len(segs)-1 && !hasMeta(segs[n]) {
+		n++
+	}
+	root := strings.Join(segs[:n], "/")
+	if root == "" {
+		root = "."
+		if n > 0 {
+			root = "/"
+		}
+	}
+	found := false
+	filepath.WalkDir(filepath.FromSlash(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			found = true
+			queue <- job{path: p, err: err}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if s.excluded(p) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !matchGlob(pattern, p) {
+			return nil
+		}
+		found = true
+		if d.IsDir() {
+			if s.recursive {
+				s.walkDir(p, queue)
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if s.zips && isZip(p) {
+			s.queueZip(p, queue)
+			return nil
+		}
+		queue <- job{path: p, root: filepath.FromSlash(root)}
+		return nil
+	})
+	if !found {
+		queue <- job{path: pattern, err: errNoMatch}
+	}
+}
+
+// Read the list of files, one per line.  The JSON report produced by
+// a previous run is accepted as well, either as an array or NDJSON.
+func readFileList(r io.Reader) ([]string, error) {
+	br := bufio.NewReader(r)
+	// Skip the leading white space to detect the JSON array.
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			br.UnreadByte()
+			break
+		}
+	}
+	var out []string
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			out = append(out, path)
+		}
+	}
+	if c, _ := br.Peek(1); c[0] == '[' {
+		var entries []reportEntry
+		if err := json.NewDecoder(br).Decode(&entries); err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			add(e.File)
+		}
+		return out, nil
+	}
+	sc := bufio.NewScanner(br)
+	sc.Buffer(nil, 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimRight(sc.Bytes(), "\r")
+		if len(text) > 0 && text[0] == '{' {
+			var e reportEntry
+			if err := json.Unmarshal(text, &e); err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			add(e.File)
+			continue
+		}
+		add(string(text))
+	}
+	return out, sc.Err()
+}
+
+// Queue files for processing from the command line arguments and the list
+// of files.  Every argument is either a file, a glob pattern or, in the
+// recursive mode, a directory.
+func (s *fileSource) queueFiles(args []string, queue chan<- job) {
+	defer close(queue)
+	s.queueArgs(args, queue)
+	if s.filesFrom == "" {
+		return
+	}
+	var r io.Reader = os.Stdin
+	if s.filesFrom != "-" {
+		f, err := os.Open(s.filesFrom)
+		if err != nil {
+			queue <- job{path: s.filesFrom, err: err}
+			return
+		}
+		defer f.Close()
+		r = f
+	}
+	list, err := readFileList(r)
+	if err != nil {
+		queue <- job{path: s.filesFrom, err: err}
+	}
+	s.queueArgs(list, queue)
+}
+
+func (s *fileSource) queueArgs(args []string, queue chan<- job) {
+	for _, path := range args {
+		if isRemote(path) {
+			s.queueRemote(path, queue)
+			continue
+		}
+		if _, err := os.Lstat(path); err != nil && hasMeta(path) {
+			s.expandGlob(path, queue)
+			continue
+		}
+		if s.excluded(path) {
+			continue
+		}
+		if s.recursive {
+			st, err := os.Stat(path)
+			if err != nil {
+				queue <- job{path: path, err: err}
+				continue
+			}
+			if st.IsDir() {
+				s.walkDir(path, queue)
+				continue
+			}
+		}
+		if s.zips && isZip(path) {
+			s.queueZip(path, queue)
+			continue
+		}
+		queue <- job{path: path}
+	}
+}
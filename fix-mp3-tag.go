@@ -1,255 +1,1523 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/bogem/id3v2"
-	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/charmap"
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
 )
 
 var (
-	verbose   = flag.Int("v", 0, "Increase verbosity")
+	verbose   = flag.Int("v", 0, "Increase verbosity, see -log-level")
+	logLvl    = flag.String("log-level", "", "Level of the log: debug, info, warn or error; set by -v if empty")
+	logPath   = flag.String("log-file", "", "Append the log to this file instead of printing it")
+	logFormat = flag.String("log-format", logPlain, "Format of the log: plain, text (key=value) or json")
+	quiet     = flag.Bool("q", false, "Print nothing but the errors, see the exit code: 0 if nothing needed fixing, 1 if fixed (or would be in the dry-run mode), 2 if some files failed")
 	doWrite   = flag.Bool("w", false, "Write converted frames back")
 	threshold = flag.Float64("t", 1, "Conversion threshold.  If some fields cannot be converted, try lower values, e.g. 0.8")
+	recursive = flag.Bool("r", false, "Process directories recursively")
+	interact  = flag.Bool("i", false, "Interactively confirm the changes of every file and resolve ambiguous conversions, implies -j 1")
+	planPath  = flag.String("plan", "", "In the dry-run mode, save the proposed changes into this file, see -apply")
+	applyPath = flag.String("apply", "", "Write the changes from the plan file saved with -plan, without converting anything")
+	filesFrom = flag.String("files-from", "", "Read the list of files to process from this file, one per line, or from stdin if \"-\"")
+	csvPath   = flag.String("csv", "", "Also save the report into this CSV file, one row per frame, e.g. for a spreadsheet")
+	htmlPath  = flag.String("html", "", "Also save the report into this HTML file, the original text next to the converted one, grouped by directory")
+	summary   = flag.String("summary-json", "", "Save the summary of the run in JSON into this file, or print it to stdout if \"-\"")
+	jrnPath   = flag.String("journal", "", "Record the original frames into this file before writing, see the undo subcommand")
+	exts      = flag.String("ext", strings.Join(fixtag.Extensions(), ","), "Comma-separated list of file extensions to process in recursive mode")
+	playlists = flag.Bool("playlists", false, "Also fix the M3U, M3U8 and PLS playlists and the CUE sheets in recursive mode, writing them in UTF-8")
+	cueTags   = flag.Bool("cue-tags", false, "Set the title, the performer and the album of the files of the fixed CUE sheets, if every file has a single track")
+	fixNames  = flag.Bool("fix-filenames", false, "Also fix the broken names of the processed files and of their directories below the given ones, renaming them with -w")
+	fromName  = flag.String("from-filename", "", "Fill the empty title, artist, album and track frames from the file name by the pattern, e.g. \"<track> - <artist> - <title>\"")
+	rename    = flag.String("rename", "", "Move the processed files by the template of their fixed tags, e.g. \"{artist}/{album}/{track:02d} - {title}\", relative to the given directories")
+	watchWait = flag.Duration("watch-delay", 2*time.Second, "In the watch mode, process a file once it is not written for this time")
+	dbPath    = flag.String("state", "", "Keep the size, the modification time and the result of every processed file in this file, e.g. ~/.cache/fix-mp3-tag/state.db, and skip the files not changed since")
+	zips      = flag.Bool("zip", false, "Also fix the files in the ZIP archives, rewriting the archives with -w; the other files in them are kept as is")
+	fromStdin = flag.Bool("stdin", false, "Read a single MP3 from stdin and write it with the fixed tags to stdout, the output goes to stderr")
+	resume    = flag.Bool("resume", false, "Run the command line of the batch interrupted before again, skipping the files it has processed; needs -state")
+	statePath = flag.String("watch-state", "", "In the watch mode, keep the state of the processed files in this file, so that they are not processed again after a restart")
+	mbLookup  = flag.Bool("musicbrainz", false, "Resolve the ambiguous artists, albums and titles by searching the candidates in MusicBrainz, which needs the network")
+	acoustKey = flag.String("acoustid-key", "", "Identify the files with the lost titles, artists or albums by their fingerprints with this AcoustID API key, and set them from MusicBrainz; needs fpcalc")
+	discogs   = flag.Bool("discogs", false, "Correct the track titles by the albums found in Discogs, and fill the empty years and genres; needs -discogs-token")
+	dcToken   = flag.String("discogs-token", os.Getenv("DISCOGS_TOKEN"), "The personal access token of Discogs, $DISCOGS_TOKEN by default")
+	hookCmd   = flag.String("hook-cmd", "", "Run this command for every frame converted, passing the original text and the candidates in JSON on stdin, and take its choice in JSON from stdout, e.g. {\"chain\": \"cp1251\"} or {\"text\": \"...\"}")
+	review    = flag.String("review", "", "In the watch mode, serve the page for reviewing the ambiguous and not converted frames at this address, e.g. \"localhost:8080\"")
+	jobs      = flag.Int("j", 1, "Number of files to process in parallel, 0 means the number of CPUs")
+	maxReadMB = flag.Float64("max-read-mbps", 0, "Read and write at most this many megabytes of the files a second, e.g. not to saturate a NAS; no limit if 0")
+	maxIOPS   = flag.Float64("max-iops", 0, "Read and write the files at most this many times a second; no limit if 0")
+	fileTmout = flag.Duration("file-timeout", 0, "Give up a file not processed in this time, e.g. \"30s\" for a hanging network mount, writing nothing into it; no timeout if 0")
+	timeout   = flag.Duration("timeout", 0, "Stop after this time, e.g. \"8h\" for an overnight run, giving up the files being processed; no timeout if 0")
+	niceness  = flag.Int("nice", 0, "Run with this niceness, from 1 to 19, lowering the priority of the CPU and, with the default I/O scheduling, of the I/O (Linux only)")
+	targetEnc = flag.String("target-encoding", "utf8", "Encoding of the converted frames: utf8 or utf16")
+	stripV1   = flag.Bool("strip-id3v1", false, "Remove the ID3v1 tag from the written files, its fields are converted into ID3v2 frames anyway")
+	translit  = flag.String("translit", "", "Write the frames transliterated into Latin for the players without Cyrillic, by the scheme \"gost\" or \"informal\"; also used by -write-id3v1=translit")
+	fixGenre  = flag.Bool("fix-genre", false, "Also replace the ID3v1 genre numbers like \"(17)\" with their names and normalize the capitalization of the genres")
+	fixNums   = flag.String("fix-numbers", "", "Also normalize the track and the disc numbers, writing the track numbers either \"pad\"ded to two digits or with the leading zeros \"strip\"ped")
+	fixDates  = flag.Bool("fix-dates", false, "Also move the dates into the frames of the written ID3v2 version, e.g. TYER and TDAT into TDRC of ID3v2.4, normalizing them as yyyy-MM-dd")
+	sortFrms  = flag.Bool("sort-frames", false, "Add the sort frames of the artists, the albums and the titles fixed in Cyrillic, transliterated by the -translit scheme, so that the players sort them among the Latin ones")
+	replFile  = flag.String("replace-file", "", "Read the replacements like -replace from this file, one per line")
+	rulesPath = flag.String("rules", "", "Apply the rules of this YAML file to the frames after the conversion, ~/.config/fix-mp3-tag/rules.yaml by default if it exists")
+	untransl  = flag.Bool("untranslit", false, "Also transliterate the frames typed in Latin back into Cyrillic, if every word is known, e.g. \"Gruppa krovi\"")
+	wordsPath = flag.String("untranslit-words", "", "The file of the words known to -untranslit in addition to the common ones, one per line")
+	version   = flag.String("id3-version", "", "Write the tags in this ID3v2 version, 2.3 or 2.4, mapping the date frames; kept if empty")
+	verify    = flag.Bool("verify", false, "Read the written files back and check that the frames are the same as written and none is lost, restoring the files from -backup otherwise")
+	chkAudio  = flag.Bool("check-audio", false, "Hash the audio data of the MP3, FLAC, WAV and AIFF files before and after writing the tags, and fail instead of writing if it would change")
+	touchNone = flag.Bool("touch-nothing", false, "Only write the files if the text of some frame changes, not for the encoding, -id3-version or the ID3v1 tag alone")
+	keepMtime = flag.Bool("preserve-mtime", false, "Keep the access and modification times of the written files")
+	album     = flag.Bool("album", false, "Resolve the ambiguous conversions with the charset of the other files of the same album; all files are read before writing")
+	albArtist = flag.Bool("album-artist", false, "Fill the empty album artists (TPE2) of the files of every album with the artist most of them have, or -various-artists, so that the players group the compilations; all files are read before writing")
+	various   = flag.String("various-artists", fixtag.VariousArtists, "The album artist filled by -album-artist if the files of the album have different artists")
+	showCands = flag.Bool("show-candidates", false, "Print every conversion tried for every frame with its goodness and score, even if not verbose")
+	detect    = flag.Bool("detect", false, "Detect the charset of every frame statistically, falling back to trying all conversions if unsure")
+	replBad   = flag.Bool("replace-invalid", false, "Replace the characters which cannot be converted with U+FFFD instead of failing, to recover the rest of the text; use with a lower -t, e.g. 0.8")
+	entities  = flag.String("html-entities", "", "Unescape the HTML entities and numeric character references like \"&#1055;\" in the frames, either \"before\" converting the result, or \"only\" instead of converting")
+	segments  = flag.Bool("segments", false, "Convert the parts of the text independently if it is not converted as a whole, e.g. the mojibake after the correct Cyrillic, or two halves broken differently")
+	cleanup   = flag.String("cleanup", "", "Comma-separated cleanups of the converted text: \"nfc\" composing the characters, \"controls\" stripping the control and zero-width characters, \"spaces\" collapsing the spaces, the no-break ones included, and \"punct\" replacing the typographic quotes and dashes")
+	punctMap  = flag.String("cleanup-punct", "", "Comma-separated replacements of the punctuation, implying -cleanup punct with them instead of the ASCII quotes and dashes, e.g. '«»=\",—–=-'")
+	capital   = flag.Bool("capitalize", false, "Lowercase the converted text in Cyrillic capitals, e.g. \"ГРУППА КРОВИ\", but the first letter of every sentence, \"Группа крови\"; see -keep-case")
+	artistSep = flag.String("artist-separator", "", "Join the artists split by -artist-split with this separator, e.g. \"; \"; if empty, write them as the multiple values of ID3v2.4, or with \"/\" in ID3v2.3 and \"; \" in the other formats")
+	onlyFrms  = flag.String("frames", "", "Comma-separated list of the only frames to convert, e.g. \"TIT2,TPE1,TALB\"; all frames if empty")
+	skipFrms  = flag.String("skip-frames", "", "Comma-separated list of the frames never converted, e.g. \"COMM,TXXX\"")
+	cfgPath   = flag.String("config", "", "Read the default options from this TOML file, ~/.config/fix-mp3-tag/config.toml by default if it exists")
+	langs     = flag.String("lang", "ru", "Comma-separated list of the languages of the tags: ru, uk, be, bg, sr, el, ja, zh, zh-tw, ko")
+	ngramPath = flag.String("ngram-models", "", "Comma-separated files of the counts of the letter n-grams of your texts or languages, one \"xyz count\" per line with \"_\" marking the word boundary, ranking the candidates along with the built-in Russian and Ukrainian trigrams")
+	accRanges = flag.String("accept-ranges", "", "Comma-separated ranges of the only characters of the correct text, beside the white space, instead of the letters of -lang, e.g. \"U+0400-U+04FF,U+0020-U+007E,U+2010-U+2027\"")
+	jsonOut   reportFormat
+	backup    backupFlag
+	excludes  patternList
+	chains    chainList
+	writeV1   id3v1Flag
+	replaces  replaceList
+	accepts   = scriptList{parse: fixtag.ParsePredicate}
+	transfrms = scriptList{parse: fixtag.ParseTransform}
+	perFrame  thresholdList
+	watchDirs dirList
+	setFrms   frameValues
+	delFrms   frameKeys
+	keepCase  wordList
+	artSplit  wordList
 )
 
-// The function counts the ratio of the correct UTF8 Cyrillic characters to the string length, in range [0..1].
-// For empty string it returns 1.
-// If the input is not UTF8, it returns 0.
-func countCyr(s string) float64 {
-	// Check that the input is UTF8.
-	if _, _, err := encoding.UTF8Validator.Transform([]byte(s), []byte(s), true); err != nil {
-		return 0
-	}
-	bad := 0
-	total := 0
-	for _, c := range s {
-		total++
-		switch {
-		case 0 <= c && c <= 0x7f:
-			// ascii
-		case 0x410 <= c && c <= 0x44f:
-			// basic russian
-		case c == 0x401 || c == 0x451:
-			// yo
-		default:
-			bad++
+func init() {
+	flag.Var(&backup, "backup", "Back up the original files before writing, either with the given suffix (default \""+defaultBackupSuffix+"\") or into the given directory")
+	flag.Var(&excludes, "exclude", "Skip files and directories matching the pattern, e.g. \"*/Podcasts/*\"; may be repeated")
+	flag.Var(&chains, "chain", "Also try the custom chain of charsets, e.g. \"iso8859-1>win1251\"; may be repeated")
+	flag.Var(&writeV1, "write-id3v1", "Also write the ID3v1.1 tag for old players, either in \"cp1251\" (default) or \"translit\"")
+	flag.Var(&replaces, "replace", "Replace the matches of the regular expression in the frames after the conversion, e.g. \"frame=TIT2;from=\\s*\\[www\\..*\\];to=\"; may be repeated")
+	flag.Var(&accepts, "accept", "Only accept the conversions satisfying the expression, e.g. '!contains(text, \"\\ufffd\") && len(text) >= 2'; may be repeated")
+	flag.Var(&transfrms, "transform", "Change the converted text by the expression, e.g. 'frame == \"TPE1\" ? title(lower(text)) : text'; may be repeated, applied in turn")
+	flag.Var(&perFrame, "threshold", "Conversion thresholds of the frames, e.g. \"TIT2=0.7,TPE1=0.95,default=0.9\"; the default overrides -t")
+	flag.Var(&watchDirs, "watch", "Watch the directory and its subdirectories, fixing the new and modified files until interrupted; may be repeated, implies -r")
+	flag.Var(&setFrms, "set", "Set the text of the frame by hand, e.g. \"TIT2=Группа крови\"; may be repeated")
+	flag.Var(&delFrms, "delete-frame", "Remove all frames with the key, e.g. \"COMM\"; may be repeated")
+	flag.Var(&keepCase, "keep-case", "Spell the word or the phrase as given with -capitalize, e.g. \"ДДТ\" or \"Ночные Снайперы\"; may be repeated")
+	flag.Var(&artSplit, "artist-split", "Split the artists of the artist frames on the separator, e.g. \"feat.\", \";\" or \"/\", converting them one by one and joining them with -artist-separator; may be repeated")
+	flag.Var(&jsonOut, "json", "Print the report in JSON to stdout: \"array\" (default) or \"ndjson\"")
+}
+
+// A list of custom conversion chains given with a repeated flag.
+type chainList []string
+
+func (c *chainList) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *chainList) Set(value string) error {
+	if err := fixtag.ParseChain(value); err != nil {
+		return err
+	}
+	*c = append(*c, value)
+	return nil
+}
+
+// The replacements in the frames given with a repeated flag.
+type replaceList []*fixtag.Replacement
+
+func (r *replaceList) String() string {
+	var out []string
+	for _, e := range *r {
+		out = append(out, e.From.String())
+	}
+	return strings.Join(out, ",")
+}
+
+func (r *replaceList) Set(value string) error {
+	e, err := fixtag.ParseReplacement(value)
+	if err != nil {
+		return err
+	}
+	*r = append(*r, e)
+	return nil
+}
+
+// The scripts of the flag, parsed by the function, see fixtag.Script.
+type scriptList struct {
+	parse   func(string) (*fixtag.Script, error)
+	scripts []*fixtag.Script
+}
+
+func (l *scriptList) String() string {
+	var out []string
+	for _, s := range l.scripts {
+		out = append(out, s.String())
+	}
+	return strings.Join(out, ",")
+}
+
+func (l *scriptList) Set(value string) error {
+	s, err := l.parse(value)
+	if err != nil {
+		return err
+	}
+	l.scripts = append(l.scripts, s)
+	return nil
+}
+
+// The conversion thresholds by the frame id, "default" for all other frames.
+type thresholdList map[string]float64
+
+func (t *thresholdList) String() string {
+	var out []string
+	for key, v := range *t {
+		out = append(out, fmt.Sprintf("%s=%g", key, v))
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func (t *thresholdList) Set(value string) error {
+	if *t == nil {
+		*t = make(thresholdList)
+	}
+	for _, e := range parseList(value) {
+		i := strings.IndexByte(e, '=')
+		if i < 0 {
+			return fmt.Errorf("invalid threshold %q, must be FRAME=VALUE", e)
+		}
+		v, err := strconv.ParseFloat(e[i+1:], 64)
+		if err != nil {
+			return fmt.Errorf("invalid threshold %q: %v", e, err)
 		}
+		(*t)[strings.TrimSpace(e[:i])] = v
+	}
+	return nil
+}
+
+// The text of the frames set by hand, by their keys.
+type frameValues map[string]string
+
+func (v *frameValues) String() string {
+	var out []string
+	for key, text := range *v {
+		out = append(out, key+"="+text)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func (v *frameValues) Set(value string) error {
+	i := strings.IndexByte(value, '=')
+	if i <= 0 {
+		return fmt.Errorf("invalid frame %q, must be FRAME=TEXT", value)
 	}
-	if total == 0 {
-		return 1
+	if *v == nil {
+		*v = make(frameValues)
 	}
-	return float64(total-bad) / float64(total)
+	(*v)[strings.TrimSpace(value[:i])] = value[i+1:]
+	return nil
 }
 
-// the interface similar to that of encoding.Decoder and encoding.Encoder
-type StringTrans interface {
-	String(src string) (string, error)
+// The keys of the frames given with a repeated flag.
+type frameKeys []string
+
+func (k *frameKeys) String() string {
+	return strings.Join(*k, ",")
 }
 
-// Apply a number of transformations to the string.
-func decode(src string, tlist ...StringTrans) (string, error) {
-	for _, f := range tlist {
-		dst, err := f.String(src)
-		if err != nil && len(src) > 4 {
-			// Also try transforming with one byte at the end stripped.
-			src2 := src[0 : len(src)-1]
-			dst, err = f.String(src2)
-			if err != nil {
-				if *verbose > 1 {
-					fmt.Printf("  failed: %v\n", err)
-				}
-				return "", err
-			}
+func (k *frameKeys) Set(value string) error {
+	if value = strings.TrimSpace(value); value == "" {
+		return fmt.Errorf("empty frame key")
+	}
+	*k = append(*k, value)
+	return nil
+}
+
+// The words, the phrases or the separators, e.g. for -keep-case.
+type wordList []string
+
+func (w *wordList) String() string {
+	return strings.Join(*w, ",")
+}
+
+func (w *wordList) Set(value string) error {
+	if value = strings.TrimSpace(value); value == "" {
+		return fmt.Errorf("empty word")
+	}
+	*w = append(*w, value)
+	return nil
+}
+
+// The charset of the written ID3v1 tag, cp1251 if the flag is given without a value.
+type id3v1Flag string
+
+func (f *id3v1Flag) String() string {
+	return string(*f)
+}
+
+func (f *id3v1Flag) Set(value string) error {
+	switch value {
+	case "true":
+		*f = fixtag.ID3v1CP1251
+	case "false":
+		*f = ""
+	case fixtag.ID3v1CP1251, fixtag.ID3v1Translit:
+		*f = id3v1Flag(value)
+	default:
+		return fmt.Errorf("unknown ID3v1 charset %q", value)
+	}
+	return nil
+}
+
+// Allow -write-id3v1 without a value.
+func (f *id3v1Flag) IsBoolFlag() bool {
+	return true
+}
+
+// Parse the comma-separated list, skipping empty items.
+func parseList(list string) []string {
+	var out []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Get the file of the rules: the given one, or the default one if it
+// exists, or empty.
+func rulesFile(path string) string {
+	if path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	path = filepath.Join(dir, "fix-mp3-tag", "rules.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Load the rules from the file, or from the default one if it exists.
+func loadRules(path string) ([]*fixtag.Rule, error) {
+	if path = rulesFile(path); path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := fixtag.ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return rules, nil
+}
+
+// The user agent of the requests to the web services, e.g. MusicBrainz.
+const userAgent = "fix-mp3-tag ( https://github.com/bukind/fix-mp3-tag )"
+
+// Settings affecting the processing of a single file.
+type config struct {
+	level   slog.Level
+	format  string // of the log, see logPlain
+	fixer   *fixtag.Fixer
+	dirs    *dirFixers // the fixers of the directories with .fixtagrc
+	write   bool
+	backup  backupFlag
+	journal *journal  // nil if the journal is not kept
+	prompt  *prompter // nil if not interactive
+	plan    *plan     // nil if the plan is not saved
+	// Print all conversions tried for the frames.
+	showCandidates bool
+	// Set the tags of the files of the tracks of the CUE sheets.
+	cueTags bool
+	renames *renamer // nil if the names are not fixed
+	// Fill the empty frames from the file names if not nil.
+	namePattern *fixtag.NamePattern
+	acoustID    *fixtag.AcoustID // nil if the files are not identified
+	discogs     *fixtag.Discogs  // nil if the albums are not looked up
+	state       *watchState      // the processed files in the watch mode, nil otherwise
+	db          *stateDB         // the processed files of the previous runs, nil if not kept
+	review      *reviewServer    // nil if the frames are not reviewed
+	// Closed on the first interrupt, the files being processed are finished
+	// and the rest are not.
+	interrupted <-chan struct{}
+	// Done at the deadline of the run, see -timeout, the files being
+	// processed are not finished then.
+	ctx         context.Context
+	fileTimeout time.Duration // no timeout if 0
+	// The frames set and removed by hand.
+	set    map[string]string
+	remove []string
+	// The tags written over the old ones, counted for the summary.
+	inPlace atomic.Int64
+}
+
+// Convert the frames of the file with the fixer of its directory.
+func planFile(ctx context.Context, log *logger, cfg *config, path string) (*fixtag.Result, error) {
+	fixer, err := cfg.dirs.fixer(path)
+	if err != nil {
+		return nil, err
+	}
+	log.Headf(slog.LevelInfo, "processing file %q...", path)
+	return fixer.WithLogger(log).WithContext(ctx).Plan(path)
+}
+
+// Resolve, confirm and write the conversion results of a single file,
+// returning the results for all frames considered for conversion.
+func processFile(ctx context.Context, log *logger, cfg *config, res *fixtag.Result) ([]*fixtag.Field, error) {
+	path := res.File
+	results := res.Fields()
+	if cfg.showCandidates {
+		showCandidates(log, results)
+	}
+	if cfg.prompt != nil {
+		// Show the output so far before asking questions.
+		cfg.prompt.out.Write(log.buf.Bytes())
+		log.buf.Reset()
+		cfg.prompt.resolveAmbiguous(results)
+	}
+	fp := res.Plan()
+	if err := cfg.fixer.SortFrames(fp); err != nil {
+		return results, err
+	}
+	cfg.fixer.Transliterate(fp)
+	if len(fp.Frames) == 0 {
+		log.Printf(slog.LevelInfo, "cannot convert any frames, nothing to write back")
+		return results, nil
+	}
+	logPlan(log, cfg, fp)
+	if cfg.prompt != nil && !cfg.prompt.confirm(path, results) {
+		log.Printf(slog.LevelInfo, "skipped")
+		return results, nil
+	}
+	if !cfg.write {
+		if cfg.plan != nil {
+			cfg.plan.add(fp)
 		}
-		if *verbose > 1 {
-			fmt.Printf("  converted %s => %s\n", dump(src), dump(dst))
+		return results, nil
+	}
+	written, err := writePlan(ctx, log, cfg, fp)
+	if err != nil {
+		log.Errorf("failed %q: %s", path, err.Error())
+	}
+	setWritten(results, written, err)
+	return results, nil
+}
+
+// Check whether the file is a CUE sheet.
+func isCueSheet(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".cue")
+}
+
+// Set the tags of the files of the tracks of the CUE sheet, see fixtag.CueTags.
+func tagCueFiles(ctx context.Context, log *logger, cfg *config, res *fixtag.Result) ([]*fixtag.Field, error) {
+	plans, err := cfg.fixer.WithLogger(log).WithContext(ctx).CueTags(res)
+	if err != nil {
+		return nil, err
+	}
+	var results []*fixtag.Field
+	for _, fp := range plans {
+		log.Printf(slog.LevelInfo, "tags of %q from the CUE sheet:", fp.File)
+		fields, err := setTags(ctx, log, cfg, fp, "cue")
+		results = append(results, fields...)
+		if err != nil {
+			return results, err
 		}
-		src = dst
 	}
-	return src, nil
+	return results, nil
 }
 
-// Show the string with both symbol and hex representation.
-func dump(in string) string {
-	return fmt.Sprintf("%q [% x]", in, []byte(in))
+// Fill the empty frames of the file with the values from its name, see fixtag.NameTags.
+func tagFromName(ctx context.Context, log *logger, cfg *config, path string) ([]*fixtag.Field, error) {
+	fp, err := cfg.fixer.WithLogger(log).WithContext(ctx).NameTags(path, cfg.namePattern)
+	if err != nil || fp == nil || len(fp.Frames) == 0 {
+		return nil, err
+	}
+	log.Printf(slog.LevelInfo, "tags from the file name:")
+	return setTags(ctx, log, cfg, fp, "name")
+}
+
+// Set the lost frames of the file identified by its fingerprint, see fixtag.AcoustIDTags.
+func tagFromAcoustID(ctx context.Context, log *logger, cfg *config, res *fixtag.Result) ([]*fixtag.Field, error) {
+	fp, err := cfg.fixer.WithLogger(log).WithContext(ctx).AcoustIDTags(res, cfg.acoustID)
+	if err != nil || fp == nil || len(fp.Frames) == 0 {
+		return nil, err
+	}
+	log.Printf(slog.LevelInfo, "tags from the fingerprint:")
+	return setTags(ctx, log, cfg, fp, "acoustid")
+}
+
+// Correct the title and fill the year and the genre from Discogs, see fixtag.DiscogsTags.
+func tagFromDiscogs(ctx context.Context, log *logger, cfg *config, res *fixtag.Result) ([]*fixtag.Field, error) {
+	fp, err := cfg.fixer.WithLogger(log).WithContext(ctx).DiscogsTags(res, cfg.discogs)
+	if err != nil || fp == nil || len(fp.Frames) == 0 {
+		return nil, err
+	}
+	log.Printf(slog.LevelInfo, "tags from Discogs:")
+	return setTags(ctx, log, cfg, fp, "discogs")
+}
+
+// Set and remove the frames of the file by hand, see fixtag.EditTags.
+func editTags(ctx context.Context, log *logger, cfg *config, path string) ([]*fixtag.Field, error) {
+	fp, err := cfg.fixer.WithLogger(log).WithContext(ctx).EditTags(path, cfg.set, cfg.remove)
+	if err != nil || len(fp.Frames) == 0 {
+		return nil, err
+	}
+	log.Printf(slog.LevelInfo, "frames edited by hand:")
+	return setTags(ctx, log, cfg, fp, "set")
+}
+
+// Show the frames being set, and either write them or add them to the plan.
+// The fields set are returned with the action taken, counted in the summary
+// under the source given.
+func setTags(ctx context.Context, log *logger, cfg *config, fp *fixtag.FilePlan, source string) ([]*fixtag.Field, error) {
+	if err := cfg.fixer.SortFrames(fp); err != nil {
+		return nil, err
+	}
+	results := fp.Fields()
+	for _, res := range results {
+		res.Winner.Chain = source
+	}
+	logPlan(log, cfg, fp)
+	if cfg.prompt != nil {
+		cfg.prompt.out.Write(log.buf.Bytes())
+		log.buf.Reset()
+		if !cfg.prompt.confirm(fp.File, results) {
+			log.Printf(slog.LevelInfo, "skipped")
+			return nil, nil
+		}
+	}
+	if !cfg.write {
+		if cfg.plan != nil {
+			cfg.plan.add(fp)
+		}
+		return results, nil
+	}
+	written, err := writePlan(ctx, log, cfg, fp)
+	if err != nil {
+		log.Errorf("failed %q: %s", fp.File, err.Error())
+	}
+	setWritten(results, written, err)
+	return results, nil
 }
 
-// Extract potential frames to convert into a map.
-func extractFrames(tag *id3v2.Tag) (map[string]id3v2.TextFrame, error) {
-	out := make(map[string]id3v2.TextFrame)
-	// Get all frames
-	for key, framers := range tag.AllFrames() {
-		for _, frame := range framers {
-			tf, ok := frame.(id3v2.TextFrame)
-			if !ok {
-				// This is not a text frame.
-				// Since a single key cannot have different types of framers, we can break here.
-				break
+// Show the table of all conversions tried for every field, the winner marked with "*".
+func showCandidates(log *logger, results []*fixtag.Field) {
+	for _, res := range results {
+		if len(res.Attempts) == 0 {
+			continue
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "candidates for frame %q:\n", res.Name())
+		tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "  \tCHAIN\tCHARSET\tGOODNESS\tSCORE\tTEXT\n")
+		for _, a := range res.Attempts {
+			mark := ""
+			if res.Winner != nil && res.Winner.Chain == a.Chain {
+				mark = "*"
 			}
-			if tf.Text == "" {
+			if a.Err != nil {
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t-\t-\tfailed: %v\n", mark, a.Chain, a.Charset, a.Err)
 				continue
 			}
-			// Check that we only have a single text frame.
-			if len(framers) > 1 && *verbose > 0 {
-				fmt.Printf(" Warning: the text tag %q has %d frames\n", key, len(framers))
-				// We are going to use this frame anyway.
+			score := "-"
+			if a.Scored() {
+				score = fmt.Sprintf("%.2f", a.Score)
 			}
-			if !tf.Encoding.Equals(id3v2.EncodingISO) {
-				// We don't have to convert non-ISO frames.
-				if *verbose > 1 {
-					fmt.Printf(" frame %q encoding is not ISO, skipping\n", key)
-				}
-				continue
-			}
-			if countCyr(strings.TrimSpace(tf.Text)) >= 1 {
-				// If the result is already correct, skip it as well.
-				if *verbose > 1 {
-					fmt.Printf(" frame %q => %v is already correct\n", key, tf)
-				}
-				continue
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%.3f\t%s\t%q\n", mark, a.Chain, a.Charset, a.Goodness, score, a.Text)
+		}
+		tw.Flush()
+		log.Print(buf.String())
+	}
+}
+
+// Show the frames to be written.
+func logPlan(log *logger, cfg *config, fp *fixtag.FilePlan) {
+	for i := range fp.Frames {
+		if fp.Frames[i].Remove {
+			log.Printf(slog.LevelInfo, "frame to remove: %s", fp.Frames[i].ID)
+			continue
+		}
+		tf, _ := fp.Frames[i].TextFrame(cfg.fixer.Encoding())
+		log.Printf(slog.LevelInfo, "frame to write: %s %+v", fp.Frames[i].ID, tf.Framer(fp.Frames[i].ID))
+	}
+}
+
+// Write the planned frames into the file, making the backup and recording
+// the journal first if requested.  False is returned if the file is left as
+// it was because nothing would change, see fixtag.Options.TouchNothing.
+func writePlan(ctx context.Context, log *logger, cfg *config, fp *fixtag.FilePlan) (bool, error) {
+	// The file is backed up and journaled only if it is written.
+	var saved string
+	err := cfg.fixer.WithLogger(log).WithContext(ctx).ApplyFunc(fp, func(orig map[string][]fixtag.FrameData) error {
+		if cfg.backup.enabled() {
+			dst, err := cfg.backup.backup(fp.File)
+			if err != nil {
+				return err
 			}
-			if *verbose > 1 {
-				fmt.Printf(" frame %q found, encoding %v, text: %s\n", key, tf.Encoding, dump(tf.Text))
+			saved = dst
+			log.Printf(slog.LevelInfo, "backed up to %q", dst)
+		}
+		if cfg.journal != nil {
+			if err := cfg.journal.record(fp.File, orig); err != nil {
+				return fmt.Errorf("journal: %v", err)
 			}
-			out[key] = tf
-			break
 		}
+		return nil
+	})
+	if errors.Is(err, fixtag.ErrVerify) && saved != "" {
+		if rerr := cfg.backup.restore(fp.File, saved); rerr != nil {
+			return true, fmt.Errorf("%v, and not restored: %v", err, rerr)
+		}
+		log.Printf(slog.LevelWarn, "%v, restored from the backup", err)
+	}
+	if err == nil && fp.InPlace {
+		cfg.inPlace.Add(1)
 	}
-	return out, nil
+	return fp.Written, err
 }
 
-// Attempt to convert frames to utf8.
-// Only those that can be converted are returned.
-func convertFrames(frames map[string]id3v2.TextFrame) map[string]id3v2.TextFrame {
-	out := make(map[string]id3v2.TextFrame)
+// Set the action of the converted fields after writing them, see writePlan.
+// The fields of the file left as it was are kept converted, but not written.
+func setWritten(results []*fixtag.Field, written bool, err error) {
+	for _, res := range results {
+		switch {
+		case res.Action != fixtag.ActionConverted:
+		case err != nil:
+			res.Action, res.Err = fixtag.ActionWriteFailed, err
+		case written:
+			res.Action = fixtag.ActionWritten
+		}
+	}
+}
 
-	win := charmap.Windows1251.NewDecoder()
-	enc := charmap.Windows1251.NewEncoder()
-	iso := charmap.ISO8859_1.NewEncoder()
+// The exit codes of the program.
+const (
+	exitClean  = 0 // nothing needed fixing
+	exitFixed  = 1 // the fixes are written, or would be in the dry-run mode
+	exitFailed = 2 // some files failed
+	// Interrupted, the files being processed are finished.
+	exitInterrupted = 3
+)
 
-	combinations := []struct {
-		name  string
-		tlist []StringTrans
-	}{
-		{"win", []StringTrans{win}},
-		{"enc-iso-win", []StringTrans{enc, iso, win}},
-		{"iso-win", []StringTrans{iso, win}},
-		{"iso", []StringTrans{iso}}, // for incorrect encoding field.
+// Get the context done on the first SIGINT or SIGTERM, or with the parent.
+// The default handling of the signals is restored then, so that the second
+// one kills the program.
+func notifyInterrupt(parent context.Context) context.Context {
+	sig, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	// The program is stopped once the user is told what is going on.
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		<-sig.Done()
+		stop()
+		fmt.Fprintf(os.Stderr, "interrupted, finishing the files being processed; interrupt again to abort\n")
+		cancel()
+	}()
+	return ctx
+}
+
+// Get the next job from the queue, false if the queue is closed or the
+// program has been interrupted.
+func (cfg *config) next(queue <-chan job) (job, bool) {
+	select {
+	case <-cfg.interrupted:
+		return job{}, false
+	default:
+	}
+	select {
+	case <-cfg.interrupted:
+		return job{}, false
+	case j, ok := <-queue:
+		return j, ok
 	}
+}
 
-	for key, tf := range frames {
-		if *verbose > 1 {
-			fmt.Printf(" ------------------\n processing frame %q...\n", key)
+// Process the file of the job, planning it unless already planned.
+func processJob(ctx context.Context, log *logger, cfg *config, j *job) ([]*fixtag.Field, error) {
+	if j.plan != nil {
+		return applyPlan(ctx, log, cfg, j.plan)
+	}
+	var err error
+	if j.result == nil {
+		if j.result, err = planFile(ctx, log, cfg, j.path); err != nil {
+			return nil, err
 		}
-		value := strings.TrimSpace(tf.Text)
-		best := 0.0
-		var newvals []string
-		for _, cmb := range combinations {
-			if *verbose > 1 {
-				fmt.Printf(" attempting %s...\n", cmb.name)
+	}
+	if j.result.Skipped {
+		return nil, nil
+	}
+	results, err := processFile(ctx, log, cfg, j.result)
+	// The tags set from every source are counted along with the converted ones.
+	more := func(fields []*fixtag.Field, ferr error) {
+		results, err = append(results, fields...), ferr
+	}
+	if err == nil && cfg.cueTags && isCueSheet(j.path) {
+		more(tagCueFiles(ctx, log, cfg, j.result))
+	}
+	if err == nil && cfg.namePattern != nil && !hasExtension(j.path, fixtag.PlaylistExtensions()) {
+		more(tagFromName(ctx, log, cfg, j.path))
+	}
+	if err == nil && cfg.acoustID != nil && !hasExtension(j.path, fixtag.PlaylistExtensions()) {
+		more(tagFromAcoustID(ctx, log, cfg, j.result))
+	}
+	if err == nil && cfg.discogs != nil && !hasExtension(j.path, fixtag.PlaylistExtensions()) {
+		more(tagFromDiscogs(ctx, log, cfg, j.result))
+	}
+	if err == nil && (len(cfg.set) > 0 || len(cfg.remove) > 0) && !hasExtension(j.path, fixtag.PlaylistExtensions()) {
+		more(editTags(ctx, log, cfg, j.path))
+	}
+	return results, err
+}
+
+// The processing of a file has not finished in time, see withTimeout.
+var errTimeout = errors.New("timed out")
+
+// Run the processing of a file with the context done at the per-file timeout
+// or at the deadline of the run, if any.  A file not done by then, e.g. hanging
+// on a dead network mount, is left to the goroutine processing it, and
+// errTimeout is returned; the logger and the results of the file are not to
+// be used then.  The files are written under a fixtag.WriteGuard, which is
+// stopped first, waiting for the write in progress, if any: nothing is
+// written once errTimeout is returned.  If the file has been written by then,
+// its processing is waited for instead, so that its results are reported.
+func (cfg *config) withTimeout(fn func(ctx context.Context) error) error {
+	ctx := cfg.ctx
+	if cfg.fileTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.fileTimeout)
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return fn(ctx)
+	}
+	var guard fixtag.WriteGuard
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(fixtag.WithWriteGuard(ctx, &guard))
+	}()
+	select {
+	case err := <-done:
+		if ctx.Err() == nil || !errors.Is(err, ctx.Err()) {
+			return err
+		}
+	case <-ctx.Done():
+		if guard.Stop() {
+			return <-done
+		}
+		// The file may have been done just now.
+		select {
+		case err := <-done:
+			if !errors.Is(err, ctx.Err()) {
+				return err
 			}
-			val, err := decode(value, cmb.tlist...)
-			if err != nil {
-				continue
+		default:
+		}
+	}
+	if cfg.ctx.Err() != nil {
+		return fmt.Errorf("%w, the run is past -timeout", errTimeout)
+	}
+	return fmt.Errorf("%w after %v", errTimeout, cfg.fileTimeout)
+}
+
+// Process the queued files with the given number of workers, returning the
+// totals.  The output of every file is printed at once when the file is done.
+// If the report is not nil, the results are added to it.  The progress is
+// shown if not nil.
+func processFiles(cfg *config, workers int, queue <-chan job, logOut io.Writer, rep reports, prog *progress) *stats {
+	st := newStats()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				// The rest of the queue is left on the interrupt.
+				j, ok := cfg.next(queue)
+				if !ok {
+					return
+				}
+				if cfg.prompt != nil && cfg.prompt.quit {
+					// Drain the queue.
+					continue
+				}
+				j.fetch()
+				err := j.err
+				log := j.log
+				if log == nil {
+					log = newLogger(cfg.level, cfg.format, j.path)
+				}
+				if j.unchanged {
+					log.Headf(slog.LevelDebug, "file %q has not changed since the last run, skipping", j.path)
+					mu.Lock()
+					if prog != nil && log.buf.Len() > 0 {
+						prog.clear()
+					}
+					logOut.Write(log.buf.Bytes())
+					st.Unchanged++
+					if prog != nil {
+						prog.step()
+					}
+					mu.Unlock()
+					continue
+				}
+				var results []*fixtag.Field
+				if err == nil {
+					// The job is copied, so that the file timing out may be
+					// left to the goroutine processing it.
+					jj := j
+					var res []*fixtag.Field
+					err = cfg.withTimeout(func(ctx context.Context) error {
+						var err error
+						res, err = processJob(ctx, log, cfg, &jj)
+						return err
+					})
+					if errors.Is(err, errTimeout) {
+						log = newLogger(cfg.level, cfg.format, j.path)
+						j.result = nil
+					} else {
+						j, results = jj, res
+					}
+				}
+				skipped := j.result != nil && j.result.Skipped
+				if cfg.renames != nil && j.err == nil && !skipped {
+					cfg.renames.add(log, j.path, j.root, j.result)
+				}
+				if cfg.review != nil && j.result != nil {
+					cfg.review.add(j.result)
+				}
+				name := j.path
+				if j.staged != nil {
+					// The original is written back whatever has been written locally.
+					name = j.staged.String()
+					stored, serr := j.staged.store(j.path)
+					switch {
+					case serr != nil && err == nil:
+						err = fmt.Errorf("cannot write back: %w", serr)
+					case stored:
+						log.Printf(slog.LevelInfo, "written back to %s", name)
+					}
+				}
+				// The file left to fix is tried again on its next change or
+				// the next start.
+				if cfg.state != nil && err == nil && !pending(results) {
+					if err := cfg.state.done(j.path); err != nil {
+						log.Printf(slog.LevelWarn, "cannot save the watch state: %v", err)
+					}
+				}
+				if cfg.db != nil && err == nil && j.plan == nil {
+					if err := cfg.db.record(j.path, results); err != nil {
+						log.Printf(slog.LevelWarn, "cannot save the state: %v", err)
+					}
+				}
+				mu.Lock()
+				if prog != nil && (log.buf.Len() > 0 || log.errs.Len() > 0 || err != nil) {
+					prog.clear()
+				}
+				log.flush(logOut)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: failed: %v\n", name, err)
+				}
+				st.add(name, cfg.write, j.result, results, err)
+				rep.add(name, results)
+				if prog != nil {
+					prog.step()
+				}
+				mu.Unlock()
 			}
-			goodness := countCyr(val)
-			if goodness > best {
-				best = goodness
+		}()
+	}
+	wg.Wait()
+	st.InPlace = int(cfg.inPlace.Swap(0))
+	return st
+}
+
+// Convert all files from the queue first, then resolve the ambiguous fields
+// with the consensus of the files of the same album, and fill the empty
+// album artists, see -album and -album-artist.  The files are queued for
+// the rest of the processing in the original order.
+func planAlbums(cfg *config, workers int, in <-chan job) <-chan job {
+	var all []job
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := cfg.next(in)
+				if !ok {
+					return
+				}
+				j.fetch()
+				if j.err == nil && !j.unchanged {
+					log := newLogger(cfg.level, cfg.format, j.path)
+					var res *fixtag.Result
+					j.err = cfg.withTimeout(func(ctx context.Context) error {
+						var err error
+						res, err = planFile(ctx, log, cfg, j.path)
+						return err
+					})
+					if errors.Is(j.err, errTimeout) {
+						log = newLogger(cfg.level, cfg.format, j.path)
+					} else {
+						j.result = res
+					}
+					j.log = log
+				}
+				mu.Lock()
+				all = append(all, j)
+				mu.Unlock()
 			}
-			if goodness < *threshold {
-				if *verbose > 1 {
-					fmt.Printf("  failed (bad result %f)!\n", goodness)
+		}()
+	}
+	wg.Wait()
+
+	albums := make(map[string][]*fixtag.Result)
+	for _, j := range all {
+		if j.result != nil {
+			key := j.result.AlbumKey()
+			albums[key] = append(albums[key], j.result)
+		}
+	}
+	out := make(chan job, len(all))
+	if *album {
+		for _, j := range all {
+			if j.result != nil {
+				cs := fixtag.Consensus(albums[j.result.AlbumKey()])
+				for _, res := range j.result.Prefer(cs) {
+					j.log.Printf(slog.LevelInfo, "frame %q resolved by the album consensus (%s): %q", res.Name(), cs, res.Winner.Text)
 				}
-				continue
 			}
-			if *verbose > 1 {
-				fmt.Printf(" frame %q converted to %q, goodness %f\n", key, val, goodness)
+		}
+	}
+	if *albArtist {
+		artists := make(map[string]string)
+		for key, results := range albums {
+			artist, err := fixtag.AlbumArtist(results, *various)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "cannot get the album artist of %q: %v\n", filepath.Dir(results[0].File), err)
 			}
-			newvals = append(newvals, val)
-		}
-		switch len(newvals) {
-		case 0:
-			fmt.Printf(" Warning: could not convert frame %s, best result is %f\n", key, best)
-		case 1:
-			out[key] = id3v2.TextFrame{
-				Encoding: id3v2.EncodingUTF8,
-				Text:     newvals[0],
+			artists[key] = artist
+		}
+		for _, j := range all {
+			if j.result != nil {
+				if _, err := cfg.fixer.WithLogger(j.log).FillAlbumArtist(j.result, artists[j.result.AlbumKey()]); err != nil {
+					j.log.Printf(slog.LevelWarn, "cannot fill the album artist: %v", err)
+				}
 			}
-		default:
-			fmt.Printf(" Warning: ambiguous conversion for frame %s -- got %d possible results, best is %f\n", key, len(newvals), best)
 		}
 	}
+	for _, j := range all {
+		out <- j
+	}
+	close(out)
 	return out
 }
 
-// Save frames back into mp3.
-func saveFrames(tag *id3v2.Tag, frames map[string]id3v2.TextFrame) error {
-	for key, tf := range frames {
-		tag.AddTextFrame(key, tf.Encoding, tf.Text)
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "undo" {
+		runUndo(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		runDump(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "scan" {
+		runScan(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "copy" {
+		runCopy(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
 	}
-	return tag.Save()
-}
 
-func processFile(path string) error {
-	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	args, dir, err := resumeArgs(os.Args[1:])
+	if err == nil && dir != "" {
+		err = os.Chdir(dir)
+	}
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "cannot resume: %v\n", err)
+		os.Exit(exitFailed)
 	}
-	defer tag.Close()
-	if *verbose > 0 {
-		fmt.Printf("processing file %q...\n", path)
+	flag.CommandLine.Parse(args)
+	if path := configPath(*cfgPath); path != "" {
+		if err := loadConfig(path); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			os.Exit(exitFailed)
+		}
 	}
-
-	frames, err := extractFrames(tag)
+	enc, err := fixtag.EncodingByName(*targetEnc)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailed)
 	}
-	if *verbose > 0 {
-		fmt.Printf(" %d frames to convert found\n", len(frames))
+	var v byte
+	if *version != "" {
+		if v, err = fixtag.ParseVersion(*version); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
 	}
-
-	frames = convertFrames(frames)
-	if len(frames) == 0 {
-		if *verbose > 0 {
-			fmt.Printf(" cannot convert any frames, nothing to write back\n")
+	thresholds := make(map[string]float64)
+	for key, t := range perFrame {
+		if key == "default" {
+			*threshold = t
+			continue
 		}
-		return nil
+		thresholds[key] = t
 	}
-	if *verbose > 0 {
-		fmt.Printf(" frames to write: %v\n", frames)
+	var words string
+	if *wordsPath != "" {
+		data, err := os.ReadFile(*wordsPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		words = string(data)
 	}
-	if *doWrite {
-		if err := saveFrames(tag, frames); err != nil {
-			fmt.Printf("failed %q: %s\n", path, err.Error())
+	var models []string
+	for _, path := range parseList(*ngramPath) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
 		}
+		models = append(models, string(data))
+	}
+	if *replFile != "" {
+		data, err := os.ReadFile(*replFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		rs, err := fixtag.ParseReplacements(string(data))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *replFile, err)
+			os.Exit(exitFailed)
+		}
+		replaces = append(replaces, rs...)
+	}
+	rules, err := loadRules(*rulesPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailed)
+	}
+	var lookup fixtag.Lookup
+	if *mbLookup {
+		lookup = fixtag.NewMusicBrainz(userAgent)
+	}
+	if *maxReadMB < 0 || *maxIOPS < 0 {
+		fmt.Fprintln(os.Stderr, "-max-read-mbps and -max-iops cannot be negative")
+		os.Exit(exitFailed)
+	}
+	var scorer fixtag.Scorer
+	if *accRanges != "" {
+		if scorer, err = fixtag.NewRangeScorer(*accRanges); err != nil {
+			fmt.Fprintf(os.Stderr, "-accept-ranges: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	var clean *fixtag.Cleanup
+	if *cleanup != "" || *punctMap != "" {
+		if clean, err = fixtag.ParseCleanup(*cleanup); err != nil {
+			fmt.Fprintf(os.Stderr, "-cleanup: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		if *punctMap != "" {
+			if clean.Punct, err = fixtag.ParsePunctuation(*punctMap); err != nil {
+				fmt.Fprintf(os.Stderr, "-cleanup-punct: %v\n", err)
+				os.Exit(exitFailed)
+			}
+		}
+	}
+	var hook fixtag.Hook
+	if *hookCmd != "" {
+		hook = fixtag.NewCommandHook(*hookCmd)
+	}
+	var throttle fixtag.Throttle
+	if *maxReadMB > 0 || *maxIOPS > 0 {
+		throttle = fixtag.NewThrottle(*maxReadMB*1e6, *maxIOPS)
+	}
+	opts := fixtag.Options{
+		Threshold:       *threshold,
+		Thresholds:      thresholds,
+		Chains:          chains,
+		Languages:       parseList(*langs),
+		Models:          models,
+		Scorer:          scorer,
+		Detect:          *detect,
+		ReplaceInvalid:  *replBad,
+		Entities:        *entities,
+		Segments:        *segments,
+		Encoding:        enc,
+		Version:         v,
+		StripID3v1:      *stripV1,
+		PreserveTimes:   *keepMtime,
+		Verify:          *verify,
+		CheckAudio:      *chkAudio,
+		TouchNothing:    *touchNone,
+		WriteID3v1:      string(writeV1),
+		Translit:        *translit,
+		Cleanup:         clean,
+		Capitalize:      *capital,
+		KeepCase:        keepCase,
+		ArtistSplit:     artSplit,
+		ArtistSeparator: *artistSep,
+		Replace:         replaces,
+		Accept:          accepts.scripts,
+		Transform:       transfrms.scripts,
+		Rules:           rules,
+		Frames:          parseList(*onlyFrms),
+		SkipFrames:      parseList(*skipFrms),
+		FixGenre:        *fixGenre,
+		Numbers:         *fixNums,
+		FixDates:        *fixDates,
+		SortFrames:      *sortFrms,
+		Untranslit:      *untransl,
+		Words:           words,
+		Lookup:          lookup,
+		Hook:            hook,
+		Throttle:        throttle,
+	}
+	fixer, err := fixtag.New(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailed)
 	}
-	return nil
-}
 
-func main() {
-	flag.Parse()
-	if *threshold < 0.1 || *threshold > 1 {
-		fmt.Fprintf(os.Stderr, "Invalid value of threshold (%f), must be in range [0.1, 1]\n", *threshold)
-		os.Exit(1)
+	if *applyPath != "" {
+		if len(flag.Args()) > 0 || *filesFrom != "" || *interact || *fixNames || *rename != "" {
+			fmt.Fprintln(os.Stderr, "the plan cannot be combined with other files, the interactive mode, -fix-filenames or -rename")
+			os.Exit(exitFailed)
+		}
+		*doWrite = true
+	}
+	if *fromStdin {
+		switch {
+		case len(flag.Args()) > 0 || *filesFrom != "" || *applyPath != "" || len(watchDirs) > 0:
+			fmt.Fprintln(os.Stderr, "-stdin cannot be combined with other files, the plan or the watch mode")
+			os.Exit(exitFailed)
+		case *interact || *fixNames || *rename != "" || *jrnPath != "" || *dbPath != "" || *planPath != "" || backup.enabled():
+			fmt.Fprintln(os.Stderr, "-stdin cannot be combined with -i, -fix-filenames, -rename, -journal, -state, -plan or -backup")
+			os.Exit(exitFailed)
+		case jsonOut != "" || *summary == "-":
+			fmt.Fprintln(os.Stderr, "-stdin cannot print the report or the summary to stdout")
+			os.Exit(exitFailed)
+		}
+		// The file is written in a temporary copy.
+		*doWrite = true
 	}
 
 	if !*doWrite && *verbose <= 0 {
 		// In a dry-run mode we'd like to see at least some output.
 		*verbose = 1
 	}
+	level, err := logLevel(*logLvl, *verbose)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(exitFailed)
+	}
+	switch *logFormat {
+	case logPlain, logText, logJSON:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown log format %q\n", *logFormat)
+		os.Exit(exitFailed)
+	}
 
-	if len(flag.Args()) == 0 {
+	var db *stateDB
+	if *dbPath != "" {
+		db, err = openState(*dbPath, optionsHash())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the state: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		defer db.close()
+	}
+
+	if len(watchDirs) > 0 {
+		switch {
+		case len(flag.Args()) > 0 || *filesFrom != "" || *applyPath != "":
+			fmt.Fprintln(os.Stderr, "the watch mode cannot be combined with other files or the plan")
+			os.Exit(exitFailed)
+		case !*doWrite:
+			fmt.Fprintln(os.Stderr, "the watch mode requires -w")
+			os.Exit(exitFailed)
+		case *interact || *album || *albArtist || *fixNames || *rename != "":
+			fmt.Fprintln(os.Stderr, "the watch mode cannot be combined with -i, -album, -album-artist, -fix-filenames or -rename")
+			os.Exit(exitFailed)
+		case *watchWait <= 0:
+			fmt.Fprintln(os.Stderr, "the watch delay must be positive")
+			os.Exit(exitFailed)
+		}
+		*recursive = true
+	} else if *review != "" {
+		fmt.Fprintln(os.Stderr, "the review page is only served in the watch mode")
+		os.Exit(exitFailed)
+	} else if len(flag.Args()) == 0 && *filesFrom == "" && *applyPath == "" && !*fromStdin {
 		fmt.Fprintln(os.Stderr, "please specify at least one mp3")
-		os.Exit(1)
+		os.Exit(exitFailed)
+	}
+	// The local copies of the remote and archived files are removed once
+	// processed.  The remote files listed by -files-from are refused one by
+	// one, see fileSource.
+	var stagedErr error
+	switch {
+	case *fixNames || *rename != "" || *jrnPath != "" || *dbPath != "" || *planPath != "":
+		stagedErr = errors.New("the remote and archived files cannot be combined with -fix-filenames, -rename, -journal, -state or -plan")
+	case backup.suffix != "":
+		stagedErr = errors.New("the remote and archived files can only be backed up into a directory")
+	}
+	if stagedErr != nil && (hasRemote(flag.Args()) || *zips) {
+		fmt.Fprintln(os.Stderr, stagedErr)
+		os.Exit(exitFailed)
+	}
+
+	extensions := parseExtensions(*exts)
+	if *playlists {
+		extensions = append(extensions, fixtag.PlaylistExtensions()...)
+	}
+	if *recursive && len(extensions) == 0 {
+		fmt.Fprintln(os.Stderr, "please specify at least one extension")
+		os.Exit(exitFailed)
+	}
+
+	if *interact && !*doWrite {
+		fmt.Fprintln(os.Stderr, "interactive mode requires -w")
+		os.Exit(exitFailed)
+	}
+	if *summary == "-" && jsonOut != "" {
+		fmt.Fprintln(os.Stderr, "the summary cannot be printed to stdout with the JSON report")
+		os.Exit(exitFailed)
+	}
+	if *interact && *quiet {
+		fmt.Fprintln(os.Stderr, "interactive mode cannot be quiet")
+		os.Exit(exitFailed)
+	}
+	if *interact && *filesFrom == "-" {
+		fmt.Fprintln(os.Stderr, "interactive mode cannot read the list of files from stdin")
+		os.Exit(exitFailed)
 	}
 
-	for _, image := range flag.Args() {
-		if err := processFile(image); err != nil {
-			fmt.Fprintln(os.Stderr, "%s: failed: %v", image, err)
+	if *fileTmout < 0 || *timeout < 0 {
+		fmt.Fprintln(os.Stderr, "-file-timeout and -timeout cannot be negative")
+		os.Exit(exitFailed)
+	}
+	if *interact && (*fileTmout > 0 || *timeout > 0) {
+		fmt.Fprintln(os.Stderr, "interactive mode cannot have -file-timeout or -timeout")
+		os.Exit(exitFailed)
+	}
+	if *niceness != 0 {
+		if *niceness < 0 || *niceness > 19 {
+			fmt.Fprintf(os.Stderr, "invalid -nice %d, must be from 1 to 19\n", *niceness)
+			os.Exit(exitFailed)
+		}
+		if err := setNice(*niceness); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set the niceness: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+
+	workers := *jobs
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if *interact {
+		workers = 1
+	}
+
+	cfg := &config{
+		level:  level,
+		format: *logFormat,
+		fixer:  fixer,
+		dirs:   newDirFixers(opts, fixer),
+		write:  *doWrite,
+		backup: backup,
+
+		showCandidates: *showCands,
+		cueTags:        *cueTags,
+		set:            setFrms,
+		remove:         delFrms,
+		db:             db,
+	}
+	if db != nil {
+		db.dirOptions = cfg.dirs.options
+	}
+	if *jrnPath != "" && *doWrite {
+		j, err := openJournal(*jrnPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the journal: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		defer j.Close()
+		cfg.journal = j
+	}
+	// With the JSON report or the file on stdout, the human readable output
+	// goes to stderr.
+	var out io.Writer = os.Stdout
+	var rep reports
+	if jsonOut != "" || *fromStdin {
+		out = os.Stderr
+	}
+	if jsonOut != "" {
+		rep = append(rep, newReport(os.Stdout, jsonOut))
+	}
+	if *csvPath != "" {
+		f, err := os.Create(*csvPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create the report: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		defer f.Close()
+		rep = append(rep, newReport(f, reportCSV))
+	}
+	if *htmlPath != "" {
+		f, err := os.Create(*htmlPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create the report: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		defer f.Close()
+		rep = append(rep, newReport(f, reportHTML))
+	}
+	if *quiet {
+		out = io.Discard
+	}
+	logOut := out
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the log: %v\n", err)
+			os.Exit(exitFailed)
 		}
+		defer f.Close()
+		logOut = f
+	}
+	if *interact {
+		cfg.prompt = newPrompter(os.Stdin, out)
+	}
+	if *planPath != "" && !*doWrite {
+		cfg.plan = newPlan()
+	}
+	if *acoustKey != "" {
+		a, err := fixtag.NewAcoustID(*acoustKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		cfg.acoustID = a
+	}
+	if *discogs {
+		if *dcToken == "" {
+			fmt.Fprintln(os.Stderr, "-discogs needs the token, see -discogs-token")
+			os.Exit(exitFailed)
+		}
+		cfg.discogs = fixtag.NewDiscogs(*dcToken, userAgent)
+	}
+	if *fromName != "" {
+		p, err := fixtag.ParseNamePattern(*fromName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		cfg.namePattern = p
+	}
+	if *fixNames && *rename != "" {
+		fmt.Fprintln(os.Stderr, "-fix-filenames cannot be combined with -rename")
+		os.Exit(exitFailed)
+	}
+	if *rename != "" {
+		t, err := parseTemplate(*rename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(exitFailed)
+		}
+		cfg.renames = newRenamer(t)
+	} else if *fixNames {
+		cfg.renames = newRenamer(nil)
+	}
+
+	queue := make(chan job, workers)
+	var stdinPath string // the copy of the file from stdin, see -stdin
+	src := &fileSource{
+		recursive:  *recursive,
+		extensions: extensions,
+		excludes:   excludes,
+		filesFrom:  *filesFrom,
+		zips:       *zips,
+		stagedErr:  stagedErr,
+	}
+	// The files being processed are finished on the first interrupt, and the
+	// summary is printed; the watch mode is stopped this way.
+	cfg.ctx = context.Background()
+	cfg.fileTimeout = *fileTmout
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		cfg.ctx, cancel = context.WithTimeout(cfg.ctx, *timeout)
+		defer cancel()
+	}
+	ctx := notifyInterrupt(cfg.ctx)
+	cfg.interrupted = ctx.Done()
+	if *applyPath != "" {
+		entries, err := readPlan(*applyPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read the plan: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		go queuePlan(entries, queue)
+	} else if len(watchDirs) > 0 {
+		state, err := loadState(*statePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load the watch state: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		cfg.state = state
+		w, err := newWatcher(src, state, *watchWait)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to watch: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		if *review != "" {
+			cfg.review = newReviewServer(cfg, logOut)
+			ln, err := net.Listen("tcp", *review)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to serve the review page: %v\n", err)
+				os.Exit(exitFailed)
+			}
+			srv := &http.Server{Handler: cfg.review}
+			go srv.Serve(ln)
+			defer srv.Close()
+			fmt.Fprintf(out, "review the frames at http://%s/\n", ln.Addr())
+		}
+		fmt.Fprintf(out, "watching %s, press Ctrl-C to stop...\n", strings.Join(watchDirs, ", "))
+		go w.run(ctx, watchDirs, queue)
+	} else if *fromStdin {
+		path, err := readStdin()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read stdin: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		stdinPath = path
+		go func() {
+			queue <- job{path: path}
+			close(queue)
+		}()
+	} else {
+		if cfg.db != nil && !*resume {
+			if err := cfg.db.begin(os.Args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to save the state: %v\n", err)
+				os.Exit(exitFailed)
+			}
+		}
+		go src.queueFiles(flag.Args(), queue)
+	}
+	var in <-chan job = queue
+	if cfg.db != nil {
+		in = cfg.db.mark(in, *resume)
+	}
+	if (*album || *albArtist) && *applyPath == "" {
+		in = planAlbums(cfg, workers, in)
+	}
+	// The progress is only shown to a human watching the terminal.
+	var prog *progress
+	if isTerminal(os.Stdout) && jsonOut == "" && !*fromStdin && !*quiet && !*interact && len(watchDirs) == 0 {
+		prog = newProgress(os.Stdout)
+		in = prog.count(in)
+	}
+	st := processFiles(cfg, workers, in, logOut, rep, prog)
+	if err := src.cleanup(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed: %v\n", err)
+		st.Errors++
+	}
+	if stdinPath != "" {
+		// The file is written whole even if it has not been fixed.
+		if err := writeStdout(stdinPath); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write stdout: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	if prog != nil {
+		prog.clear()
+	}
+	if cfg.renames != nil {
+		cfg.renames.run(cfg, logOut, st)
+	}
+	st.print(out)
+	if *summary != "" {
+		if err := st.save(*summary); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save the summary: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	if cfg.plan != nil {
+		if err := cfg.plan.save(*planPath); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save the plan: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	if err := rep.close(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write the report: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	// The batch quit by the user is left to be resumed too.
+	quit := ctx.Err() != nil || cfg.prompt != nil && cfg.prompt.quit
+	if cfg.db != nil && !quit && *applyPath == "" && len(watchDirs) == 0 {
+		if err := cfg.db.finish(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save the state: %v\n", err)
+			os.Exit(exitFailed)
+		}
+	}
+	if cfg.ctx.Err() != nil {
+		fmt.Fprintf(os.Stderr, "stopped after -timeout %v\n", *timeout)
+	}
+	if ctx.Err() != nil && len(watchDirs) == 0 {
+		os.Exit(exitInterrupted)
 	}
+	os.Exit(st.status())
 }
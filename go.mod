@@ -1,8 +1,22 @@
 module github.com/bukind/fix-mp3-tag
 
-go 1.18
+go 1.23.0
 
 require (
 	github.com/bogem/id3v2 v1.2.0
-	golang.org/x/text v0.3.7
+	golang.org/x/text v0.28.0
+)
+
+require (
+	github.com/BurntSushi/toml v1.3.2
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/pkg/sftp v1.13.9
+	github.com/saintfish/chardet v0.0.0-20230101081208-5e3ef4b5456d
+	golang.org/x/crypto v0.41.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 )
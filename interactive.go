@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The prompter asks the user to confirm the changes of every file
+// and to resolve ambiguous conversions.
+type prompter struct {
+	in   *bufio.Reader
+	out  io.Writer
+	all  bool // the user has accepted all remaining files
+	quit bool // the user has asked to stop
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+// Read a line of the answer.  At the end of input the prompter quits.
+func (p *prompter) readAnswer() string {
+	line, err := p.in.ReadString('\n')
+	if err != nil && line == "" {
+		p.quit = true
+		return "q"
+	}
+	return strings.TrimSpace(line)
+}
+
+// Let the user pick one of the candidates for every ambiguous frame.
+// The chosen frames become converted.
+func (p *prompter) resolveAmbiguous(results []*fixtag.Field) {
+	for _, res := range results {
+		if res.Action != fixtag.ActionAmbiguous || p.quit {
+			continue
+		}
+		fmt.Fprintf(p.out, " frame %s is ambiguous, original %s\n", res.Name(), fixtag.Dump(res.Orig))
+		for i, c := range res.Candidates {
+			fmt.Fprintf(p.out, "  %d) %q (%s, goodness %f)\n", i+1, c.Text, c.Chain, c.Goodness)
+		}
+		for {
+			fmt.Fprintf(p.out, " choose [1-%d], or 0 to leave the frame as is: ", len(res.Candidates))
+			answer := p.readAnswer()
+			if p.quit {
+				break
+			}
+			n, err := strconv.Atoi(answer)
+			if err != nil || n < 0 || n > len(res.Candidates) {
+				continue
+			}
+			if n > 0 {
+				res.Winner = res.Candidates[n-1]
+				res.Action = fixtag.ActionConverted
+			}
+			break
+		}
+	}
+}
+
+// Show the changes for the file and ask whether to save them.
+func (p *prompter) confirm(path string, results []*fixtag.Field) bool {
+	if p.quit {
+		return false
+	}
+	fmt.Fprintf(p.out, "changes for %q:\n", path)
+	for _, res := range results {
+		if res.Action != fixtag.ActionConverted {
+			continue
+		}
+		fmt.Fprintf(p.out, " %s: %q => %q\n", res.Name(), res.Orig, res.Winner.Text)
+	}
+	if p.all {
+		return true
+	}
+	for {
+		fmt.Fprintf(p.out, "save? [y/n/a/q]: ")
+		switch strings.ToLower(p.readAnswer()) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		case "a", "all":
+			p.all = true
+			return true
+		case "q", "quit":
+			p.quit = true
+			return false
+		}
+	}
+}
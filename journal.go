@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The journal records the original frames of every written file, so that
+// the changes can be undone later.  It is a file of JSON lines, appended to
+// before every write.
+type journal struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// A journal record for a single file.
+// It contains all original frames with the keys being overwritten,
+// and the keys of the frames being added, e.g. from the ID3v1 tag.
+// If the file is renamed instead, the new path is recorded, see -fix-filenames.
+type journalEntry struct {
+	File    journalPath    `json:"file"`
+	Frames  []journalFrame `json:"frames"`
+	Added   []string       `json:"added,omitempty"`
+	Renamed journalPath    `json:"renamed,omitempty"`
+}
+
+// The path in the journal.  The broken names are not valid UTF-8, so they are
+// recorded as the base64 of the bytes, e.g. {"bytes":"yujt7g=="}.
+type journalPath string
+
+type rawPath struct {
+	Bytes []byte `json:"bytes"`
+}
+
+func (p journalPath) MarshalJSON() ([]byte, error) {
+	if utf8.ValidString(string(p)) {
+		return json.Marshal(string(p))
+	}
+	return json.Marshal(rawPath{Bytes: []byte(p)})
+}
+
+func (p *journalPath) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var raw rawPath
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		*p = journalPath(raw.Bytes)
+		return nil
+	}
+	return json.Unmarshal(data, (*string)(p))
+}
+
+// The original contents of a single frame.
+type journalFrame struct {
+	ID string `json:"id"`
+	fixtag.FrameData
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f}, nil
+}
+
+// Record the original frames which are going to be overwritten in the file,
+// all frames with the keys being written, see fixtag.Fixer.ApplyFunc.
+// The record is synced to disk before returning.
+func (j *journal) record(path string, orig map[string][]fixtag.FrameData) error {
+	e := journalEntry{File: journalPath(path)}
+	keys := make([]string, 0, len(orig))
+	for key := range orig {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if len(orig[key]) == 0 {
+			e.Added = append(e.Added, key)
+		}
+		for _, d := range orig[key] {
+			e.Frames = append(e.Frames, journalFrame{ID: key, FrameData: d})
+		}
+	}
+	return j.write(e)
+}
+
+// Record the rename of the file or the directory.
+func (j *journal) recordRename(path, target string) error {
+	return j.write(journalEntry{File: journalPath(path), Renamed: journalPath(target)})
+}
+
+// Append the entry and sync it to disk.
+func (j *journal) write(e journalEntry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+// Read all entries from the journal file.
+func readJournal(path string) ([]journalEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []journalEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e journalEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, sc.Err()
+}
+
+// Restore the original frames of the file from the journal entry.
+// The frames with the recorded keys are replaced completely,
+// and the added frames are removed.  The renamed file gets its name back.
+func undoEntry(e journalEntry) error {
+	if e.Renamed != "" {
+		if _, err := os.Lstat(string(e.File)); err == nil {
+			return fmt.Errorf("cannot rename %q back, the file exists", e.Renamed)
+		}
+		return os.Rename(string(e.Renamed), string(e.File))
+	}
+	frames := make(map[string][]fixtag.FrameData)
+	for _, key := range e.Added {
+		frames[key] = nil
+	}
+	for _, jf := range e.Frames {
+		frames[jf.ID] = append(frames[jf.ID], jf.FrameData)
+	}
+	return fixtag.RestoreFrames(string(e.File), frames)
+}
+
+// The undo subcommand: restore the frames recorded in the journals.
+// The entries are undone from the latest to the earliest, so that a file
+// written several times gets its very first contents back.
+func runUndo(args []string) {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	verbose := fs.Int("v", 0, "Increase verbosity")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s undo [flags] <journal>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		entries, err := readJournal(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", path, err)
+			failed = true
+			continue
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if *verbose > 0 && e.Renamed != "" {
+				fmt.Printf("renaming %q back to %q...\n", e.Renamed, e.File)
+			} else if *verbose > 0 {
+				fmt.Printf("restoring %d frames in %q...\n", len(e.Frames)+len(e.Added), e.File)
+			}
+			if err := undoEntry(e); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: failed: %v\n", e.File, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
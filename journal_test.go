@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2"
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// Write an MP3 file with the title in ISO-8859-1 and some audio after the tag.
+func writeTitleMP3(t *testing.T, path, title string) {
+	t.Helper()
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(3)
+	tag.AddTextFrame("TIT2", id3v2.EncodingISO, title)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := tag.WriteTo(f); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 256; i++ {
+		f.WriteString("\xff\xfb\x90\x00")
+	}
+}
+
+func readTitleFrame(t *testing.T, path string) id3v2.TextFrame {
+	t.Helper()
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	tf, _ := tag.GetLastFrame("TIT2").(id3v2.TextFrame)
+	return tf
+}
+
+func TestJournalUndo(t *testing.T) {
+	dir := t.TempDir()
+	// The name in cp1251 is not valid UTF-8.
+	path := filepath.Join(dir, "\xca\xe8\xed\xee.mp3")
+	writeTitleMP3(t, path, "Çâåçäà")
+	jpath := filepath.Join(dir, "journal")
+	j, err := openJournal(jpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixer, err := fixtag.New(fixtag.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config{fixer: fixer, write: true, journal: j}
+	res, err := fixer.Plan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := processFile(context.Background(), newLogger(slog.LevelInfo, logPlain, path), cfg, res); err != nil {
+		t.Fatal(err)
+	}
+	if tf := readTitleFrame(t, path); tf.Text != "Звезда" {
+		t.Fatalf("written title = %q", tf.Text)
+	}
+	renamed := filepath.Join(dir, "Кино.mp3")
+	if err := os.Rename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.recordRename(path, renamed); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	entries, err := readJournal(jpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || string(entries[0].File) != path || string(entries[1].Renamed) != renamed {
+		t.Fatalf("readJournal() = %+v", entries)
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := undoEntry(entries[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tf := readTitleFrame(t, path); tf.Text != "Çâåçäà" || !tf.Encoding.Equals(id3v2.EncodingISO) {
+		t.Errorf("restored title = %q in %v", tf.Text, tf.Encoding)
+	}
+	if _, err := os.Stat(renamed); !os.IsNotExist(err) {
+		t.Errorf("the renamed file is left: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// The formats of the log.
+const (
+	logPlain = "plain" // the messages only, as the human readable output
+	logText  = "text"  // key=value pairs
+	logJSON  = "json"  // one JSON object per line
+)
+
+// Get the level of the log, either given explicitly or by the verbosity.
+func logLevel(name string, verbose int) (slog.Level, error) {
+	if name != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return 0, fmt.Errorf("unknown log level %q", name)
+		}
+		return level, nil
+	}
+	return slogLevel(verbose), nil
+}
+
+// Map the verbosity given by -v onto the levels: 0 shows the warnings,
+// 1 the progress and 2 the debugging details.
+func slogLevel(verbose int) slog.Level {
+	switch {
+	case verbose <= 0:
+		return slog.LevelWarn
+	case verbose == 1:
+		return slog.LevelInfo
+	}
+	return slog.LevelDebug
+}
+
+// The handler of the plain format, writing the messages alone, one line
+// each.  The messages about a file are indented under the one naming it,
+// see logger.
+type plainHandler struct {
+	w      io.Writer
+	level  slog.Leveler
+	indent string
+}
+
+func (h *plainHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *plainHandler) Handle(_ context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	for _, line := range strings.Split(r.Message, "\n") {
+		buf.WriteString(h.indent)
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+// The attributes are not shown, the file is named by the messages.
+func (h *plainHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h
+}
+
+// The messages of the group are indented by one more space.
+func (h *plainHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &plainHandler{w: h.w, level: h.level, indent: h.indent + " "}
+}
+
+// The logger collects the output produced while processing a single file,
+// so that the output of the files processed in parallel does not interleave.
+type logger struct {
+	level slog.Level
+	buf   bytes.Buffer
+	errs  bytes.Buffer // the failures, see Errorf
+	head  *slog.Logger // the messages naming the file, see Headf
+	log   *slog.Logger // the messages about the file
+	fail  *slog.Logger // the failures in the plain format, see Errorf
+	// The failures are logged in the structured format as well.
+	structured bool
+}
+
+// The group of the messages about the file.  It has no attributes, so it
+// only shows in the plain format, as the indentation.
+const logDetails = "details"
+
+// Create the logger of the file in the given format.
+func newLogger(level slog.Level, format, path string) *logger {
+	l := &logger{level: level, structured: true}
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case logText:
+		l.head = slog.New(slog.NewTextHandler(&l.buf, opts)).With("file", path)
+	case logJSON:
+		l.head = slog.New(slog.NewJSONHandler(&l.buf, opts)).With("file", path)
+	default:
+		l.head = slog.New(&plainHandler{w: &l.buf, level: level})
+		l.structured = false
+	}
+	l.log = l.head.WithGroup(logDetails)
+	// The failures are shown whatever the level.
+	l.fail = slog.New(&plainHandler{w: &l.errs, level: slog.Level(math.MinInt)})
+	return l
+}
+
+// Log the message about the file of the given level, see fixtag.Logger.
+func (l *logger) Printf(level slog.Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.log.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+// Log the message naming the file, which the messages about it follow.
+func (l *logger) Headf(level slog.Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	l.head.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+// Report the failure, printed to the standard error by flush whatever the
+// level and the output, e.g. with -q.  In the structured formats it is
+// logged as an error as well.
+func (l *logger) Errorf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	l.fail.Error(msg)
+	if l.structured {
+		l.head.Error(msg)
+	}
+}
+
+// Write the output collected to the writer, and the failures to the
+// standard error.
+func (l *logger) flush(w io.Writer) {
+	w.Write(l.buf.Bytes())
+	os.Stderr.Write(l.errs.Bytes())
+	l.buf.Reset()
+	l.errs.Reset()
+}
+
+// Print the text about the file regardless of the level, e.g. the output
+// asked for explicitly.  In the structured formats it is logged as a single
+// warning.
+func (l *logger) Print(text string) {
+	r := slog.NewRecord(time.Now(), slog.LevelWarn, strings.TrimRight(text, "\n"), 0)
+	l.log.Handler().Handle(context.Background(), r)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLoggerPlain(t *testing.T) {
+	log := newLogger(slog.LevelInfo, logPlain, "song.mp3")
+	log.Headf(slog.LevelInfo, "processing file %q...", "song.mp3")
+	log.Printf(slog.LevelInfo, "frame %q set to %q", "TIT2", "Звезда")
+	log.Printf(slog.LevelDebug, "not shown")
+	log.Print("candidates:\n  a\n  b\n")
+	log.Errorf("failed %q: %s", "song.mp3", "broken")
+	want := "processing file \"song.mp3\"...\n" +
+		" frame \"TIT2\" set to \"Звезда\"\n" +
+		" candidates:\n   a\n   b\n"
+	if got := log.buf.String(); got != want {
+		t.Errorf("the output = %q, want %q", got, want)
+	}
+	if got, want := log.errs.String(), "failed \"song.mp3\": broken\n"; got != want {
+		t.Errorf("the failures = %q, want %q", got, want)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	log := newLogger(slog.LevelWarn, logJSON, "song.mp3")
+	log.Headf(slog.LevelInfo, "processing file %q...", "song.mp3")
+	log.Printf(slog.LevelWarn, "Warning: could not convert frame %s", "TIT2")
+	log.Errorf("failed %q: %s", "song.mp3", "broken")
+	lines := strings.Split(strings.TrimSpace(log.buf.String()), "\n")
+	want := []struct{ level, msg string }{
+		{"WARN", "Warning: could not convert frame TIT2"},
+		{"ERROR", "failed \"song.mp3\": broken"},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("the output = %q, want %d records", log.buf.String(), len(want))
+	}
+	for i, line := range lines {
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec["level"] != want[i].level || rec["msg"] != want[i].msg || rec["file"] != "song.mp3" || len(rec) != 4 {
+			t.Errorf("the record %d = %v, want %s %q", i, rec, want[i].level, want[i].msg)
+		}
+	}
+	if got, want := log.errs.String(), "failed \"song.mp3\": broken\n"; got != want {
+		t.Errorf("the failures = %q, want %q", got, want)
+	}
+}
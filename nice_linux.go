@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Set the niceness of the process.  On Linux it is set for every thread,
+// the threads started later inherit it; the I/O priority follows it unless
+// set otherwise.
+func setNice(n int) error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return syscall.Setpriority(syscall.PRIO_PROCESS, 0, n)
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		// The thread may have exited since.
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, tid, n); err != nil && err != syscall.ESRCH {
+			return err
+		}
+	}
+	return nil
+}
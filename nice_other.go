@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// The niceness is only set on Linux.
+func setNice(n int) error {
+	return errors.New("not supported on this system")
+}
@@ -0,0 +1,207 @@
+package fixtag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// SourceAcoustID marks the frames set from the acoustic fingerprint, see AcoustIDTags.
+const SourceAcoustID = "acoustid"
+
+// AcoustID identifies the tracks by their acoustic fingerprints made
+// by fpcalc of Chromaprint, and gets their titles, artists and albums
+// from MusicBrainz through the AcoustID web service.
+type AcoustID struct {
+	key    string
+	fpcalc string
+	client *http.Client
+	base   string
+
+	mu   sync.Mutex // the requests are made one by one, see lookup
+	last time.Time
+}
+
+// The default address of the AcoustID web service.
+const acoustIDURL = "https://api.acoustid.org/v2/lookup"
+
+// The minimal score of the fingerprint match, in range [0, 1].
+const acoustIDScore = 0.9
+
+// NewAcoustID makes the identification with the API key of the application,
+// see https://acoustid.org/new-application.  The fpcalc program must be
+// in the PATH.
+func NewAcoustID(key string) (*AcoustID, error) {
+	fpcalc, err := exec.LookPath("fpcalc")
+	if err != nil {
+		return nil, fmt.Errorf("fpcalc of Chromaprint is not found: %v", err)
+	}
+	return &AcoustID{key: key, fpcalc: fpcalc, client: &http.Client{Timeout: 30 * time.Second}, base: acoustIDURL}, nil
+}
+
+// Check whether the text is lost, i.e. the letters were replaced by the
+// question marks when it was converted into a charset without them, or by
+// U+FFFD when it was decoded from a wrong one.  No conversion recovers it,
+// unlike the raw bytes of the text not in UTF-8.
+func lostText(text string) bool {
+	if !utf8.ValidString(text) {
+		return false
+	}
+	lost := false
+	for _, c := range text {
+		switch {
+		case c == '?' || c == utf8.RuneError:
+			lost = true
+		case unicode.IsLetter(c):
+			return false
+		}
+	}
+	return lost
+}
+
+// Get the fingerprint of the file and its duration in seconds.
+func (a *AcoustID) fingerprint(path string) (string, int, error) {
+	out, err := exec.Command(a.fpcalc, "-json", path).Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			return "", 0, fmt.Errorf("fpcalc: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", 0, fmt.Errorf("fpcalc: %v", err)
+	}
+	var fp struct {
+		Duration    float64 `json:"duration"`
+		Fingerprint string  `json:"fingerprint"`
+	}
+	if err := json.Unmarshal(out, &fp); err != nil {
+		return "", 0, fmt.Errorf("fpcalc: %v", err)
+	}
+	return fp.Fingerprint, int(fp.Duration), nil
+}
+
+// The results of the lookup, only the best recording is used.
+type acoustIDResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			Title   string `json:"title"`
+			Artists []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artists"`
+			ReleaseGroups []struct {
+				Title string `json:"title"`
+			} `json:"releasegroups"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// Identify the file, the values are empty if it is not identified for sure.
+func (a *AcoustID) lookup(path string) (tagValues, error) {
+	var v tagValues
+	fp, duration, err := a.fingerprint(path)
+	if err != nil {
+		return v, err
+	}
+	a.mu.Lock()
+	// The service allows three requests a second.
+	if wait := time.Second/3 - time.Since(a.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	a.last = time.Now()
+	a.mu.Unlock()
+
+	q := url.Values{
+		"client":      {a.key},
+		"meta":        {"recordings releasegroups compress"},
+		"duration":    {strconv.Itoa(duration)},
+		"fingerprint": {fp},
+	}
+	resp, err := a.client.PostForm(a.base, q)
+	if err != nil {
+		return v, err
+	}
+	defer resp.Body.Close()
+	var r acoustIDResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return v, fmt.Errorf("AcoustID: %s", resp.Status)
+	}
+	if r.Status != "ok" {
+		return v, fmt.Errorf("AcoustID: %s", r.Error.Message)
+	}
+	for _, res := range r.Results {
+		if res.Score < acoustIDScore || len(res.Recordings) == 0 {
+			continue
+		}
+		rec := res.Recordings[0]
+		v.title = rec.Title
+		var artist strings.Builder
+		for _, a := range rec.Artists {
+			artist.WriteString(a.Name + a.JoinPhrase)
+		}
+		v.artist = artist.String()
+		if len(rec.ReleaseGroups) > 0 {
+			v.album = rec.ReleaseGroups[0].Title
+		}
+		break
+	}
+	return v, nil
+}
+
+// AcoustIDTags makes the plan of setting the title, the artist and the album
+// which are lost, i.e. replaced by the question marks or not converted in the
+// result, from the identification of the file.  The plan is nil if nothing
+// is lost or the file is not identified.
+func (f *Fixer) AcoustIDTags(res *Result, a *AcoustID) (*FilePlan, error) {
+	info, err := ReadTagInfo(res.File)
+	if err != nil {
+		return nil, err
+	}
+	lost := make(map[string]bool)
+	for _, name := range []string{"title", "artist", "album"} {
+		lost[name] = lostText(info[name])
+	}
+	for _, field := range res.Fields() {
+		if (field.Action == ActionFailed || field.Action == ActionLost) && field.Index == 0 && field.Field == FieldText {
+			if name := infoName(res.File, field.Key); name != "" {
+				lost[name] = true
+			}
+		}
+	}
+	if !lost["title"] && !lost["artist"] && !lost["album"] {
+		return nil, nil
+	}
+	v, err := a.lookup(res.File)
+	if err != nil {
+		return nil, err
+	}
+	if v.title == "" {
+		f.log.Printf(slog.LevelInfo, "not identified by the fingerprint")
+		return nil, nil
+	}
+	f.log.Printf(slog.LevelInfo, "identified by the fingerprint: %q by %q", v.title, v.artist)
+	if !lost["title"] {
+		v.title = ""
+	}
+	if !lost["artist"] {
+		v.artist = ""
+	}
+	if !lost["album"] {
+		v.album = ""
+	}
+	return f.valuesPlan(res.File, v, SourceAcoustID)
+}
@@ -0,0 +1,77 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// The AIFF files keep the ID3v2 tag in the "ID3 " chunk, so they are fixed
+// the same way as the MP3 files, see openTag and saveFile.
+var aiffExtensions = []string{".aif", ".aiff", ".aifc"}
+
+// The ids of the chunk with the ID3v2 tag, the second one is written by some tools.
+var aiffID3Chunks = []string{"ID3 ", "id3 "}
+
+// Check whether the file is AIFF by its extension.
+func isAIFF(path string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range aiffExtensions {
+		if strings.EqualFold(ext, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read the chunks of the AIFF file.
+func readAIFF(f *os.File) (*iffFile, error) {
+	return readIFF(f, "FORM", binary.BigEndian)
+}
+
+// Read the ID3v2 tag of the AIFF file, an empty one if there is none.
+func readAIFFTag(path string) (*id3v2.Tag, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ff, err := readAIFF(f)
+	if err != nil {
+		return nil, err
+	}
+	i := ff.find(aiffID3Chunks...)
+	if i < 0 {
+		return id3v2.NewEmptyTag(), nil
+	}
+	data, err := ff.read(f, i)
+	if err != nil {
+		return nil, err
+	}
+	return id3v2.ParseReader(bytes.NewReader(data), id3v2.Options{Parse: true})
+}
+
+// Save the ID3v2 tag into the chunk of the AIFF file atomically, see replaceFile.
+// The chunk is removed if the tag is empty.
+func saveAIFFTag(path string, tag *id3v2.Tag, so saveOptions) error {
+	var buf bytes.Buffer
+	if _, err := tag.WriteTo(&buf); err != nil {
+		return err
+	}
+	var data []byte
+	if buf.Len() > 0 {
+		data = buf.Bytes()
+	}
+	return replaceFile(path, so, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		ff, err := readAIFF(orig)
+		if err != nil {
+			return err
+		}
+		return ff.copyWithChunk(w, orig, ff.find(aiffID3Chunks...), aiffID3Chunks[0], data)
+	})
+}
@@ -0,0 +1,188 @@
+package fixtag
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// AlbumKey gets the key grouping the files of the same album: the directory
+// of the file and the album title as read from the tag.
+func (r *Result) AlbumKey() string {
+	return filepath.Dir(r.File) + "\x00" + r.Album
+}
+
+// Consensus gets the charset most of the fields of the results have been
+// unambiguously decoded from, e.g. for the files of the same album.
+// The empty string is returned if there is no single such charset.
+func Consensus(results []*Result) string {
+	votes := make(map[string]int)
+	for _, r := range results {
+		for _, res := range r.Fields() {
+			if res.Action == ActionConverted && res.Winner != nil && res.Winner.Charset != "" {
+				votes[res.Winner.Charset]++
+			}
+		}
+	}
+	best, n, tie := "", 0, false
+	for cs, v := range votes {
+		switch {
+		case v > n:
+			best, n, tie = cs, v, false
+		case v == n:
+			tie = true
+		}
+	}
+	if tie {
+		return ""
+	}
+	return best
+}
+
+// Prefer resolves the ambiguous fields in favour of the candidate decoded
+// from the given charset, if there is exactly one such among the best candidates.
+// The resolved fields are returned.
+func (r *Result) Prefer(charset string) []*Field {
+	if charset == "" {
+		return nil
+	}
+	var out []*Field
+	for _, res := range r.Fields() {
+		if res.Action != ActionAmbiguous || len(res.Candidates) == 0 {
+			continue
+		}
+		var found *Candidate
+		n := 0
+		for _, c := range res.Candidates {
+			if res.Candidates[0].betterThan(c) {
+				break
+			}
+			if c.Charset == charset {
+				found = c
+				n++
+			}
+		}
+		if n != 1 {
+			continue
+		}
+		res.Winner = found
+		res.Action = ActionConverted
+		out = append(out, res)
+	}
+	return out
+}
+
+// VariousArtists is the album artist of the compilations, see AlbumArtist.
+const VariousArtists = "Various Artists"
+
+// The chain of the album artists filled from the artists, see FillAlbumArtist.
+const ChainAlbumArtist = "albumartist"
+
+// SourceAlbumArtist marks the album artists filled from the artists.
+const SourceAlbumArtist = "albumartist"
+
+// The keys of the album artist by the names of the backends, see
+// tagInfoKeys, spelled as they are written.
+var albumArtistKeys = map[string]string{
+	"":     "TPE2",
+	"flac": "ALBUMARTIST",
+	"ogg":  "ALBUMARTIST",
+	"ape":  "Album Artist",
+	"mp4":  "aART",
+}
+
+// Get the common values of the file with the converted ones, see
+// TagInfo.Update.  The values of the fields not converted are dropped, as
+// they are broken.
+func resultInfo(r *Result) (TagInfo, error) {
+	info, err := ReadTagInfo(r.File)
+	if err != nil {
+		return nil, err
+	}
+	info.Update(r)
+	for _, field := range r.Fields() {
+		if field.Index > 0 || field.Field != FieldText {
+			continue
+		}
+		switch field.Action {
+		case ActionFailed, ActionAmbiguous, ActionLost:
+			if name := infoName(r.File, field.Key); name != "" {
+				delete(info, name)
+			}
+		}
+	}
+	return info, nil
+}
+
+// AlbumArtist gets the album artist of the files of the same album, e.g.
+// to fill the empty ones: the artist of more than half of the files, or
+// various if the files have different artists, e.g. of a compilation.  It
+// is empty if no file has the artist.
+func AlbumArtist(results []*Result, various string) (string, error) {
+	votes := make(map[string]int)
+	n := 0
+	for _, r := range results {
+		info, err := resultInfo(r)
+		if err != nil {
+			return "", err
+		}
+		if a := info["artist"]; a != "" {
+			votes[a]++
+			n++
+		}
+	}
+	for a, v := range votes {
+		if 2*v > n {
+			return a, nil
+		}
+	}
+	if n == 0 {
+		return "", nil
+	}
+	return various, nil
+}
+
+// FillAlbumArtist adds the album artist to the result, if the file has
+// none and its format has one, marking it with ChainAlbumArtist.  It returns
+// false if nothing is added.
+func (f *Fixer) FillAlbumArtist(r *Result, artist string) (bool, error) {
+	if artist == "" {
+		return false, nil
+	}
+	name := ""
+	if b := backendFor(r.File); b != nil {
+		name = b.Name()
+	}
+	key, ok := albumArtistKeys[name]
+	if !ok {
+		return false, nil
+	}
+	info, err := resultInfo(r)
+	if err != nil {
+		return false, err
+	}
+	if info["albumartist"] != "" {
+		return false, nil
+	}
+	for _, fi := range r.Frames {
+		if strings.EqualFold(fi.Key, key) {
+			// The album artist is there, but not converted.
+			return false, nil
+		}
+	}
+	f.log.Printf(slog.LevelInfo, "frame %q set to the artist of the album: %q", key, artist)
+	r.Frames = append(r.Frames, &Frame{
+		Key:    key,
+		Orig:   TextFrame{Encoding: id3v2.EncodingUTF8},
+		Source: SourceAlbumArtist,
+		Fields: []*Field{{
+			Key:    key,
+			Field:  FieldText,
+			Winner: &Candidate{Chain: ChainAlbumArtist, Charset: "utf-8", Text: artist, Goodness: 1},
+			Action: ActionConverted,
+		}},
+	})
+	return true, nil
+}
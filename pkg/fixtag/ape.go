@@ -0,0 +1,230 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// The Monkey's Audio, Musepack and WavPack files, with the APEv2 tag at
+// the end of the file, before the ID3v1 tag if any.  The values of the text
+// items are in UTF-8, the multiple values being separated with zero bytes.
+type apeBackend struct{}
+
+// The size of the header and the footer of the APE tag.
+const apeFooterSize = 32
+
+// The flags of the APE tag and its items.
+const (
+	apeHasHeader = 1 << 31
+	apeIsHeader  = 1 << 29
+	apeItemType  = 3 << 1 // the mask of the item type, 0 for the text
+)
+
+// The version of the written APE tags.
+const apeVersion = 2000
+
+var errBadAPE = errors.New("malformed APE tag")
+
+// An item of the APE tag.
+type apeItem struct {
+	flags uint32
+	key   string
+	value []byte
+}
+
+// The APE tag of the file.
+type apeTag struct {
+	start int64 // the offset of the tag, including the header
+	end   int64 // the offset after the footer
+	flags uint32
+	items []apeItem
+}
+
+// Read the APE tag of the file, an empty one at its place if there is none.
+func readAPE(f *os.File) (*apeTag, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := st.Size()
+	if end >= id3v1Size {
+		magic := make([]byte, 3)
+		if _, err := f.ReadAt(magic, end-id3v1Size); err != nil {
+			return nil, err
+		}
+		if string(magic) == "TAG" {
+			end -= id3v1Size
+		}
+	}
+	tag := &apeTag{start: end, end: end}
+	if end < apeFooterSize {
+		return tag, nil
+	}
+	footer := make([]byte, apeFooterSize)
+	if _, err := f.ReadAt(footer, end-apeFooterSize); err != nil {
+		return nil, err
+	}
+	if string(footer[:8]) != "APETAGEX" {
+		// No tag, a new one would be added at the end.
+		return tag, nil
+	}
+	size := int64(binary.LittleEndian.Uint32(footer[12:]))
+	count := binary.LittleEndian.Uint32(footer[16:])
+	tag.flags = binary.LittleEndian.Uint32(footer[20:])
+	if size < apeFooterSize || size > end {
+		return nil, errBadAPE
+	}
+	data := make([]byte, size-apeFooterSize)
+	if _, err := f.ReadAt(data, end-size); err != nil {
+		return nil, err
+	}
+	tag.start = end - size
+	if tag.flags&apeHasHeader != 0 {
+		tag.start -= apeFooterSize
+		if tag.start < 0 {
+			return nil, errBadAPE
+		}
+	}
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 8 {
+			return nil, errBadAPE
+		}
+		n := binary.LittleEndian.Uint32(data)
+		item := apeItem{flags: binary.LittleEndian.Uint32(data[4:])}
+		data = data[8:]
+		k := bytes.IndexByte(data, 0)
+		if k < 0 || uint64(n) > uint64(len(data)-k-1) {
+			return nil, errBadAPE
+		}
+		item.key = string(data[:k])
+		item.value = data[k+1 : k+1+int(n)]
+		data = data[k+1+int(n):]
+		tag.items = append(tag.items, item)
+	}
+	return tag, nil
+}
+
+// Make the header or the footer of the tag.
+func (t *apeTag) frame(size, count int, header bool) []byte {
+	flags := t.flags | apeHasHeader
+	if header {
+		flags |= apeIsHeader
+	} else {
+		flags &^= apeIsHeader
+	}
+	b := make([]byte, apeFooterSize)
+	copy(b, "APETAGEX")
+	binary.LittleEndian.PutUint32(b[8:], apeVersion)
+	binary.LittleEndian.PutUint32(b[12:], uint32(size))
+	binary.LittleEndian.PutUint32(b[16:], uint32(count))
+	binary.LittleEndian.PutUint32(b[20:], flags)
+	return b
+}
+
+// Make the tag with the header and the footer.
+func (t *apeTag) bytes() []byte {
+	var items bytes.Buffer
+	for _, item := range t.items {
+		binary.Write(&items, binary.LittleEndian, uint32(len(item.value)))
+		binary.Write(&items, binary.LittleEndian, item.flags)
+		items.WriteString(item.key)
+		items.WriteByte(0)
+		items.Write(item.value)
+	}
+	size := items.Len() + apeFooterSize
+	out := t.frame(size, len(t.items), true)
+	out = append(out, items.Bytes()...)
+	return append(out, t.frame(size, len(t.items), false)...)
+}
+
+func (apeBackend) Name() string {
+	return "ape"
+}
+
+func (apeBackend) Extensions() []string {
+	return []string{".ape", ".mpc", ".wv"}
+}
+
+func (apeBackend) ReadFields(f *os.File) ([]TagField, error) {
+	tag, err := readAPE(f)
+	if err != nil {
+		return nil, err
+	}
+	var fields []TagField
+	for _, item := range tag.items {
+		if item.flags&apeItemType != 0 {
+			continue
+		}
+		for _, v := range strings.Split(string(item.value), "\x00") {
+			fields = append(fields, TagField{Name: item.key, Value: v})
+		}
+	}
+	return fields, nil
+}
+
+func (apeBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	tag, err := readAPE(f)
+	if err != nil {
+		return err
+	}
+	// All values of the key are joined into the first text item with it.
+	values := make(map[string][]string)
+	var keys []string
+	for _, field := range fields {
+		if _, ok := values[field.Name]; !ok {
+			keys = append(keys, field.Name)
+		}
+		values[field.Name] = append(values[field.Name], field.Value)
+	}
+	var items []apeItem
+	done := make(map[string]bool)
+	for _, item := range tag.items {
+		if item.flags&apeItemType != 0 {
+			items = append(items, item)
+			continue
+		}
+		if _, ok := values[item.key]; ok && !done[item.key] {
+			done[item.key] = true
+			item.value = []byte(strings.Join(values[item.key], "\x00"))
+			items = append(items, item)
+		}
+	}
+	for _, key := range keys {
+		if !done[key] {
+			items = append(items, apeItem{key: key, value: []byte(strings.Join(values[key], "\x00"))})
+		}
+	}
+	tag.items = items
+
+	if _, err := io.Copy(w, io.NewSectionReader(f, 0, tag.start)); err != nil {
+		return err
+	}
+	if _, err := w.Write(tag.bytes()); err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, tag.end, st.Size()-tag.end))
+	return err
+}
+
+// Check whether the file has the APE tag, e.g. an MP3 file tagged with
+// foobar2000.  Such a tag is kept intact when the ID3 tags are written.
+func hasAPE(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	tag, err := readAPE(f)
+	if err != nil {
+		return false, err
+	}
+	return tag.start != tag.end, nil
+}
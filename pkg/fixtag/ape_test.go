@@ -0,0 +1,77 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+var apeAudio = bytes.Repeat([]byte("MAC \x96\x0f"), 64)
+
+func TestAPERoundTrip(t *testing.T) {
+	cover := apeItem{flags: 1 << 1, key: "Cover Art (Front)", value: []byte("cover.jpg\x00\xff\xd8\xff")}
+	tag := (&apeTag{items: []apeItem{{key: "Title", value: []byte("Ãðóïïà êðîâè")}, cover}}).bytes()
+	id3v1 := append([]byte("TAG"), make([]byte, id3v1Size-3)...)
+	for _, tt := range []struct {
+		name  string
+		tail  []byte // after the audio
+		cover bool
+		id3v1 bool
+	}{
+		{"tag", tag, true, false},
+		{"tag and id3v1", append(append([]byte{}, tag...), id3v1...), true, true},
+		{"no tag", nil, false, false},
+		{"only id3v1", id3v1, false, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.ape", append(append([]byte{}, apeAudio...), tt.tail...))
+			fields := []TagField{{Name: "Title", Value: "Группа крови"}, {Name: "Title", Value: "Кино"}, {Name: "Artist", Value: "Кино"}}
+			if err := writeFields(apeBackend{}, path, fields, saveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFields(apeBackend{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 3 || got[0] != fields[0] || got[1] != fields[1] || got[2] != fields[2] {
+				t.Errorf("ReadFields() after write = %+v", got)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, apeAudio) {
+				t.Error("the audio has changed")
+			}
+			if tt.id3v1 != bytes.HasSuffix(data, id3v1) {
+				t.Error("the ID3v1 tag is lost or added")
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			ape, err := readAPE(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ape.start != int64(len(apeAudio)) {
+				t.Errorf("the tag starts at %d, want %d", ape.start, len(apeAudio))
+			}
+			// The header and the footer agree, the size excludes the header.
+			header, footer := data[ape.start:ape.start+apeFooterSize], data[ape.end-apeFooterSize:ape.end]
+			if string(header[:8]) != "APETAGEX" || !bytes.Equal(header[8:20], footer[8:20]) {
+				t.Errorf("the header % x does not match the footer % x", header[:24], footer[:24])
+			}
+			if size := binary.LittleEndian.Uint32(footer[12:]); int64(size) != ape.end-ape.start-apeFooterSize {
+				t.Errorf("the tag size is %d, want %d", size, ape.end-ape.start-apeFooterSize)
+			}
+			if tt.cover {
+				if n := len(ape.items); n != 3 || ape.items[1].key != cover.key || !bytes.Equal(ape.items[1].value, cover.value) {
+					t.Errorf("the binary item is not kept: %d items", n)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package fixtag
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// The chain of the artists split and joined again, see Options.
+const ChainArtists = "artists"
+
+// The separator of the artists, if none is given, in the ID3v2.3 tags and
+// in the other formats; the ID3v2.4 tags have the multiple values.
+const (
+	id3v23ArtistSeparator = "/"
+	otherArtistSeparator  = "; "
+)
+
+// The names of the artist frames among the common values, see tagInfoKeys.
+var artistInfoNames = []string{"artist", "albumartist"}
+
+// Check whether the frame is of the artists in any format.
+func artistKey(key string) bool {
+	for _, keys := range tagInfoKeys {
+		for _, name := range artistInfoNames {
+			for _, k := range keys[name] {
+				if strings.EqualFold(k, key) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Build the expression matching any of the separators of the artists with
+// the spaces around, e.g. "feat." or "/", and the NUL between the multiple
+// values.  The separators starting with a letter only match a whole word.
+func artistSplitter(seps []string) *regexp.Regexp {
+	alts := []string{`\x00`}
+	for _, s := range seps {
+		alt := regexp.QuoteMeta(strings.TrimSpace(s))
+		if wordRune([]rune(strings.TrimSpace(s))[0]) {
+			alt = `(?:^|\s)` + alt
+		}
+		alts = append(alts, alt)
+	}
+	return regexp.MustCompile(`(?i)\s*(?:` + strings.Join(alts, "|") + `)\s*`)
+}
+
+// Split the text into the artists, dropping the empty ones.
+func (f *Fixer) splitArtists(text string) []string {
+	var out []string
+	for _, a := range f.artistRe.Split(text, -1) {
+		if a = strings.TrimSpace(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// Convert the artists of the field one by one, if it is not converted as
+// a whole, or not into the proper case, e.g. two names in different
+// charsets.  The artists are joined with NUL, see artistFrames.
+func (f *Fixer) convertArtists(combinations []combination, res *Field) {
+	f.convertField(combinations, res)
+	if res.Action == ActionConverted && res.Winner.mixed == 0 {
+		return
+	}
+	parts := f.splitArtists(trimGarbage(res.Orig))
+	if len(parts) < 2 {
+		return
+	}
+	var chains []string
+	for i, p := range parts {
+		if f.alpha.Goodness(p) >= 1 {
+			continue
+		}
+		c := f.convertText(combinations, res.Key, p)
+		if c == nil {
+			return
+		}
+		parts[i] = c.Text
+		chains = append(chains, c.Chain)
+	}
+	c := f.newCandidate(ChainArtists, "", strings.Join(parts, "\x00"))
+	if res.Winner != nil && !c.betterThan(res.Winner) || !f.acceptable(res, c) {
+		return
+	}
+	res.Attempts = append(res.Attempts, Attempt{Candidate: *c})
+	res.Candidates = []*Candidate{c}
+	res.Winner = c
+	res.Best = c.Goodness
+	res.Action = ActionConverted
+	f.log.Printf(slog.LevelInfo, "frame %q converted by the artists with %s: %q", res.Name(), strings.Join(chains, ", "), c.Text)
+}
+
+// Join the artists of the frames split by the separators again with the
+// separator of the options or, if none, as the multiple values of ID3v2.4,
+// or with "/" in ID3v2.3 and "; " in the other formats.  The name is of the
+// backend, empty for ID3v2.  The current frames are the first ones with
+// every key.
+func (f *Fixer) artistFrames(res *Result, name string, version byte, current map[string]TextFrame) {
+	if f.artistRe == nil {
+		return
+	}
+	sep := f.opts.ArtistSeparator
+	switch {
+	case sep != "":
+	case name == "" && version == 4:
+		sep = "\x00"
+	case name == "":
+		sep = id3v23ArtistSeparator
+	default:
+		sep = otherArtistSeparator
+	}
+	for _, info := range artistInfoNames {
+		for _, key := range tagInfoKeys[name][info] {
+			var field *Field
+			for _, fi := range res.Frames {
+				if strings.EqualFold(fi.Key, key) && fi.Index == 0 && len(fi.Fields) > 0 && fi.Fields[0].Field == FieldText {
+					field = fi.Fields[0]
+				}
+			}
+			if field != nil {
+				if field.Action == ActionConverted {
+					field.Winner.Text = strings.Join(f.splitArtists(field.Winner.Text), sep)
+				}
+				continue
+			}
+			for k, tf := range current {
+				if !strings.EqualFold(k, key) {
+					continue
+				}
+				text := strings.Join(f.splitArtists(tf.Text), sep)
+				if text == "" || text == tf.Text {
+					continue
+				}
+				f.log.Printf(slog.LevelInfo, "frame %q artists joined: %q", k, text)
+				res.Frames = append(res.Frames, &Frame{
+					Key:  k,
+					Orig: tf,
+					Fields: []*Field{{
+						Key:    k,
+						Field:  FieldText,
+						Orig:   tf.Text,
+						Winner: &Candidate{Chain: ChainArtists, Charset: "utf-8", Text: text, Goodness: 1},
+						Action: ActionConverted,
+					}},
+				})
+				res.Correct--
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package fixtag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrAudioChanged is wrapped by the error of the file whose audio data would
+// change on writing, see Options.
+var ErrAudioChanged = errors.New("the audio data would change")
+
+// A part of the file, the offset and the size.
+type section struct {
+	off, size int64
+}
+
+// Get the parts of the file holding the audio data, outside the tags, by
+// the format of the file at the path.  It returns false if the format is
+// not supported.
+func audioSections(path string, f *os.File) ([]section, bool, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if isAIFF(path) {
+		ff, err := readAIFF(f)
+		if err != nil {
+			return nil, false, err
+		}
+		if i := ff.find("SSND"); i >= 0 {
+			return []section{{ff.chunks[i].data, ff.chunks[i].size}}, true, nil
+		}
+		return nil, true, nil
+	}
+	b := backendFor(path)
+	switch {
+	case b == nil:
+		// The MP3 frames between the ID3v2 and the ID3v1 tags.
+		start, err := id3v2Size(f)
+		if err != nil {
+			return nil, false, err
+		}
+		end := st.Size()
+		if end-start >= id3v1Size {
+			tail := make([]byte, 3)
+			if _, err := f.ReadAt(tail, end-id3v1Size); err != nil {
+				return nil, false, err
+			}
+			if bytes.Equal(tail, []byte("TAG")) {
+				end -= id3v1Size
+			}
+		}
+		return []section{{start, end - start}}, true, nil
+	case b.Name() == "flac":
+		ff, err := readFLAC(f)
+		if err != nil {
+			return nil, false, err
+		}
+		return []section{{ff.audio, st.Size() - ff.audio}}, true, nil
+	case b.Name() == "wav":
+		ff, _, err := readWAV(f)
+		if err != nil {
+			return nil, false, err
+		}
+		if i := ff.find("data"); i >= 0 {
+			return []section{{ff.chunks[i].data, ff.chunks[i].size}}, true, nil
+		}
+		return nil, true, nil
+	}
+	return nil, false, nil
+}
+
+// Hash the audio data of the file, see audioSections.
+func audioHash(path string, f *os.File) ([]byte, bool, error) {
+	sections, ok, err := audioSections(path, f)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	h := sha256.New()
+	for _, s := range sections {
+		if _, err := io.Copy(h, io.NewSectionReader(f, s.off, s.size)); err != nil {
+			return nil, false, err
+		}
+	}
+	return h.Sum(nil), true, nil
+}
+
+// Check that the new contents of the file at the path have the same audio
+// data as the original ones.
+func checkAudio(path string, orig, tmp *os.File) error {
+	before, ok, err := audioHash(path, orig)
+	if err != nil || !ok {
+		return err
+	}
+	after, _, err := audioHash(path, tmp)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAudioChanged, err)
+	}
+	if !bytes.Equal(before, after) {
+		return fmt.Errorf("%w, not written", ErrAudioChanged)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+package fixtag
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// TagField is a text field of a tag in a format other than ID3v2,
+// e.g. a Vorbis comment of a FLAC file.
+type TagField struct {
+	Name  string // e.g. "ARTIST", as stored in the tag
+	Value string // as stored in the tag, may be not valid UTF-8
+}
+
+// TagBackend reads and writes the text fields of the tags of a file format
+// other than MP3.  The fields are stored in UTF-8, and converted the same
+// way as the ID3v2 frames.
+type TagBackend interface {
+	// Name of the format, e.g. "flac".
+	Name() string
+	// Extensions of the files in the format, e.g. ".flac".
+	Extensions() []string
+	// ReadFields reads the text fields of the tag, in the order of the tag.
+	ReadFields(f *os.File) ([]TagField, error)
+	// CopyWithFields copies the file into w, with the text fields of the tag
+	// replaced by the given ones.
+	CopyWithFields(w io.Writer, f *os.File, fields []TagField) error
+}
+
+// The supported formats other than MP3.
+var backends = []TagBackend{flacBackend{}, mp4Backend{}, oggBackend{}, apeBackend{}, wavBackend{}}
+
+// Extensions lists the extensions of the files of all supported formats.
+func Extensions() []string {
+	out := append([]string{".mp3"}, aiffExtensions...)
+	for _, b := range backends {
+		out = append(out, b.Extensions()...)
+	}
+	return out
+}
+
+// Get the backend for the file by its extension, nil for MP3 and AIFF.
+func backendFor(path string) TagBackend {
+	ext := filepath.Ext(path)
+	for _, b := range append(backends, playlists, cueSheets) {
+		for _, e := range b.Extensions() {
+			if strings.EqualFold(ext, e) {
+				return b
+			}
+		}
+	}
+	return nil
+}
+
+// Read the text fields of the file.
+func readFields(b TagBackend, path string) ([]TagField, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return b.ReadFields(f)
+}
+
+// Write the text fields into the file atomically.
+func writeFields(b TagBackend, path string, fields []TagField, so saveOptions) error {
+	return replaceFile(path, so, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		return b.CopyWithFields(w, orig, fields)
+	})
+}
+
+// Get the text fields as the frames, the frame key being the field name.
+func fieldFrame(field TagField) TextFrame {
+	return TextFrame{Encoding: id3v2.EncodingUTF8, Text: field.Value}
+}
+
+// Extract the fields to convert.  The number of the fields which need no
+// conversion is returned too.
+func (f *Fixer) extractFields(fields []TagField) ([]*Frame, int) {
+	var out []*Frame
+	correct := 0
+	count := make(map[string]int)
+	for _, field := range fields {
+		i := count[field.Name]
+		count[field.Name]++
+		if field.Value == "" {
+			continue
+		}
+		if f.skipped(field.Name) {
+			f.log.Printf(slog.LevelDebug, "field %q is not to be converted, skipping", field.Name)
+			continue
+		}
+		res := &Field{Key: field.Name, Index: i, Field: FieldText, Orig: field.Value}
+		fix, ok := f.utf8Fix(field.Name, field.Value)
+		switch {
+		case lostText(field.Value):
+			fix, ok = fixLost, true
+		case f.opts.Entities != "" && hasEntities(field.Value):
+			fix, ok = fixEntities, true
+		case percentEncoded(field.Value):
+			fix, ok = fixPercent, true
+		case f.alpha.Goodness(trimGarbage(field.Value)) >= 1:
+			f.log.Printf(slog.LevelDebug, "field %q => %q is already correct", res.Name(), field.Value)
+			ok = false
+		case !ok:
+			f.log.Printf(slog.LevelDebug, "field %q is valid Unicode, skipping", res.Name())
+		}
+		if !ok && !f.genreField(res) && !f.translitField(res) && !f.untranslitField(res) && !f.stripField(res) && !f.cleanupField(res) && !f.replaceField(res) {
+			correct++
+			continue
+		}
+		res.fix = fix
+		f.log.Printf(slog.LevelDebug, "field %q found, text: %s", res.Name(), Dump(field.Value))
+		out = append(out, &Frame{Key: field.Name, Index: i, Orig: fieldFrame(field), Fields: []*Field{res}})
+	}
+	return out, correct
+}
+
+// The names of the album fields in the formats of the backends, upper case.
+var albumFields = map[string]bool{"ALBUM": true, "©ALB": true, "IPRD": true}
+
+// Plan the conversion of the file in the format of the backend.
+func (f *Fixer) planFields(b TagBackend, path string) (*Result, error) {
+	fields, err := readFields(b, path)
+	if err != nil {
+		return nil, readError(path, err)
+	}
+	f.wait(fieldsSize(fields))
+	res := &Result{File: path}
+	for _, field := range fields {
+		if albumFields[strings.ToUpper(field.Name)] {
+			res.Album = field.Value
+			break
+		}
+	}
+	res.Frames, res.Correct = f.extractFields(fields)
+	f.log.Printf(slog.LevelInfo, "%d fields to convert found", len(res.Frames))
+	f.convertFrames(res)
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+	current := make(map[string]TextFrame)
+	for _, field := range fields {
+		if _, ok := current[field.Name]; !ok {
+			current[field.Name] = fieldFrame(field)
+		}
+	}
+	f.numberFrames(res, b.Name(), current)
+	f.dateField(res, b.Name(), current)
+	f.artistFrames(res, b.Name(), 0, current)
+	f.ruleFrames(res, current)
+	if b == playlists {
+		// The whole playlist is in a single charset.
+		if cs := Consensus([]*Result{res}); cs != "" {
+			for _, field := range res.Prefer(cs) {
+				f.log.Printf(slog.LevelInfo, "field %q resolved by the charset of the playlist (%s): %q", field.Name(), cs, field.Winner.Text)
+			}
+		}
+	}
+	return res, nil
+}
+
+// Get the positions of the fields with every name.
+func fieldPositions(fields []TagField) map[string][]int {
+	pos := make(map[string][]int)
+	for i, field := range fields {
+		pos[field.Name] = append(pos[field.Name], i)
+	}
+	return pos
+}
+
+// Write the planned changes into the file in the format of the backend, see ApplyFunc.
+func (f *Fixer) applyFields(b TagBackend, p *FilePlan, before func(orig map[string][]FrameData) error) error {
+	fields, err := readFields(b, p.File)
+	if err != nil {
+		return readError(p.File, err)
+	}
+	f.wait(fieldsSize(fields))
+	pos := fieldPositions(fields)
+	orig := make(map[string][]FrameData)
+	removed := make(map[int]bool)
+	changed := false
+	for i := range p.Frames {
+		fp := &p.Frames[i]
+		if fp.Source != "" {
+			// The new field is added after the existing ones, if any appeared since.
+			if len(pos[fp.ID]) > 0 {
+				return staleError(p.File, "field %s has been added since the plan was made", fp.ID)
+			}
+			orig[fp.ID] = nil
+			fields = append(fields, TagField{Name: fp.ID, Value: fp.Text})
+			changed = true
+			continue
+		}
+		text, err := decodeText(fp.Original.Text, fp.Original.TextHex)
+		if err != nil {
+			return fmt.Errorf("field %s[%d]: %v", fp.ID, fp.Index, err)
+		}
+		if fp.Index >= len(pos[fp.ID]) {
+			return staleError(p.File, "field %s[%d] not found", fp.ID, fp.Index)
+		}
+		field := &fields[pos[fp.ID][fp.Index]]
+		if field.Value != text {
+			return staleError(p.File, "field %s[%d] has changed since the plan was made", fp.ID, fp.Index)
+		}
+		if _, ok := orig[fp.ID]; !ok {
+			for _, j := range pos[fp.ID] {
+				orig[fp.ID] = append(orig[fp.ID], NewFrameData(fieldFrame(fields[j])))
+			}
+		}
+		if field.Value != fp.Text || fp.Remove {
+			changed = true
+		}
+		field.Value = fp.Text
+		removed[pos[fp.ID][fp.Index]] = fp.Remove
+	}
+	if !changed {
+		f.log.Printf(slog.LevelInfo, "nothing changed, not written")
+		return nil
+	}
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	if before != nil {
+		if err := before(orig); err != nil {
+			return err
+		}
+	}
+	kept := fields[:0]
+	for i, field := range fields {
+		if !removed[i] {
+			kept = append(kept, field)
+		}
+	}
+	fields = kept
+	if err := writeFields(b, p.File, fields, f.saveOptions()); err != nil {
+		return writeError(p.File, err)
+	}
+	p.Written = true
+	if !f.opts.Verify {
+		return nil
+	}
+	if err := verifyFrames(p.File, fieldInfos(fields)); err != nil {
+		return writeError(p.File, err)
+	}
+	return nil
+}
+
+// Restore the fields of the file in the format of the backend, see RestoreFrames.
+func restoreFields(b TagBackend, path string, frames map[string][]FrameData) error {
+	fields, err := readFields(b, path)
+	if err != nil {
+		return err
+	}
+	var out []TagField
+	done := make(map[string]bool)
+	for _, field := range fields {
+		saved, ok := frames[field.Name]
+		if !ok {
+			out = append(out, field)
+			continue
+		}
+		// All fields with the name are put in place of the first one.
+		if done[field.Name] {
+			continue
+		}
+		done[field.Name] = true
+		for _, d := range saved {
+			text, err := decodeText(d.Text, d.TextHex)
+			if err != nil {
+				return fmt.Errorf("field %s: %v", field.Name, err)
+			}
+			out = append(out, TagField{Name: field.Name, Value: text})
+		}
+	}
+	var names []string
+	for name := range frames {
+		if !done[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, d := range frames[name] {
+			text, err := decodeText(d.Text, d.TextHex)
+			if err != nil {
+				return fmt.Errorf("field %s: %v", name, err)
+			}
+			out = append(out, TagField{Name: name, Value: text})
+		}
+	}
+	return writeFields(b, path, out, saveOptions{})
+}
@@ -0,0 +1,77 @@
+package fixtag
+
+import (
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+// Check whether the text is in capitals, i.e. it has a few Cyrillic
+// letters and no small letters at all.
+func allCaps(text string) bool {
+	cyrillic := 0
+	for _, c := range text {
+		switch {
+		case unicode.IsLower(c):
+			return false
+		case unicode.Is(unicode.Cyrillic, c):
+			cyrillic++
+		}
+	}
+	return cyrillic > 1
+}
+
+// Lowercase the Cyrillic letters of the text in capitals but the first one
+// of every sentence, and spell the words and the phrases of keep as given
+// there wherever they are found, case-insensitively, as whole words.  The
+// words in Latin, e.g. "(LIVE)" or "DJ", are kept as they are.
+func capitalize(text string, keep []string) string {
+	out := []rune(text)
+	start := true
+	for i, c := range out {
+		switch {
+		case wordRune(c):
+			if !start && unicode.Is(unicode.Cyrillic, c) {
+				out[i] = unicode.ToLower(c)
+			}
+			start = false
+		case c == '.' || c == '!' || c == '?':
+			start = true
+		}
+	}
+	for _, k := range keep {
+		word := []rune(k)
+		lower := []rune(strings.ToLower(k))
+		if len(lower) != len(word) || len(word) == 0 {
+			continue
+		}
+		for i := 0; i+len(lower) <= len(out); i++ {
+			if i > 0 && wordRune(out[i-1]) || i+len(lower) < len(out) && wordRune(out[i+len(lower)]) {
+				continue
+			}
+			if strings.ToLower(string(out[i:i+len(lower)])) == string(lower) {
+				copy(out[i:], word)
+			}
+		}
+	}
+	return string(out)
+}
+
+// Check whether the character continues a word, see capitalize.
+func wordRune(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// Lowercase the converted text in capitals, see capitalize.
+func (f *Fixer) capitalizeFields(res *Result) {
+	if !f.opts.Capitalize {
+		return
+	}
+	for _, field := range res.Fields() {
+		if field.Action == ActionConverted && field.Field == FieldText && allCaps(field.Winner.Text) {
+			text := capitalize(field.Winner.Text, f.opts.KeepCase)
+			f.log.Printf(slog.LevelDebug, "frame %q capitalized into %q", field.Name(), text)
+			field.Winner.Text = text
+		}
+	}
+}
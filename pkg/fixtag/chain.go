@@ -0,0 +1,83 @@
+package fixtag
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// Short names of the charsets, used in the names of the built-in chains.
+var charsetAliases = map[string]encoding.Encoding{
+	"win":     charmap.Windows1251,
+	"win1251": charmap.Windows1251,
+	"koi":     charmap.KOI8R,
+	"dos":     charmap.CodePage866,
+	"iso":     charmap.ISO8859_1,
+	"latin1":  charmap.ISO8859_1,
+}
+
+// Find the encoding by the charset name, either a short alias, an IANA name
+// or a WHATWG label.  The IANA names go first, so that "iso-8859-1" is the
+// real Latin-1 rather than Windows-1252.
+func charsetByName(name string) (encoding.Encoding, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if e, ok := charsetAliases[name]; ok {
+		return e, nil
+	}
+	if e, err := ianaindex.IANA.Encoding(name); err == nil && e != nil {
+		return e, nil
+	}
+	// Also accept the ISO names without the dash, e.g. "iso8859-1".
+	if e, err := ianaindex.IANA.Encoding(strings.Replace(name, "iso8859", "iso-8859", 1)); err == nil && e != nil {
+		return e, nil
+	}
+	if e, err := htmlindex.Get(name); err == nil {
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown charset %q", name)
+}
+
+// A custom chain of transformations, e.g. "iso8859-1>win1251".
+// All charsets but the last one are used to encode the text,
+// and the last one decodes it.
+type chain struct {
+	name      string
+	charsets  []string
+	encodings []encoding.Encoding
+}
+
+// ParseChain checks the chain of charsets separated by ">", e.g. "cp866" or
+// "iso8859-1>win1251".  The text is encoded into all charsets but the last
+// one in turn and then decoded from the last charset.
+func ParseChain(spec string) error {
+	_, err := parseChain(spec)
+	return err
+}
+
+func parseChain(spec string) (*chain, error) {
+	c := &chain{name: strings.TrimSpace(spec)}
+	for _, name := range strings.Split(spec, ">") {
+		e, err := charsetByName(name)
+		if err != nil {
+			return nil, fmt.Errorf("chain %q: %v", spec, err)
+		}
+		c.charsets = append(c.charsets, strings.ToLower(strings.TrimSpace(name)))
+		c.encodings = append(c.encodings, e)
+	}
+	return c, nil
+}
+
+// Build the combination with fresh transformers.
+func (c *chain) combination() combination {
+	last := len(c.encodings) - 1
+	cmb := combination{name: c.name, charset: c.charsets[last]}
+	for _, e := range c.encodings[:last] {
+		cmb.tlist = append(cmb.tlist, e.NewEncoder())
+	}
+	cmb.tlist = append(cmb.tlist, c.encodings[last].NewDecoder())
+	return cmb
+}
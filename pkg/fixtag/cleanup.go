@@ -0,0 +1,154 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// The chain of the fields changed by the cleanup only, see Options.
+const ChainCleanup = "cleanup"
+
+// Cleanup tidies the text of the frames after the conversion, e.g. the
+// no-break spaces and the C1 controls left by the legacy charsets.
+type Cleanup struct {
+	NFC      bool            // compose the characters, e.g. "и" and U+0306 into "й"
+	Controls bool            // strip the control characters but the tabs and the newlines, and the zero-width ones
+	Spaces   bool            // replace the runs of the spaces, the no-break ones included, with a single space, and trim them
+	Punct    map[rune]string // the replacements of the punctuation, e.g. of '«' with "\""
+}
+
+// The replacements of the typographic quotes, dashes and ellipsis with the
+// ASCII ones, see DefaultPunctuation.
+var defaultPunctuation = map[rune]string{
+	'«': `"`, '»': `"`, '“': `"`, '”': `"`, '„': `"`, '‟': `"`,
+	'‘': "'", '’': "'", '‚': "'", '‛': "'",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-",
+	'…': "...",
+}
+
+// DefaultPunctuation gets the replacements of the typographic quotes, dashes
+// and ellipsis with the ASCII ones.  Every call returns a new map, which the
+// caller may change.
+func DefaultPunctuation() map[rune]string {
+	return maps.Clone(defaultPunctuation)
+}
+
+// ParseCleanup parses the comma-separated list of the cleanups: "nfc",
+// "controls", "spaces" and "punct", the latter with DefaultPunctuation.
+func ParseCleanup(spec string) (*Cleanup, error) {
+	c := &Cleanup{}
+	for _, name := range strings.Split(spec, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "nfc":
+			c.NFC = true
+		case "controls":
+			c.Controls = true
+		case "spaces":
+			c.Spaces = true
+		case "punct":
+			c.Punct = DefaultPunctuation()
+		default:
+			return nil, fmt.Errorf("invalid cleanup %q, must be nfc, controls, spaces or punct", name)
+		}
+	}
+	return c, nil
+}
+
+// ParsePunctuation parses the comma-separated replacements of the
+// punctuation "CHARS=TEXT", e.g. `«»=",—–=-`, every one of the characters
+// being replaced with the text, which may be empty.
+func ParsePunctuation(spec string) (map[rune]string, error) {
+	out := make(map[rune]string)
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		i := strings.LastIndex(item, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid replacement of the punctuation %q, must be CHARS=TEXT", item)
+		}
+		for _, c := range item[:i] {
+			out[c] = item[i+1:]
+		}
+	}
+	return out, nil
+}
+
+// Check whether the character is stripped with Controls.
+func strippedChar(c rune) bool {
+	if c == '\t' || c == '\n' {
+		return false
+	}
+	return unicode.IsControl(c) || unicode.Is(unicode.Cf, c)
+}
+
+// Clean the text up.  The text is kept if nothing would be left of it.
+func (c *Cleanup) apply(text string) string {
+	out := text
+	if c.NFC {
+		out = norm.NFC.String(out)
+	}
+	var b strings.Builder
+	space := false
+	for _, r := range out {
+		if c.Controls && strippedChar(r) {
+			continue
+		}
+		if c.Spaces && unicode.IsSpace(r) && r != '\n' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		if s, ok := c.Punct[r]; ok {
+			b.WriteString(s)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	if out = b.String(); c.Spaces {
+		out = strings.TrimSpace(out)
+	}
+	if strings.TrimSpace(out) == "" {
+		return text
+	}
+	return out
+}
+
+// Mark the correct field to be written cleaned up, if the cleanup
+// changes it.  It returns false if the field need not be written.
+func (f *Fixer) cleanupField(res *Field) bool {
+	if f.opts.Cleanup == nil || res.Field != FieldText {
+		return false
+	}
+	text := f.opts.Cleanup.apply(res.Orig)
+	if text == res.Orig {
+		return false
+	}
+	res.Winner = &Candidate{Chain: ChainCleanup, Charset: "utf-8", Text: text, Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
+
+// Clean the converted fields up.
+func (f *Fixer) cleanupFields(res *Result) {
+	if f.opts.Cleanup == nil {
+		return
+	}
+	for _, field := range res.Fields() {
+		if field.Action == ActionConverted && field.Field == FieldText {
+			if text := f.opts.Cleanup.apply(field.Winner.Text); text != field.Winner.Text {
+				f.log.Printf(slog.LevelDebug, "frame %q cleaned up into %q", field.Name(), text)
+				field.Winner.Text = text
+			}
+		}
+	}
+}
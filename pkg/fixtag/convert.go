@@ -0,0 +1,357 @@
+package fixtag
+
+import (
+	"container/list"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// The function counts the non-ASCII capital letters following a small letter
+// within a word, e.g. in "гПСООЮ".  Such mixed case is typical for the text
+// decoded with a wrong charset, e.g. KOI8-R instead of Windows-1251.
+func countMixedCase(s string) int {
+	mixed := 0
+	prevLower := false
+	for _, c := range s {
+		letter := c > 0x7f && unicode.IsLetter(c)
+		if letter && prevLower && unicode.IsUpper(c) {
+			mixed++
+		}
+		prevLower = letter && unicode.IsLower(c)
+	}
+	return mixed
+}
+
+// StringTrans is the interface similar to that of encoding.Decoder and encoding.Encoder.
+type StringTrans interface {
+	String(src string) (string, error)
+}
+
+// Apply a number of transformations to the string.
+func decode(log Logger, src string, tlist ...StringTrans) (string, error) {
+	for _, f := range tlist {
+		dst, err := f.String(src)
+		// Also try transforming with a few characters at the end stripped,
+		// one by one, or the bytes if the text is not UTF-8.
+		for i, s := 0, src; err != nil && i < maxGarbage && utf8.RuneCountInString(s) > 4; i++ {
+			_, n := utf8.DecodeLastRuneInString(s)
+			s = s[:len(s)-n]
+			dst, err = f.String(s)
+		}
+		if err != nil {
+			log.Printf(slog.LevelDebug, "failed: %v", err)
+			return "", err
+		}
+		log.Printf(slog.LevelDebug, "converted %s => %s", Dump(src), Dump(dst))
+		src = dst
+	}
+	return src, nil
+}
+
+// Dump shows the string with both symbol and hex representation.
+func Dump(in string) string {
+	return fmt.Sprintf("%q [% x]", in, []byte(in))
+}
+
+// A chain of transformations to attempt.
+type combination struct {
+	name    string
+	charset string // the charset the text is finally decoded from
+	tlist   []StringTrans
+}
+
+// The charsets the text may be decoded from.
+const (
+	CharsetCP1251   = "cp1251"
+	CharsetKOI8R    = "koi8-r"
+	CharsetCP866    = "cp866"
+	CharsetCP1253   = "cp1253"
+	CharsetISO88597 = "iso-8859-7"
+	CharsetShiftJIS = "shift_jis"
+	CharsetGBK      = "gbk"
+	CharsetBig5     = "big5"
+	CharsetEUCKR    = "euc-kr"
+	CharsetUTF8     = "utf-8" // for incorrect encoding field
+	CharsetUTF16LE  = "utf-16le"
+)
+
+// Charsets lists all supported charsets to decode from.  The slice is a copy,
+// changing it does not change the charsets of the package.
+func Charsets() []string {
+	return slices.Clone(allCharsets)
+}
+
+var allCharsets = []string{CharsetCP1251, CharsetKOI8R, CharsetCP866, CharsetCP1253, CharsetISO88597,
+	CharsetShiftJIS, CharsetGBK, CharsetBig5, CharsetEUCKR, CharsetUTF8}
+
+// Build the combinations for the given charsets.  The transformers are
+// stateful, so every goroutine must have its own combinations.
+func newCombinations(charsets []string) []combination {
+	win := charmap.Windows1251.NewDecoder()
+	koi := charmap.KOI8R.NewDecoder()
+	dos := charmap.CodePage866.NewDecoder()
+	grk := charmap.Windows1253.NewDecoder()
+	iso7 := charmap.ISO8859_7.NewDecoder()
+	sjis := japanese.ShiftJIS.NewDecoder()
+	gbk := simplifiedchinese.GBK.NewDecoder()
+	big5 := traditionalchinese.Big5.NewDecoder()
+	kor := korean.EUCKR.NewDecoder()
+	enc := charmap.Windows1251.NewEncoder()
+	iso := charmap.ISO8859_1.NewEncoder()
+
+	all := []combination{
+		{"win", CharsetCP1251, []StringTrans{win}},
+		{"enc-iso-win", CharsetCP1251, []StringTrans{enc, iso, win}},
+		{"iso-win", CharsetCP1251, []StringTrans{iso, win}},
+		{"koi", CharsetKOI8R, []StringTrans{koi}},
+		{"enc-iso-koi", CharsetKOI8R, []StringTrans{enc, iso, koi}},
+		{"iso-koi", CharsetKOI8R, []StringTrans{iso, koi}},
+		{"dos", CharsetCP866, []StringTrans{dos}},
+		{"enc-iso-dos", CharsetCP866, []StringTrans{enc, iso, dos}},
+		{"iso-dos", CharsetCP866, []StringTrans{iso, dos}},
+		{"grk", CharsetCP1253, []StringTrans{grk}},
+		{"iso-grk", CharsetCP1253, []StringTrans{iso, grk}},
+		{"iso7", CharsetISO88597, []StringTrans{iso7}},
+		{"iso-iso7", CharsetISO88597, []StringTrans{iso, iso7}},
+		{"sjis", CharsetShiftJIS, []StringTrans{sjis}},
+		{"iso-sjis", CharsetShiftJIS, []StringTrans{iso, sjis}},
+		{"gbk", CharsetGBK, []StringTrans{gbk}},
+		{"iso-gbk", CharsetGBK, []StringTrans{iso, gbk}},
+		{"big5", CharsetBig5, []StringTrans{big5}},
+		{"iso-big5", CharsetBig5, []StringTrans{iso, big5}},
+		{"kor", CharsetEUCKR, []StringTrans{kor}},
+		{"iso-kor", CharsetEUCKR, []StringTrans{iso, kor}},
+		{"iso", CharsetUTF8, []StringTrans{iso}}, // for incorrect encoding field.
+		{"iso-dbl-1252", CharsetUTF8, []StringTrans{iso, charmap.Windows1252.NewEncoder()}},
+		{"iso-dbl-1251", CharsetUTF8, []StringTrans{iso, enc}},
+	}
+	var out []combination
+	for _, cmb := range all {
+		for _, cs := range charsets {
+			if cmb.charset == cs {
+				out = append(out, cmb)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// Candidate is a possible result of the field conversion.
+type Candidate struct {
+	Chain    string  // the name of the chain of transformations
+	Charset  string  // the charset the text is finally decoded from
+	Text     string  // the decoded text
+	Goodness float64 // the ratio of the correct characters, in range [0..1]
+	Score    float64 // the average log probability of the letter n-grams, if scored
+	scored   bool    // whether there is an n-gram model for the text
+	mixed    int
+	length   int // in runes
+}
+
+// Scored tells whether the Score is set, i.e. there is an n-gram model for the text.
+func (c *Candidate) Scored() bool {
+	return c.scored
+}
+
+// Attempt is a single conversion tried for the field.
+type Attempt struct {
+	Candidate
+	Err error // set if the text could not be decoded
+}
+
+// Check whether the candidate is a better result than the other one.
+func (c *Candidate) betterThan(o *Candidate) bool {
+	if c.Goodness != o.Goodness {
+		return c.Goodness > o.Goodness
+	}
+	if c.mixed != o.mixed {
+		return c.mixed < o.mixed
+	}
+	// The letter pairs of a wrong conversion are unusual for the language.
+	if c.scored && o.scored && math.Abs(c.Score-o.Score) > scoreMargin {
+		return c.Score > o.Score
+	}
+	// The multi-byte charsets decode the mojibake into a shorter text,
+	// while a wrong chain makes a character of every byte.
+	return c.length < o.length
+}
+
+// Add the candidate to the list, unless there is one with the same text already.
+func addCandidate(cands []*Candidate, c *Candidate) []*Candidate {
+	for i, o := range cands {
+		if o.Text == c.Text {
+			if c.betterThan(o) {
+				cands[i] = c
+			}
+			return cands
+		}
+	}
+	return append(cands, c)
+}
+
+// The most conversions kept by the cache, so that it does not grow without
+// bound in a long run, e.g. watching the directories.
+const conversionCacheSize = 10000
+
+// The conversions of the texts made by the Fixer, so that the same text,
+// e.g. the artist of every file of an album, is converted only once.  The
+// least recently used conversions are dropped once there are too many.
+type conversionCache struct {
+	mu    sync.Mutex
+	size  int
+	m     map[conversionKey]*list.Element
+	order *list.List // of *cachedConversion, the most recently used first
+}
+
+type conversionKey struct {
+	value     string  // trimmed
+	fix       int     // see fixNone
+	threshold float64 // of the frame
+	frame     string  // the key of the frame, if the predicates may depend on it
+}
+
+type cachedConversion struct {
+	key   conversionKey
+	field *Field
+}
+
+// Make the cache keeping at most the given number of conversions.
+func newConversionCache(size int) *conversionCache {
+	return &conversionCache{size: size, m: make(map[conversionKey]*list.Element), order: list.New()}
+}
+
+// Copy the cached conversion of the text into the field, if any.
+func (c *conversionCache) restore(key conversionKey, res *Field) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.m[key]
+	if !ok {
+		return false
+	}
+	c.order.MoveToFront(e)
+	cached := e.Value.(*cachedConversion).field
+	res.Attempts = append([]Attempt(nil), cached.Attempts...)
+	res.Candidates = nil
+	for _, cand := range cached.Candidates {
+		cp := *cand
+		res.Candidates = append(res.Candidates, &cp)
+	}
+	res.Best = cached.Best
+	return true
+}
+
+// Cache the conversion of the text made into the field.
+func (c *conversionCache) save(key conversionKey, res *Field) {
+	cached := &Field{Attempts: res.Attempts, Best: res.Best}
+	for _, cand := range res.Candidates {
+		cp := *cand
+		cached.Candidates = append(cached.Candidates, &cp)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.m[key]; ok {
+		e.Value.(*cachedConversion).field = cached
+		c.order.MoveToFront(e)
+		return
+	}
+	c.m[key] = c.order.PushFront(&cachedConversion{key: key, field: cached})
+	if c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.m, last.Value.(*cachedConversion).key)
+	}
+}
+
+// Attempt to convert a single text field, trying all combinations, unless
+// the same text has been converted before.
+func (f *Fixer) convertField(combinations []combination, res *Field) {
+	log := f.log
+	key := res.Name()
+	log.Printf(slog.LevelDebug, "processing frame %q...", key)
+	value := trimGarbage(res.Orig)
+	ck := conversionKey{value: value, fix: res.fix, threshold: f.threshold(res.Key)}
+	if len(f.opts.Accept) > 0 {
+		ck.frame = res.Key
+	}
+	if f.cache.restore(ck, res) {
+		log.Printf(slog.LevelDebug, "the same text has been converted before")
+		f.resolveField(res)
+		return
+	}
+	defer f.cache.save(ck, res)
+	if f.opts.Detect && f.convertDetected(res, value) {
+		return
+	}
+	best := 0.0
+	var cands []*Candidate
+	for _, cmb := range combinations {
+		log.Printf(slog.LevelDebug, "attempting %s...", cmb.name)
+		val, err := decode(log, value, cmb.tlist...)
+		if err != nil && f.opts.ReplaceInvalid {
+			val, err = decodeReplacing(log, value, cmb.tlist...)
+		}
+		if err != nil {
+			res.Attempts = append(res.Attempts, Attempt{Candidate: Candidate{Chain: cmb.name, Charset: cmb.charset}, Err: err})
+			continue
+		}
+		goodness := f.alpha.Goodness(val)
+		if goodness > best {
+			best = goodness
+		}
+		c := &Candidate{Chain: cmb.name, Charset: cmb.charset, Text: val, Goodness: goodness, mixed: countMixedCase(val), length: utf8.RuneCountInString(val)}
+		f.score(c)
+		res.Attempts = append(res.Attempts, Attempt{Candidate: *c})
+		if goodness < f.threshold(res.Key) {
+			log.Printf(slog.LevelDebug, "failed (bad result %f)!", goodness)
+			continue
+		}
+		if !f.acceptable(res, c) {
+			continue
+		}
+		log.Printf(slog.LevelDebug, "frame %q converted to %q, goodness %f", key, val, goodness)
+		cands = addCandidate(cands, c)
+	}
+	// The best candidates go first.
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].betterThan(cands[j]) })
+	res.Candidates = cands
+	res.Best = best
+	f.resolveField(res)
+}
+
+// Choose the winner of the candidates of the field, if there is a single
+// best one.
+func (f *Fixer) resolveField(res *Field) {
+	log := f.log
+	key := res.Name()
+	cands := res.Candidates
+	best := res.Best
+	switch {
+	case len(cands) == 0:
+		log.Printf(slog.LevelWarn, "Warning: could not convert frame %s, best result is %f", key, best)
+		res.Action = ActionFailed
+	case len(cands) > 1 && !cands[0].betterThan(cands[1]):
+		ambiguous := 1
+		for ambiguous < len(cands) && !cands[0].betterThan(cands[ambiguous]) {
+			ambiguous++
+		}
+		log.Printf(slog.LevelWarn, "Warning: ambiguous conversion for frame %s -- got %d possible results, best is %f", key, ambiguous, best)
+		res.Action = ActionAmbiguous
+	default:
+		res.Winner = cands[0]
+		log.Printf(slog.LevelInfo, "frame %q decoded from %s (%s): %q", key, res.Winner.Charset, res.Winner.Chain, res.Winner.Text)
+		res.Action = ActionConverted
+	}
+}
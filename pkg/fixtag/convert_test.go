@@ -0,0 +1,31 @@
+package fixtag
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestConversionCacheSize(t *testing.T) {
+	c := newConversionCache(2)
+	key := func(i int) conversionKey { return conversionKey{value: fmt.Sprint(i)} }
+	save := func(i int) {
+		c.save(key(i), &Field{Best: float64(i)})
+	}
+	save(1)
+	save(2)
+	// The first is used, so the second is the least recently used one.
+	var res Field
+	if !c.restore(key(1), &res) || res.Best != 1 {
+		t.Fatalf("restore(1) = %+v", res)
+	}
+	save(3)
+	for i, want := range map[int]bool{1: true, 2: false, 3: true} {
+		var res Field
+		if got := c.restore(key(i), &res); got != want || got && res.Best != float64(i) {
+			t.Errorf("restore(%d) = %v, %+v, want %v", i, got, res, want)
+		}
+	}
+	if len(c.m) != 2 || c.order.Len() != 2 {
+		t.Errorf("the cache keeps %d, %d conversions, want 2", len(c.m), c.order.Len())
+	}
+}
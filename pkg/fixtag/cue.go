@@ -0,0 +1,290 @@
+package fixtag
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2"
+)
+
+// The CUE sheets.  The fields are the values of the commands, e.g. "TITLE",
+// "PERFORMER" or "FILE"; the sheet is written back in UTF-8.
+type cueBackend struct{}
+
+var cueSheets = cueBackend{}
+
+// SourceCue marks the frames set from the CUE sheet, see CueTags.
+const SourceCue = "cue"
+
+// The commands of the CUE sheet with the text values.
+var cueCommands = map[string]bool{"TITLE": true, "PERFORMER": true, "SONGWRITER": true, "FILE": true}
+
+// Parse the CUE sheet into the lines, the byte order mark is returned too.
+// The comments, e.g. "REM GENRE", are fields with the name "REM GENRE".
+func parseCue(data []byte) (string, []playlistLine) {
+	return splitLines(data, func(l *playlistLine, text string) {
+		rest := strings.TrimLeft(text, " \t")
+		indent := text[:len(text)-len(rest)]
+		command, rest, _ := strings.Cut(rest, " ")
+		name := strings.ToUpper(command)
+		if name == "REM" {
+			var key string
+			key, rest, _ = strings.Cut(rest, " ")
+			command += " " + key
+			name += " " + strings.ToUpper(key)
+		} else if !cueCommands[name] {
+			return
+		}
+		if rest == "" {
+			return
+		}
+		l.name, l.prefix, l.value = name, indent+command+" ", rest
+		if strings.HasPrefix(rest, `"`) {
+			if i := strings.LastIndexByte(rest, '"'); i > 0 {
+				l.prefix += `"`
+				l.value, l.suffix = rest[1:i], rest[i:]
+			}
+		} else if name == "FILE" {
+			// The file type follows the unquoted name.
+			if i := strings.LastIndexByte(rest, ' '); i > 0 {
+				l.value, l.suffix = rest[:i], rest[i:]
+			}
+		}
+	})
+}
+
+func (cueBackend) Name() string {
+	return "cue"
+}
+
+func (cueBackend) Extensions() []string {
+	return []string{".cue"}
+}
+
+func (cueBackend) ReadFields(f *os.File) ([]TagField, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	_, lines := parseCue(data)
+	return lineFields(lines), nil
+}
+
+func (cueBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	bom, lines := parseCue(data)
+	return copyLines(w, bom, lines, fields)
+}
+
+// A track of the CUE sheet.
+type cueTrack struct {
+	file      string
+	number    int
+	title     string
+	performer string
+}
+
+// The keys of the tag fields set from the CUE sheet, the file name or
+// a database: the title, the performer, the album, the track number, the year
+// and the genre.  The key of the track number is empty if it is not a text field.
+type cueKeys struct {
+	title, performer, album, track, year, genre string
+}
+
+// The keys of the formats by the names of the backends, the ID3v2 frames
+// for MP3 and AIFF.  The year of ID3v2.3 is in TYER.
+var cueFormats = map[string]cueKeys{
+	"":     {"TIT2", "TPE1", "TALB", "TRCK", "TDRC", "TCON"},
+	"flac": {"TITLE", "ARTIST", "ALBUM", "TRACKNUMBER", "DATE", "GENRE"},
+	"ogg":  {"TITLE", "ARTIST", "ALBUM", "TRACKNUMBER", "DATE", "GENRE"},
+	"ape":  {"Title", "Artist", "Album", "Track", "Year", "Genre"},
+	"mp4":  {"©nam", "©ART", "©alb", "", "©day", "©gen"},
+	"wav":  {"INAM", "IART", "IPRD", "ITRK", "ICRD", "IGNR"},
+}
+
+// CueTags makes the plans of setting the tags of the audio files referenced
+// by the CUE sheet: the title, the performer, the album and the track number,
+// as converted in the result of the sheet.  Only the files with a single track
+// are tagged, the files with several tracks cannot have the tags per track.
+// The frames already having the values are not planned, nor is the track
+// number if there is one.
+func (f *Fixer) CueTags(res *Result) ([]*FilePlan, error) {
+	data, err := os.ReadFile(res.File)
+	if err != nil {
+		return nil, err
+	}
+	_, lines := parseCue(data)
+	converted := make(map[string]string)
+	for _, fi := range res.Frames {
+		for _, field := range fi.Fields {
+			switch field.Action {
+			case ActionConverted, ActionWritten, ActionWriteFailed:
+				converted[field.Name()] = field.Winner.Text
+			}
+		}
+	}
+	// Get the values of the lines, the ones not converted are ignored
+	// unless they are valid UTF-8.
+	count := make(map[string]int)
+	value := func(l playlistLine) string {
+		field := &Field{Key: l.name, Index: count[l.name], Field: FieldText}
+		count[l.name]++
+		if text, ok := converted[field.Name()]; ok {
+			return text
+		}
+		if !utf8.ValidString(l.value) {
+			return ""
+		}
+		return l.value
+	}
+
+	// The commands before the first track are of the whole disc.
+	var album, performer, file string
+	var tracks []*cueTrack
+	var track *cueTrack
+	perFile := make(map[string]int)
+	for _, l := range lines {
+		switch l.name {
+		case "FILE":
+			file = value(l)
+		case "TITLE":
+			if track == nil {
+				album = value(l)
+			} else {
+				track.title = value(l)
+			}
+		case "PERFORMER":
+			if track == nil {
+				performer = value(l)
+			} else {
+				track.performer = value(l)
+			}
+		case "":
+			words := strings.Fields(l.prefix)
+			if len(words) >= 2 && strings.EqualFold(words[0], "TRACK") && file != "" {
+				n, _ := strconv.Atoi(words[1])
+				track = &cueTrack{file: file, number: n}
+				tracks = append(tracks, track)
+				perFile[file]++
+			}
+		default:
+			value(l)
+		}
+	}
+
+	var out []*FilePlan
+	for _, t := range tracks {
+		path := filepath.Join(filepath.Dir(res.File), t.file)
+		if perFile[t.file] > 1 {
+			f.log.Printf(slog.LevelInfo, "file %q has %d tracks, not tagged", path, perFile[t.file])
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			f.log.Printf(slog.LevelWarn, "file %q of track %d not found", path, t.number)
+			continue
+		}
+		if t.performer == "" {
+			t.performer = performer
+		}
+		p, err := f.valuesPlan(path, tagValues{title: t.title, artist: t.performer, album: album, track: t.number}, SourceCue)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if len(p.Frames) > 0 {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// The values of the tag set from the CUE sheet, the file name or a database.
+type tagValues struct {
+	title, artist, album string
+	track                int
+	year, genre          string
+}
+
+// Make the plan of setting the values into the tag of the file.  The frames
+// already having the values are not planned, nor is the track number if
+// there is one.  The values from the file name only fill the empty frames,
+// and so do the year and the genre from Discogs.  The values are
+// transliterated if set in the options.
+func (f *Fixer) valuesPlan(path string, v tagValues, source string) (*FilePlan, error) {
+	name := ""
+	b := backendFor(path)
+	if b != nil {
+		name = b.Name()
+	}
+	keys, ok := cueFormats[name]
+	if !ok {
+		return nil, fmt.Errorf("cannot set the tags of %s files", name)
+	}
+	// Get the current values of the fields, the first one for every key.
+	current := make(map[string]TextFrame)
+	if b != nil {
+		fields, err := readFields(b, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if _, ok := current[field.Name]; !ok {
+				current[field.Name] = fieldFrame(field)
+			}
+		}
+	} else {
+		tag, err := openTag(path)
+		if err != nil {
+			return nil, err
+		}
+		defer tag.Close()
+		if tag.Version() == 3 {
+			keys.year = "TYER"
+		}
+		for _, key := range []string{keys.title, keys.performer, keys.album, keys.track, keys.year, keys.genre} {
+			if framers := tag.GetFrames(key); len(framers) > 0 {
+				if tf, ok := ToTextFrame(framers[0]); ok {
+					current[key] = tf
+				}
+			}
+		}
+	}
+
+	p := &FilePlan{File: path}
+	set := func(key, text string, fill bool) {
+		if key == "" || text == "" {
+			return
+		}
+		text = f.translitText(text)
+		fp := FramePlan{ID: key, Text: text}
+		if tf, ok := current[key]; ok {
+			if tf.Text == text || fill && strings.TrimSpace(tf.Text) != "" {
+				return
+			}
+			fp.Original = NewFrameData(tf)
+		} else {
+			fp.Original = NewFrameData(TextFrame{Encoding: id3v2.EncodingUTF8})
+			fp.Source = source
+		}
+		p.Frames = append(p.Frames, fp)
+	}
+	fill := source == SourceFilename
+	set(keys.title, v.title, fill)
+	set(keys.performer, v.artist, fill)
+	set(keys.album, v.album, fill)
+	if v.track > 0 {
+		// The existing number may have the total, e.g. "1/12".
+		set(keys.track, strconv.Itoa(v.track), true)
+	}
+	set(keys.year, v.year, true)
+	set(keys.genre, v.genre, true)
+	return p, nil
+}
@@ -0,0 +1,26 @@
+package fixtag
+
+import "testing"
+
+func TestCueRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text func(enc func(string) string) string
+	}{
+		{"crlf", func(enc func(string) string) string {
+			return "REM GENRE " + enc("Русский рок") + "\r\nREM DATE 1988\r\n" +
+				"PERFORMER \"" + enc("Кино") + "\"\r\nTITLE \"" + enc("Группа крови") + "\"\r\n" +
+				"FILE \"" + enc("01 Группа крови.wav") + "\" WAVE\r\n" +
+				"  TRACK 01 AUDIO\r\n    TITLE \"" + enc("Группа крови") + "\"\r\n    PERFORMER \"" + enc("Кино") + "\"\r\n    INDEX 01 00:00:00\r\n" +
+				"FILE " + enc("02 Закрой за мной дверь.wav") + " WAVE\r\n" +
+				"  TRACK 02 AUDIO\r\n    TITLE \"" + enc("Закрой за мной дверь") + "\"\r\n    INDEX 01 00:00:00\r\n"
+		}},
+		{"bom", func(enc func(string) string) string {
+			return utf8BOM + "TITLE " + enc("Группа крови") + "\nFILE \"album.flac\" WAVE\n\tTRACK 01 AUDIO\n\t\tSONGWRITER \"" + enc("Цой") + "\"\n"
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkLinesRoundTrip(t, cueSheets, "test.cue", tt.text)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+)
+
+// The chain of the dates normalized, see Options.
+const ChainDate = "date"
+
+// SourceDate marks the date frames made of the frames of the other version.
+const SourceDate = "date"
+
+// The keys of the dates by the names of the backends other than ID3v2,
+// see tagInfoKeys.  Their format is only normalized.
+var dateKeys = map[string]string{
+	"flac": "DATE",
+	"ogg":  "DATE",
+	"ape":  "Year",
+	"mp4":  "©day",
+	"wav":  "ICRD",
+}
+
+// The date frames of ID3v2.3 and ID3v2.4.
+var id3DateKeys = []string{"TYER", "TDAT", "TIME", "TORY", "TDRC", "TDOR"}
+
+var (
+	// The ISO 8601 timestamp, which is valid as is.
+	timestampRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}(:\d{2})?$`)
+	// The year, maybe with the month and the day, e.g. "1988.05.12".
+	yearFirstRe = regexp.MustCompile(`^(\d{4})(?:[-./](\d{1,2})(?:[-./](\d{1,2}))?)?$`)
+	// The day, the month and the year, e.g. "12.05.1988".
+	dayFirstRe = regexp.MustCompile(`^(\d{1,2})[-./](\d{1,2})[-./](\d{4})$`)
+)
+
+// Normalize the date as "yyyy", "yyyy-MM" or "yyyy-MM-dd", keeping the valid
+// timestamps with the time.  It returns false if the text is not a date.
+func normalizeDate(text string) (string, bool) {
+	if timestampRe.MatchString(text) {
+		return text, true
+	}
+	var year, month, day string
+	if m := yearFirstRe.FindStringSubmatch(text); m != nil {
+		year, month, day = m[1], m[2], m[3]
+	} else if m := dayFirstRe.FindStringSubmatch(text); m != nil {
+		year, month, day = m[3], m[2], m[1]
+	} else {
+		return "", false
+	}
+	date := year
+	if month != "" {
+		n, _ := strconv.Atoi(month)
+		if n < 1 || n > 12 {
+			return "", false
+		}
+		date += fmt.Sprintf("-%02d", n)
+	}
+	if day != "" {
+		n, _ := strconv.Atoi(day)
+		if n < 1 || n > 31 {
+			return "", false
+		}
+		date += fmt.Sprintf("-%02d", n)
+	}
+	return date, true
+}
+
+// Get the timestamp of the ID3v2.3 frames, TYER with TDAT as DDMM and TIME
+// as HHMM.  It returns false if the year is not valid.
+func id3v23Date(year, date, tm string) (string, bool) {
+	ts, ok := normalizeDate(year)
+	if !ok || len(ts) != 4 {
+		// The year may be the whole date.
+		return ts, ok
+	}
+	if len(date) == 4 {
+		if d, ok := normalizeDate(ts + "-" + date[2:] + "-" + date[:2]); ok {
+			ts = d
+			if len(tm) == 4 {
+				ts += "T" + tm[:2] + ":" + tm[2:]
+			}
+		}
+	}
+	return ts, true
+}
+
+// Move the dates of the result into the frames of the version, if set in the
+// options: TDRC and TDOR of ID3v2.4, or TYER, TDAT, TIME and TORY of ID3v2.3,
+// removing the frames of the other version.  The dates are normalized, see
+// normalizeDate.  The dates not valid are kept as they are.
+func (f *Fixer) dateFrames(res *Result, version byte, current map[string]TextFrame) {
+	if !f.opts.FixDates {
+		return
+	}
+	for _, fi := range res.Frames {
+		for _, key := range id3DateKeys {
+			if fi.Key == key {
+				// The date is being converted, e.g. from the ID3v1 tag.
+				return
+			}
+		}
+	}
+	text := func(key string) string { return current[key].Text }
+
+	// The recording date of ID3v2.4 is preferred, unless ID3v2.3 has more of it.
+	var rec string
+	recOK := true
+	if text("TDRC") != "" {
+		rec, recOK = normalizeDate(text("TDRC"))
+	}
+	if text("TYER") != "" {
+		old, ok := id3v23Date(text("TYER"), text("TDAT"), text("TIME"))
+		switch {
+		case !ok:
+			recOK = false
+		case rec == "" || len(old) > len(rec) && old[:4] == rec[:4]:
+			rec = old
+		}
+	}
+	var orig string
+	origOK := true
+	if text("TDOR") != "" {
+		orig, origOK = normalizeDate(text("TDOR"))
+	} else if text("TORY") != "" {
+		orig, origOK = normalizeDate(text("TORY"))
+	}
+	if !recOK || !origOK {
+		f.log.Printf(slog.LevelWarn, "the dates are not valid, not moved: TDRC %q, TYER %q, TDOR %q, TORY %q",
+			text("TDRC"), text("TYER"), text("TDOR"), text("TORY"))
+		return
+	}
+
+	if version == 4 {
+		f.planFrame(res, ChainDate, SourceDate, "TDRC", rec, current)
+		f.planFrame(res, ChainDate, SourceDate, "TDOR", orig, current)
+		for _, key := range []string{"TYER", "TDAT", "TIME", "TORY"} {
+			f.planFrame(res, ChainDate, SourceDate, key, "", current)
+		}
+		return
+	}
+	var year, date, tm string
+	if rec != "" {
+		year = rec[:4]
+	}
+	if len(rec) >= 10 {
+		date = rec[8:10] + rec[5:7]
+	}
+	if len(rec) >= 16 {
+		tm = rec[11:13] + rec[14:16]
+	}
+	if len(orig) >= 4 {
+		orig = orig[:4]
+	}
+	f.planFrame(res, ChainDate, SourceDate, "TYER", year, current)
+	f.planFrame(res, ChainDate, SourceDate, "TDAT", date, current)
+	f.planFrame(res, ChainDate, SourceDate, "TIME", tm, current)
+	f.planFrame(res, ChainDate, SourceDate, "TORY", orig, current)
+	f.planFrame(res, ChainDate, SourceDate, "TDRC", "", current)
+	f.planFrame(res, ChainDate, SourceDate, "TDOR", "", current)
+}
+
+// Normalize the date field of the result in the format of the backend,
+// if set in the options.
+func (f *Fixer) dateField(res *Result, name string, current map[string]TextFrame) {
+	key, ok := dateKeys[name]
+	if !f.opts.FixDates || !ok || current[key].Text == "" {
+		return
+	}
+	for _, fi := range res.Frames {
+		if fi.Key == key {
+			return
+		}
+	}
+	if text, ok := normalizeDate(current[key].Text); ok {
+		f.planFrame(res, ChainDate, SourceDate, key, text, current)
+	}
+}
@@ -0,0 +1,73 @@
+package fixtag
+
+import (
+	"log/slog"
+	"strings"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The minimal confidence of the detected charset, in range [1, 100].
+// The tags are short, so the detector is often unsure about them.
+const minConfidence = 50
+
+// Detect the charset of the original bytes of the text and build the combination
+// decoding from it.  It fails if the detector is not confident enough
+// or the charset is not supported.
+func (f *Fixer) detectCharset(text string) (combination, bool) {
+	iso := charmap.ISO8859_1.NewEncoder()
+	b, err := iso.String(text)
+	if err != nil {
+		return combination{}, false
+	}
+	res, err := chardet.NewTextDetector().DetectBest([]byte(b))
+	if err != nil {
+		return combination{}, false
+	}
+	f.log.Printf(slog.LevelDebug, "detected charset %s, language %q, confidence %d", res.Charset, res.Language, res.Confidence)
+	if res.Confidence < minConfidence {
+		return combination{}, false
+	}
+	enc, err := charsetByName(res.Charset)
+	if err != nil {
+		f.log.Printf(slog.LevelDebug, "%v", err)
+		return combination{}, false
+	}
+	return combination{
+		name:    "detect",
+		charset: strings.ToLower(res.Charset),
+		tlist:   []StringTrans{iso, enc.NewDecoder()},
+	}, true
+}
+
+// Attempt to convert the text with the detected charset.
+// The result is used only if it is good enough.
+func (f *Fixer) convertDetected(res *Field, value string) bool {
+	cmb, ok := f.detectCharset(value)
+	if !ok {
+		return false
+	}
+	val, err := decode(f.log, value, cmb.tlist...)
+	if err != nil {
+		res.Attempts = append(res.Attempts, Attempt{Candidate: Candidate{Chain: cmb.name, Charset: cmb.charset}, Err: err})
+		return false
+	}
+	goodness := f.alpha.Goodness(val)
+	c := &Candidate{Chain: cmb.name, Charset: cmb.charset, Text: val, Goodness: goodness}
+	f.score(c)
+	res.Attempts = append(res.Attempts, Attempt{Candidate: *c})
+	if goodness < f.threshold(res.Key) {
+		f.log.Printf(slog.LevelDebug, "failed (bad result %f), trying all combinations", goodness)
+		return false
+	}
+	if !f.acceptable(res, c) {
+		return false
+	}
+	res.Winner = c
+	res.Candidates = []*Candidate{res.Winner}
+	res.Best = goodness
+	res.Action = ActionConverted
+	f.log.Printf(slog.LevelInfo, "frame %q decoded from detected %s: %q", res.Name(), cmb.charset, val)
+	return true
+}
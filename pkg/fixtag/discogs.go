@@ -0,0 +1,169 @@
+package fixtag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SourceDiscogs marks the frames set from Discogs, see DiscogsTags.
+const SourceDiscogs = "discogs"
+
+// Discogs gets the track titles, the year and the genre of the albums
+// from the Discogs database.
+type Discogs struct {
+	token     string
+	userAgent string
+	client    *http.Client
+	base      string
+
+	mu     sync.Mutex // the requests are made one by one, see get
+	last   time.Time
+	albums map[string]*discogsRelease // by the artist and the album, nil if not found
+}
+
+// The default address of the Discogs API.
+const discogsURL = "https://api.discogs.com"
+
+// NewDiscogs makes the client of the Discogs database with the personal
+// access token, see https://www.discogs.com/settings/developers.  The user
+// agent identifies the application, as the service requires.  The requests
+// are made at most once a second, and the albums are cached.
+func NewDiscogs(token, userAgent string) *Discogs {
+	return &Discogs{
+		token:     token,
+		userAgent: userAgent,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		base:      discogsURL,
+		albums:    make(map[string]*discogsRelease),
+	}
+}
+
+// The release of the album.
+type discogsRelease struct {
+	Title     string   `json:"title"`
+	Year      int      `json:"year"`
+	Genres    []string `json:"genres"`
+	Tracklist []struct {
+		Position string `json:"position"`
+		Type     string `json:"type_"`
+		Title    string `json:"title"`
+	} `json:"tracklist"`
+}
+
+// Get the title of the track by its number, the headings are skipped.
+func (r *discogsRelease) track(n int) string {
+	for _, t := range r.Tracklist {
+		if t.Type != "" && t.Type != "track" {
+			continue
+		}
+		if n--; n == 0 {
+			return t.Title
+		}
+	}
+	return ""
+}
+
+// Make the request of the API, decoding the response into v.
+// The caller holds the lock.
+func (d *Discogs) get(path string, query url.Values, v interface{}) error {
+	if wait := time.Second - time.Since(d.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	d.last = time.Now()
+	u := d.base + path
+	if query != nil {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	req.Header.Set("Authorization", "Discogs token="+d.token)
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Discogs: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("Discogs: %v", err)
+	}
+	return nil
+}
+
+// Find the release of the album, nil if there is none.
+func (d *Discogs) release(artist, album string) (*discogsRelease, error) {
+	key := strings.ToLower(artist + "\x00" + album)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if r, ok := d.albums[key]; ok {
+		return r, nil
+	}
+	var search struct {
+		Results []struct {
+			ID int `json:"id"`
+		} `json:"results"`
+	}
+	q := url.Values{"type": {"release"}, "artist": {artist}, "release_title": {album}, "per_page": {"1"}}
+	if err := d.get("/database/search", q, &search); err != nil {
+		return nil, err
+	}
+	var r *discogsRelease
+	if len(search.Results) > 0 {
+		r = &discogsRelease{}
+		if err := d.get("/releases/"+strconv.Itoa(search.Results[0].ID), nil, r); err != nil {
+			return nil, err
+		}
+	}
+	d.albums[key] = r
+	return r, nil
+}
+
+// DiscogsTags makes the plan of correcting the title of the track by the
+// tracklist of the album in Discogs, and of filling the empty year and genre.
+// The artist, the album and the track number are taken from the tag, as
+// converted in the result.  The plan is nil if the album is not found.
+func (f *Fixer) DiscogsTags(res *Result, d *Discogs) (*FilePlan, error) {
+	info, err := ReadTagInfo(res.File)
+	if err != nil {
+		return nil, err
+	}
+	info.Update(res)
+	artist, album := info["artist"], info["album"]
+	if info["albumartist"] != "" {
+		artist = info["albumartist"]
+	}
+	if artist == "" || album == "" {
+		return nil, nil
+	}
+	r, err := d.release(artist, album)
+	if err != nil {
+		return nil, err
+	}
+	if r == nil {
+		f.log.Printf(slog.LevelInfo, "album %q by %q is not found in Discogs", album, artist)
+		return nil, nil
+	}
+	var v tagValues
+	num, _, _ := strings.Cut(info["track"], "/")
+	if n, err := strconv.Atoi(strings.TrimSpace(num)); err == nil && n > 0 {
+		v.title = r.track(n)
+	}
+	if r.Year > 0 {
+		v.year = strconv.Itoa(r.Year)
+	}
+	if len(r.Genres) > 0 {
+		v.genre = r.Genres[0]
+	}
+	return f.valuesPlan(res.File, v, SourceDiscogs)
+}
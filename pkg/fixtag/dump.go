@@ -0,0 +1,114 @@
+package fixtag
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/bogem/id3v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// FrameInfo describes a frame of the tag as stored in the file, see ReadFrames.
+type FrameInfo struct {
+	ID       string `json:"id"`
+	Index    int    `json:"index"`              // among the frames with the same id
+	Encoding string `json:"encoding,omitempty"` // as declared, empty if not an ID3v2 text frame
+	Language string `json:"language,omitempty"`
+	// Description of the text frame, or the summary of the binary one.
+	Description string `json:"description,omitempty"`
+	Text        string `json:"text,omitempty"`
+	// TextHex is the bytes of the text, in ISO-8859-1 for the frames declared
+	// so, and in UTF-8 otherwise.
+	TextHex string `json:"text_hex,omitempty"`
+	Size    int    `json:"size"`             // of the body of the frame in bytes
+	Binary  bool   `json:"binary,omitempty"` // not a text frame
+}
+
+// ReadFrames reads every frame of the tag of the file, including the binary
+// ones, sorted by their ids.
+func ReadFrames(path string) ([]FrameInfo, error) {
+	if b := backendFor(path); b != nil {
+		fields, err := readFields(b, path)
+		if err != nil {
+			return nil, err
+		}
+		return fieldInfos(fields), nil
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, err
+	}
+	defer tag.Close()
+	return tagInfos(tag), nil
+}
+
+// Describe the fields of the tag in the format of a backend.
+func fieldInfos(fields []TagField) []FrameInfo {
+	var out []FrameInfo
+	count := make(map[string]int)
+	for _, field := range fields {
+		out = append(out, FrameInfo{
+			ID:      field.Name,
+			Index:   count[field.Name],
+			Text:    field.Value,
+			TextHex: hex.EncodeToString([]byte(field.Value)),
+			Size:    len(field.Value),
+		})
+		count[field.Name]++
+	}
+	return out
+}
+
+// Describe the frames of the ID3v2 tag, sorted by their ids.
+func tagInfos(tag *id3v2.Tag) []FrameInfo {
+	all := tag.AllFrames()
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out []FrameInfo
+	for _, key := range keys {
+		for i, framer := range all[key] {
+			out = append(out, frameInfo(key, i, framer))
+		}
+	}
+	return out
+}
+
+// Describe the frame of the ID3v2 tag.
+func frameInfo(key string, index int, framer id3v2.Framer) FrameInfo {
+	fi := FrameInfo{ID: key, Index: index, Size: framer.Size()}
+	if tf, ok := ToTextFrame(framer); ok {
+		fi.Encoding = tf.Encoding.Name
+		fi.Language = tf.Language
+		fi.Description = tf.Description
+		fi.Text = tf.Text
+		raw := []byte(tf.Text)
+		if tf.Encoding.Equals(id3v2.EncodingISO) {
+			// The text has been decoded from ISO-8859-1 by the reader.
+			if b, err := charmap.ISO8859_1.NewEncoder().Bytes(raw); err == nil {
+				raw = b
+			}
+		}
+		fi.TextHex = hex.EncodeToString(raw)
+		return fi
+	}
+	fi.Binary = true
+	switch f := framer.(type) {
+	case id3v2.PictureFrame:
+		fi.Encoding = f.Encoding.Name
+		fi.Description = fmt.Sprintf("%s, picture type %d, %d bytes", f.MimeType, f.PictureType, len(f.Picture))
+		if f.Description != "" {
+			fi.Description += fmt.Sprintf(", %q", f.Description)
+		}
+	case id3v2.UFIDFrame:
+		fi.Description = fmt.Sprintf("owner %q, identifier %q", f.OwnerIdentifier, f.Identifier)
+	case id3v2.PopularimeterFrame:
+		fi.Description = fmt.Sprintf("email %q, rating %d, counter %v", f.Email, f.Rating, f.Counter)
+	default:
+		fi.Description = fmt.Sprintf("%d bytes", framer.Size())
+	}
+	return fi
+}
@@ -0,0 +1,240 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// SourceEdit marks the frames set by hand, see EditTags.
+const SourceEdit = "edit"
+
+// Get the text frames of the file, by their keys.
+func textFrames(path string) (map[string][]TextFrame, error) {
+	out := make(map[string][]TextFrame)
+	if b := backendFor(path); b != nil {
+		fields, err := readFields(b, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			out[field.Name] = append(out[field.Name], fieldFrame(field))
+		}
+		return out, nil
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, err
+	}
+	defer tag.Close()
+	for key, framers := range tag.AllFrames() {
+		for _, framer := range framers {
+			tf, ok := ToTextFrame(framer)
+			if !ok {
+				// This is not a text frame.
+				break
+			}
+			out[key] = append(out[key], tf)
+		}
+	}
+	return out, nil
+}
+
+// EditTags makes the plan of setting the text of the frames of the file by
+// their keys, and of removing all frames with the given keys.  The text of
+// the first frame with the key is changed, or the frame is added if there
+// is none.  The text is not transliterated.
+func (f *Fixer) EditTags(path string, set map[string]string, remove []string) (*FilePlan, error) {
+	if backendFor(path) == nil {
+		for key := range set {
+			if !validFrameKey(key) {
+				return nil, fmt.Errorf("invalid frame key %q", key)
+			}
+		}
+	}
+	frames, err := textFrames(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &FilePlan{File: path}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		text := set[key]
+		if len(frames[key]) == 0 {
+			tf := TextFrame{Encoding: id3v2.EncodingUTF8}
+			if key == "COMM" || key == "USLT" {
+				// The language is unknown.
+				tf.Language = "XXX"
+			}
+			p.Frames = append(p.Frames, FramePlan{
+				ID:       key,
+				Original: NewFrameData(tf),
+				Text:     text,
+				Source:   SourceEdit,
+			})
+			continue
+		}
+		tf := frames[key][0]
+		if tf.Text == text {
+			f.log.Printf(slog.LevelInfo, "frame %q is already %q", key, text)
+			continue
+		}
+		p.Frames = append(p.Frames, FramePlan{
+			ID:          key,
+			Original:    NewFrameData(tf),
+			Description: tf.Description,
+			Text:        text,
+		})
+	}
+	for _, key := range remove {
+		if _, ok := set[key]; ok {
+			continue
+		}
+		if len(frames[key]) == 0 {
+			f.log.Printf(slog.LevelInfo, "frame %q not found, not removed", key)
+			continue
+		}
+		for i, tf := range frames[key] {
+			p.Frames = append(p.Frames, FramePlan{
+				ID:          key,
+				Index:       i,
+				Original:    NewFrameData(tf),
+				Description: tf.Description,
+				Text:        tf.Text,
+				Remove:      true,
+			})
+		}
+	}
+	return p, nil
+}
+
+// SourceCopy marks the frames copied from another file, see CopyTags.
+const SourceCopy = "copy"
+
+// Get the name of the backend of the file, empty for ID3v2.
+func backendName(path string) string {
+	if b := backendFor(path); b != nil {
+		return b.Name()
+	}
+	return ""
+}
+
+// Map the keys of the text frames of the file copied from onto the keys of
+// the file copied to, if they are of different formats: the frames of the
+// common values are mapped, see tagInfoKeys, and the others are dropped.
+func copiedKeys(from, to string, src, dst map[string][]TextFrame) map[string]string {
+	out := make(map[string]string)
+	fromName, toName := backendName(from), backendName(to)
+	if fromName == toName {
+		for key := range src {
+			out[key] = key
+		}
+		return out
+	}
+	find := func(frames map[string][]TextFrame, keys []string) string {
+		for _, k := range keys {
+			for key := range frames {
+				if strings.EqualFold(key, k) {
+					return key
+				}
+			}
+		}
+		return ""
+	}
+	for _, name := range tagInfoNames {
+		srcKey := find(src, tagInfoKeys[fromName][name])
+		keys := tagInfoKeys[toName][name]
+		if srcKey == "" || len(keys) == 0 {
+			continue
+		}
+		dstKey := find(dst, keys)
+		switch {
+		case dstKey != "":
+		case name == "albumartist" && albumArtistKeys[toName] != "":
+			dstKey = albumArtistKeys[toName]
+		default:
+			dstKey = keys[0]
+		}
+		out[srcKey] = dstKey
+	}
+	return out
+}
+
+// CopyTags makes the plan of copying the text frames of a file, e.g. of a
+// correctly tagged duplicate, onto another one, either all or the ones with
+// the given keys of the file copied from.  Every frame changes the frame of
+// the same key and index, or is added if there are none with the key.  If
+// the files are of different formats, only the common values are copied,
+// e.g. the artist and the title, see TagInfo.
+func (f *Fixer) CopyTags(from, to string, keys []string) (*FilePlan, error) {
+	src, err := textFrames(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := textFrames(to)
+	if err != nil {
+		return nil, err
+	}
+	mapped := copiedKeys(from, to, src, dst)
+	if len(keys) == 0 {
+		for key := range src {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	p := &FilePlan{File: to}
+	for _, key := range keys {
+		dstKey, ok := mapped[key]
+		if !ok || len(src[key]) == 0 {
+			f.log.Printf(slog.LevelInfo, "frame %q not found, not copied", key)
+			continue
+		}
+		for i, tf := range src[key] {
+			switch {
+			case i < len(dst[dstKey]):
+				cur := dst[dstKey][i]
+				if cur.Text == tf.Text && cur.Description == tf.Description {
+					continue
+				}
+				f.log.Printf(slog.LevelDebug, "frame %q[%d] copied: %q => %q", dstKey, i, cur.Text, tf.Text)
+				p.Frames = append(p.Frames, FramePlan{
+					ID:          dstKey,
+					Index:       i,
+					Original:    NewFrameData(cur),
+					Description: tf.Description,
+					Text:        tf.Text,
+				})
+			case len(dst[dstKey]) == 0 && i == 0:
+				f.log.Printf(slog.LevelDebug, "frame %q copied: %q", dstKey, tf.Text)
+				orig := TextFrame{Encoding: id3v2.EncodingUTF8, Language: tf.Language}
+				p.Frames = append(p.Frames, FramePlan{
+					ID:          dstKey,
+					Original:    NewFrameData(orig),
+					Description: tf.Description,
+					Text:        tf.Text,
+					Source:      SourceCopy,
+				})
+			default:
+				// The new frames are only added after the existing ones.
+				f.log.Printf(slog.LevelInfo, "frame %q[%d] not copied, the file has fewer of them", dstKey, i)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Check whether the key is a valid ID3v2 frame key, i.e. four capital
+// letters or digits, e.g. "TIT2".  The keys of the other formats are not
+// checked.
+func validFrameKey(key string) bool {
+	return len(key) == 4 && strings.IndexFunc(key, func(r rune) bool {
+		return !('A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) < 0
+}
@@ -0,0 +1,56 @@
+package fixtag
+
+import (
+	"html"
+	"strings"
+)
+
+// The ways to repair the HTML entities, see Options.
+const (
+	EntitiesBefore = "before" // unescape, then convert the result if it is broken
+	EntitiesOnly   = "only"   // unescape, and take the result as is
+)
+
+// The chain of the fields with the HTML entities unescaped, see Options.
+const ChainEntities = "html"
+
+// Check whether the text has the HTML entities or the numeric character
+// references, e.g. "&#1055;&#1088;" or "&amp;".
+func hasEntities(text string) bool {
+	return strings.Contains(text, "&") && html.UnescapeString(text) != text
+}
+
+// Unescape the HTML entities of the field, and convert the result like the
+// text in ISO-8859-1 if it is still broken and EntitiesBefore is given.
+func (f *Fixer) convertEntities(combinations []combination, res *Field) {
+	text := html.UnescapeString(res.Orig)
+	value := strings.TrimSpace(text)
+	if f.opts.Entities == EntitiesBefore && f.alpha.Goodness(value) < 1 && latinWord(value) {
+		// The fields are cached by the key of their text, so the field is
+		// converted as a copy.
+		tmp := &Field{Key: res.Key, Index: res.Index, Field: res.Field, Orig: text}
+		f.convertField(combinations, tmp)
+		for _, a := range tmp.Attempts {
+			a.Chain = ChainEntities + "+" + a.Chain
+			res.Attempts = append(res.Attempts, a)
+		}
+		for _, c := range tmp.Candidates {
+			cp := *c
+			cp.Chain = ChainEntities + "+" + c.Chain
+			res.Candidates = append(res.Candidates, &cp)
+			if c == tmp.Winner {
+				res.Winner = &cp
+			}
+		}
+		res.Best = tmp.Best
+		res.Action = tmp.Action
+		return
+	}
+	c := f.newCandidate(ChainEntities, CharsetUTF8, value)
+	res.Attempts = append(res.Attempts, Attempt{Candidate: *c})
+	res.Best = c.Goodness
+	if c.Goodness >= f.threshold(res.Key) && f.acceptable(res, c) {
+		res.Candidates = []*Candidate{c}
+	}
+	f.resolveField(res)
+}
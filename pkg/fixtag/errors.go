@@ -0,0 +1,91 @@
+package fixtag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// The kinds of the errors of the files, see Error.
+var (
+	ErrOpen      = errors.New("cannot open")
+	ErrParse     = errors.New("cannot parse")
+	ErrConvert   = errors.New("cannot convert")
+	ErrWrite     = errors.New("cannot write")
+	ErrStale     = errors.New("changed since the plan")
+	ErrAmbiguous = errors.New("ambiguous conversion")
+	ErrLost      = errors.New("data lost")
+)
+
+// Error is the error of a file, or of a frame of it, of one of the kinds
+// above: errors.Is reports both the kind and the cause.  The file is not in
+// the message, like in the errors of the os package it wraps.
+type Error struct {
+	Kind  error
+	File  string
+	Frame string // the name of the field, e.g. "TIT2", empty for the whole file
+	Err   error  // the cause, nil for the frames not converted
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Frame != "" {
+		return "frame " + e.Frame + ": " + msg
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// Get the error of reading the file: ErrOpen if the file cannot be read at
+// all, ErrParse if its tags are malformed.
+func readError(path string, err error) error {
+	kind := ErrParse
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		kind = ErrOpen
+	}
+	return &Error{Kind: kind, File: path, Err: err}
+}
+
+// Get the error of writing the file.  The file left as it was once the
+// context is done is not an error of writing.
+func writeError(path string, err error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &Error{Kind: ErrWrite, File: path, Err: err}
+}
+
+// Get the error of applying the plan to the file which has changed since
+// the plan was made.
+func staleError(path, format string, args ...interface{}) error {
+	return &Error{Kind: ErrStale, File: path, Err: fmt.Errorf(format, args...)}
+}
+
+// Errors gets the errors of the frames of the file which could not be
+// converted, ErrConvert, which are ambiguous, ErrAmbiguous, or which have
+// the text lost, ErrLost.
+func (r *Result) Errors() []error {
+	var out []error
+	for _, field := range r.Fields() {
+		switch field.Action {
+		case ActionFailed:
+			out = append(out, &Error{Kind: ErrConvert, File: r.File, Frame: field.Name()})
+		case ActionAmbiguous:
+			out = append(out, &Error{Kind: ErrAmbiguous, File: r.File, Frame: field.Name()})
+		case ActionLost:
+			out = append(out, &Error{Kind: ErrLost, File: r.File, Frame: field.Name()})
+		}
+	}
+	return out
+}
@@ -0,0 +1,105 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/bogem/id3v2"
+)
+
+// SourceImport marks the frames added from the edited export, see ImportTags.
+const SourceImport = "import"
+
+// ExportedFrame is a text frame of a file exported to be edited by hand,
+// e.g. in a spreadsheet, and imported back, see ExportTags and ImportTags.
+type ExportedFrame struct {
+	File        string `json:"file"`
+	Key         string `json:"frame"`
+	Index       int    `json:"index"` // among the frames with the same key
+	Description string `json:"description,omitempty"`
+	Text        string `json:"text"`
+}
+
+// ExportTags gets the text frames of the file as stored, sorted by the key.
+func ExportTags(path string) ([]ExportedFrame, error) {
+	frames, err := textFrames(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(frames))
+	for key := range frames {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var out []ExportedFrame
+	for _, key := range keys {
+		for i, tf := range frames[key] {
+			out = append(out, ExportedFrame{File: path, Key: key, Index: i, Description: tf.Description, Text: tf.Text})
+		}
+	}
+	return out, nil
+}
+
+// ImportTags makes the plan of setting the frames of the file to the edited
+// ones, e.g. exported with ExportTags.  The frame of the same key and index
+// is changed if its text or description differs, or is added if there are
+// none with the key.  The frames of the file not given are kept.
+func (f *Fixer) ImportTags(path string, frames []ExportedFrame) (*FilePlan, error) {
+	if backendFor(path) == nil {
+		for _, e := range frames {
+			if !validFrameKey(e.Key) {
+				return nil, fmt.Errorf("invalid frame key %q", e.Key)
+			}
+		}
+	}
+	cur, err := textFrames(path)
+	if err != nil {
+		return nil, err
+	}
+	frames = append([]ExportedFrame(nil), frames...)
+	sort.SliceStable(frames, func(i, j int) bool {
+		if frames[i].Key != frames[j].Key {
+			return frames[i].Key < frames[j].Key
+		}
+		return frames[i].Index < frames[j].Index
+	})
+	p := &FilePlan{File: path}
+	for _, e := range frames {
+		switch {
+		case e.Index < 0:
+			return nil, fmt.Errorf("frame %q: invalid index %d", e.Key, e.Index)
+		case e.Index < len(cur[e.Key]):
+			tf := cur[e.Key][e.Index]
+			if tf.Text == e.Text && tf.Description == e.Description {
+				continue
+			}
+			f.log.Printf(slog.LevelDebug, "frame %q[%d] imported: %q => %q", e.Key, e.Index, tf.Text, e.Text)
+			p.Frames = append(p.Frames, FramePlan{
+				ID:          e.Key,
+				Index:       e.Index,
+				Original:    NewFrameData(tf),
+				Description: e.Description,
+				Text:        e.Text,
+			})
+		case len(cur[e.Key]) == 0 && e.Index == 0:
+			f.log.Printf(slog.LevelDebug, "frame %q imported: %q", e.Key, e.Text)
+			tf := TextFrame{Encoding: id3v2.EncodingUTF8}
+			if e.Key == "COMM" || e.Key == "USLT" {
+				// The language is unknown.
+				tf.Language = "XXX"
+			}
+			p.Frames = append(p.Frames, FramePlan{
+				ID:          e.Key,
+				Original:    NewFrameData(tf),
+				Description: e.Description,
+				Text:        e.Text,
+				Source:      SourceImport,
+			})
+		default:
+			// The new frames are only added after the existing ones.
+			f.log.Printf(slog.LevelInfo, "frame %q[%d] not imported, the file has fewer of them", e.Key, e.Index)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,875 @@
+// Package fixtag fixes the text frames of ID3v2 tags, which were written in
+// a legacy Cyrillic charset but marked as ISO-8859-1.  The tags of other
+// formats, e.g. the Vorbis comments of FLAC files, are fixed through
+// a TagBackend.
+//
+// The Fixer makes a plan of the changes for a file, trying a number of
+// charset combinations for every text field, and applies the plan:
+//
+//	f, err := fixtag.New(fixtag.Options{Threshold: 1})
+//	res, err := f.Plan("song.mp3")
+//	err = f.Apply(res.Plan())
+package fixtag
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// Logger receives the diagnostic messages of the Fixer about the file being
+// processed, which the caller is to name.  The level is slog.LevelWarn for
+// the warnings, slog.LevelInfo for the progress and slog.LevelDebug for the
+// debugging details.  The messages are single lines with no layout, e.g. no
+// indentation or trailing newline, which is left to the logger.
+type Logger interface {
+	Printf(level slog.Level, format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(slog.Level, string, ...interface{}) {}
+
+// Options of the Fixer.
+type Options struct {
+	// Threshold is the minimal goodness of the conversion result, in range [0.1, 1].
+	// The zero value means 1.
+	Threshold float64
+	// Thresholds override the Threshold for the frames with the given ids,
+	// e.g. a lower one for the titles with English words and punctuation.
+	Thresholds map[string]float64
+	// Charsets to decode from, see Charsets.  If empty, the charsets
+	// used for the languages are tried.
+	Charsets []string
+	// Chains are the custom chains of transformations tried in addition
+	// to the built-in ones, see ParseChain.
+	Chains []string
+	// Languages of the text, which define the letters accepted in the
+	// converted text and the charsets tried, e.g. "ru" or "el".
+	// DefaultLanguages if empty.
+	Languages []string
+	// Models are the counts of the letter n-grams of other languages, or of
+	// the texts of the user, one "xyz count" per line with "_" marking the
+	// word boundary; the candidates equally good are ranked by the most
+	// probable text by any model, the built-in ones of the Languages too.
+	Models []string
+	// Scorer judges the converted text instead of the letters of the
+	// Languages, e.g. NewRangeScorer for the other languages.  May be nil.
+	Scorer Scorer
+	// Detect the charset of every field with a statistical detector first,
+	// and only try all combinations if the detection is not confident.
+	Detect bool
+	// Encoding of the written frames, UTF-8 or UTF-16.  UTF-8 if not set.
+	Encoding id3v2.Encoding
+	// Version of the written ID3v2 tags, 3 or 4.  The version is kept if 0.
+	// With ID3v2.3 the frames are written in UTF-16 instead of UTF-8.
+	Version byte
+	// PreserveTimes keeps the access and modification times of the written files.
+	PreserveTimes bool
+	// TouchNothing only writes the files if the text of some frame changes,
+	// not for the encoding, the version or the ID3v1 tag alone, see Apply.
+	TouchNothing bool
+	// CheckAudio hashes the audio data of the files, everything but the tags,
+	// before replacing them, failing with ErrAudioChanged if it would change.
+	// Only MP3, FLAC, WAV and AIFF files are checked.
+	CheckAudio bool
+	// Verify reads the written files back and checks that every frame is
+	// the same as written, and none is lost, failing with ErrVerify otherwise.
+	Verify bool
+	// StripID3v1 removes the ID3v1 tag from the written files.
+	StripID3v1 bool
+	// WriteID3v1 writes the ID3v1.1 tag made of the converted frames for
+	// the old players, in the given charset, ID3v1CP1251 or ID3v1Translit.
+	WriteID3v1 string
+	// Translit is the scheme of the transliteration of the written frames
+	// into Latin, TranslitInformal or TranslitGOST, see Transliterate.
+	// The frames are written in Cyrillic if empty.  The scheme is used
+	// for the ID3v1 tag too, the informal one by default.
+	Translit string
+	// ReplaceInvalid replaces the characters which cannot be converted with
+	// U+FFFD, instead of failing the conversion, so that the rest of the
+	// text is recovered; the result is only good enough with a lower
+	// Threshold.  Anyway the byte order marks and the trailing NULs are
+	// stripped, and so are a few characters at the end if they cannot be
+	// converted.
+	ReplaceInvalid bool
+	// Entities unescapes the HTML entities and the numeric character
+	// references like "&#1055;&#1088;" in the text, either EntitiesBefore
+	// the conversion or EntitiesOnly instead of it, marking the fields with
+	// ChainEntities; the entities are kept if empty.
+	Entities string
+	// Segments converts the parts of the text independently, if it is not
+	// converted as a whole: the runs of the words in different scripts,
+	// e.g. of the correct Cyrillic and the mojibake, and the halves broken
+	// differently, marking the fields with ChainSegments.
+	Segments bool
+	// Cleanup tidies the text of the frames after the conversion, marking
+	// the fields already correct otherwise with ChainCleanup.  Nothing is
+	// cleaned up if nil.
+	Cleanup *Cleanup
+	// Capitalize lowercases the converted text in Cyrillic capitals, e.g.
+	// "ГРУППА КРОВИ", but the first letter of every sentence, "Группа
+	// крови".  The words and the phrases of KeepCase are spelled as given
+	// there, e.g. the names of the bands and the acronyms like "ДДТ".
+	Capitalize bool
+	KeepCase   []string
+	// ArtistSplit are the separators of the artists in the artist frames,
+	// e.g. "feat.", ";" or "/".  The artists are converted one by one if
+	// the frame is not converted as a whole, and joined again with
+	// ArtistSeparator or, if empty, as the multiple values of ID3v2.4, see
+	// artistFrames.  The fields are marked with ChainArtists.
+	ArtistSplit     []string
+	ArtistSeparator string
+	// Replace are the replacements in the text of the frames, applied after
+	// the conversion.  The fields already correct otherwise are marked with
+	// ChainReplace.
+	Replace []*Replacement
+	// Accept are the predicates every candidate of the conversion must
+	// satisfy, e.g. `!contains(text, "\ufffd")`, see ParsePredicate.
+	Accept []*Script
+	// Transform are the scripts changing the converted text in turn, e.g.
+	// `trim(text)`, see ParseTransform.
+	Transform []*Script
+	// Rules change the frames of the files after the conversion, see Rule.
+	Rules []*Rule
+	// Frames are the keys of the only frames converted, e.g. "TIT2", all
+	// frames if empty.  SkipFrames are the keys of the frames never converted,
+	// e.g. "COMM".  The keys are case-insensitive.
+	Frames     []string
+	SkipFrames []string
+	// FixGenre replaces the ID3v1 genre references like "(17)" with the names
+	// and normalizes the capitalization of the genres, marking the fields
+	// already correct otherwise with ChainGenre.
+	FixGenre bool
+	// Numbers normalizes the track and the disc numbers, writing the track
+	// numbers as NumbersPad or NumbersStrip, and marks the fields already
+	// correct otherwise with ChainNumber, see numberFrames.
+	Numbers string
+	// FixDates moves the dates into the frames of the written ID3v2 version,
+	// e.g. TYER and TDAT into TDRC of ID3v2.4, and normalizes them as
+	// "yyyy" or "yyyy-MM-dd", marking the fields with ChainDate.
+	FixDates bool
+	// SortFrames adds the sort frames of the artist, the album and the title
+	// fixed in Cyrillic, transliterated into Latin with the Translit scheme,
+	// see SortFrames.
+	SortFrames bool
+	// Untranslit transliterates the text in Latin back into Cyrillic, if
+	// every word of it is known, marking the fields with ChainUntranslit.
+	Untranslit bool
+	// Words are the known words for Untranslit in addition to the common
+	// ones, one per line.
+	Words string
+	// Lookup resolves the ambiguous artists, albums and titles by searching
+	// the candidates, e.g. in MusicBrainz, see NewMusicBrainz.  May be nil.
+	Lookup Lookup
+	// Hook decides the conversion of every field after the Fixer, e.g. by
+	// running the command of the user, see NewCommandHook.  May be nil.
+	Hook Hook
+	// Throttle limits the reading and the writing of the files, may be nil.
+	Throttle Throttle
+	// Logger for the diagnostic messages, may be nil.
+	Logger Logger
+}
+
+// Fixer converts the frames of the files.  It is safe for concurrent use.
+type Fixer struct {
+	opts     Options
+	chains   []*chain
+	translit translitScheme
+	words    dictionary // nil unless transliterating back
+	alpha    Scorer
+	models   []*ngrams
+	artistRe *regexp.Regexp   // nil unless splitting the artists
+	cache    *conversionCache // shared by the copies, see WithLogger
+	log      Logger
+	ctx      context.Context // see WithContext
+}
+
+// Get the threshold of the goodness for the frame.
+func (f *Fixer) threshold(key string) float64 {
+	if t, ok := f.opts.Thresholds[key]; ok {
+		return t
+	}
+	return f.opts.Threshold
+}
+
+// Check whether the frame is not converted, see Options.
+func (f *Fixer) skipped(key string) bool {
+	has := func(keys []string) bool {
+		for _, k := range keys {
+			if strings.EqualFold(k, key) {
+				return true
+			}
+		}
+		return false
+	}
+	return has(f.opts.SkipFrames) || len(f.opts.Frames) > 0 && !has(f.opts.Frames)
+}
+
+// New creates the Fixer with the given options.  The Fixer keeps its own
+// copies of the slices and the maps of the options, so the caller may
+// change them afterwards.
+func New(opts Options) (*Fixer, error) {
+	opts.Thresholds = maps.Clone(opts.Thresholds)
+	opts.Charsets = slices.Clone(opts.Charsets)
+	opts.Chains = slices.Clone(opts.Chains)
+	opts.Languages = slices.Clone(opts.Languages)
+	opts.Replace = slices.Clone(opts.Replace)
+	opts.Rules = slices.Clone(opts.Rules)
+	opts.Frames = slices.Clone(opts.Frames)
+	opts.SkipFrames = slices.Clone(opts.SkipFrames)
+	opts.Accept = slices.Clone(opts.Accept)
+	opts.Transform = slices.Clone(opts.Transform)
+	opts.Models = slices.Clone(opts.Models)
+	opts.KeepCase = slices.Clone(opts.KeepCase)
+	opts.ArtistSplit = slices.Clone(opts.ArtistSplit)
+	if opts.Threshold == 0 {
+		opts.Threshold = 1
+	}
+	if opts.Threshold < 0.1 || opts.Threshold > 1 {
+		return nil, fmt.Errorf("invalid value of threshold (%f), must be in range [0.1, 1]", opts.Threshold)
+	}
+	for key, t := range opts.Thresholds {
+		if t < 0.1 || t > 1 {
+			return nil, fmt.Errorf("invalid value of threshold for %s (%f), must be in range [0.1, 1]", key, t)
+		}
+	}
+	switch {
+	case opts.Encoding.Name == "":
+		opts.Encoding = id3v2.EncodingUTF8
+	case opts.Encoding.Equals(id3v2.EncodingISO):
+		return nil, fmt.Errorf("cannot write the frames in %v", opts.Encoding)
+	}
+	switch opts.Version {
+	case 0, 4:
+	case 3:
+		if opts.Encoding.Equals(id3v2.EncodingUTF8) {
+			opts.Encoding = id3v2.EncodingUTF16
+		}
+	default:
+		return nil, fmt.Errorf("unsupported ID3v2 version %d", opts.Version)
+	}
+	switch opts.WriteID3v1 {
+	case "", ID3v1CP1251, ID3v1Translit:
+	default:
+		return nil, fmt.Errorf("unknown ID3v1 charset %q", opts.WriteID3v1)
+	}
+	if opts.StripID3v1 && opts.WriteID3v1 != "" {
+		return nil, fmt.Errorf("cannot both strip and write the ID3v1 tag")
+	}
+	switch opts.Entities {
+	case "", EntitiesBefore, EntitiesOnly:
+	default:
+		return nil, fmt.Errorf("unknown HTML entities repair %q, must be %s or %s", opts.Entities, EntitiesBefore, EntitiesOnly)
+	}
+	for _, sep := range opts.ArtistSplit {
+		if strings.TrimSpace(sep) == "" {
+			return nil, fmt.Errorf("empty separator of the artists")
+		}
+	}
+	switch opts.Numbers {
+	case "", NumbersPad, NumbersStrip:
+	default:
+		return nil, fmt.Errorf("unknown track numbers %q, must be %s or %s", opts.Numbers, NumbersPad, NumbersStrip)
+	}
+	scheme, err := translitByName(opts.Translit)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Untranslit && opts.Translit != "" {
+		return nil, fmt.Errorf("cannot both transliterate and transliterate back")
+	}
+	for _, cs := range opts.Charsets {
+		if len(newCombinations([]string{cs})) == 0 {
+			return nil, fmt.Errorf("unknown charset %q", cs)
+		}
+	}
+	if len(opts.Languages) == 0 {
+		opts.Languages = DefaultLanguages()
+	}
+	alpha, err := newAlphabets(opts.Languages)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Charsets) == 0 {
+		opts.Charsets = languageCharsets(opts.Languages)
+	}
+	var scorer Scorer = alpha
+	if opts.Scorer != nil {
+		scorer = opts.Scorer
+	}
+	models, err := newModels(opts.Languages, opts.Models)
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixer{opts: opts, alpha: scorer, models: models, translit: scheme, log: opts.Logger, ctx: context.Background()}
+	f.cache = newConversionCache(conversionCacheSize)
+	if opts.Untranslit {
+		f.words = newDictionary(ruWords, opts.Words)
+	}
+	if len(opts.ArtistSplit) > 0 {
+		f.artistRe = artistSplitter(opts.ArtistSplit)
+	}
+	for _, spec := range opts.Chains {
+		c, err := parseChain(spec)
+		if err != nil {
+			return nil, err
+		}
+		f.chains = append(f.chains, c)
+	}
+	if f.log == nil {
+		f.log = nopLogger{}
+	}
+	return f, nil
+}
+
+// Encoding gets the encoding of the written frames.
+func (f *Fixer) Encoding() id3v2.Encoding {
+	return f.opts.Encoding
+}
+
+// WithLogger returns a copy of the Fixer with a different logger,
+// e.g. to collect the messages of every file separately.
+func (f *Fixer) WithLogger(log Logger) *Fixer {
+	c := *f
+	c.log = log
+	if c.log == nil {
+		c.log = nopLogger{}
+	}
+	return &c
+}
+
+// WithContext returns a copy of the Fixer with the context, e.g. with the
+// timeout of a file.  Once the context is done, the files are neither read
+// nor written: Plan and Apply fail with the error of the context, and a file
+// being written is left as it was.  The files are written under the
+// WriteGuard of the context, if any, see WithWriteGuard.
+func (f *Fixer) WithContext(ctx context.Context) *Fixer {
+	c := *f
+	c.ctx = ctx
+	if c.ctx == nil {
+		c.ctx = context.Background()
+	}
+	return &c
+}
+
+// Actions taken on a field.
+const (
+	ActionConverted   = "converted" // converted, but not written (dry-run)
+	ActionWritten     = "written"
+	ActionWriteFailed = "write-failed"
+	ActionAmbiguous   = "ambiguous"
+	ActionFailed      = "failed"
+	ActionSkipped     = "skipped" // converted, but other fields of the frame are not
+	ActionLost        = "lost"    // the text was destroyed before, e.g. replaced by "????"
+)
+
+// Field is the result of the conversion of a single text field of a frame.
+type Field struct {
+	Key        string
+	Index      int          // the index among the frames with the same key
+	Field      string       // the name of the text field, see FieldText
+	Orig       string       // the original text
+	Candidates []*Candidate // all candidates above the threshold, the best first
+	Attempts   []Attempt    // all conversions tried, in the order of trying
+	Winner     *Candidate   // nil if the field was not converted
+	Best       float64      // the best goodness among all candidates
+	Action     string
+	Err        error // the error of writing, with ActionWriteFailed
+	fix        int   // how to fix the frame declared in Unicode, see fixNone
+}
+
+// Name of the frame field for the output.  The main text field of the first
+// frame with the key is named by the frame key only.
+func (res *Field) Name() string {
+	name := res.Key
+	if res.Index > 0 {
+		name = fmt.Sprintf("%s[%d]", name, res.Index)
+	}
+	if res.Field == FieldText {
+		return name
+	}
+	return name + "/" + res.Field
+}
+
+// Frame is a frame selected for conversion.
+type Frame struct {
+	Key    string
+	Index  int       // the index among the frames with the same key
+	Orig   TextFrame // the original contents of the frame
+	Fields []*Field  // the text fields to convert
+	Source string    // the source of the new frame, e.g. SourceID3v1 for the field of the ID3v1 tag
+	Remove bool      // the frame is removed, e.g. the date moved into another frame
+}
+
+// Converted gets the contents of the frame with the converted fields.
+// If some fields are not converted, the frame is not ready for writing.
+func (fi *Frame) Converted() (TextFrame, bool) {
+	tf := fi.Orig
+	tf.Encoding = id3v2.EncodingUTF8
+	for _, res := range fi.Fields {
+		if res.Action != ActionConverted {
+			return tf, false
+		}
+		*tf.Field(res.Field) = res.Winner.Text
+	}
+	return tf, true
+}
+
+// Result is the result of the conversion of a single file.
+type Result struct {
+	File   string
+	Album  string // the album title as read from the tag
+	Frames []*Frame
+	// Correct is the number of the text frames which need no conversion.
+	Correct int
+	// Skipped is set if the file is not to be changed at all, see Rule.
+	Skipped bool
+}
+
+// Fields gets the results of all fields of the frames.
+func (r *Result) Fields() []*Field {
+	var out []*Field
+	for _, fi := range r.Frames {
+		out = append(out, fi.Fields...)
+	}
+	return out
+}
+
+// SetAction sets the action for all converted fields.
+func (r *Result) SetAction(action string) {
+	setAction(r.Frames, action)
+}
+
+func setAction(frames []*Frame, action string) {
+	for _, fi := range frames {
+		for _, res := range fi.Fields {
+			if res.Action == ActionConverted {
+				res.Action = action
+			}
+		}
+	}
+}
+
+// Plan makes the plan of writing the frames ready to be written back.
+// The converted fields of the frames which cannot be written are marked as skipped.
+func (r *Result) Plan() *FilePlan {
+	p := &FilePlan{File: r.File}
+	for _, fi := range r.Frames {
+		tf, ok := fi.Converted()
+		if !ok {
+			setAction([]*Frame{fi}, ActionSkipped)
+			continue
+		}
+		p.Frames = append(p.Frames, FramePlan{
+			ID:          fi.Key,
+			Index:       fi.Index,
+			Original:    NewFrameData(fi.Orig),
+			Description: tf.Description,
+			Text:        tf.Text,
+			Source:      fi.Source,
+			Remove:      fi.Remove,
+		})
+	}
+	return p
+}
+
+// FilePlan is the planned changes of a single file.
+type FilePlan struct {
+	File   string      `json:"file"`
+	Frames []FramePlan `json:"frames"`
+	// Written is set by Apply if the file has been written, and not left as
+	// it was because nothing would change, see TouchNothing.
+	Written bool `json:"-"`
+	// InPlace is set by Apply if the tag has been written over the old one,
+	// without copying the rest of the file.
+	InPlace bool `json:"-"`
+}
+
+// FramePlan is the planned change of a single frame.  The frame is written
+// in the encoding of the Fixer applying the plan.  The original contents
+// are used to check that the file has not changed since the plan was made.
+type FramePlan struct {
+	ID          string    `json:"id"`
+	Index       int       `json:"index"` // among the frames with the same id
+	Original    FrameData `json:"original"`
+	Description string    `json:"description,omitempty"`
+	Text        string    `json:"text"`
+	Source      string    `json:"source,omitempty"` // the source of the new frame, e.g. SourceID3v1
+	Remove      bool      `json:"remove,omitempty"` // the frame is removed instead
+}
+
+// TextFrame gets the planned contents of the frame in the given encoding.
+func (fp *FramePlan) TextFrame(enc id3v2.Encoding) (TextFrame, error) {
+	tf, err := fp.Original.TextFrame()
+	if err != nil {
+		return tf, err
+	}
+	tf.Encoding = enc
+	tf.Description = fp.Description
+	tf.Text = fp.Text
+	return tf, nil
+}
+
+// Fields gets the changes of the plan as the converted fields, e.g. for the report.
+func (p *FilePlan) Fields() []*Field {
+	var out []*Field
+	alpha := allLanguages()
+	for i := range p.Frames {
+		fp := &p.Frames[i]
+		planned := TextFrame{Description: fp.Description, Text: fp.Text}
+		orig, _ := fp.Original.TextFrame()
+		n := len(out)
+		for _, name := range textFields {
+			if *planned.Field(name) == *orig.Field(name) && (name != FieldText || len(out) > n) {
+				// The text field is reported even if only the encoding changes.
+				continue
+			}
+			text := *planned.Field(name)
+			out = append(out, &Field{
+				Key:    fp.ID,
+				Index:  fp.Index,
+				Field:  name,
+				Orig:   *orig.Field(name),
+				Winner: &Candidate{Chain: "plan", Text: text, Goodness: alpha.Goodness(text)},
+				Action: ActionConverted,
+			})
+		}
+	}
+	return out
+}
+
+// Plan reads the file and attempts to convert its frames.
+func (f *Fixer) Plan(path string) (*Result, error) {
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if b := backendFor(path); b != nil {
+		return f.planFields(b, path)
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, readError(path, err)
+	}
+	defer tag.Close()
+	f.wait(int64(tag.Size()))
+
+	res := &Result{File: path, Album: tag.Album()}
+	res.Frames, res.Correct = f.extractFrames(tag)
+	v1, err := f.extractID3v1(path, tag)
+	if err != nil {
+		return nil, readError(path, err)
+	}
+	res.Frames = append(res.Frames, v1...)
+	if ape, err := hasAPE(path); err != nil {
+		f.log.Printf(slog.LevelWarn, "cannot read the APE tag: %v", err)
+	} else if ape {
+		f.log.Printf(slog.LevelInfo, "APE tag found, it is kept intact")
+	}
+	f.log.Printf(slog.LevelInfo, "%d frames to convert found", len(res.Frames))
+	f.convertFrames(res)
+	if err := f.ctx.Err(); err != nil {
+		return nil, err
+	}
+	// The first text frame with every key.
+	current := make(map[string]TextFrame)
+	for key, framers := range tag.AllFrames() {
+		if len(framers) == 0 {
+			continue
+		}
+		if tf, ok := ToTextFrame(framers[0]); ok {
+			current[key] = tf
+		}
+	}
+	f.numberFrames(res, "", current)
+	version := f.opts.Version
+	if version == 0 {
+		version = tag.Version()
+	}
+	f.dateFrames(res, version, current)
+	f.artistFrames(res, "", version, current)
+	f.ruleFrames(res, current)
+	return res, nil
+}
+
+// Convert the fields of the frames which need the conversion.
+func (f *Fixer) convertFrames(res *Result) {
+	combinations := newCombinations(f.opts.Charsets)
+	for _, c := range f.chains {
+		combinations = append(combinations, c.combination())
+	}
+	double := newDoubleCombinations()
+	var converted []*Field
+	for _, fi := range res.Frames {
+		for _, field := range fi.Fields {
+			if field.Action != "" {
+				// The field needs no conversion.
+				continue
+			}
+			converted = append(converted, field)
+			switch field.fix {
+			case fixDouble:
+				f.convertField(double, field)
+			case fixSwap:
+				f.convertField([]combination{newSwapCombination()}, field)
+			case fixLost:
+				f.log.Printf(slog.LevelWarn, "Warning: the text of frame %s is lost and cannot be converted, it needs an external lookup", field.Name())
+				field.Action = ActionLost
+			case fixPercent:
+				f.convertField(newPercentCombinations(combinations), field)
+			case fixEntities:
+				f.convertEntities(combinations, field)
+			case fixMixed:
+				if !f.convertSegments(combinations, field) {
+					f.log.Printf(slog.LevelWarn, "Warning: could not convert the parts of frame %s", field.Name())
+					field.Action = ActionFailed
+				}
+			default:
+				if f.artistRe != nil && artistKey(field.Key) && field.Field == FieldText {
+					f.convertArtists(combinations, field)
+				} else {
+					f.convertField(combinations, field)
+				}
+				if f.opts.Segments && field.fix == fixNone {
+					f.convertSegments(combinations, field)
+				}
+			}
+		}
+	}
+	f.fixGenres(res)
+	f.cleanupFields(res)
+	f.capitalizeFields(res)
+	f.replaceFields(res)
+	if f.opts.Lookup != nil && res.File != "" {
+		f.lookupFields(res)
+	}
+	f.transformFields(res)
+	if f.opts.Hook != nil && res.File != "" {
+		f.hookFields(res.File, converted)
+	}
+}
+
+// Extract potential frames to convert, sorted by the frame key.
+// All frames with the same key are considered, e.g. all comments.
+// The number of the text frames which need no conversion is returned too.
+func (f *Fixer) extractFrames(tag *id3v2.Tag) ([]*Frame, int) {
+	log := f.log
+	var out []*Frame
+	correct := 0
+	all := tag.AllFrames()
+	keys := make([]string, 0, len(all))
+	for key := range all {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if f.skipped(key) {
+			log.Printf(slog.LevelDebug, "frame %q is not to be converted, skipping", key)
+			continue
+		}
+		framers := all[key]
+		for i, frame := range framers {
+			tf, ok := ToTextFrame(frame)
+			if !ok {
+				// This is not a text frame.
+				// Since a single key cannot have different types of framers, we can break here.
+				break
+			}
+			if tf.Text == "" && tf.Description == "" {
+				continue
+			}
+			unicode := !tf.Encoding.Equals(id3v2.EncodingISO)
+			fi := &Frame{Key: key, Index: i, Orig: tf}
+			for _, name := range textFields {
+				text := *tf.Field(name)
+				if text == "" {
+					continue
+				}
+				res := &Field{Key: key, Index: i, Field: name, Orig: text}
+				if lostText(text) {
+					log.Printf(slog.LevelDebug, "frame %q has the text lost, e.g. replaced by the question marks: %s", res.Name(), Dump(text))
+					res.fix = fixLost
+					fi.Fields = append(fi.Fields, res)
+					continue
+				}
+				if f.opts.Entities != "" && hasEntities(text) {
+					log.Printf(slog.LevelDebug, "frame %q has HTML entities, text: %s", res.Name(), Dump(text))
+					res.fix = fixEntities
+					fi.Fields = append(fi.Fields, res)
+					continue
+				}
+				if percentEncoded(text) {
+					log.Printf(slog.LevelDebug, "frame %q is percent-encoded, text: %s", res.Name(), Dump(text))
+					res.fix = fixPercent
+					fi.Fields = append(fi.Fields, res)
+					continue
+				}
+				if f.alpha.Goodness(trimGarbage(text)) >= 1 {
+					// If the result is already correct, skip it as well.
+					log.Printf(slog.LevelDebug, "frame %q => %q is already correct", res.Name(), text)
+					if f.genreField(res) || f.translitField(res) || f.untranslitField(res) || f.stripField(res) || f.cleanupField(res) || f.replaceField(res) {
+						fi.Fields = append(fi.Fields, res)
+					}
+					continue
+				}
+				if unicode {
+					// We only have to convert non-ISO frames if the encoding is wrong.
+					fix, ok := f.unicodeFix(key, tf.Encoding, text)
+					if !ok {
+						log.Printf(slog.LevelDebug, "frame %q encoding is not ISO, skipping", res.Name())
+						if f.genreField(res) || f.translitField(res) || f.cleanupField(res) || f.replaceField(res) {
+							fi.Fields = append(fi.Fields, res)
+						}
+						continue
+					}
+					res.fix = fix
+				}
+				log.Printf(slog.LevelDebug, "frame %q found, encoding %v, text: %s", res.Name(), tf.Encoding, Dump(text))
+				fi.Fields = append(fi.Fields, res)
+			}
+			if len(fi.Fields) == 0 {
+				correct++
+				continue
+			}
+			out = append(out, fi)
+		}
+	}
+	return out, correct
+}
+
+// Apply writes the planned changes into the file.  Nothing is written if any
+// of the frames has changed since the plan was made, failing with ErrStale,
+// or if the file would not change.  With TouchNothing, the file is only
+// written if the text of some frame changes.
+func (f *Fixer) Apply(p *FilePlan) error {
+	return f.ApplyFunc(p, nil)
+}
+
+// ApplyFunc is like Apply, but calls the function with the original frames
+// before writing, e.g. to record them or to back the file up.  The function
+// is not called if nothing is written.  All frames with the keys being written
+// are given, and none for the keys of the new frames.  If the function returns
+// an error, nothing is written.
+func (f *Fixer) ApplyFunc(p *FilePlan, before func(orig map[string][]FrameData) error) error {
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	if b := backendFor(p.File); b != nil {
+		return f.applyFields(b, p, before)
+	}
+	tag, err := openTag(p.File)
+	if err != nil {
+		return readError(p.File, err)
+	}
+	defer tag.Close()
+	f.wait(int64(tag.Size()))
+
+	var updates []Update
+	for i := range p.Frames {
+		fp := &p.Frames[i]
+		orig, err := fp.Original.TextFrame()
+		if err != nil {
+			return fmt.Errorf("frame %s[%d]: %v", fp.ID, fp.Index, err)
+		}
+		framers := tag.GetFrames(fp.ID)
+		if fp.Source != "" {
+			// The new frame is added after the existing ones, if any appeared since.
+			if len(framers) > 0 {
+				return staleError(p.File, "frame %s has been added since the plan was made", fp.ID)
+			}
+			tf, _ := fp.TextFrame(f.opts.Encoding)
+			updates = append(updates, Update{Key: fp.ID, Index: len(framers), Frame: tf.Framer(fp.ID)})
+			continue
+		}
+		if fp.Index >= len(framers) {
+			return staleError(p.File, "frame %s[%d] not found", fp.ID, fp.Index)
+		}
+		cur, ok := ToTextFrame(framers[fp.Index])
+		if !ok || !cur.equal(orig) {
+			return staleError(p.File, "frame %s[%d] has changed since the plan was made", fp.ID, fp.Index)
+		}
+		if fp.Remove {
+			updates = append(updates, Update{Key: fp.ID, Index: fp.Index})
+			continue
+		}
+		tf, _ := fp.TextFrame(f.opts.Encoding)
+		if cur.equal(tf) || f.opts.TouchNothing && cur.Text == tf.Text && cur.Description == tf.Description {
+			continue
+		}
+		updates = append(updates, Update{Key: fp.ID, Index: fp.Index, Frame: tf.Framer(fp.ID)})
+	}
+	if len(updates) == 0 && (f.opts.TouchNothing || !f.changesTag(p.File, tag)) {
+		f.log.Printf(slog.LevelInfo, "nothing changed, not written")
+		return nil
+	}
+	if err := f.ctx.Err(); err != nil {
+		return err
+	}
+	if before != nil {
+		orig, err := originalFrames(tag, updates)
+		if err != nil {
+			return err
+		}
+		if err := before(orig); err != nil {
+			return err
+		}
+	}
+	if err := replaceFrames(tag, updates); err != nil {
+		return err
+	}
+	if f.opts.Version != 0 {
+		setVersion(tag, f.opts.Version)
+	}
+	var v1 []byte
+	switch {
+	case f.opts.StripID3v1:
+		v1 = []byte{}
+	case f.opts.WriteID3v1 != "":
+		v1 = makeID3v1(tag, f.opts.WriteID3v1, f.translit)
+	}
+	inPlace, err := saveFile(p.File, tag, v1, f.saveOptions())
+	if err != nil {
+		return writeError(p.File, err)
+	}
+	p.Written = true
+	if inPlace {
+		f.log.Printf(slog.LevelDebug, "tag rewritten in place")
+		p.InPlace = true
+	}
+	if !f.opts.Verify {
+		return nil
+	}
+	if err := verifyFrames(p.File, tagInfos(tag)); err != nil {
+		return writeError(p.File, err)
+	}
+	return nil
+}
+
+// Check whether saving the tag would change the file even without any frame
+// changed: its version or its ID3v1 tag, see Options.
+func (f *Fixer) changesTag(path string, tag *id3v2.Tag) bool {
+	if f.opts.Version != 0 && tag.Version() != f.opts.Version {
+		return true
+	}
+	if isAIFF(path) || !f.opts.StripID3v1 && f.opts.WriteID3v1 == "" {
+		return false
+	}
+	old, err := readID3v1(path)
+	if err != nil {
+		// Let the saving fail.
+		return true
+	}
+	if f.opts.StripID3v1 {
+		return old != nil
+	}
+	return !bytes.Equal(old, makeID3v1(tag, f.opts.WriteID3v1, f.translit))
+}
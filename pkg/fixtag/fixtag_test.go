@@ -0,0 +1,200 @@
+package fixtag
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Encode the text into the charset and read the bytes as Latin-1, the way
+// the text tagged in a Cyrillic charset is read from an ISO-8859-1 frame.
+func mojibake(t *testing.T, text string, cm encoding.Encoding) string {
+	t.Helper()
+	b, err := cm.NewEncoder().String(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := make([]rune, len(b))
+	for i := 0; i < len(b); i++ {
+		r[i] = rune(b[i])
+	}
+	return string(r)
+}
+
+// Write a new MP3 file with the title in the encoding given, followed by
+// some audio frames.
+func writeMP3(t *testing.T, enc id3v2.Encoding, title string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.mp3")
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(3)
+	tag.AddTextFrame("TIT2", enc, title)
+	var buf bytes.Buffer
+	if _, err := tag.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	buf.Write(bytes.Repeat([]byte("\xff\xfb\x90\x00"), 256))
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Read the title of the MP3 file.
+func readTitle(t *testing.T, path string) string {
+	t.Helper()
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	return tag.Title()
+}
+
+func TestPlanMojibake(t *testing.T) {
+	// The text tagged in UTF-8 and read as Windows-1252, of the letters
+	// with no bytes undefined in Windows-1252.
+	dbl, err := charmap.Windows1252.NewDecoder().String("Ветер перемен")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		enc   id3v2.Encoding
+		title string
+		want  string
+		chain string
+	}{
+		{"cp1251", id3v2.EncodingISO, mojibake(t, "Группа крови", charmap.Windows1251), "Группа крови", "iso-win"},
+		{"koi8-r", id3v2.EncodingISO, mojibake(t, "Звезда по имени Солнце", charmap.KOI8R), "Звезда по имени Солнце", "iso-koi"},
+		{"cp866", id3v2.EncodingISO, mojibake(t, "Пачка сигарет", charmap.CodePage866), "Пачка сигарет", "iso-dos"},
+		{"utf-8 read as cp1252", id3v2.EncodingUTF8, dbl, "Ветер перемен", "dbl-1252"},
+		{"trailing nul", id3v2.EncodingISO, mojibake(t, "Кукушка", charmap.Windows1251) + "\x00", "Кукушка", "iso-win"},
+		{"nul padding", id3v2.EncodingUTF8, "Спокойная ночь\x00\x00", "Спокойная ночь", ChainStrip},
+		{"byte order mark", id3v2.EncodingUTF16, "Бе\ufeffлый снег", "Белый снег", ChainStrip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMP3(t, tt.enc, tt.title)
+			fixer, err := New(Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := fixer.Plan(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := res.Fields()
+			if len(fields) != 1 {
+				t.Fatalf("Plan() fields = %d, want 1", len(fields))
+			}
+			field := fields[0]
+			if field.Action != ActionConverted || field.Winner == nil {
+				t.Fatalf("Plan() action = %s, candidates %d", field.Action, len(field.Candidates))
+			}
+			if field.Winner.Text != tt.want || field.Winner.Chain != tt.chain {
+				t.Errorf("Plan() = %q by %s, want %q by %s", field.Winner.Text, field.Winner.Chain, tt.want, tt.chain)
+			}
+		})
+	}
+}
+
+func TestApplyPlan(t *testing.T) {
+	fixer, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := func(path string) *FilePlan {
+		t.Helper()
+		res, err := fixer.Plan(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Plan()
+	}
+
+	path := writeMP3(t, id3v2.EncodingISO, mojibake(t, "Группа крови", charmap.Windows1251))
+	audio := func() []byte {
+		t.Helper()
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		size, err := id3v2Size(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data[size:]
+	}
+	before := audio()
+	p := plan(path)
+	if err := fixer.Apply(p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Written {
+		t.Error("Apply() has not marked the plan written")
+	}
+	if got := readTitle(t, path); got != "Группа крови" {
+		t.Errorf("title after Apply() = %q", got)
+	}
+	if !bytes.Equal(audio(), before) {
+		t.Error("Apply() has changed the audio")
+	}
+	// Nothing is left to write.
+	p = plan(path)
+	if len(p.Frames) != 0 {
+		t.Errorf("plan after Apply() = %+v", p.Frames)
+	}
+
+	// The file changed since the plan is not written.
+	path = writeMP3(t, id3v2.EncodingISO, mojibake(t, "Группа крови", charmap.Windows1251))
+	p = plan(path)
+	other := mojibake(t, "Кончится лето", charmap.Windows1251)
+	path2 := writeMP3(t, id3v2.EncodingISO, other)
+	data, err := os.ReadFile(path2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixer.Apply(p); !errors.Is(err, ErrStale) {
+		t.Errorf("Apply() of the stale plan error = %v, want %v", err, ErrStale)
+	}
+	if p.Written {
+		t.Error("Apply() of the stale plan has marked it written")
+	}
+	if got := readTitle(t, path); got != other {
+		t.Errorf("title after the stale Apply() = %q, want %q", got, other)
+	}
+}
+
+func TestNewCopiesOptions(t *testing.T) {
+	opts := Options{Languages: []string{"ru"}, Frames: []string{"TIT2"}, Thresholds: map[string]float64{"TIT2": 0.5}}
+	f, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Languages[0] = "el"
+	opts.Frames[0] = "COMM"
+	opts.Thresholds["TIT2"] = 1
+	if f.opts.Languages[0] != "ru" || f.opts.Frames[0] != "TIT2" || f.opts.Thresholds["TIT2"] != 0.5 {
+		t.Errorf("the Fixer shares the options: %v %v %v", f.opts.Languages, f.opts.Frames, f.opts.Thresholds)
+	}
+	DefaultLanguages()[0] = "el"
+	Charsets()[0] = "none"
+	if DefaultLanguages()[0] != "ru" || Charsets()[0] != CharsetCP1251 {
+		t.Error("the package defaults are changed through the returned slices")
+	}
+}
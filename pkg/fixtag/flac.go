@@ -0,0 +1,149 @@
+package fixtag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The FLAC files, with the tag in the VORBIS_COMMENT metadata block.
+type flacBackend struct{}
+
+// The types of the FLAC metadata blocks.
+const (
+	flacStreamInfo = 0
+	flacComment    = 4
+)
+
+// The maximal size of a FLAC metadata block, the size being 24 bits.
+const flacMaxBlock = 1<<24 - 1
+
+// The vendor of the Vorbis comment if the file has none.
+const defaultVendor = "fix-mp3-tag"
+
+var errNotFLAC = errors.New("not a FLAC file")
+
+// A metadata block of the FLAC file.
+type flacBlock struct {
+	typ  byte
+	data []byte
+}
+
+// The metadata of the FLAC file.
+type flacFile struct {
+	start  int64 // the offset of the "fLaC" marker, after the ID3v2 tag if any
+	audio  int64 // the offset of the audio frames
+	blocks []flacBlock
+}
+
+// Read the metadata blocks of the FLAC file.
+func readFLAC(f *os.File) (*flacFile, error) {
+	start, err := id3v2Size(f)
+	if err != nil {
+		return nil, err
+	}
+	magic := make([]byte, 4)
+	if _, err := f.ReadAt(magic, start); err != nil || string(magic) != "fLaC" {
+		return nil, errNotFLAC
+	}
+	ff := &flacFile{start: start}
+	off := start + 4
+	for {
+		header := make([]byte, 4)
+		if _, err := f.ReadAt(header, off); err != nil {
+			return nil, fmt.Errorf("truncated metadata block at %d", off)
+		}
+		last := header[0]&0x80 != 0
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		b := flacBlock{typ: header[0] & 0x7f, data: make([]byte, size)}
+		if _, err := f.ReadAt(b.data, off+4); err != nil {
+			return nil, fmt.Errorf("truncated metadata block at %d", off)
+		}
+		ff.blocks = append(ff.blocks, b)
+		off += 4 + size
+		if last {
+			break
+		}
+	}
+	if ff.blocks[0].typ != flacStreamInfo {
+		return nil, errNotFLAC
+	}
+	ff.audio = off
+	return ff, nil
+}
+
+func (flacBackend) Name() string {
+	return "flac"
+}
+
+func (flacBackend) Extensions() []string {
+	return []string{".flac"}
+}
+
+func (flacBackend) ReadFields(f *os.File) ([]TagField, error) {
+	ff, err := readFLAC(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range ff.blocks {
+		if b.typ == flacComment {
+			_, fields, err := parseVorbisComment(b.data)
+			return fields, err
+		}
+	}
+	return nil, nil
+}
+
+func (flacBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	ff, err := readFLAC(f)
+	if err != nil {
+		return err
+	}
+	// Replace the comment block, or add one after the stream info.
+	blocks := ff.blocks
+	found := false
+	for i, b := range blocks {
+		if b.typ == flacComment {
+			v, _, err := parseVorbisComment(b.data)
+			if err != nil {
+				return err
+			}
+			blocks[i].data = makeVorbisComment(v, fields)
+			found = true
+			break
+		}
+	}
+	if !found {
+		b := flacBlock{typ: flacComment, data: makeVorbisComment(defaultVendor, fields)}
+		blocks = append(blocks[:1], append([]flacBlock{b}, blocks[1:]...)...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("fLaC")
+	for i, b := range blocks {
+		if len(b.data) > flacMaxBlock {
+			return fmt.Errorf("metadata block %d is too large", b.typ)
+		}
+		typ := b.typ
+		if i == len(blocks)-1 {
+			typ |= 0x80
+		}
+		n := len(b.data)
+		buf.Write([]byte{typ, byte(n >> 16), byte(n >> 8), byte(n)})
+		buf.Write(b.data)
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(f, 0, ff.start)); err != nil {
+		return err
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, ff.audio, st.Size()-ff.audio))
+	return err
+}
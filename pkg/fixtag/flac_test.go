@@ -0,0 +1,91 @@
+package fixtag
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// Make the FLAC metadata block, set the high bit of the type for the last one.
+func makeFLACBlock(typ byte, data []byte) []byte {
+	n := len(data)
+	return append([]byte{typ, byte(n >> 16), byte(n >> 8), byte(n)}, data...)
+}
+
+// Make the FLAC file of the metadata blocks, followed by the data given.
+func makeFLAC(tail []byte, blocks ...[]byte) []byte {
+	data := []byte("fLaC")
+	for _, b := range blocks {
+		data = append(data, b...)
+	}
+	return append(data, tail...)
+}
+
+var flacAudio = bytes.Repeat([]byte("\xff\xf8\x69\x08"), 64)
+
+func TestFLACMalformed(t *testing.T) {
+	stream := makeFLACBlock(flacStreamInfo, make([]byte, 34))
+	comment := func(data string) []byte {
+		return makeFLACBlock(0x80|flacComment, []byte(data))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error // nil for any error
+	}{
+		{"not flac", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), errNotFLAC},
+		{"no stream info", makeFLAC(flacAudio, comment("\x00\x00\x00\x00\x00\x00\x00\x00")), errNotFLAC},
+		{"truncated header", makeFLAC([]byte{0, 0}, stream), nil},
+		{"truncated block", makeFLAC(nil, stream, []byte{0x80 | flacComment, 0, 1, 0, 0, 0, 0, 0}), nil},
+		{"vendor too long", makeFLAC(flacAudio, stream, comment("\xff\x00\x00\x00test")), errBadComment},
+		{"no field count", makeFLAC(flacAudio, stream, comment("\x04\x00\x00\x00test")), errBadComment},
+		{"too many fields", makeFLAC(flacAudio, stream, comment("\x04\x00\x00\x00test\x02\x00\x00\x00\x07\x00\x00\x00TITLE=x")), errBadComment},
+		{"field without value", makeFLAC(flacAudio, stream, comment("\x04\x00\x00\x00test\x01\x00\x00\x00\x05\x00\x00\x00TITLE")), errBadComment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.flac", tt.data)
+			_, err := readFields(flacBackend{}, path)
+			if err == nil || tt.want != nil && err != tt.want {
+				t.Errorf("ReadFields() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	padding := makeFLACBlock(0x80|1, make([]byte, 16))
+	for _, tt := range []struct {
+		name   string
+		blocks [][]byte
+	}{
+		{"comment", [][]byte{
+			makeFLACBlock(flacStreamInfo, make([]byte, 34)),
+			makeFLACBlock(flacComment, makeVorbisComment("test", []TagField{{Name: "TITLE", Value: "Ãðóïïà êðîâè"}})),
+			padding,
+		}},
+		{"no comment", [][]byte{makeFLACBlock(flacStreamInfo, make([]byte, 34)), padding}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.flac", makeFLAC(flacAudio, tt.blocks...))
+			fields := []TagField{{Name: "TITLE", Value: "Группа крови"}, {Name: "ARTIST", Value: "Кино"}}
+			if err := writeFields(flacBackend{}, path, fields, saveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFields(flacBackend{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[0] != fields[0] || got[1] != fields[1] {
+				t.Errorf("ReadFields() after write = %+v", got)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasSuffix(data, append(padding, flacAudio...)) {
+				t.Error("the other blocks or the audio have changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,306 @@
+package fixtag
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2"
+)
+
+// TextFrame is the text contents of a frame, common for all frame types supported
+// for conversion.  The language is only used by the comment and lyrics frames, and
+// the description by the comment, lyrics and user-defined text frames.  For the
+// lyrics the description is the content descriptor and the text is the lyrics,
+// for the user-defined text frames the text is the value.
+type TextFrame struct {
+	Encoding    id3v2.Encoding
+	Language    string
+	Description string
+	Text        string
+}
+
+// Names of the text fields of a frame, in the order of conversion.
+const (
+	FieldDescription = "description"
+	FieldText        = "text"
+)
+
+var textFields = []string{FieldDescription, FieldText}
+
+// ToTextFrame gets the text contents of the frame, if the frame type is supported.
+func ToTextFrame(f id3v2.Framer) (TextFrame, bool) {
+	switch f := f.(type) {
+	case id3v2.TextFrame:
+		return TextFrame{Encoding: f.Encoding, Text: f.Text}, true
+	case id3v2.CommentFrame:
+		return TextFrame{Encoding: f.Encoding, Language: f.Language, Description: f.Description, Text: f.Text}, true
+	case id3v2.UnsynchronisedLyricsFrame:
+		return TextFrame{Encoding: f.Encoding, Language: f.Language, Description: f.ContentDescriptor, Text: f.Lyrics}, true
+	case id3v2.UserDefinedTextFrame:
+		return TextFrame{Encoding: f.Encoding, Description: f.Description, Text: f.Value}, true
+	}
+	return TextFrame{}, false
+}
+
+// Framer builds the frame with the given id from the text contents.
+func (tf TextFrame) Framer(id string) id3v2.Framer {
+	switch id {
+	case "COMM":
+		return id3v2.CommentFrame{
+			Encoding:    tf.Encoding,
+			Language:    tf.Language,
+			Description: tf.Description,
+			Text:        tf.Text,
+		}
+	case "USLT":
+		return id3v2.UnsynchronisedLyricsFrame{
+			Encoding:          tf.Encoding,
+			Language:          tf.Language,
+			ContentDescriptor: tf.Description,
+			Lyrics:            tf.Text,
+		}
+	case "TXXX":
+		return id3v2.UserDefinedTextFrame{
+			Encoding:    tf.Encoding,
+			Description: tf.Description,
+			Value:       tf.Text,
+		}
+	}
+	return id3v2.TextFrame{Encoding: tf.Encoding, Text: tf.Text}
+}
+
+// Field returns the pointer to the named text field.
+func (tf *TextFrame) Field(name string) *string {
+	if name == FieldDescription {
+		return &tf.Description
+	}
+	return &tf.Text
+}
+
+// Check whether the frames have the same contents.
+func (tf TextFrame) equal(o TextFrame) bool {
+	return tf.Encoding.Equals(o.Encoding) && tf.Language == o.Language &&
+		tf.Description == o.Description && tf.Text == o.Text
+}
+
+// FrameData is the serialized contents of a text frame.
+// The text which is not valid UTF-8, e.g. the raw bytes in a frame
+// declared as UTF-8, is kept in hex, since JSON cannot hold it.
+type FrameData struct {
+	Encoding       byte   `json:"encoding"` // ID3v2 encoding key
+	Language       string `json:"language,omitempty"`
+	Description    string `json:"description,omitempty"`
+	DescriptionHex string `json:"description_hex,omitempty"`
+	Text           string `json:"text"`
+	TextHex        string `json:"text_hex,omitempty"`
+}
+
+// NewFrameData serializes the text frame.
+func NewFrameData(tf TextFrame) FrameData {
+	d := FrameData{
+		Encoding: tf.Encoding.Key,
+		Language: tf.Language,
+	}
+	d.Description, d.DescriptionHex = encodeText(tf.Description)
+	d.Text, d.TextHex = encodeText(tf.Text)
+	return d
+}
+
+// Encode the text either as is or in hex, if it is not valid UTF-8.
+func encodeText(text string) (string, string) {
+	if utf8.ValidString(text) {
+		return text, ""
+	}
+	return "", hex.EncodeToString([]byte(text))
+}
+
+// Decode the text encoded with encodeText.
+func decodeText(text, textHex string) (string, error) {
+	if textHex == "" {
+		return text, nil
+	}
+	b, err := hex.DecodeString(textHex)
+	return string(b), err
+}
+
+// TextFrame restores the text frame.
+func (d FrameData) TextFrame() (TextFrame, error) {
+	enc, err := EncodingByKey(d.Encoding)
+	if err != nil {
+		return TextFrame{}, err
+	}
+	tf := TextFrame{Encoding: enc, Language: d.Language}
+	if tf.Description, err = decodeText(d.Description, d.DescriptionHex); err != nil {
+		return tf, err
+	}
+	if tf.Text, err = decodeText(d.Text, d.TextHex); err != nil {
+		return tf, err
+	}
+	return tf, nil
+}
+
+// All encodings, indexed by the ID3v2 encoding key.
+var encodings = []id3v2.Encoding{
+	id3v2.EncodingISO,
+	id3v2.EncodingUTF16,
+	id3v2.EncodingUTF16BE,
+	id3v2.EncodingUTF8,
+}
+
+// EncodingByKey returns the encoding with the ID3v2 encoding key.
+func EncodingByKey(key byte) (id3v2.Encoding, error) {
+	if int(key) >= len(encodings) {
+		return id3v2.Encoding{}, fmt.Errorf("unknown encoding %d", key)
+	}
+	return encodings[key], nil
+}
+
+// EncodingByName returns the Unicode encoding by its name, "utf8" or "utf16".
+// The UTF-16 is written with BOM and is readable by ID3v2.3 players.
+func EncodingByName(name string) (id3v2.Encoding, error) {
+	switch strings.ToLower(name) {
+	case "utf8", "utf-8":
+		return id3v2.EncodingUTF8, nil
+	case "utf16", "utf-16":
+		return id3v2.EncodingUTF16, nil
+	}
+	return id3v2.Encoding{}, fmt.Errorf("unknown encoding %q, must be utf8 or utf16", name)
+}
+
+// Update is a frame to put into the tag in place of the existing one,
+// or a new frame if the index is the number of the frames with the key.
+type Update struct {
+	Key   string
+	Index int          // the index among the frames with the same key
+	Frame id3v2.Framer // nil to remove the frame
+}
+
+// SaveFrames replaces the frames in the tag and saves it back into the file.
+// All frames with the same key are replaced at once, so that the frames
+// with changed identifiers (e.g. the comment description) are not duplicated.
+func SaveFrames(path string, tag *id3v2.Tag, updates []Update) error {
+	if err := replaceFrames(tag, updates); err != nil {
+		return err
+	}
+	return SaveTag(path, tag)
+}
+
+// Get all frames with the keys of the updates, none for the new keys.
+func originalFrames(tag *id3v2.Tag, updates []Update) (map[string][]FrameData, error) {
+	orig := make(map[string][]FrameData)
+	for _, u := range updates {
+		if _, ok := orig[u.Key]; ok {
+			continue
+		}
+		orig[u.Key] = nil
+		for _, f := range tag.GetFrames(u.Key) {
+			tf, ok := ToTextFrame(f)
+			if !ok {
+				return nil, fmt.Errorf("frame %s is not a text frame", u.Key)
+			}
+			orig[u.Key] = append(orig[u.Key], NewFrameData(tf))
+		}
+	}
+	return orig, nil
+}
+
+// RestoreFrames replaces all frames with the given keys, e.g. the frames
+// recorded before writing.  The frames with the keys given no frames are removed.
+func RestoreFrames(path string, frames map[string][]FrameData) error {
+	if b := backendFor(path); b != nil {
+		return restoreFields(b, path, frames)
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return err
+	}
+	defer tag.Close()
+	for key, saved := range frames {
+		tag.DeleteFrames(key)
+		for _, d := range saved {
+			tf, err := d.TextFrame()
+			if err != nil {
+				return fmt.Errorf("frame %s: %v", key, err)
+			}
+			tag.AddFrame(key, tf.Framer(key))
+		}
+	}
+	return SaveTag(path, tag)
+}
+
+// Replace the frames in the tag, see SaveFrames.
+func replaceFrames(tag *id3v2.Tag, updates []Update) error {
+	updated := make(map[string][]id3v2.Framer)
+	for _, u := range updates {
+		framers, ok := updated[u.Key]
+		if !ok {
+			framers = append([]id3v2.Framer(nil), tag.GetFrames(u.Key)...)
+			updated[u.Key] = framers
+		}
+		switch {
+		case u.Index == len(framers):
+			framers = append(framers, u.Frame)
+			updated[u.Key] = framers
+		case u.Index > len(framers):
+			return fmt.Errorf("frame %s[%d] not found", u.Key, u.Index)
+		default:
+			framers[u.Index] = u.Frame
+		}
+	}
+	for key, framers := range updated {
+		tag.DeleteFrames(key)
+		for _, f := range framers {
+			if f != nil {
+				tag.AddFrame(key, f)
+			}
+		}
+	}
+	return nil
+}
+
+// Plan the change of the frame of the result to the text, or the new frame
+// from the source if there is none.  The frame is removed if the text is
+// empty.  The current frames are the first ones with every key.
+func (f *Fixer) planFrame(res *Result, chain, source, key, text string, current map[string]TextFrame) {
+	tf, exists := current[key]
+	switch {
+	case exists && tf.Text == text:
+		return
+	case !exists && text == "":
+		return
+	case !exists:
+		f.log.Printf(slog.LevelInfo, "frame %q set to %q", key, text)
+		res.Frames = append(res.Frames, &Frame{
+			Key:    key,
+			Orig:   TextFrame{Encoding: id3v2.EncodingUTF8},
+			Source: source,
+			Fields: []*Field{{
+				Key:    key,
+				Field:  FieldText,
+				Winner: &Candidate{Chain: chain, Charset: "utf-8", Text: text, Goodness: 1},
+				Action: ActionConverted,
+			}},
+		})
+		return
+	case text == "":
+		f.log.Printf(slog.LevelInfo, "frame %q removed", key)
+	default:
+		f.log.Printf(slog.LevelInfo, "frame %q changed to %q", key, text)
+	}
+	res.Frames = append(res.Frames, &Frame{
+		Key:    key,
+		Orig:   tf,
+		Remove: text == "",
+		Fields: []*Field{{
+			Key:    key,
+			Field:  FieldText,
+			Orig:   tf.Text,
+			Winner: &Candidate{Chain: chain, Charset: "utf-8", Text: text, Goodness: 1},
+			Action: ActionConverted,
+		}},
+	})
+	res.Correct--
+}
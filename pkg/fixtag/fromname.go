@@ -0,0 +1,114 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SourceFilename marks the frames set from the file name, see NameTags.
+const SourceFilename = "filename"
+
+// NamePattern is the pattern of the file names, e.g. "<track> - <artist> - <title>".
+// The values are "<track>", "<artist>", "<title>" and "<album>"; the pattern
+// with the slashes matches the directories too, e.g. "<artist>/<album>/<track>. <title>".
+type NamePattern struct {
+	re    *regexp.Regexp
+	names []string
+	depth int // the number of the path segments matched
+}
+
+// The regular expressions of the values of the pattern.
+var namePatternValues = map[string]string{
+	"track":  `\s*(\d+)\s*`,
+	"artist": `\s*(.+?)\s*`,
+	"title":  `\s*(.+?)\s*`,
+	"album":  `\s*(.+?)\s*`,
+}
+
+// ParseNamePattern parses the pattern of the file names.
+func ParseNamePattern(s string) (*NamePattern, error) {
+	p := &NamePattern{depth: strings.Count(s, "/") + 1}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for rest := s; rest != ""; {
+		i := strings.IndexByte(rest, '<')
+		if i < 0 {
+			expr.WriteString(regexp.QuoteMeta(rest))
+			break
+		}
+		expr.WriteString(regexp.QuoteMeta(rest[:i]))
+		j := strings.IndexByte(rest[i:], '>')
+		if j < 0 {
+			return nil, fmt.Errorf("unclosed %q in the pattern", rest[i:])
+		}
+		name := rest[i+1 : i+j]
+		v, ok := namePatternValues[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown value <%s> in the pattern, must be <track>, <artist>, <title> or <album>", name)
+		}
+		expr.WriteString(v)
+		p.names = append(p.names, name)
+		rest = rest[i+j+1:]
+	}
+	expr.WriteString("$")
+	if len(p.names) == 0 {
+		return nil, fmt.Errorf("no values in the pattern %q", s)
+	}
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, err
+	}
+	p.re = re
+	return p, nil
+}
+
+// Get the values from the trailing part of the path, the extension being
+// stripped.  The broken names are fixed first, see FixName.
+func (f *Fixer) nameValues(path string, p *NamePattern) (tagValues, bool) {
+	var v tagValues
+	segs := strings.Split(filepath.ToSlash(filepath.Clean(path)), "/")
+	if len(segs) < p.depth {
+		return v, false
+	}
+	segs = segs[len(segs)-p.depth:]
+	for i, seg := range segs {
+		if fixed, _ := f.FixName(seg); fixed != "" {
+			segs[i] = fixed
+		}
+	}
+	name := strings.Join(segs, "/")
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	m := p.re.FindStringSubmatch(name)
+	if m == nil {
+		return v, false
+	}
+	for i, n := range p.names {
+		switch n {
+		case "track":
+			v.track, _ = strconv.Atoi(m[i+1])
+		case "artist":
+			v.artist = m[i+1]
+		case "title":
+			v.title = m[i+1]
+		case "album":
+			v.album = m[i+1]
+		}
+	}
+	return v, true
+}
+
+// NameTags makes the plan of filling the empty frames of the tag with the values
+// from the name of the file: the title, the artist, the album and the track number.
+// The plan is nil if the name does not match the pattern.
+func (f *Fixer) NameTags(path string, p *NamePattern) (*FilePlan, error) {
+	v, ok := f.nameValues(path, p)
+	if !ok {
+		f.log.Printf(slog.LevelInfo, "file name does not match the pattern")
+		return nil, nil
+	}
+	return f.valuesPlan(path, v, SourceFilename)
+}
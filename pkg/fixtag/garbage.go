@@ -0,0 +1,107 @@
+package fixtag
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// The chain of the fields only stripped of the garbage, see trimGarbage.
+const ChainStrip = "strip"
+
+// The most characters stripped from the end of the text which cannot be
+// converted, e.g. the garbage left by the truncated frames.
+const maxGarbage = 3
+
+var errInvalidUTF8 = errors.New("invalid UTF-8")
+
+// Strip the text of the byte order marks anywhere, e.g. left by gluing the
+// UTF-16 strings together, of the trailing NUL padding, and of the spaces.
+func trimGarbage(text string) string {
+	text = strings.ReplaceAll(text, "\ufeff", "")
+	return strings.TrimSpace(strings.TrimRight(text, "\x00"))
+}
+
+// Check whether the text has any garbage stripped by trimGarbage, beside
+// the spaces.
+func hasGarbage(text string) bool {
+	return trimGarbage(text) != strings.TrimSpace(text)
+}
+
+// Mark the correct field to be written stripped of the garbage, if it has
+// any.  It returns false if the field need not be written.
+func (f *Fixer) stripField(res *Field) bool {
+	if res.Field != FieldText || !hasGarbage(res.Orig) || trimGarbage(res.Orig) == "" {
+		return false
+	}
+	res.Winner = &Candidate{Chain: ChainStrip, Charset: "utf-8", Text: trimGarbage(res.Orig), Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
+
+// Apply the transformations in turn, without stripping anything.
+func transform(src string, tlist ...StringTrans) (string, error) {
+	for _, t := range tlist {
+		dst, err := t.String(src)
+		if err != nil {
+			return "", err
+		}
+		src = dst
+	}
+	return src, nil
+}
+
+// Apply the transformations to the longest prefix of the text which can be
+// transformed into valid UTF-8 as a whole, replace the character after it
+// with U+FFFD, and go on with the rest, see Options.ReplaceInvalid.  The
+// prefixes are transformed whole, so that the multi-byte sequences, e.g. of
+// the double encoded UTF-8, are never split.  It fails if nothing can be
+// transformed.
+func decodeReplacing(log Logger, src string, tlist ...StringTrans) (string, error) {
+	var out strings.Builder
+	var firstErr error
+	good := 0
+	for rest := src; rest != ""; {
+		dst, n, err := transformPrefix(rest, tlist...)
+		if n > 0 {
+			out.WriteString(dst)
+			good++
+			rest = rest[n:]
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		out.WriteRune(utf8.RuneError)
+		_, n = utf8.DecodeRuneInString(rest)
+		rest = rest[n:]
+	}
+	if good == 0 && firstErr != nil {
+		return "", firstErr
+	}
+	log.Printf(slog.LevelDebug, "converted %s => %s, replacing the invalid characters", Dump(src), Dump(out.String()))
+	return out.String(), nil
+}
+
+// Transform the longest prefix of the text, whole runes, which becomes
+// valid UTF-8.  It returns the length of the prefix, 0 with the error of
+// the whole text if there is none.
+func transformPrefix(src string, tlist ...StringTrans) (string, int, error) {
+	var firstErr error
+	for end := len(src); end > 0; {
+		dst, err := transform(src[:end], tlist...)
+		if err == nil && !utf8.ValidString(dst) {
+			err = errInvalidUTF8
+		}
+		if err == nil {
+			return dst, end, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		_, n := utf8.DecodeLastRuneInString(src[:end])
+		end -= n
+	}
+	return "", 0, firstErr
+}
@@ -0,0 +1,110 @@
+package fixtag
+
+import (
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestTrimGarbage(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		garbage bool
+	}{
+		{"clean", "Кино", "Кино", false},
+		{"spaces", " Кино ", "Кино", false},
+		{"nul padding", "Кино\x00\x00", "Кино", true},
+		{"nul after spaces", "Кино \x00", "Кино", true},
+		{"byte order marks", "\ufeffКи\ufeffно", "Кино", true},
+		{"only garbage", "\x00\ufeff", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimGarbage(tt.text); got != tt.want {
+				t.Errorf("trimGarbage() = %q, want %q", got, tt.want)
+			}
+			if got := hasGarbage(tt.text); got != tt.garbage {
+				t.Errorf("hasGarbage() = %v, want %v", got, tt.garbage)
+			}
+		})
+	}
+}
+
+func TestStripField(t *testing.T) {
+	tests := []struct {
+		name  string
+		field string
+		orig  string
+		want  string // empty if the field is not stripped
+	}{
+		{"nul padding", FieldText, "Кино\x00", "Кино"},
+		{"byte order mark", FieldText, "Ки\ufeffно", "Кино"},
+		{"spaces only", FieldText, " Кино ", ""},
+		{"only garbage", FieldText, "\x00\x00", ""},
+		{"description", FieldDescription, "Кино\x00", ""},
+	}
+	fixer, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := &Field{Key: "TIT2", Field: tt.field, Orig: tt.orig}
+			ok := fixer.stripField(res)
+			if ok != (tt.want != "") {
+				t.Fatalf("stripField() = %v", ok)
+			}
+			if !ok {
+				return
+			}
+			if res.Action != ActionConverted || res.Winner.Text != tt.want || res.Winner.Chain != ChainStrip {
+				t.Errorf("stripField() = %q by %s, %s, want %q by %s", res.Winner.Text, res.Winner.Chain, res.Action, tt.want, ChainStrip)
+			}
+		})
+	}
+}
+
+func TestDecodeReplacing(t *testing.T) {
+	iso := charmap.ISO8859_1.NewEncoder()
+	win := charmap.Windows1251.NewDecoder()
+	dbl := charmap.Windows1252.NewEncoder()
+	// The text tagged in UTF-8 and read as Windows-1252.
+	double, err := charmap.Windows1252.NewDecoder().String("Ветер перемен")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		text  string
+		tlist []StringTrans
+		want  string // empty if nothing can be converted
+	}{
+		{"valid", mojibake(t, "Кино", charmap.Windows1251), []StringTrans{iso, win}, "Кино"},
+		{"invalid in the middle", mojibake(t, "Ки", charmap.Windows1251) + "€" + mojibake(t, "но", charmap.Windows1251), []StringTrans{iso, win}, "Ки\ufffdно"},
+		{"invalid at the end", mojibake(t, "Кино", charmap.Windows1251) + "€€", []StringTrans{iso, win}, "Кино\ufffd\ufffd"},
+		{"all invalid", "€€", []StringTrans{iso, win}, ""},
+		// "Ж" cannot be encoded, and "€" alone is not UTF-8.
+		{"double encoded with garbage", double + "Ж€", []StringTrans{dbl}, "Ветер перемен\ufffd\ufffd"},
+		// The last letter is cut in half, the rest of it is not UTF-8.
+		{"double encoded cut", double[:len(double)-len("½")] + "ЖЖ", []StringTrans{dbl}, "Ветер переме\ufffd\ufffd\ufffd"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeReplacing(nopLogger{}, tt.text, tt.tlist...)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("decodeReplacing() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decodeReplacing() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
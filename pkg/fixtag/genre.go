@@ -0,0 +1,154 @@
+package fixtag
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The chain of the genres normalized, see Options.
+const ChainGenre = "genre"
+
+// The genres of ID3v1 by their numbers, with the Winamp extensions.
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+	"Club-House", "Hardcore Techno", "Terror", "Indie", "BritPop", "Afro-Punk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover", "Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "JPop", "Synthpop", "Abstract", "Art Rock", "Baroque", "Bhangra",
+	"Big Beat", "Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth",
+	"Jam Band", "Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk",
+	"Post-Rock", "Psytrance", "Shoegaze", "Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
+
+// The special references of ID3v2.3.
+var id3Genres = map[string]string{"RX": "Remix", "CR": "Cover"}
+
+// The keys of the genre fields in all formats, upper case, see tagInfoKeys.
+var genreKeys = map[string]bool{"TCON": true, "GENRE": true, "©GEN": true, "IGNR": true}
+
+// The known genres by their spelling in lower case without the spaces
+// and the hyphens, e.g. "hiphop".
+var knownGenres = func() map[string]string {
+	known := make(map[string]string)
+	for _, g := range id3v1Genres {
+		known[genreKey(g)] = g
+	}
+	return known
+}()
+
+func genreKey(genre string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(genre))
+}
+
+// Get the name of the genre by its ID3 reference, e.g. "17" or "RX".
+func genreRef(ref string) (string, bool) {
+	if g, ok := id3Genres[ref]; ok {
+		return g, true
+	}
+	n, err := strconv.Atoi(ref)
+	if err != nil || n < 0 || n >= len(id3v1Genres) {
+		return "", false
+	}
+	return id3v1Genres[n], true
+}
+
+// Normalize the capitalization of the genre: the known ones are spelled as in
+// the list, the others in the title case if all in the same case.
+func genreCase(genre string) string {
+	if g, ok := knownGenres[genreKey(genre)]; ok {
+		return g
+	}
+	if genre != strings.ToLower(genre) && genre != strings.ToUpper(genre) {
+		return genre
+	}
+	r := []rune(strings.ToLower(genre))
+	for i := range r {
+		if i == 0 || !unicode.IsLetter(r[i-1]) && r[i-1] != '\'' {
+			r[i] = unicode.ToUpper(r[i])
+		}
+	}
+	return string(r)
+}
+
+// Normalize the genres of the text: the ID3 references like "(17)" or "17"
+// are replaced by the names, the refinement of "(17)Rock" is kept alone, and
+// the capitalization is normalized.  Several genres are joined with "/".
+func normalizeGenre(text string) string {
+	var genres []string
+	rest := strings.TrimSpace(text)
+	for strings.HasPrefix(rest, "(") && !strings.HasPrefix(rest, "((") {
+		end := strings.IndexByte(rest, ')')
+		if end < 0 {
+			break
+		}
+		g, ok := genreRef(rest[1:end])
+		if !ok {
+			break
+		}
+		genres = append(genres, g)
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	// "((" escapes the parenthesis of the text starting with it.
+	rest = strings.Replace(rest, "((", "(", 1)
+	if g, ok := genreRef(rest); ok {
+		genres = append(genres, g)
+	} else if rest != "" {
+		// The refinement replaces the references.
+		genres = []string{genreCase(rest)}
+	}
+	var out []string
+	seen := make(map[string]bool)
+	for _, g := range genres {
+		if !seen[strings.ToLower(g)] {
+			seen[strings.ToLower(g)] = true
+			out = append(out, g)
+		}
+	}
+	return strings.Join(out, "/")
+}
+
+// Mark the correct genre field to be written normalized, if set in the
+// options.  It returns false if the field need not be written.
+func (f *Fixer) genreField(res *Field) bool {
+	if !f.opts.FixGenre || !genreKeys[strings.ToUpper(res.Key)] || res.Field != FieldText {
+		return false
+	}
+	text := normalizeGenre(res.Orig)
+	if text == "" || text == res.Orig {
+		return false
+	}
+	res.Winner = &Candidate{Chain: ChainGenre, Charset: "utf-8", Text: text, Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
+
+// Normalize the converted genre fields, if set in the options.
+func (f *Fixer) fixGenres(res *Result) {
+	if !f.opts.FixGenre {
+		return
+	}
+	for _, field := range res.Fields() {
+		if field.Action == ActionConverted && genreKeys[strings.ToUpper(field.Key)] && field.Field == FieldText {
+			if text := normalizeGenre(field.Winner.Text); text != "" {
+				field.Winner.Text = text
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package fixtag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// ChainHook marks the fields whose text is given by the hook, see Hook.
+const ChainHook = "hook"
+
+// Hook decides the conversion of every field converted, after the Fixer has
+// decided it, e.g. with the heuristics of the user, see Options.
+type Hook interface {
+	// Choose gets the choice for the field of the file, nil to keep the
+	// decision of the Fixer.
+	Choose(ctx context.Context, file string, field *Field) (*HookChoice, error)
+}
+
+// HookChoice is the choice of the hook: the candidate by the name of its
+// chain, the text of the field, or no conversion at all.
+type HookChoice struct {
+	Chain string `json:"chain,omitempty"`
+	Text  string `json:"text,omitempty"`
+	Skip  bool   `json:"skip,omitempty"`
+}
+
+// HookCandidate is a decoding of the field passed to the hook.
+type HookCandidate struct {
+	Chain    string  `json:"chain"`
+	Charset  string  `json:"charset,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	Goodness float64 `json:"goodness"`
+	Score    float64 `json:"score,omitempty"`
+	Accepted bool    `json:"accepted"` // above the threshold
+	Error    string  `json:"error,omitempty"`
+}
+
+// HookInput is the field passed to the hook.
+type HookInput struct {
+	File       string          `json:"file"`
+	Frame      string          `json:"frame"`
+	Original   string          `json:"original"`
+	Bytes      []byte          `json:"bytes,omitempty"` // as stored, if the text is in ISO-8859-1
+	Action     string          `json:"action"`
+	Winner     string          `json:"winner,omitempty"` // the chain of the winner
+	Candidates []HookCandidate `json:"candidates"`
+}
+
+// NewHookInput makes the input of the hook for the field of the file.
+func NewHookInput(file string, field *Field) *HookInput {
+	in := &HookInput{File: file, Frame: field.Name(), Original: field.Orig, Action: field.Action, Candidates: []HookCandidate{}}
+	if b, err := charmap.ISO8859_1.NewEncoder().String(field.Orig); err == nil {
+		in.Bytes = []byte(b)
+	}
+	if field.Winner != nil {
+		in.Winner = field.Winner.Chain
+	}
+	accepted := make(map[string]bool)
+	for _, c := range field.Candidates {
+		accepted[c.Chain] = true
+	}
+	for _, a := range field.Attempts {
+		hc := HookCandidate{Chain: a.Chain, Charset: a.Charset, Text: a.Text, Goodness: a.Goodness, Score: a.Score, Accepted: accepted[a.Chain]}
+		if a.Err != nil {
+			hc.Error = a.Err.Error()
+		}
+		in.Candidates = append(in.Candidates, hc)
+	}
+	return in
+}
+
+// The hook running a command, see NewCommandHook.
+type commandHook struct {
+	command string
+}
+
+// NewCommandHook makes the hook running the command with the shell for every
+// field: the command gets the HookInput as JSON on the standard input, and
+// prints the HookChoice as JSON on the standard output, or nothing to keep
+// the decision of the Fixer.
+func NewCommandHook(command string) Hook {
+	return &commandHook{command: command}
+}
+
+func (h *commandHook) Choose(ctx context.Context, file string, field *Field) (*HookChoice, error) {
+	in, err := json.Marshal(NewHookInput(file, field))
+	if err != nil {
+		return nil, err
+	}
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.command)
+	}
+	cmd.Stdin = bytes.NewReader(in)
+	out, err := cmd.Output()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) && len(ee.Stderr) > 0 {
+			return nil, fmt.Errorf("hook: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("hook: %v", err)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var choice HookChoice
+	if err := json.Unmarshal(out, &choice); err != nil {
+		return nil, fmt.Errorf("hook: invalid output: %v", err)
+	}
+	return &choice, nil
+}
+
+// Let the hook decide the fields converted.  After an error, the rest of
+// the fields are left as the Fixer decided them.
+func (f *Fixer) hookFields(file string, fields []*Field) {
+	for _, field := range fields {
+		choice, err := f.opts.Hook.Choose(f.ctx, file, field)
+		if err != nil {
+			f.log.Printf(slog.LevelWarn, "cannot run the hook for frame %q: %v", field.Name(), err)
+			return
+		}
+		switch {
+		case choice == nil:
+		case choice.Skip:
+			field.Winner = nil
+			field.Action = ActionFailed
+			f.log.Printf(slog.LevelInfo, "frame %q left unconverted by the hook", field.Name())
+		case choice.Text != "":
+			field.Winner = &Candidate{Chain: ChainHook, Text: choice.Text, Goodness: 1}
+			field.Action = ActionConverted
+			f.log.Printf(slog.LevelInfo, "frame %q set by the hook: %q", field.Name(), choice.Text)
+		case choice.Chain != "":
+			c := field.attempt(choice.Chain)
+			if c == nil {
+				f.log.Printf(slog.LevelWarn, "Warning: the hook chose the unknown conversion %q of frame %q", choice.Chain, field.Name())
+				continue
+			}
+			field.Winner = c
+			field.Action = ActionConverted
+			f.log.Printf(slog.LevelInfo, "frame %q converted by the hook with %s: %q", field.Name(), c.Chain, c.Text)
+		}
+	}
+}
+
+// Get the candidate of the chain, among the candidates or the successful
+// attempts, nil if there is none.
+func (res *Field) attempt(chain string) *Candidate {
+	for _, c := range res.Candidates {
+		if c.Chain == chain {
+			return c
+		}
+	}
+	for i := range res.Attempts {
+		if a := &res.Attempts[i]; a.Err == nil && a.Chain == chain {
+			return &a.Candidate
+		}
+	}
+	return nil
+}
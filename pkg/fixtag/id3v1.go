@@ -0,0 +1,171 @@
+package fixtag
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bogem/id3v2"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// SourceID3v1 marks the frames made of the ID3v1 tag fields.
+const SourceID3v1 = "id3v1"
+
+// The size of the ID3v1 tag at the end of the file.
+const id3v1Size = 128
+
+// A field of the ID3v1 tag: the ID3v2 frame key, the offset and the length in the tag.
+// The year frame depends on the ID3v2 version, see yearKey.
+type id3v1Field struct {
+	key    string
+	offset int
+	size   int
+}
+
+var id3v1Fields = []id3v1Field{
+	{"TIT2", 3, 30},
+	{"TPE1", 33, 30},
+	{"TALB", 63, 30},
+	{"", 93, 4},
+	{"COMM", 97, 30},
+}
+
+// Read the ID3v1 tag at the end of the file, if any.
+func readID3v1(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if st.Size() < id3v1Size {
+		return nil, nil
+	}
+	buf := make([]byte, id3v1Size)
+	if _, err := f.ReadAt(buf, st.Size()-id3v1Size); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.HasPrefix(buf, []byte("TAG")) {
+		return nil, nil
+	}
+	return buf, nil
+}
+
+// Get the text of the ID3v1 field as is, with the bytes for the runes,
+// the same way the frames in ISO-8859-1 are read.
+func id3v1Text(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	b = bytes.TrimRight(b, " ")
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
+
+// Make the frames of the ID3v1 tag fields which are missing in the ID3v2 tag.
+// The fields which need no conversion are copied as is.
+func (f *Fixer) extractID3v1(path string, tag *id3v2.Tag) ([]*Frame, error) {
+	if isAIFF(path) {
+		return nil, nil
+	}
+	v1, err := readID3v1(path)
+	if v1 == nil || err != nil {
+		return nil, err
+	}
+	fields := append([]id3v1Field(nil), id3v1Fields...)
+	comment := 30
+	if v1[125] == 0 && v1[126] != 0 {
+		// ID3v1.1 keeps the track number at the end of the comment.
+		comment = 28
+		fields[len(fields)-1].size = comment
+	}
+	var out []*Frame
+	add := func(key, text string) {
+		if text == "" || len(tag.GetFrames(key)) > 0 {
+			return
+		}
+		tf := TextFrame{Encoding: id3v2.EncodingISO, Text: text}
+		if key == "COMM" {
+			tf.Language = "XXX"
+		}
+		fi := &Frame{Key: key, Orig: tf, Source: SourceID3v1}
+		res := &Field{Key: key, Field: FieldText, Orig: text}
+		if f.alpha.Goodness(text) >= 1 {
+			res.Winner = &Candidate{Chain: "copy", Text: text, Goodness: 1}
+			res.Candidates = []*Candidate{res.Winner}
+			res.Best = 1
+			res.Action = ActionConverted
+		}
+		f.log.Printf(slog.LevelDebug, "ID3v1 frame %q found, text: %s", key, Dump(text))
+		fi.Fields = append(fi.Fields, res)
+		out = append(out, fi)
+	}
+	for _, fld := range fields {
+		key := fld.key
+		if key == "" {
+			key = tag.CommonID("Year")
+		}
+		add(key, id3v1Text(v1[fld.offset:fld.offset+fld.size]))
+	}
+	if comment == 28 {
+		add("TRCK", strconv.Itoa(int(v1[126])))
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// The charsets of the written ID3v1 tag.
+const (
+	ID3v1CP1251   = "cp1251"   // in Windows-1251, unsupported characters replaced with "?"
+	ID3v1Translit = "translit" // transliterated into ASCII
+)
+
+// Get the text of the first frame with the key, if any.
+func frameText(tag *id3v2.Tag, key string) string {
+	framers := tag.GetFrames(key)
+	if len(framers) == 0 {
+		return ""
+	}
+	tf, _ := ToTextFrame(framers[0])
+	return tf.Text
+}
+
+// Build the ID3v1.1 tag from the frames of the ID3v2 tag.  The text is
+// transliterated with the scheme if the charset is ID3v1Translit.
+func makeID3v1(tag *id3v2.Tag, charset string, scheme translitScheme) []byte {
+	v1 := make([]byte, id3v1Size)
+	copy(v1, "TAG")
+	enc := encoding.ReplaceUnsupported(charmap.Windows1251.NewEncoder())
+	put := func(off, size int, text string) {
+		if charset == ID3v1Translit {
+			text = scheme.apply(text, true)
+		} else {
+			text, _ = enc.String(text)
+		}
+		copy(v1[off:off+size], text)
+	}
+	put(3, 30, frameText(tag, "TIT2"))
+	put(33, 30, frameText(tag, "TPE1"))
+	put(63, 30, frameText(tag, "TALB"))
+	put(93, 4, frameText(tag, tag.CommonID("Year")))
+	put(97, 28, frameText(tag, "COMM"))
+	// The track number may be given as "3/12".
+	track, _ := strconv.Atoi(strings.SplitN(frameText(tag, "TRCK"), "/", 2)[0])
+	if track > 0 && track < 256 {
+		v1[126] = byte(track)
+	}
+	v1[127] = 255 // no genre
+	return v1
+}
@@ -0,0 +1,153 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// A chunk of an IFF file: the RIFF chunks of WAV files are little-endian,
+// the chunks of AIFF files are big-endian.  The data of a chunk is padded
+// to the even size.
+type iffChunk struct {
+	id   string
+	data int64 // the offset of the data
+	size int64
+}
+
+// The chunks of an IFF file.
+type iffFile struct {
+	order  binary.ByteOrder
+	magic  string // "RIFF" or "FORM"
+	form   string // e.g. "WAVE" or "AIFF"
+	chunks []iffChunk
+	end    int64 // the end of the last chunk, the rest of the file is kept as is
+}
+
+var errBadChunk = errors.New("malformed chunk")
+
+// Read the chunks of the file.
+func readIFF(f *os.File, magic string, order binary.ByteOrder) (*iffFile, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 12)
+	if _, err := f.ReadAt(header, 0); err != nil || string(header[:4]) != magic {
+		return nil, fmt.Errorf("not a %s file", magic)
+	}
+	ff := &iffFile{order: order, magic: magic, form: string(header[8:12])}
+	end := 8 + int64(order.Uint32(header[4:]))
+	if end > st.Size() {
+		// Many tools write the size of a truncated file.
+		end = st.Size()
+	}
+	off := int64(12)
+	for off+8 <= end {
+		if _, err := f.ReadAt(header[:8], off); err != nil {
+			return nil, err
+		}
+		c := iffChunk{id: string(header[:4]), data: off + 8, size: int64(order.Uint32(header[4:]))}
+		if c.data+c.size > end {
+			return nil, fmt.Errorf("%v %q at %d", errBadChunk, c.id, off)
+		}
+		ff.chunks = append(ff.chunks, c)
+		off = c.data + c.size + c.size&1
+	}
+	if off > end {
+		// The padding of the last chunk is missing.
+		off = end
+	}
+	ff.end = off
+	return ff, nil
+}
+
+// Find the first chunk with one of the ids, -1 if none.
+func (ff *iffFile) find(ids ...string) int {
+	for i, c := range ff.chunks {
+		for _, id := range ids {
+			if c.id == id {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Read the data of the chunk.
+func (ff *iffFile) read(f *os.File, i int) ([]byte, error) {
+	data := make([]byte, ff.chunks[i].size)
+	_, err := f.ReadAt(data, ff.chunks[i].data)
+	return data, err
+}
+
+// Copy the file into w with the data of the i-th chunk replaced, or with
+// the chunk of the id added at the end if i is -1.  The chunk is removed
+// if the data is nil.
+func (ff *iffFile) copyWithChunk(w io.Writer, f *os.File, i int, id string, data []byte) error {
+	padded := func(n int64) int64 {
+		return n + n&1
+	}
+	size := int64(4)
+	for j, c := range ff.chunks {
+		if j != i {
+			size += 8 + padded(c.size)
+		}
+	}
+	if data != nil {
+		size += 8 + padded(int64(len(data)))
+	}
+	if size > 0xffffffff {
+		return errors.New("the file is too large")
+	}
+	header := make([]byte, 12)
+	copy(header, ff.magic)
+	ff.order.PutUint32(header[4:], uint32(size))
+	copy(header[8:], ff.form)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	write := func(id string, r io.Reader, n int64) error {
+		h := make([]byte, 8)
+		copy(h, id)
+		ff.order.PutUint32(h[4:], uint32(n))
+		if _, err := w.Write(h); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, n); err != nil {
+			return err
+		}
+		if n&1 != 0 {
+			_, err := w.Write([]byte{0})
+			return err
+		}
+		return nil
+	}
+	for j, c := range ff.chunks {
+		if j == i {
+			if data != nil {
+				if err := write(c.id, bytes.NewReader(data), int64(len(data))); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := write(c.id, io.NewSectionReader(f, c.data, c.size), c.size); err != nil {
+			return err
+		}
+	}
+	if i < 0 && data != nil {
+		if err := write(id, bytes.NewReader(data), int64(len(data))); err != nil {
+			return err
+		}
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, io.NewSectionReader(f, ff.end, st.Size()-ff.end))
+	return err
+}
@@ -0,0 +1,155 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/bogem/id3v2"
+)
+
+// Make the IFF chunk, padded to the even size.
+func makeChunk(order binary.ByteOrder, id string, data []byte) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	order.PutUint32(out[4:], uint32(len(data)))
+	out = append(out, data...)
+	if len(data)&1 != 0 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// Make the IFF file of the chunks.
+func makeIFF(order binary.ByteOrder, magic, form string, chunks ...[]byte) []byte {
+	data := []byte(form)
+	for _, c := range chunks {
+		data = append(data, c...)
+	}
+	return makeChunk(order, magic, data)
+}
+
+// The audio data of odd size, so that its chunk is padded.
+var iffAudio = bytes.Repeat([]byte{0x12, 0x34, 0x56}, 333)
+
+// Check that the size in the header of the file covers all of its chunks,
+// and that the audio chunk has not changed.
+func checkIFF(t *testing.T, path string, order binary.ByteOrder, magic, audio string, tail []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, tail) {
+		t.Error("the data after the chunks has changed")
+	}
+	if size := int(order.Uint32(data[4:])); size != len(data)-len(tail)-8 {
+		t.Errorf("the %s size is %d, want %d", magic, size, len(data)-len(tail)-8)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ff, err := readIFF(f, magic, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ff.end != int64(len(data)-len(tail)) {
+		t.Errorf("the chunks end at %d, want %d", ff.end, len(data)-len(tail))
+	}
+	i := ff.find(audio)
+	if i < 0 {
+		t.Fatalf("no %q chunk", audio)
+	}
+	if got, err := ff.read(f, i); err != nil || !bytes.Equal(got, iffAudio) {
+		t.Errorf("the %q chunk has changed: %v", audio, err)
+	}
+}
+
+func TestWAVRoundTrip(t *testing.T) {
+	le := binary.LittleEndian
+	format := makeChunk(le, "fmt ", make([]byte, 16))
+	audio := makeChunk(le, "data", iffAudio)
+	info := makeChunk(le, "LIST", []byte("INFOINAM\x0d\x00\x00\x00Ãðóïïà êðîâè\x00\x00"))
+	for _, tt := range []struct {
+		name string
+		data []byte
+		tail []byte // after the chunks, kept
+	}{
+		{"info", makeIFF(le, "RIFF", "WAVE", format, info, audio), nil},
+		{"info at the end", makeIFF(le, "RIFF", "WAVE", format, audio, info), nil},
+		{"no info", makeIFF(le, "RIFF", "WAVE", format, audio), nil},
+		{"junk after the chunks", makeIFF(le, "RIFF", "WAVE", format, audio, info), []byte("junk")},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.wav", append(tt.data, tt.tail...))
+			// The odd and even sizes of the values.
+			fields := []TagField{{Name: "INAM", Value: "Группа крови"}, {Name: "IART", Value: "Кино!"}}
+			if err := writeFields(wavBackend{}, path, fields, saveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFields(wavBackend{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[0] != fields[0] || got[1] != fields[1] {
+				t.Errorf("ReadFields() after write = %+v", got)
+			}
+			checkIFF(t, path, le, "RIFF", "data", tt.tail)
+		})
+	}
+}
+
+func TestAIFFRoundTrip(t *testing.T) {
+	be := binary.BigEndian
+	comm := makeChunk(be, "COMM", make([]byte, 18))
+	audio := makeChunk(be, "SSND", iffAudio)
+	old := id3v2.NewEmptyTag()
+	old.AddTextFrame("TIT2", id3v2.EncodingISO, "Ãðóïïà êðîâè")
+	var buf bytes.Buffer
+	if _, err := old.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name  string
+		data  []byte
+		title string // empty to remove the tag
+	}{
+		{"tag", makeIFF(be, "FORM", "AIFF", comm, makeChunk(be, "ID3 ", buf.Bytes()), audio), "Группа крови"},
+		{"lowercase chunk", makeIFF(be, "FORM", "AIFF", comm, audio, makeChunk(be, "id3 ", buf.Bytes())), "Группа крови"},
+		{"no tag", makeIFF(be, "FORM", "AIFC", comm, audio), "Группа крови"},
+		{"remove", makeIFF(be, "FORM", "AIFF", comm, makeChunk(be, "ID3 ", buf.Bytes()), audio), ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.aiff", tt.data)
+			tag := id3v2.NewEmptyTag()
+			if tt.title != "" {
+				tag.AddTextFrame("TIT2", id3v2.EncodingUTF8, tt.title)
+			}
+			if err := saveAIFFTag(path, tag, saveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readAIFFTag(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title() != tt.title {
+				t.Errorf("the title after write = %q, want %q", got.Title(), tt.title)
+			}
+			checkIFF(t, path, be, "FORM", "SSND", nil)
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			ff, err := readAIFF(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(ff.chunks); tt.title == "" && n != 2 || tt.title != "" && n != 3 {
+				t.Errorf("the file has %d chunks", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package fixtag
+
+import (
+	"slices"
+	"strings"
+)
+
+// TagInfo is the common values of the tag by their names, e.g. "artist",
+// whatever the format of the file.  The missing values are empty.
+type TagInfo map[string]string
+
+// TagInfoNames gets the names of the common values.  The slice is a copy.
+func TagInfoNames() []string {
+	return slices.Clone(tagInfoNames)
+}
+
+var tagInfoNames = []string{"artist", "albumartist", "album", "title", "track", "disc", "year", "genre"}
+
+// The keys of the common values by the names of the backends, the ID3v2
+// frames for MP3 and AIFF.  The first key found is used, the keys of the
+// fields are compared in upper case.
+var tagInfoKeys = map[string]map[string][]string{
+	"": {
+		"artist": {"TPE1"}, "albumartist": {"TPE2"}, "album": {"TALB"}, "title": {"TIT2"},
+		"track": {"TRCK"}, "disc": {"TPOS"}, "year": {"TDRC", "TYER"}, "genre": {"TCON"},
+	},
+	"flac": vorbisInfoKeys,
+	"ogg":  vorbisInfoKeys,
+	"ape": {
+		"artist": {"ARTIST"}, "albumartist": {"ALBUM ARTIST", "ALBUMARTIST"}, "album": {"ALBUM"}, "title": {"TITLE"},
+		"track": {"TRACK"}, "disc": {"DISC"}, "year": {"YEAR"}, "genre": {"GENRE"},
+	},
+	"mp4": {
+		"artist": {"©ART"}, "albumartist": {"AART"}, "album": {"©ALB"}, "title": {"©NAM"},
+		"year": {"©DAY"}, "genre": {"©GEN"},
+	},
+	"wav": {
+		"artist": {"IART"}, "album": {"IPRD"}, "title": {"INAM"}, "track": {"ITRK"},
+		"year": {"ICRD"}, "genre": {"IGNR"},
+	},
+}
+
+var vorbisInfoKeys = map[string][]string{
+	"artist": {"ARTIST"}, "albumartist": {"ALBUMARTIST"}, "album": {"ALBUM"}, "title": {"TITLE"},
+	"track": {"TRACKNUMBER"}, "disc": {"DISCNUMBER"}, "year": {"DATE"}, "genre": {"GENRE"},
+}
+
+// Get the keys of the common values of the file.
+func infoKeys(path string) (TagBackend, map[string][]string) {
+	name := ""
+	b := backendFor(path)
+	if b != nil {
+		name = b.Name()
+	}
+	return b, tagInfoKeys[name]
+}
+
+// Get the name of the common value in the frame of the file, empty if none.
+func infoName(path, key string) string {
+	_, keys := infoKeys(path)
+	for name, ids := range keys {
+		for _, id := range ids {
+			if strings.EqualFold(id, key) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// ReadTagInfo reads the common values of the tag of the file.
+func ReadTagInfo(path string) (TagInfo, error) {
+	b, keys := infoKeys(path)
+	if keys == nil {
+		return TagInfo{}, nil
+	}
+	values := make(map[string]string)
+	if b != nil {
+		fields, err := readFields(b, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			key := strings.ToUpper(field.Name)
+			if _, ok := values[key]; !ok {
+				values[key] = field.Value
+			}
+		}
+	} else {
+		tag, err := openTag(path)
+		if err != nil {
+			return nil, err
+		}
+		defer tag.Close()
+		for _, ids := range keys {
+			for _, id := range ids {
+				if framers := tag.GetFrames(id); len(framers) > 0 {
+					if tf, ok := ToTextFrame(framers[0]); ok {
+						values[id] = tf.Text
+					}
+				}
+			}
+		}
+	}
+	info := make(TagInfo)
+	for name, ids := range keys {
+		for _, id := range ids {
+			if v := strings.TrimSpace(values[id]); v != "" {
+				info[name] = v
+				break
+			}
+		}
+	}
+	return info, nil
+}
+
+// Update sets the values converted in the result of the file, e.g. to see
+// the values of the tag in the dry-run mode.
+func (info TagInfo) Update(res *Result) {
+	for _, field := range res.Fields() {
+		if field.Index > 0 || field.Field != FieldText || field.Winner == nil {
+			continue
+		}
+		switch field.Action {
+		case ActionConverted, ActionWritten:
+		default:
+			continue
+		}
+		if name := infoName(res.File, field.Key); name != "" {
+			info[name] = strings.TrimSpace(field.Winner.Text)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package fixtag
+
+import (
+	"fmt"
+
+	"golang.org/x/text/encoding"
+)
+
+// A range of letters, inclusive.
+type letterRange struct {
+	lo, hi rune
+}
+
+// A language of the tags.
+type language struct {
+	letters  []letterRange // accepted in the converted text, beside ASCII
+	charsets []string      // the legacy charsets used for the language
+}
+
+var cyrillicCharsets = []string{CharsetCP1251, CharsetKOI8R, CharsetCP866}
+
+// The ideographs, the punctuation and the full-width forms common for CJK languages.
+var cjkLetters = []letterRange{{0x3000, 0x303f}, {0x3400, 0x4dbf}, {0x4e00, 0x9fff}, {0xff00, 0xffef}}
+
+// The supported languages.  The basic Cyrillic range contains a few letters
+// not used by the language, e.g. "ы" in Ukrainian, which is good enough for
+// telling the right conversion.
+var languages = map[string]language{
+	"ru": {[]letterRange{{'А', 'я'}, {'Ё', 'Ё'}, {'ё', 'ё'}}, cyrillicCharsets},
+	"uk": {[]letterRange{{'А', 'я'}, {'Є', 'Є'}, {'І', 'Ї'}, {'є', 'є'}, {'і', 'ї'}, {'Ґ', 'ґ'}}, cyrillicCharsets},
+	"be": {[]letterRange{{'А', 'я'}, {'Ё', 'Ё'}, {'ё', 'ё'}, {'І', 'І'}, {'і', 'і'}, {'Ў', 'Ў'}, {'ў', 'ў'}}, cyrillicCharsets},
+	"bg": {[]letterRange{{'А', 'я'}}, cyrillicCharsets},
+	"sr": {[]letterRange{{'А', 'я'}, {'Ђ', 'Ђ'}, {'Ј', 'Ћ'}, {'Џ', 'Џ'}, {'ђ', 'ђ'}, {'ј', 'ћ'}, {'џ', 'џ'}}, cyrillicCharsets},
+	"el": {[]letterRange{{'Ά', 'Ά'}, {'Έ', 'Ί'}, {'Ό', 'Ό'}, {'Ύ', 'ώ'}}, []string{CharsetCP1253, CharsetISO88597}},
+	// Hiragana and katakana.
+	"ja": {append([]letterRange{{0x3040, 0x30ff}}, cjkLetters...), []string{CharsetShiftJIS}},
+	// Simplified and traditional Chinese are separate, since the text in GBK
+	// often decodes from Big5 into valid ideographs and vice versa.
+	"zh":    {cjkLetters, []string{CharsetGBK}},
+	"zh-tw": {cjkLetters, []string{CharsetBig5}},
+	// Hangul syllables and jamo.
+	"ko": {append([]letterRange{{0x1100, 0x11ff}, {0x3130, 0x318f}, {0xac00, 0xd7af}}, cjkLetters...), []string{CharsetEUCKR}},
+}
+
+// Get the charsets used for the languages, in the order of the languages.
+// The UTF-8 is always used for the frames with incorrect encoding field.
+func languageCharsets(langs []string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, lang := range langs {
+		for _, cs := range languages[lang].charsets {
+			if !seen[cs] {
+				seen[cs] = true
+				out = append(out, cs)
+			}
+		}
+	}
+	return append(out, CharsetUTF8)
+}
+
+// DefaultLanguages are used if no languages are given.  The slice is a copy.
+func DefaultLanguages() []string {
+	return []string{"ru"}
+}
+
+// The alphabet is the set of letters accepted in the converted text.
+type alphabet []letterRange
+
+// The alphabets of the languages.  The text is scored with every alphabet,
+// so that a text mixing the letters of different languages is not correct.
+type alphabets []alphabet
+
+// Get the alphabets of the given languages.
+func newAlphabets(langs []string) (alphabets, error) {
+	var out alphabets
+	for _, lang := range langs {
+		l, ok := languages[lang]
+		if !ok {
+			return nil, fmt.Errorf("unknown language %q", lang)
+		}
+		out = append(out, l.letters)
+	}
+	return out, nil
+}
+
+// The alphabets of all supported languages.
+func allLanguages() alphabets {
+	var out alphabets
+	for _, l := range languages {
+		out = append(out, l.letters)
+	}
+	return out
+}
+
+// Goodness is the best goodness of the string among the alphabets.
+func (as alphabets) Goodness(s string) float64 {
+	best := 0.0
+	for _, a := range as {
+		if g := a.goodness(s); g > best {
+			best = g
+		}
+	}
+	return best
+}
+
+// Check whether the alphabet contains the letter.
+func (a alphabet) contains(c rune) bool {
+	for _, r := range a {
+		if r.lo <= c && c <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// The function counts the ratio of the correct UTF8 characters of the alphabet to the string length, in range [0..1].
+// ASCII characters are always correct.
+// For empty string it returns 1.
+// If the input is not UTF8, it returns 0.
+func (a alphabet) goodness(s string) float64 {
+	// Check that the input is UTF8.
+	if _, _, err := encoding.UTF8Validator.Transform([]byte(s), []byte(s), true); err != nil {
+		return 0
+	}
+	bad := 0
+	total := 0
+	for _, c := range s {
+		total++
+		if c > 0x7f && !a.contains(c) {
+			bad++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(total-bad) / float64(total)
+}
@@ -0,0 +1,190 @@
+package fixtag
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lookup finds out whether the text is a known name of the kind, e.g. of an
+// artist in MusicBrainz, to resolve the ambiguous conversions, see Options.
+type Lookup interface {
+	Known(kind, text string) (bool, error)
+}
+
+// The kinds of the names looked up.
+const (
+	LookupArtist    = "artist"
+	LookupRelease   = "release"
+	LookupRecording = "recording"
+)
+
+// The kinds of the names by the common values of the tag, see TagInfo.
+var lookupKinds = map[string]string{
+	"artist":      LookupArtist,
+	"albumartist": LookupArtist,
+	"album":       LookupRelease,
+	"title":       LookupRecording,
+}
+
+// The number of the best conversions looked up for the field not converted.
+const lookupAttempts = 3
+
+// Get the kind of the name in the frame of the file, empty if it is not a name.
+func lookupKind(path, key string) string {
+	return lookupKinds[infoName(path, key)]
+}
+
+// Resolve the ambiguous fields and the ones not converted with the lookup:
+// the only candidate found becomes the winner.  After an error, the rest
+// of the fields are not looked up.
+func (f *Fixer) lookupFields(res *Result) {
+	for _, fi := range res.Frames {
+		kind := lookupKind(res.File, fi.Key)
+		if kind == "" {
+			continue
+		}
+		for _, field := range fi.Fields {
+			if field.Field != FieldText {
+				continue
+			}
+			var cands []*Candidate
+			switch field.Action {
+			case ActionAmbiguous:
+				cands = field.Candidates
+			case ActionFailed:
+				for i := range field.Attempts {
+					if field.Attempts[i].Err == nil && strings.TrimSpace(field.Attempts[i].Text) != "" {
+						cands = append(cands, &field.Attempts[i].Candidate)
+					}
+				}
+				sort.SliceStable(cands, func(i, j int) bool { return cands[i].Goodness > cands[j].Goodness })
+				if len(cands) > lookupAttempts {
+					cands = cands[:lookupAttempts]
+				}
+			default:
+				continue
+			}
+			var known *Candidate
+			for _, c := range cands {
+				ok, err := f.opts.Lookup.Known(kind, c.Text)
+				if err != nil {
+					f.log.Printf(slog.LevelWarn, "cannot look up %q: %v", c.Text, err)
+					return
+				}
+				if !ok {
+					continue
+				}
+				if known != nil {
+					known = nil
+					break
+				}
+				known = c
+			}
+			if known != nil {
+				field.Winner = known
+				field.Action = ActionConverted
+				f.log.Printf(slog.LevelInfo, "frame %q resolved by the lookup of the %s: %q", field.Name(), kind, known.Text)
+			}
+		}
+	}
+}
+
+// The MusicBrainz search, see NewMusicBrainz.
+type musicBrainz struct {
+	client    *http.Client
+	base      string
+	userAgent string
+
+	mu    sync.Mutex // the requests are made one by one, see Known
+	last  time.Time
+	cache map[string]bool
+}
+
+// The default address of the MusicBrainz web service.
+const musicBrainzURL = "https://musicbrainz.org/ws/2"
+
+// The minimal score of the search result, in range [0, 100].
+const musicBrainzScore = 90
+
+// NewMusicBrainz makes the lookup of the names in MusicBrainz.  The user agent
+// identifies the application, as the service requires.  The requests are made
+// at most once a second, and the results are cached.
+func NewMusicBrainz(userAgent string) Lookup {
+	return &musicBrainz{
+		client:    &http.Client{Timeout: 30 * time.Second},
+		base:      musicBrainzURL,
+		userAgent: userAgent,
+		cache:     make(map[string]bool),
+	}
+}
+
+// The entities of the search results, the artists have the names,
+// the releases and the recordings have the titles.
+type musicBrainzResults struct {
+	Artists    []musicBrainzEntity `json:"artists"`
+	Releases   []musicBrainzEntity `json:"releases"`
+	Recordings []musicBrainzEntity `json:"recordings"`
+}
+
+type musicBrainzEntity struct {
+	Name  string `json:"name"`
+	Title string `json:"title"`
+	Score int    `json:"score"`
+}
+
+func (m *musicBrainz) Known(kind, text string) (bool, error) {
+	text = strings.TrimSpace(text)
+	key := kind + "\x00" + text
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if known, ok := m.cache[key]; ok {
+		return known, nil
+	}
+	if wait := time.Second - time.Since(m.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	m.last = time.Now()
+
+	// The phrase query, only the quotes and the backslashes are special.
+	phrase := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+	q := url.Values{"query": {kind + `:"` + phrase + `"`}, "fmt": {"json"}, "limit": {"10"}}
+	req, err := http.NewRequest(http.MethodGet, m.base+"/"+kind+"?"+q.Encode(), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", m.userAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("MusicBrainz: %s", resp.Status)
+	}
+	var results musicBrainzResults
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return false, fmt.Errorf("MusicBrainz: %v", err)
+	}
+	known := false
+	for _, list := range [][]musicBrainzEntity{results.Artists, results.Releases, results.Recordings} {
+		for _, e := range list {
+			name := e.Name
+			if name == "" {
+				name = e.Title
+			}
+			if e.Score >= musicBrainzScore && strings.EqualFold(strings.TrimSpace(name), text) {
+				known = true
+			}
+		}
+	}
+	m.cache[key] = known
+	return known, nil
+}
@@ -0,0 +1,418 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The MP4 files, e.g. AAC, with the iTunes tag in the moov/udta/meta/ilst atom.
+// Every item of the ilst, e.g. "©nam", has the value in the "data" atoms.
+type mp4Backend struct{}
+
+// The type of the "data" atom with the UTF-8 text.
+const mp4UTF8 = 1
+
+var (
+	errNotMP4  = errors.New("not an MP4 file")
+	errBadAtom = errors.New("malformed MP4 atom")
+	errNoIlst  = errors.New("no iTunes tag (ilst) in the file")
+)
+
+// An MP4 atom within a byte slice.
+type mp4Atom struct {
+	typ     string
+	start   int // the offset of the header
+	payload int // the offset of the payload
+	end     int
+}
+
+// Split the byte slice into the atoms.
+func splitAtoms(b []byte) ([]mp4Atom, error) {
+	var out []mp4Atom
+	for off := 0; off < len(b); {
+		if len(b)-off < 8 {
+			return nil, errBadAtom
+		}
+		a := mp4Atom{typ: string(b[off+4 : off+8]), start: off, payload: off + 8}
+		size := uint64(binary.BigEndian.Uint32(b[off:]))
+		switch size {
+		case 0:
+			size = uint64(len(b) - off)
+		case 1:
+			if len(b)-off < 16 {
+				return nil, errBadAtom
+			}
+			size = binary.BigEndian.Uint64(b[off+8:])
+			a.payload += 8
+		}
+		if size < uint64(a.payload-off) || size > uint64(len(b)-off) {
+			return nil, errBadAtom
+		}
+		a.end = off + int(size)
+		out = append(out, a)
+		off = a.end
+	}
+	return out, nil
+}
+
+// Make the atom of the type with the payload.
+func makeAtom(typ string, payload ...[]byte) []byte {
+	n := 8
+	for _, p := range payload {
+		n += len(p)
+	}
+	out := make([]byte, 8, n)
+	binary.BigEndian.PutUint32(out, uint32(n))
+	copy(out[4:], typ)
+	for _, p := range payload {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// Find the first child atom of the type.
+func findAtom(b []byte, typ string) (mp4Atom, bool, error) {
+	atoms, err := splitAtoms(b)
+	if err != nil {
+		return mp4Atom{}, false, err
+	}
+	for _, a := range atoms {
+		if a.typ == typ {
+			return a, true, nil
+		}
+	}
+	return mp4Atom{}, false, nil
+}
+
+// The offset of the children of the meta atom, which is a full atom with
+// the version and the flags in MP4, but not in the QuickTime files.
+func metaChildren(payload []byte) int {
+	if len(payload) >= 8 && string(payload[4:8]) == "hdlr" {
+		return 0
+	}
+	return 4
+}
+
+// The top level atoms of the file.
+type mp4File struct {
+	atoms []mp4Atom // the offsets are in the file
+	moov  int       // the index of the moov atom
+	data  []byte    // the moov atom
+}
+
+// Read the top level atoms of the file, and the moov atom.
+func readMP4(f *os.File) (*mp4File, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	mf := &mp4File{moov: -1}
+	header := make([]byte, 16)
+	for off := int64(0); off < st.Size(); {
+		n, _ := f.ReadAt(header, off)
+		if n < 8 {
+			if len(mf.atoms) == 0 {
+				return nil, errNotMP4
+			}
+			return nil, errBadAtom
+		}
+		a := mp4Atom{typ: string(header[4:8]), start: int(off), payload: int(off) + 8}
+		size := int64(binary.BigEndian.Uint32(header))
+		switch size {
+		case 0:
+			size = st.Size() - off
+		case 1:
+			if n < 16 {
+				return nil, errBadAtom
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			a.payload += 8
+		}
+		if size < int64(a.payload-a.start) || size > st.Size()-off {
+			if len(mf.atoms) == 0 {
+				return nil, errNotMP4
+			}
+			return nil, errBadAtom
+		}
+		a.end = int(off + size)
+		if a.typ == "moov" {
+			mf.moov = len(mf.atoms)
+			mf.data = make([]byte, size)
+			if _, err := f.ReadAt(mf.data, off); err != nil && err != io.EOF {
+				return nil, err
+			}
+		}
+		mf.atoms = append(mf.atoms, a)
+		off += size
+	}
+	if len(mf.atoms) == 0 || mf.atoms[0].typ != "ftyp" || mf.moov < 0 {
+		return nil, errNotMP4
+	}
+	return mf, nil
+}
+
+// Find the ilst atom within the moov atom, returning the chain of the atoms
+// from the moov down to the ilst.  The offsets are in the moov atom.
+func findIlst(moov []byte) ([]mp4Atom, error) {
+	top, err := splitAtoms(moov)
+	if err != nil || len(top) != 1 {
+		return nil, errBadAtom
+	}
+	path := []mp4Atom{top[0]}
+	for _, typ := range []string{"udta", "meta", "ilst"} {
+		parent := path[len(path)-1]
+		start := parent.payload
+		if parent.typ == "meta" {
+			start += metaChildren(moov[parent.payload:parent.end])
+		}
+		if start > parent.end {
+			// The meta atom is too short for the version and the flags.
+			return nil, errBadAtom
+		}
+		a, ok, err := findAtom(moov[start:parent.end], typ)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, errNoIlst
+		}
+		a.start += start
+		a.payload += start
+		a.end += start
+		path = append(path, a)
+	}
+	return path, nil
+}
+
+// Get the name of the item, the atom type being in Latin-1, e.g. "©nam".
+func mp4Name(typ string) string {
+	r := make([]rune, len(typ))
+	for i := 0; i < len(typ); i++ {
+		r[i] = rune(typ[i])
+	}
+	return string(r)
+}
+
+// Get the atom type of the item name, see mp4Name.
+func mp4Type(name string) (string, error) {
+	var b []byte
+	for _, c := range name {
+		if c > 0xff {
+			return "", fmt.Errorf("invalid MP4 item %q", name)
+		}
+		b = append(b, byte(c))
+	}
+	if len(b) != 4 {
+		return "", fmt.Errorf("invalid MP4 item %q", name)
+	}
+	return string(b), nil
+}
+
+// Get the text of the data atom, if it is UTF-8.
+func mp4Text(data []byte) (string, bool) {
+	if len(data) < 8 || binary.BigEndian.Uint32(data) != mp4UTF8 {
+		return "", false
+	}
+	return string(data[8:]), true
+}
+
+func (mp4Backend) Name() string {
+	return "mp4"
+}
+
+func (mp4Backend) Extensions() []string {
+	return []string{".m4a", ".m4b", ".mp4", ".m4v"}
+}
+
+func (mp4Backend) ReadFields(f *os.File) ([]TagField, error) {
+	mf, err := readMP4(f)
+	if err != nil {
+		return nil, err
+	}
+	path, err := findIlst(mf.data)
+	if err == errNoIlst {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ilst := path[len(path)-1]
+	items, err := splitAtoms(mf.data[ilst.payload:ilst.end])
+	if err != nil {
+		return nil, err
+	}
+	var fields []TagField
+	for _, item := range items {
+		payload := mf.data[ilst.payload+item.payload : ilst.payload+item.end]
+		children, err := splitAtoms(payload)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range children {
+			if c.typ != "data" {
+				continue
+			}
+			if text, ok := mp4Text(payload[c.payload:c.end]); ok {
+				fields = append(fields, TagField{Name: mp4Name(item.typ), Value: text})
+			}
+		}
+	}
+	return fields, nil
+}
+
+// Make the ilst with the text items replaced by the fields.
+func makeIlst(ilst []byte, fields []TagField) ([]byte, error) {
+	values := make(map[string][]string)
+	var names []string
+	for _, f := range fields {
+		if _, ok := values[f.Name]; !ok {
+			names = append(names, f.Name)
+		}
+		values[f.Name] = append(values[f.Name], f.Value)
+	}
+	dataAtom := func(text string) []byte {
+		return makeAtom("data", []byte{0, 0, 0, mp4UTF8, 0, 0, 0, 0}, []byte(text))
+	}
+	items, err := splitAtoms(ilst)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	seen := make(map[string]bool)
+	for _, item := range items {
+		name := mp4Name(item.typ)
+		seen[name] = true
+		payload := ilst[item.payload:item.end]
+		children, err := splitAtoms(payload)
+		if err != nil {
+			return nil, err
+		}
+		// The text data atoms are replaced in order, the rest is kept.
+		var parts [][]byte
+		for _, c := range children {
+			if _, ok := mp4Text(payload[c.payload:c.end]); c.typ == "data" && ok {
+				if len(values[name]) > 0 {
+					parts = append(parts, dataAtom(values[name][0]))
+					values[name] = values[name][1:]
+				}
+				continue
+			}
+			parts = append(parts, payload[c.start:c.end])
+		}
+		for _, text := range values[name] {
+			parts = append(parts, dataAtom(text))
+		}
+		values[name] = nil
+		if len(parts) > 0 {
+			out.Write(makeAtom(item.typ, parts...))
+		}
+	}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		typ, err := mp4Type(name)
+		if err != nil {
+			return nil, err
+		}
+		var parts [][]byte
+		for _, text := range values[name] {
+			parts = append(parts, dataAtom(text))
+		}
+		out.Write(makeAtom(typ, parts...))
+	}
+	return out.Bytes(), nil
+}
+
+// Shift the chunk offsets of all tracks in the moov atom by delta,
+// as the audio data moves when the moov atom before it changes the size.
+func shiftChunkOffsets(b []byte, delta int64) error {
+	atoms, err := splitAtoms(b)
+	if err != nil {
+		return err
+	}
+	for _, a := range atoms {
+		payload := b[a.payload:a.end]
+		switch a.typ {
+		case "moov", "trak", "mdia", "minf", "stbl":
+			if err := shiftChunkOffsets(payload, delta); err != nil {
+				return err
+			}
+		case "stco", "co64":
+			width := 4
+			if a.typ == "co64" {
+				width = 8
+			}
+			if len(payload) < 8 {
+				return errBadAtom
+			}
+			n := int(binary.BigEndian.Uint32(payload[4:]))
+			if n > (len(payload)-8)/width {
+				return errBadAtom
+			}
+			for i := 0; i < n; i++ {
+				p := payload[8+i*width:]
+				if width == 4 {
+					v := int64(binary.BigEndian.Uint32(p)) + delta
+					if v < 0 || v > 0xffffffff {
+						return errors.New("chunk offset out of range")
+					}
+					binary.BigEndian.PutUint32(p, uint32(v))
+				} else {
+					binary.BigEndian.PutUint64(p, uint64(int64(binary.BigEndian.Uint64(p))+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (mp4Backend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	mf, err := readMP4(f)
+	if err != nil {
+		return err
+	}
+	path, err := findIlst(mf.data)
+	if err != nil {
+		return err
+	}
+	// Rebuild the atoms from the ilst up to the moov, keeping the siblings.
+	last := path[len(path)-1]
+	items, err := makeIlst(mf.data[last.payload:last.end], fields)
+	if err != nil {
+		return err
+	}
+	moov := makeAtom("ilst", items)
+	for i := len(path) - 2; i >= 0; i-- {
+		parent, child := path[i], path[i+1]
+		moov = makeAtom(parent.typ, mf.data[parent.payload:child.start], moov, mf.data[child.end:parent.end])
+	}
+	delta := int64(len(moov) - len(mf.data))
+	if delta != 0 {
+		// Only the audio data after the moov atom moves.
+		for _, a := range mf.atoms[mf.moov+1:] {
+			if a.typ == "mdat" {
+				if err := shiftChunkOffsets(moov, delta); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	for i, a := range mf.atoms {
+		if i == mf.moov {
+			if _, err := w.Write(moov); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(f, int64(a.start), int64(a.end-a.start))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
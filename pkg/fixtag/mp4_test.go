@@ -0,0 +1,72 @@
+package fixtag
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write the atoms into a new MP4 file.
+func writeMP4(t *testing.T, atoms ...[]byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.m4a")
+	var data []byte
+	for _, a := range atoms {
+		data = append(data, a...)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMP4Malformed(t *testing.T) {
+	ftyp := makeAtom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	tests := []struct {
+		name string
+		moov []byte
+	}{
+		{"meta without payload", makeAtom("moov", makeAtom("udta", makeAtom("meta")))},
+		{"meta with short payload", makeAtom("moov", makeAtom("udta", makeAtom("meta", []byte{0, 0})))},
+		{"truncated child", makeAtom("moov", makeAtom("udta", []byte{0, 0, 0, 99, 'm', 'e', 't', 'a'}))},
+		{"short header", makeAtom("moov", makeAtom("udta", []byte{0, 0, 0}))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMP4(t, ftyp, tt.moov)
+			if _, err := readFields(mp4Backend{}, path); err != errBadAtom {
+				t.Errorf("ReadFields() error = %v, want %v", err, errBadAtom)
+			}
+		})
+	}
+}
+
+func TestMP4NotMP4(t *testing.T) {
+	path := writeMP4(t, []byte("ID3\x04\x00\x00\x00\x00\x00\x00"))
+	if _, err := readFields(mp4Backend{}, path); err != errNotMP4 {
+		t.Errorf("ReadFields() error = %v, want %v", err, errNotMP4)
+	}
+}
+
+func TestMP4RoundTrip(t *testing.T) {
+	ilst := makeAtom("ilst", makeAtom("\xa9nam", makeAtom("data", []byte{0, 0, 0, mp4UTF8, 0, 0, 0, 0}, []byte("Ãðóïïà êðîâè"))))
+	moov := makeAtom("moov", makeAtom("udta", makeAtom("meta", []byte{0, 0, 0, 0}, ilst)))
+	path := writeMP4(t, makeAtom("ftyp", []byte("M4A \x00\x00\x00\x00")), moov)
+	fields, err := readFields(mp4Backend{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].Name != "©nam" || fields[0].Value != "Ãðóïïà êðîâè" {
+		t.Fatalf("ReadFields() = %+v", fields)
+	}
+	if err := writeFields(mp4Backend{}, path, []TagField{{Name: "©nam", Value: "Группа крови"}}, saveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	fields, err = readFields(mp4Backend{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 1 || fields[0].Value != "Группа крови" {
+		t.Errorf("ReadFields() after write = %+v", fields)
+	}
+}
@@ -0,0 +1,35 @@
+package fixtag
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// The pseudo field of the file name in the results, see FixName.
+const nameField = "NAME"
+
+// FixName converts the broken name of the file or the directory, e.g.
+// "Êèíî.mp3", the raw bytes of cp1251 or the double encoded UTF-8, the same way
+// as the text fields.  The extension is kept as is.  The converted name
+// is empty if the name was not converted, and the field is nil if the name
+// needs no conversion.
+func (f *Fixer) FixName(name string) (string, *Field) {
+	ext := filepath.Ext(name)
+	if ext == name || strings.ContainsFunc(ext, func(c rune) bool { return c >= 0x80 }) {
+		ext = ""
+	}
+	frames, _ := f.extractFields([]TagField{{Name: nameField, Value: strings.TrimSuffix(name, ext)}})
+	if len(frames) == 0 {
+		return "", nil
+	}
+	f.convertFrames(&Result{Frames: frames})
+	field := frames[0].Fields[0]
+	if field.Action != ActionConverted {
+		return "", field
+	}
+	fixed := field.Winner.Text + ext
+	if fixed == name || strings.ContainsAny(fixed, "/\x00") {
+		return "", field
+	}
+	return fixed, field
+}
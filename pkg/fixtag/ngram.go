@@ -0,0 +1,157 @@
+package fixtag
+
+import (
+	_ "embed" // for the trigram counts
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed ru-trigrams.txt
+var ruTrigramCounts string
+
+//go:embed uk-trigrams.txt
+var ukTrigramCounts string
+
+// The languages with the n-gram model, to rank the candidates with the same goodness.
+var languageModels = map[string]*ngrams{
+	"ru": mustNgrams(ruTrigramCounts),
+	"uk": mustNgrams(ukTrigramCounts),
+}
+
+// The word boundary in the n-grams.
+const wordBoundary = '_'
+
+// The minimal difference of the scores for one candidate to be better than
+// the other, so that the texts which are equally plausible stay ambiguous.
+const scoreMargin = 1.0
+
+// The n-gram model of a language: the log probabilities of the sequences
+// of n letters in the words, the word boundary included.
+type ngrams struct {
+	n       int
+	logp    map[string]float64
+	letters map[rune]bool
+	unknown float64 // the log probability of the n-gram never seen
+}
+
+// Parse the counts of the n-grams, one "xyz count" per line; the lines
+// starting with "#" are skipped.  All n-grams must be of the same length.
+func newNgrams(data string) (*ngrams, error) {
+	counts := make(map[string]int)
+	total, n := 0, 0
+	for i, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var count int
+		var err error
+		if len(fields) == 2 {
+			count, err = strconv.Atoi(fields[1])
+		}
+		if len(fields) != 2 || err != nil || count <= 0 {
+			return nil, fmt.Errorf("line %d: must be \"letters count\"", i+1)
+		}
+		gram := strings.ToLower(fields[0])
+		if l := utf8.RuneCountInString(gram); n == 0 {
+			n = l
+		} else if l != n {
+			return nil, fmt.Errorf("line %d: %q is not of %d letters", i+1, fields[0], n)
+		}
+		counts[gram] += count
+		total += count
+	}
+	if total == 0 {
+		return nil, errors.New("no n-grams")
+	}
+	m := &ngrams{
+		n:       n,
+		logp:    make(map[string]float64),
+		letters: make(map[rune]bool),
+		unknown: math.Log(0.5 / float64(total)),
+	}
+	for gram, count := range counts {
+		m.logp[gram] = math.Log(float64(count) / float64(total))
+		for _, c := range gram {
+			if c != wordBoundary {
+				m.letters[c] = true
+			}
+		}
+	}
+	return m, nil
+}
+
+// Parse the built-in counts.
+func mustNgrams(data string) *ngrams {
+	m, err := newNgrams(data)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Get the average log probability of the n-grams of the text, and the
+// number of the n-grams.  Only the words made of the letters of the model
+// count.
+func (m *ngrams) score(s string) (float64, int) {
+	sum, n := 0.0, 0
+	words := strings.FieldsFunc(strings.ToLower(s), func(c rune) bool { return !unicode.IsLetter(c) })
+	for _, w := range words {
+		known := true
+		for _, c := range w {
+			if !m.letters[c] {
+				known = false
+				break
+			}
+		}
+		if !known {
+			continue
+		}
+		runes := []rune(string(wordBoundary) + w + string(wordBoundary))
+		for i := 0; i+m.n <= len(runes); i++ {
+			if p, ok := m.logp[string(runes[i:i+m.n])]; ok {
+				sum += p
+			} else {
+				sum += m.unknown
+			}
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, 0
+	}
+	return sum / float64(n), n
+}
+
+// Get the n-gram models of the given languages, then the ones of the user.
+func newModels(langs []string, custom []string) ([]*ngrams, error) {
+	var out []*ngrams
+	for _, lang := range langs {
+		if m, ok := languageModels[lang]; ok {
+			out = append(out, m)
+		}
+	}
+	for i, data := range custom {
+		m, err := newNgrams(data)
+		if err != nil {
+			return nil, fmt.Errorf("model %d: %v", i+1, err)
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// Score the candidate with the best of the models, if any applies to the text.
+func (f *Fixer) score(c *Candidate) {
+	for _, m := range f.models {
+		if s, n := m.score(c.Text); n > 0 && (!c.scored || s > c.Score) {
+			c.Score = s
+			c.scored = true
+		}
+	}
+}
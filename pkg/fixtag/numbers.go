@@ -0,0 +1,148 @@
+package fixtag
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+
+	"github.com/bogem/id3v2"
+)
+
+// The ways to write the track numbers, see Options.
+const (
+	NumbersPad   = "pad"   // with two digits at least, e.g. "03/12"
+	NumbersStrip = "strip" // without the leading zeros, e.g. "3/12"
+)
+
+// The chain of the track and the disc numbers normalized, see Options.
+const ChainNumber = "number"
+
+// SourceNumber marks the disc number moved out of the track number.
+const SourceNumber = "number"
+
+// The keys of the track and the disc numbers by the names of the backends,
+// see tagInfoKeys.  The disc key is empty if the format has none.
+var numberKeys = map[string][2]string{
+	"":     {"TRCK", "TPOS"},
+	"flac": {"TRACKNUMBER", "DISCNUMBER"},
+	"ogg":  {"TRACKNUMBER", "DISCNUMBER"},
+	"ape":  {"Track", "Disc"},
+	"wav":  {"ITRK", ""},
+}
+
+var (
+	// The number with the total, e.g. "03/12", "3\12", "3 of 12", maybe
+	// after the disc, e.g. "CD2/03".
+	numberRe = regexp.MustCompile(`(?i)^\s*(?:(?:cd|disc|disk)\s*(\d+)\s*[-./ ]\s*)?(\d+)\s*(?:(?:/|\\|\||of|из)\s*(\d*))?\s*$`)
+	// The disc and the track number, e.g. "1-03" or "2.11".
+	discTrackRe = regexp.MustCompile(`^\s*(\d)[-.](\d{2,})\s*$`)
+)
+
+// Parse the track or the disc number, with the total and the disc if any.
+// The numbers are zero if the text is not a number.
+func parseNumber(text string) (num, total, disc int) {
+	if m := discTrackRe.FindStringSubmatch(text); m != nil {
+		disc, _ = strconv.Atoi(m[1])
+		num, _ = strconv.Atoi(m[2])
+		return num, 0, disc
+	}
+	m := numberRe.FindStringSubmatch(text)
+	if m == nil {
+		return 0, 0, 0
+	}
+	disc, _ = strconv.Atoi(m[1])
+	num, _ = strconv.Atoi(m[2])
+	total, _ = strconv.Atoi(m[3])
+	return num, total, disc
+}
+
+// Format the number with the total, if any.
+func formatNumber(num, total int, pad bool) string {
+	format := "%d"
+	if pad {
+		format = "%02d"
+	}
+	if total == 0 {
+		return fmt.Sprintf(format, num)
+	}
+	return fmt.Sprintf(format+"/"+format, num, total)
+}
+
+// Normalize the track and the disc numbers of the result, if set in the
+// options: the track numbers are written with or without the leading zeros,
+// the disc numbers always without them, with "/" before the total, and the
+// disc found in the track number is moved into the empty disc frame.  The
+// current frames are the first ones with the keys of the numbers.
+func (f *Fixer) numberFrames(res *Result, name string, current map[string]TextFrame) {
+	if f.opts.Numbers == "" {
+		return
+	}
+	keys, ok := numberKeys[name]
+	if !ok {
+		return
+	}
+	moved := 0
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		var field *Field
+		for _, fi := range res.Frames {
+			if fi.Key == key && fi.Index == 0 && len(fi.Fields) > 0 && fi.Fields[0].Field == FieldText {
+				field = fi.Fields[0]
+			}
+		}
+		tf, exists := current[key]
+		text := tf.Text
+		switch {
+		case field != nil && field.Action != ActionConverted:
+			continue
+		case field != nil:
+			text = field.Winner.Text
+		case !exists && i == 1 && moved > 0:
+			// The disc moved out of the track number.
+			res.Frames = append(res.Frames, &Frame{
+				Key:    key,
+				Orig:   TextFrame{Encoding: id3v2.EncodingUTF8},
+				Source: SourceNumber,
+				Fields: []*Field{{
+					Key:    key,
+					Field:  FieldText,
+					Winner: &Candidate{Chain: ChainNumber, Charset: "utf-8", Text: strconv.Itoa(moved), Goodness: 1},
+					Action: ActionConverted,
+				}},
+			})
+			f.log.Printf(slog.LevelInfo, "frame %q set to the disc of the track number: %d", key, moved)
+			continue
+		}
+		num, total, disc := parseNumber(text)
+		if num == 0 {
+			continue
+		}
+		if i == 0 && keys[1] != "" {
+			moved = disc
+		}
+		norm := formatNumber(num, total, i == 0 && f.opts.Numbers == NumbersPad)
+		if field != nil {
+			field.Winner.Text = norm
+			continue
+		}
+		if norm == text {
+			continue
+		}
+		f.log.Printf(slog.LevelInfo, "frame %q normalized: %q", key, norm)
+		res.Frames = append(res.Frames, &Frame{
+			Key:  key,
+			Orig: tf,
+			Fields: []*Field{{
+				Key:    key,
+				Field:  FieldText,
+				Orig:   text,
+				Winner: &Candidate{Chain: ChainNumber, Charset: "utf-8", Text: norm, Goodness: 1},
+				Action: ActionConverted,
+			}},
+		})
+		res.Correct--
+	}
+}
@@ -0,0 +1,300 @@
+package fixtag
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// The Ogg files with Vorbis or Opus, with the tag in the comment header,
+// which is the second packet of the stream.  For Vorbis it is followed by
+// the setup header, which may share the pages with it.
+type oggBackend struct{}
+
+// The header packets of the Ogg codecs.
+type oggCodec struct {
+	id      string // the prefix of the identification header
+	comment string // the prefix of the comment header
+	headers int    // the number of the header packets after the identification one
+}
+
+var oggCodecs = []oggCodec{
+	{id: "\x01vorbis", comment: "\x03vorbis", headers: 2},
+	{id: "OpusHead", comment: "OpusTags", headers: 1},
+}
+
+// The flags of the Ogg page.
+const (
+	oggContinued = 1
+	oggFirst     = 2
+)
+
+// The maximal number of the segments of the Ogg page.
+const oggMaxSegments = 255
+
+var errNotOgg = errors.New("not an Ogg Vorbis or Opus file")
+
+// A page of the Ogg stream.
+type oggPage struct {
+	flags    byte
+	granule  uint64
+	serial   uint32
+	seq      uint32
+	segments []byte
+	data     []byte
+}
+
+var oggCRCTable = func() (t [256]uint32) {
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// Compute the checksum of the page, with the checksum field zeroed.
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+// Read the next page, io.EOF if there are no more pages.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated Ogg page")
+		}
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return nil, errors.New("malformed Ogg page")
+	}
+	p := &oggPage{
+		flags:    header[5],
+		granule:  binary.LittleEndian.Uint64(header[6:]),
+		serial:   binary.LittleEndian.Uint32(header[14:]),
+		seq:      binary.LittleEndian.Uint32(header[18:]),
+		segments: make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return nil, errors.New("truncated Ogg page")
+	}
+	n := 0
+	for _, s := range p.segments {
+		n += int(s)
+	}
+	p.data = make([]byte, n)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, errors.New("truncated Ogg page")
+	}
+	return p, nil
+}
+
+// Get the page as written into the file, with the checksum.
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(b, "OggS")
+	b[5] = p.flags
+	binary.LittleEndian.PutUint64(b[6:], p.granule)
+	binary.LittleEndian.PutUint32(b[14:], p.serial)
+	binary.LittleEndian.PutUint32(b[18:], p.seq)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+	return b
+}
+
+// Split the packets into the pages, starting with the given sequence number.
+// The last page ends with the last packet.
+func oggPages(serial, seq uint32, packets [][]byte) []*oggPage {
+	var out []*oggPage
+	p := &oggPage{serial: serial, seq: seq, granule: ^uint64(0)}
+	for _, packet := range packets {
+		for off := 0; ; off += 255 {
+			if len(p.segments) == oggMaxSegments {
+				out = append(out, p)
+				seq++
+				p = &oggPage{serial: serial, seq: seq, granule: ^uint64(0)}
+				if off > 0 {
+					p.flags = oggContinued
+				}
+			}
+			n := len(packet) - off
+			if n >= 255 {
+				n = 255
+			}
+			p.segments = append(p.segments, byte(n))
+			p.data = append(p.data, packet[off:off+n]...)
+			if n < 255 {
+				// The header packets have the zero granule position.
+				p.granule = 0
+				break
+			}
+		}
+	}
+	return append(out, p)
+}
+
+// The header of the Ogg file.
+type oggFile struct {
+	codec   oggCodec
+	serial  uint32
+	packets [][]byte // the header packets after the identification one
+	pages   uint32   // the number of the header pages
+	first   int64    // the size of the first page
+	audio   int64    // the offset of the first page after the headers
+}
+
+// Read the header packets of the Ogg file.
+func readOgg(f *os.File) (*oggFile, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(io.NewSectionReader(f, 0, st.Size()))
+	first, err := readOggPage(r)
+	if err != nil || first.flags&oggFirst == 0 {
+		return nil, errNotOgg
+	}
+	of := &oggFile{serial: first.serial, pages: 1, first: int64(len(first.bytes()))}
+	of.audio = of.first
+	found := false
+	for _, c := range oggCodecs {
+		if bytes.HasPrefix(first.data, []byte(c.id)) {
+			of.codec, found = c, true
+		}
+	}
+	if !found {
+		return nil, errNotOgg
+	}
+	if len(first.segments) == 0 || first.segments[len(first.segments)-1] == 255 {
+		return nil, errors.New("malformed Ogg identification header")
+	}
+	var packet []byte
+	for len(of.packets) < of.codec.headers {
+		p, err := readOggPage(r)
+		if err == io.EOF {
+			return nil, errors.New("truncated Ogg headers")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if p.serial != of.serial {
+			return nil, errors.New("multiplexed Ogg streams are not supported")
+		}
+		of.pages++
+		of.audio += int64(len(p.bytes()))
+		off := 0
+		for _, s := range p.segments {
+			packet = append(packet, p.data[off:off+int(s)]...)
+			off += int(s)
+			if s < 255 {
+				of.packets = append(of.packets, packet)
+				packet = nil
+			}
+		}
+		if len(of.packets) > of.codec.headers || packet != nil && len(of.packets) == of.codec.headers {
+			return nil, errors.New("Ogg headers do not end the page")
+		}
+	}
+	if !bytes.HasPrefix(of.packets[0], []byte(of.codec.comment)) {
+		return nil, errors.New("malformed Ogg comment header")
+	}
+	return of, nil
+}
+
+// Parse the comment header packet into the vendor, the fields and the rest,
+// e.g. the framing bit of Vorbis.
+func (of *oggFile) comment() (string, []TagField, []byte, error) {
+	data := of.packets[0][len(of.codec.comment):]
+	vendor, fields, err := parseVorbisComment(data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	n := len(makeVorbisComment(vendor, fields))
+	return vendor, fields, data[n:], nil
+}
+
+func (oggBackend) Name() string {
+	return "ogg"
+}
+
+func (oggBackend) Extensions() []string {
+	return []string{".ogg", ".oga", ".opus"}
+}
+
+func (oggBackend) ReadFields(f *os.File) ([]TagField, error) {
+	of, err := readOgg(f)
+	if err != nil {
+		return nil, err
+	}
+	_, fields, _, err := of.comment()
+	return fields, err
+}
+
+func (oggBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	of, err := readOgg(f)
+	if err != nil {
+		return err
+	}
+	vendor, _, tail, err := of.comment()
+	if err != nil {
+		return err
+	}
+	packets := append([][]byte{nil}, of.packets[1:]...)
+	packets[0] = append([]byte(of.codec.comment), makeVorbisComment(vendor, fields)...)
+	packets[0] = append(packets[0], tail...)
+
+	// The identification header is kept, the other headers are repaginated.
+	if _, err := io.Copy(w, io.NewSectionReader(f, 0, of.first)); err != nil {
+		return err
+	}
+	pages := oggPages(of.serial, 1, packets)
+	for _, p := range pages {
+		if _, err := w.Write(p.bytes()); err != nil {
+			return err
+		}
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rest := io.NewSectionReader(f, of.audio, st.Size()-of.audio)
+	delta := uint32(len(pages)) - (of.pages - 1)
+	if delta == 0 {
+		_, err = io.Copy(w, rest)
+		return err
+	}
+	// The audio pages are renumbered, which changes their checksums.
+	r := bufio.NewReader(rest)
+	for {
+		p, err := readOggPage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.serial == of.serial {
+			p.seq += delta
+		}
+		if _, err := w.Write(p.bytes()); err != nil {
+			return err
+		}
+	}
+}
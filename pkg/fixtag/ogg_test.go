@@ -0,0 +1,134 @@
+package fixtag
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+const oggSerial = 0x1234
+
+// Make the Ogg Vorbis file of the identification header, the pages of the
+// other packets given and the audio page.
+func makeOgg(first byte, packets ...[]byte) []byte {
+	data := oggIdent(first, oggSerial).bytes()
+	pages := oggPages(oggSerial, 1, packets)
+	for _, p := range pages {
+		data = append(data, p.bytes()...)
+	}
+	return append(data, oggAudio(uint32(len(pages))+1).bytes()...)
+}
+
+// Make the page of the Vorbis identification header.
+func oggIdent(flags byte, serial uint32) *oggPage {
+	return &oggPage{flags: flags, serial: serial, segments: []byte{30}, data: append([]byte("\x01vorbis"), make([]byte, 23)...)}
+}
+
+// Make the audio page with the sequence number given.
+func oggAudio(seq uint32) *oggPage {
+	return &oggPage{serial: oggSerial, seq: seq, granule: 4096, segments: []byte{200}, data: bytes.Repeat([]byte{0x5a}, 200)}
+}
+
+// Make the Vorbis comment header with the fields given.
+func vorbisComment(fields ...TagField) []byte {
+	data := append([]byte("\x03vorbis"), makeVorbisComment("test", fields)...)
+	return append(data, 1)
+}
+
+var vorbisSetup = append([]byte("\x05vorbis"), make([]byte, 40)...)
+
+func TestOggMalformed(t *testing.T) {
+	title := TagField{Name: "TITLE", Value: "Ãðóïïà êðîâè"}
+	good := makeOgg(oggFirst, vorbisComment(title), vorbisSetup)
+	first := len(oggIdent(oggFirst, oggSerial).bytes())
+	// The second stream starts after the identification header.
+	multiplexed := append(append(append([]byte{}, good[:first]...), oggIdent(oggFirst, oggSerial+1).bytes()...), good[first:]...)
+	tests := []struct {
+		name string
+		data []byte
+		want error // nil for any error
+	}{
+		{"not ogg", []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), errNotOgg},
+		{"not first page", makeOgg(0, vorbisComment(title), vorbisSetup), errNotOgg},
+		{"unknown codec", append([]byte("OggS\x00\x02"), make([]byte, 21)...), errNotOgg},
+		{"truncated page", good[:first+40], nil},
+		{"truncated headers", makeOgg(oggFirst, vorbisComment(title))[:first+len(oggPages(oggSerial, 1, [][]byte{vorbisComment(title)})[0].bytes())], nil},
+		{"multiplexed", multiplexed, nil},
+		{"bad comment prefix", makeOgg(oggFirst, vorbisSetup, vorbisSetup), nil},
+		{"headers do not end the page", makeOgg(oggFirst, vorbisComment(title), vorbisSetup, []byte("audio")), nil},
+		{"bad comment", makeOgg(oggFirst, []byte("\x03vorbis\xff\x00\x00\x00"), vorbisSetup), errBadComment},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.ogg", tt.data)
+			_, err := readFields(oggBackend{}, path)
+			if err == nil || tt.want != nil && err != tt.want {
+				t.Errorf("ReadFields() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	path := writeFile(t, "test.ogg", good)
+	if fields, err := readFields(oggBackend{}, path); err != nil || len(fields) != 1 || fields[0] != title {
+		t.Errorf("ReadFields() of the good file = %+v, %v", fields, err)
+	}
+}
+
+func TestOggRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value string
+	}{
+		{"same pages", "Группа крови"},
+		// The comment takes more pages, and the audio pages are renumbered.
+		{"more pages", strings.Repeat("Группа крови ", 10000)},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "test.ogg", makeOgg(oggFirst, vorbisComment(TagField{Name: "TITLE", Value: "Ãðóïïà êðîâè"}), vorbisSetup))
+			fields := []TagField{{Name: "TITLE", Value: tt.value}}
+			if err := writeFields(oggBackend{}, path, fields, saveOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readFields(oggBackend{}, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != fields[0] {
+				t.Errorf("ReadFields() after write = %d fields", len(got))
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			of, err := readOgg(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(of.packets[1], vorbisSetup) {
+				t.Error("the setup header has changed")
+			}
+			// The pages are numbered in turn, the audio one is the last.
+			r := bufio.NewReader(f)
+			var last *oggPage
+			for seq := uint32(0); ; seq++ {
+				p, err := readOggPage(r)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if p.seq != seq {
+					t.Errorf("page %d has the number %d", seq, p.seq)
+				}
+				last = p
+			}
+			if want := oggAudio(last.seq); !bytes.Equal(last.bytes(), want.bytes()) {
+				t.Error("the audio page has changed")
+			}
+		})
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package fixtag
+
+import "os"
+
+// The ownership is not preserved on this system.
+func chown(f *os.File, orig os.FileInfo) error {
+	return nil
+}
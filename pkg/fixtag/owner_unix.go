@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package fixtag
+
+import (
+	"os"
+	"syscall"
+)
+
+// Set the owner of the file to that of the original one.
+// Only root may give the file away, so the permission error is ignored.
+func chown(f *os.File, orig os.FileInfo) error {
+	st, ok := orig.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if err := f.Chown(int(st.Uid), int(st.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package fixtag
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// The chain of the fields percent-decoded, e.g. copied from the URLs.
+const ChainURL = "url"
+
+// The percent-encoded byte.
+var percentRe = regexp.MustCompile(`%[0-9A-Fa-f]{2}`)
+
+// Check whether the text has the percent-encoded bytes beyond ASCII, two at
+// least, e.g. "%D0%9F%D1%80".
+func percentEncoded(text string) bool {
+	n := 0
+	for _, m := range percentRe.FindAllString(text, -1) {
+		if b, _ := strconv.ParseUint(m[1:], 16, 8); b >= 0x80 {
+			n++
+		}
+	}
+	return n >= 2
+}
+
+// The transformer decoding the percent-encoded bytes, keeping the percent
+// signs which do not start one, e.g. in "100%".
+type percentDecoder struct{}
+
+func (percentDecoder) String(s string) (string, error) {
+	return percentRe.ReplaceAllStringFunc(s, func(m string) string {
+		b, _ := strconv.ParseUint(m[1:], 16, 8)
+		return string([]byte{byte(b)})
+	}), nil
+}
+
+// Build the combinations decoding the percent-encoded text: the bytes are
+// either UTF-8, or in any charset decoded directly by the combinations.
+func newPercentCombinations(combinations []combination) []combination {
+	out := []combination{{ChainURL, CharsetUTF8, []StringTrans{percentDecoder{}}}}
+	for _, cmb := range combinations {
+		if len(cmb.tlist) == 1 && cmb.charset != CharsetUTF8 {
+			out = append(out, combination{ChainURL + "-" + cmb.name, cmb.charset, []StringTrans{percentDecoder{}, cmb.tlist[0]}})
+		}
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package fixtag
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// The playlists: M3U, M3U8 and PLS.  Every entry is a field: the "PATH"
+// of the file, the "EXTINF" title, the value of another directive, e.g.
+// "PLAYLIST", or the "File" and the "Title" of the PLS entry.  The playlist
+// is decoded from a single charset, and written back in UTF-8.
+type playlistBackend struct{}
+
+// The playlists are not media files, so they are not in the backends.
+var playlists = playlistBackend{}
+
+const utf8BOM = "\xef\xbb\xbf"
+
+// A line of the playlist or the CUE sheet.
+type playlistLine struct {
+	name   string // the field name, empty if the line is kept as is
+	prefix string // the text before the value, e.g. "#EXTINF:123,"
+	value  string
+	suffix string // the text after the value, e.g. the closing quote
+	end    string // the line end
+}
+
+// Split the text into the lines, the byte order mark is returned too.
+// The line is parsed by the function.
+func splitLines(data []byte, parse func(l *playlistLine, text string)) (string, []playlistLine) {
+	bom := ""
+	if bytes.HasPrefix(data, []byte(utf8BOM)) {
+		bom, data = utf8BOM, data[len(utf8BOM):]
+	}
+	var out []playlistLine
+	for len(data) > 0 {
+		var l playlistLine
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i], data[i+1:]
+			l.end = "\n"
+		} else {
+			data = nil
+		}
+		if bytes.HasSuffix(line, []byte("\r")) {
+			line = line[:len(line)-1]
+			l.end = "\r" + l.end
+		}
+		l.prefix = string(line)
+		parse(&l, string(line))
+		out = append(out, l)
+	}
+	return bom, out
+}
+
+// Get the fields of the lines.
+func lineFields(lines []playlistLine) []TagField {
+	var fields []TagField
+	for _, l := range lines {
+		if l.name != "" {
+			fields = append(fields, TagField{Name: l.name, Value: l.value})
+		}
+	}
+	return fields
+}
+
+// Write the lines with the values replaced by the fields.  The lines are not
+// added or removed, the n-th field with a name goes into the n-th line with it.
+func copyLines(w io.Writer, bom string, lines []playlistLine, fields []TagField) error {
+	values := make(map[string][]string)
+	for _, field := range fields {
+		values[field.Name] = append(values[field.Name], field.Value)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(bom)
+	for _, l := range lines {
+		if l.name != "" {
+			if len(values[l.name]) == 0 {
+				return fmt.Errorf("no value for the %s line", l.name)
+			}
+			l.value = values[l.name][0]
+			values[l.name] = values[l.name][1:]
+		}
+		buf.WriteString(l.prefix + l.value + l.suffix + l.end)
+	}
+	for name, v := range values {
+		if len(v) > 0 {
+			return fmt.Errorf("no %s line for %q", name, v[0])
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Parse the playlist into the lines, the byte order mark is returned too.
+func parsePlaylist(data []byte) (string, []playlistLine) {
+	pls, first := false, true
+	return splitLines(data, func(l *playlistLine, text string) {
+		trimmed := strings.TrimSpace(text)
+		switch {
+		case trimmed == "":
+			return
+		case first && strings.EqualFold(trimmed, "[playlist]"):
+			pls = true
+		case pls:
+			key, value, ok := strings.Cut(text, "=")
+			if !ok {
+				break
+			}
+			// The entries are numbered, e.g. "File1".
+			switch name := strings.TrimRight(key, "0123456789"); {
+			case strings.EqualFold(name, "File"):
+				l.name, l.prefix, l.value = "File", key+"=", value
+			case strings.EqualFold(name, "Title"):
+				l.name, l.prefix, l.value = "Title", key+"=", value
+			}
+		case strings.HasPrefix(text, "#EXTINF:"):
+			if i := strings.IndexByte(text, ','); i >= 0 {
+				l.name, l.prefix, l.value = "EXTINF", text[:i+1], text[i+1:]
+			}
+		case strings.HasPrefix(text, "#"):
+			if i := strings.IndexByte(text, ':'); i > 1 && strings.ToUpper(text[1:i]) == text[1:i] {
+				l.name, l.prefix, l.value = text[1:i], text[:i+1], text[i+1:]
+			} else {
+				l.name, l.prefix, l.value = "COMMENT", "#", text[1:]
+			}
+		default:
+			l.name, l.prefix, l.value = "PATH", "", text
+		}
+		first = false
+	})
+}
+
+func (playlistBackend) Name() string {
+	return "playlist"
+}
+
+func (playlistBackend) Extensions() []string {
+	return []string{".m3u", ".m3u8", ".pls"}
+}
+
+func (playlistBackend) ReadFields(f *os.File) ([]TagField, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	_, lines := parsePlaylist(data)
+	return lineFields(lines), nil
+}
+
+func (playlistBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	bom, lines := parsePlaylist(data)
+	return copyLines(w, bom, lines, fields)
+}
+
+// PlaylistExtensions lists the extensions of the playlists and the CUE
+// sheets, which are fixed as well if given, see Extensions.
+func PlaylistExtensions() []string {
+	return append(playlists.Extensions(), cueSheets.Extensions()...)
+}
@@ -0,0 +1,99 @@
+package fixtag
+
+import (
+	"os"
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Write the text made by the function in cp1251, convert the values of all
+// fields read from it into UTF-8 and write them back.  The file must be the
+// same text in UTF-8, with nothing else changed.
+func checkLinesRoundTrip(t *testing.T, b TagBackend, name string, text func(enc func(string) string) string) {
+	t.Helper()
+	cp1251 := func(s string) string {
+		out, err := charmap.Windows1251.NewEncoder().String(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	path := writeFile(t, name, []byte(text(cp1251)))
+	fields, err := readFields(b, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, field := range fields {
+		if !utf8.ValidString(field.Value) {
+			if fields[i].Value, err = charmap.Windows1251.NewDecoder().String(field.Value); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := writeFields(b, path, fields, saveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := text(func(s string) string { return s }); string(data) != want {
+		t.Errorf("written %q, want %q", data, want)
+	}
+	got, err := readFields(b, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(fields) {
+		t.Fatalf("ReadFields() after write = %d fields, want %d", len(got), len(fields))
+	}
+	for i := range got {
+		if got[i] != fields[i] {
+			t.Errorf("ReadFields() after write [%d] = %+v, want %+v", i, got[i], fields[i])
+		}
+	}
+}
+
+func TestPlaylistRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		text func(enc func(string) string) string
+	}{
+		{"test.m3u", func(enc func(string) string) string {
+			return "#EXTM3U\r\n#PLAYLIST:" + enc("Лучшее") + "\r\n\r\n" +
+				"#EXTINF:215," + enc("Кино - Группа крови") + "\r\n" + enc(`Кино\Группа крови.mp3`) + "\r\n" +
+				"# " + enc("из коллекции") + "\r\n" +
+				"#EXTINF:-1,Radio\r\nhttp://example.com/stream\r\n"
+		}},
+		{"test.m3u8", func(enc func(string) string) string {
+			return utf8BOM + "#EXTM3U\n#EXTINF:215," + enc("Кино - Группа крови") + "\n" + enc("Кино/Группа крови.flac")
+		}},
+		{"test.pls", func(enc func(string) string) string {
+			return "[playlist]\nFile1=" + enc("Кино/Группа крови.mp3") + "\nTitle1=" + enc("Кино - Группа крови") +
+				"\nLength1=215\n\nfile2=radio.mp3\nNumberOfEntries=2\nVersion=2\n"
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checkLinesRoundTrip(t, playlists, tt.name, tt.text)
+		})
+	}
+}
+
+func TestPlaylistFieldCount(t *testing.T) {
+	data := "#EXTINF:215,Title\nsong.mp3\n"
+	path := writeFile(t, "test.m3u", []byte(data))
+	for _, fields := range [][]TagField{
+		{{Name: "EXTINF", Value: "Title"}},
+		{{Name: "EXTINF", Value: "Title"}, {Name: "PATH", Value: "song.mp3"}, {Name: "PATH", Value: "other.mp3"}},
+	} {
+		if err := writeFields(playlists, path, fields, saveOptions{}); err == nil {
+			t.Errorf("writing %d fields has succeeded", len(fields))
+		}
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != data {
+		t.Errorf("the playlist has changed: %q, %v", got, err)
+	}
+	checkNoTemp(t, path)
+}
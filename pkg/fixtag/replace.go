@@ -0,0 +1,125 @@
+package fixtag
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The chain of the fields changed by the replacements only, see Options.
+const ChainReplace = "replace"
+
+// Replacement replaces the matches of the regular expression in the text
+// of the frames, e.g. to remove "[www.site.ru]" from the titles.
+type Replacement struct {
+	Frame string         // the key of the frames, all text frames if empty
+	From  *regexp.Regexp // the matches to replace
+	To    string         // the replacement, with $1 for the submatches
+}
+
+// The parts of the replacement, see ParseReplacement.
+var replacementRe = regexp.MustCompile(`(?:^|;)(frame|from|to)=`)
+
+// ParseReplacement parses the replacement "frame=KEY;from=REGEXP;to=TEXT",
+// where the frame and the replacement text are optional, e.g.
+// "frame=TIT2;from=\s*\[www\..*\]".
+func ParseReplacement(spec string) (*Replacement, error) {
+	r := &Replacement{}
+	parts := replacementRe.FindAllStringSubmatchIndex(spec, -1)
+	if len(parts) == 0 || parts[0][0] != 0 {
+		return nil, fmt.Errorf("invalid replacement %q, must be frame=KEY;from=REGEXP;to=TEXT", spec)
+	}
+	seen := make(map[string]bool)
+	for i, m := range parts {
+		name := spec[m[2]:m[3]]
+		end := len(spec)
+		if i+1 < len(parts) {
+			end = parts[i+1][0]
+		}
+		value := spec[m[1]:end]
+		if seen[name] {
+			return nil, fmt.Errorf("invalid replacement %q: %s is repeated", spec, name)
+		}
+		seen[name] = true
+		switch name {
+		case "frame":
+			r.Frame = value
+		case "from":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid replacement %q: %v", spec, err)
+			}
+			r.From = re
+		case "to":
+			r.To = value
+		}
+	}
+	if r.From == nil || r.From.String() == "" {
+		return nil, fmt.Errorf("invalid replacement %q: no from=REGEXP", spec)
+	}
+	return r, nil
+}
+
+// ParseReplacements parses the replacements one per line, see
+// ParseReplacement.  The empty lines and the ones starting with "#" are skipped.
+func ParseReplacements(data string) ([]*Replacement, error) {
+	var out []*Replacement
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		r, err := ParseReplacement(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		out = append(out, r)
+	}
+	return out, nil
+}
+
+// Apply the replacements for the frame to the text.  The text is kept
+// if nothing would be left of it.
+func (f *Fixer) replace(key, text string) string {
+	out := text
+	for _, r := range f.opts.Replace {
+		if r.Frame == "" || strings.EqualFold(r.Frame, key) {
+			out = r.From.ReplaceAllString(out, r.To)
+		}
+	}
+	if out == text {
+		return text
+	}
+	if out = strings.TrimSpace(out); out == "" {
+		return text
+	}
+	return out
+}
+
+// Mark the correct field to be written with the replacements, if any
+// matches.  It returns false if the field need not be written.
+func (f *Fixer) replaceField(res *Field) bool {
+	if len(f.opts.Replace) == 0 || res.Field != FieldText {
+		return false
+	}
+	text := f.replace(res.Key, res.Orig)
+	if text == res.Orig {
+		return false
+	}
+	res.Winner = &Candidate{Chain: ChainReplace, Charset: "utf-8", Text: text, Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
+
+// Apply the replacements to the converted fields, but the ones changed by
+// the replacements only, see replaceField.
+func (f *Fixer) replaceFields(res *Result) {
+	if len(f.opts.Replace) == 0 {
+		return
+	}
+	for _, field := range res.Fields() {
+		if field.Action == ActionConverted && field.Field == FieldText && field.Winner.Chain != ChainReplace {
+			field.Winner.Text = f.replace(field.Key, field.Winner.Text)
+		}
+	}
+}
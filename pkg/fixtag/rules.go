@@ -0,0 +1,147 @@
+package fixtag
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The chain of the frames changed by the rules, see Options.
+const ChainRule = "rule"
+
+// SourceRule marks the frames set by the rules.
+const SourceRule = "rule"
+
+// Rule changes the frames of the file, if its path and its frames match.
+// The frames are matched after the conversion, a missing frame is matched
+// as the empty text.  In YAML:
+//
+//	rules:
+//	  - path: /Audiobooks/
+//	    skip: true
+//	  - when: {TALB: "^Greatest Hits"}
+//	    set: {TPE2: Various Artists}
+//	  - when: {TCOM: '^\s*$'}
+//	    remove: [TCOM]
+type Rule struct {
+	Path   string            `yaml:"path"`   // the regular expression of the absolute path
+	When   map[string]string `yaml:"when"`   // the regular expressions of the frames by their keys
+	Skip   bool              `yaml:"skip"`   // the file is not changed at all
+	Set    map[string]string `yaml:"set"`    // the text of the frames by their keys
+	Remove []string          `yaml:"remove"` // the keys of the frames
+
+	path *regexp.Regexp
+	when map[string]*regexp.Regexp
+}
+
+// ParseRules parses the rules in YAML, see Rule.
+func ParseRules(data []byte) ([]*Rule, error) {
+	var doc struct {
+		Rules []*Rule `yaml:"rules"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&doc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for i, r := range doc.Rules {
+		if !r.Skip && len(r.Set) == 0 && len(r.Remove) == 0 {
+			return nil, fmt.Errorf("rule %d: no skip, set or remove", i+1)
+		}
+		var err error
+		if r.Path != "" {
+			if r.path, err = regexp.Compile(r.Path); err != nil {
+				return nil, fmt.Errorf("rule %d: path: %v", i+1, err)
+			}
+		}
+		r.when = make(map[string]*regexp.Regexp)
+		for key, expr := range r.When {
+			if r.when[key], err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("rule %d: %s: %v", i+1, key, err)
+			}
+		}
+	}
+	return doc.Rules, nil
+}
+
+// Check whether the rule matches the file with the texts of the frames.
+func (r *Rule) matches(path string, texts map[string]string) bool {
+	if r.path != nil {
+		abs, err := filepath.Abs(path)
+		if err != nil || !r.path.MatchString(abs) {
+			return false
+		}
+	}
+	for key, re := range r.when {
+		if !re.MatchString(texts[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply the rules to the result, if set in the options.  The frames being
+// converted are matched with the converted text, but are not changed by the
+// rules.  The file is not changed at all if a skip rule matches.
+func (f *Fixer) ruleFrames(res *Result, current map[string]TextFrame) {
+	if len(f.opts.Rules) == 0 {
+		return
+	}
+	texts := make(map[string]string)
+	for key, tf := range current {
+		texts[key] = tf.Text
+	}
+	converted := make(map[string]bool)
+	for _, fi := range res.Frames {
+		converted[fi.Key] = true
+		if fi.Index > 0 {
+			continue
+		}
+		for _, field := range fi.Fields {
+			if field.Field == FieldText && field.Action == ActionConverted {
+				texts[fi.Key] = field.Winner.Text
+			}
+		}
+	}
+	set := func(key, text string) {
+		if converted[key] {
+			f.log.Printf(slog.LevelInfo, "frame %q is being converted, not changed by the rules", key)
+			return
+		}
+		texts[key] = text
+	}
+	for i, r := range f.opts.Rules {
+		if !r.matches(res.File, texts) {
+			continue
+		}
+		if r.Skip {
+			f.log.Printf(slog.LevelInfo, "skipped by rule %d", i+1)
+			res.Frames = nil
+			res.Skipped = true
+			return
+		}
+		for key, text := range r.Set {
+			set(key, text)
+		}
+		for _, key := range r.Remove {
+			set(key, "")
+		}
+	}
+	keys := make([]string, 0, len(texts))
+	for key, text := range texts {
+		if !converted[key] && text != current[key].Text {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.planFrame(res, ChainRule, SourceRule, key, texts[key], current)
+	}
+}
@@ -0,0 +1,354 @@
+package fixtag
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/bogem/id3v2"
+)
+
+// Get the size of the ID3v2 tag at the beginning of the file, including
+// the header and the footer, or 0 if there is no tag.
+func id3v2Size(f *os.File) (int64, error) {
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if !bytes.HasPrefix(header, []byte("ID3")) {
+		return 0, nil
+	}
+	// The size is a synchsafe integer, 7 bits per byte.
+	var size int64
+	for _, b := range header[6:10] {
+		size = size<<7 | int64(b&0x7f)
+	}
+	size += 10
+	if header[5]&0x10 != 0 {
+		// The footer is present.
+		size += 10
+	}
+	return size, nil
+}
+
+// Open the ID3v2 tag of the file: at the beginning of an MP3 file, or in
+// the chunk of an AIFF file.  Only the tag is read, at once, and never the
+// audio; the tag is not bound to the file, see saveFile.
+func openTag(path string) (*id3v2.Tag, error) {
+	if isAIFF(path) {
+		return readAIFFTag(path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	size, err := id3v2Size(f)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if size > st.Size() {
+		// The tag is cut short, the frames are parsed as far as they go.
+		size = st.Size()
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return id3v2.ParseReader(bytes.NewReader(data), id3v2.Options{Parse: true})
+}
+
+// SaveTag saves the tag into the file atomically, keeping the rest of the file.
+func SaveTag(path string, tag *id3v2.Tag) error {
+	_, err := saveFile(path, tag, nil, saveOptions{})
+	return err
+}
+
+// Save the tag into the file, keeping the audio.  The tag is written over
+// the old one if it fits, see writeInPlace, and the file is replaced
+// atomically otherwise, see replaceFile; whether it is written in place is
+// returned.  The ID3v1 tag at the end of the file is kept if v1 is nil,
+// stripped if v1 is empty, and replaced with v1 otherwise.  AIFF files have
+// no ID3v1 tag, the ID3v2 tag is saved into the chunk.
+func saveFile(path string, tag *id3v2.Tag, v1 []byte, so saveOptions) (bool, error) {
+	if isAIFF(path) {
+		return false, saveAIFFTag(path, tag, so)
+	}
+	if ok, err := writeInPlace(path, tag, v1, so); ok || err != nil {
+		return ok, err
+	}
+	return false, replaceFile(path, so, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		start, err := id3v2Size(orig)
+		if err != nil {
+			return err
+		}
+		end := st.Size()
+		old, err := readID3v1(path)
+		if err != nil {
+			return err
+		}
+		if old != nil && v1 != nil {
+			end -= id3v1Size
+		}
+		if _, err := tag.WriteTo(w); err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, io.NewSectionReader(orig, start, end-start)); err != nil {
+			return err
+		}
+		_, err = w.Write(v1)
+		return err
+	})
+}
+
+// Write the tag over the old one if it fits into the old one with its
+// padding, padding the rest with zeros, so that the audio is not copied.
+// The file is not written if the tag does not fit, if the ID3v1 tag changes,
+// or if the old tag has the footer, which the padding cannot precede.  The
+// tag is written at once, which is not atomic but the only write of the file.
+func writeInPlace(path string, tag *id3v2.Tag, v1 []byte, so saveOptions) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	size, err := id3v2Size(f)
+	if err != nil || size == 0 || size > st.Size() {
+		return false, err
+	}
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		return false, err
+	}
+	if header[5]&0x10 != 0 {
+		return false, nil
+	}
+	if v1 != nil {
+		old, err := readID3v1(path)
+		if err != nil || !bytes.Equal(old, v1) {
+			return false, err
+		}
+	}
+	var buf bytes.Buffer
+	if _, err := tag.WriteTo(&buf); err != nil {
+		return false, err
+	}
+	if buf.Len() == 0 || int64(buf.Len()) > size {
+		return false, nil
+	}
+	if pad := size - int64(buf.Len()); pad > 0 && pad < 10 {
+		// The padding shorter than a frame header is read as a frame
+		// running into the audio data.
+		return false, nil
+	}
+	so.wait(size)
+	data := make([]byte, size)
+	copy(data, buf.Bytes())
+	// The size of the tag includes the padding, as a synchsafe integer.
+	n := size - 10
+	for i := 9; i >= 6; i-- {
+		data[i] = byte(n & 0x7f)
+		n >>= 7
+	}
+	written := false
+	err = so.commit(func() error {
+		written = true
+		if _, err := f.WriteAt(data, 0); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		if so.keepTimes {
+			return os.Chtimes(path, atime(st), st.ModTime())
+		}
+		return nil
+	})
+	return written, err
+}
+
+// The options of saving the files.
+type saveOptions struct {
+	// Restore the access and modification times of the file after writing.
+	keepTimes bool
+	// Check that the audio data is the same, see checkAudio.
+	checkAudio bool
+	// Limits the writing, may be nil.
+	throttle Throttle
+	// The file is left as it was once the context is done, may be nil.
+	// The context may have the WriteGuard, see commit.
+	ctx context.Context
+}
+
+// Get the options of saving the files.
+func (f *Fixer) saveOptions() saveOptions {
+	return saveOptions{keepTimes: f.opts.PreserveTimes, checkAudio: f.opts.CheckAudio, throttle: f.opts.Throttle, ctx: f.ctx}
+}
+
+// Wait for the throttle, if any, before writing the bytes of a file.
+func (so saveOptions) wait(n int64) {
+	if so.throttle != nil {
+		so.throttle.Wait(n)
+	}
+}
+
+// Get the error of the context, if done.
+func (so saveOptions) err() error {
+	if so.ctx == nil {
+		return nil
+	}
+	return so.ctx.Err()
+}
+
+// Make the write which changes the file, e.g. the rename over it, unless
+// the context is done.  The WriteGuard of the context, if any, is held
+// across the check and the write.
+func (so saveOptions) commit(write func() error) error {
+	var g *WriteGuard
+	if so.ctx != nil {
+		g, _ = so.ctx.Value(writeGuardKey{}).(*WriteGuard)
+	}
+	if g == nil {
+		if err := so.err(); err != nil {
+			return err
+		}
+		return write()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := so.err(); err != nil || g.stopped {
+		if err == nil {
+			err = context.Canceled
+		}
+		return err
+	}
+	g.written = true
+	return write()
+}
+
+// WriteGuard makes the checks of the context before writing the files final,
+// e.g. to report a file timed out only if it is left as it was: the writes
+// with the context of WithWriteGuard are made with the guard held, and none
+// once the guard is stopped.
+type WriteGuard struct {
+	mu      sync.Mutex
+	stopped bool
+	written bool // any file, or started to
+}
+
+type writeGuardKey struct{}
+
+// WithWriteGuard returns the context with the guard of the writes, see
+// WriteGuard.  The Fixer with the context writes the files under the guard,
+// see Fixer.WithContext.
+func WithWriteGuard(ctx context.Context, g *WriteGuard) context.Context {
+	return context.WithValue(ctx, writeGuardKey{}, g)
+}
+
+// Stop waits for the write in progress, if any, and keeps the others from
+// starting.  It reports whether any file has been written with the guard.
+func (g *WriteGuard) Stop() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopped = true
+	return g.written
+}
+
+// Replace the file atomically: the new contents are written by the function
+// into a temporary file in the same directory, which is synced and renamed
+// over the original, so the file is never left half-written.  The permissions
+// and the ownership of the file are preserved, see saveOptions.
+func replaceFile(path string, so saveOptions, write func(w io.Writer, orig *os.File, st os.FileInfo) error) error {
+	orig, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer orig.Close()
+	st, err := orig.Stat()
+	if err != nil {
+		return err
+	}
+	// The whole file is copied.
+	so.wait(st.Size())
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err := tmp.Chmod(st.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := chown(tmp, st); err != nil {
+		return err
+	}
+	if err := write(tmp, orig, st); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	// The copy may take long, e.g. on a network share.
+	if err := so.err(); err != nil {
+		return err
+	}
+	if so.checkAudio {
+		if err := checkAudio(path, orig, tmp); err != nil {
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	err = so.commit(func() error {
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return err
+		}
+		done = true
+		if so.keepTimes {
+			return os.Chtimes(path, atime(st), st.ModTime())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// ReplaceFile replaces the file atomically the way the tags are written,
+// e.g. an archive of the files, see replaceFile.
+func ReplaceFile(path string, write func(w io.Writer, orig *os.File, st os.FileInfo) error) error {
+	return replaceFile(path, saveOptions{}, write)
+}
+
+// Sync the directory to persist the rename.  Not all systems support it,
+// so the errors are ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	d.Sync()
+	return d.Close()
+}
@@ -0,0 +1,235 @@
+package fixtag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/bogem/id3v2"
+)
+
+// Write the file into a new directory.
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Check that no temporary file is left in the directory of the file.
+func checkNoTemp(t *testing.T, path string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("%d files left in the directory", len(entries))
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	path := writeFile(t, "test.mp3", []byte("old"))
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := replaceFile(path, saveOptions{}, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		if _, err := io.Copy(w, orig); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, " new")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old new" {
+		t.Errorf("contents = %q", data)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("permissions = %v, want %v", st.Mode().Perm(), os.FileMode(0600))
+	}
+	checkNoTemp(t, path)
+
+	// The file is left as it was if the writing fails.
+	errWrite := errors.New("write failed")
+	err = replaceFile(path, saveOptions{}, func(w io.Writer, orig *os.File, st os.FileInfo) error {
+		io.WriteString(w, "partial")
+		return errWrite
+	})
+	if err != errWrite {
+		t.Errorf("replaceFile() error = %v, want %v", err, errWrite)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old new" {
+		t.Errorf("contents after the failure = %q", data)
+	}
+	checkNoTemp(t, path)
+}
+
+func TestOpenTagPastEnd(t *testing.T) {
+	src := id3v2.NewEmptyTag()
+	src.SetVersion(3)
+	src.SetTitle("Kino")
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The tag claims the largest size, 256 MB, past the end of the file.
+	data := buf.Bytes()
+	copy(data[6:10], "\x7f\x7f\x7f\x7f")
+	path := writeFile(t, "test.mp3", data)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	tag, err := openTag(path)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+		t.Errorf("openTag() has allocated %d bytes for the file of %d", n, len(data))
+	}
+	if got := tag.Title(); got != "Kino" {
+		t.Errorf("title = %q", got)
+	}
+}
+
+func TestWriteInPlace(t *testing.T) {
+	tests := []struct {
+		name  string
+		title string // the title of the new tag, the old one is 30 bytes long
+		ok    bool
+	}{
+		{"no padding", strings.Repeat("b", 30), true},
+		{"padding", "b", true},
+		{"padding shorter than a header", strings.Repeat("b", 25), false},
+		{"larger", strings.Repeat("b", 31), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMP3(t, id3v2.EncodingISO, strings.Repeat("a", 30))
+			before, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tag := id3v2.NewEmptyTag()
+			tag.SetVersion(3)
+			tag.AddTextFrame("TIT2", id3v2.EncodingISO, tt.title)
+			ok, err := writeInPlace(path, tag, nil, saveOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("writeInPlace() = %v, want %v", ok, tt.ok)
+			}
+			after, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(after) != len(before) {
+				t.Fatalf("the size has changed from %d to %d", len(before), len(after))
+			}
+			if !ok {
+				if !bytes.Equal(after, before) {
+					t.Error("the file not written in place has changed")
+				}
+				return
+			}
+			if got := readTitle(t, path); got != tt.title {
+				t.Errorf("title = %q, want %q", got, tt.title)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			size, err := id3v2Size(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(after[size:], before[size:]) {
+				t.Error("the audio has changed")
+			}
+		})
+	}
+}
+
+// A backend writing the file only once released, e.g. the one on a hanging
+// network mount.
+type blockingBackend struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (blockingBackend) Name() string                              { return "blocking" }
+func (blockingBackend) Extensions() []string                      { return nil }
+func (blockingBackend) ReadFields(f *os.File) ([]TagField, error) { return nil, nil }
+
+func (b blockingBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	close(b.started)
+	<-b.release
+	_, err := io.WriteString(w, "new")
+	return err
+}
+
+func TestWriteGuard(t *testing.T) {
+	// Stopped while the file is being copied: it is not replaced.
+	path := writeFile(t, "test.dat", []byte("old"))
+	b := blockingBackend{started: make(chan struct{}), release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var g WriteGuard
+	done := make(chan error, 1)
+	go func() {
+		done <- writeFields(b, path, nil, saveOptions{ctx: WithWriteGuard(ctx, &g)})
+	}()
+	<-b.started
+	cancel()
+	if g.Stop() {
+		t.Error("Stop() reports the file written before it is")
+	}
+	close(b.release)
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("writeFields() error = %v, want %v", err, context.Canceled)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+		t.Errorf("the file = %q, %v, want it left as it was", data, err)
+	}
+	checkNoTemp(t, path)
+
+	// Written before it is stopped, nothing is written after.
+	b = blockingBackend{started: make(chan struct{}), release: make(chan struct{})}
+	close(b.release)
+	g = WriteGuard{}
+	so := saveOptions{ctx: WithWriteGuard(context.Background(), &g)}
+	if err := writeFields(b, path, nil, so); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Stop() {
+		t.Error("Stop() does not report the file written")
+	}
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.started = make(chan struct{})
+	if err := writeFields(b, path, nil, so); err == nil {
+		t.Error("writeFields() has succeeded after Stop()")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+		t.Errorf("the file = %q, %v, want it left as it was", data, err)
+	}
+	checkNoTemp(t, path)
+}
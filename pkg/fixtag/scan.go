@@ -0,0 +1,75 @@
+package fixtag
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2"
+)
+
+// Check whether the text has any non-ASCII characters.
+func hasNonASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether the UTF-8 text may be mojibake: it is not valid, or it has
+// the Latin-1 letters like "Ïà÷", which the text in a single-byte charset
+// decoded as Latin-1 is made of.
+func hasLatin1(text string) bool {
+	if !utf8.ValidString(text) {
+		return true
+	}
+	for _, r := range text {
+		if r >= 0xc0 && r <= 0xff {
+			return true
+		}
+	}
+	return false
+}
+
+// SuspectFrames gets the keys of the frames of the file which may need
+// fixing, without converting them: the ID3v2 text frames declared as
+// ISO-8859-1 with non-ASCII text, or the fields of the other formats with
+// the Latin-1 letters or not valid UTF-8.  The keys are sorted.
+func SuspectFrames(path string) ([]string, error) {
+	var out []string
+	if b := backendFor(path); b != nil {
+		fields, err := readFields(b, path)
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, field := range fields {
+			if !seen[field.Name] && hasLatin1(field.Value) {
+				seen[field.Name] = true
+				out = append(out, field.Name)
+			}
+		}
+		sort.Strings(out)
+		return out, nil
+	}
+	tag, err := openTag(path)
+	if err != nil {
+		return nil, err
+	}
+	defer tag.Close()
+	for key, framers := range tag.AllFrames() {
+		for _, framer := range framers {
+			tf, ok := ToTextFrame(framer)
+			if !ok {
+				break
+			}
+			if tf.Encoding.Equals(id3v2.EncodingISO) && (hasNonASCII(tf.Text) || hasNonASCII(tf.Description)) {
+				out = append(out, key)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,49 @@
+package fixtag
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bogem/id3v2"
+)
+
+// Compare reading the tag of a 4 MB file with 40 frames at once, as openTag
+// does, with parsing it from the file frame by frame.
+func BenchmarkScan(b *testing.B) {
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(3)
+	for i := 0; i < 40; i++ {
+		tag.AddCommentFrame(id3v2.CommentFrame{Encoding: id3v2.EncodingISO, Language: "rus", Description: fmt.Sprint(i), Text: "Ãðóïïà êðîâè"})
+	}
+	var buf bytes.Buffer
+	if _, err := tag.WriteTo(&buf); err != nil {
+		b.Fatal(err)
+	}
+	buf.Write(bytes.Repeat([]byte("\xff\xfb\x90\x00"), 1<<20))
+	path := filepath.Join(b.TempDir(), "test.mp3")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tag, err := openTag(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			tag.Close()
+		}
+	})
+	b.Run("full", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+			if err != nil {
+				b.Fatal(err)
+			}
+			tag.Close()
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package fixtag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Scorer judges the text converted, see Options.
+type Scorer interface {
+	// Goodness gets the ratio of the correct characters of the text, in
+	// range [0..1]: 1 for the correct text, and 0 if it is not UTF-8.
+	Goodness(text string) float64
+}
+
+// The scorer accepting the characters of the ranges, see NewRangeScorer.
+type rangeScorer []letterRange
+
+// NewRangeScorer makes the scorer accepting the characters of the ranges
+// and the white space only, e.g. "U+0400-U+04FF,U+0020-U+007E"; a range may
+// be a single character, e.g. "U+00A0".  ASCII is not accepted unless given.
+func NewRangeScorer(spec string) (Scorer, error) {
+	var out rangeScorer
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lo, hi, ok := strings.Cut(item, "-")
+		if !ok {
+			hi = lo
+		}
+		r := letterRange{}
+		var err error
+		if r.lo, err = parseCodePoint(lo); err == nil {
+			r.hi, err = parseCodePoint(hi)
+		}
+		if err != nil || r.lo > r.hi {
+			return nil, fmt.Errorf("invalid range %q, must be U+XXXX-U+XXXX", item)
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no ranges in %q", spec)
+	}
+	return out, nil
+}
+
+// Parse the code point like "U+0400".
+func parseCodePoint(s string) (rune, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || !strings.EqualFold(s[:2], "U+") {
+		return 0, fmt.Errorf("invalid code point %q", s)
+	}
+	n, err := strconv.ParseUint(s[2:], 16, 32)
+	if err != nil || n > unicode.MaxRune {
+		return 0, fmt.Errorf("invalid code point %q", s)
+	}
+	return rune(n), nil
+}
+
+func (rs rangeScorer) Goodness(s string) float64 {
+	if !utf8.ValidString(s) {
+		return 0
+	}
+	bad := 0
+	total := 0
+	for _, c := range s {
+		total++
+		if !unicode.IsSpace(c) && !alphabet(rs).contains(c) {
+			bad++
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(total-bad) / float64(total)
+}
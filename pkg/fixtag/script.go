@@ -0,0 +1,604 @@
+package fixtag
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Script is an expression over a conversion of a field, either a predicate
+// every candidate must satisfy, see ParsePredicate, or a transform of the
+// converted text, see ParseTransform.  The expressions are like in Go:
+//
+//	!contains(text, "\ufffd") && len(text) >= 2
+//	frame == "TPE1" ? title(lower(text)) : text
+//	sub(text, `\s*\(www\..*\)$`, "")
+//
+// The variables are text (the decoded text), orig (the original one),
+// frame (the key, e.g. "TIT2"), chain, charset, goodness and score.  The
+// operators are ?:, ||, &&, ==, !=, <, <=, >, >=, +, -, *, /, % and !, and
+// the functions are len, contains, hasPrefix, hasSuffix, lower, upper, trim,
+// title, replace(s, old, new), matches(s, re) and sub(s, re, repl), whose
+// regular expressions must be literals.  The strings are quoted like in Go.
+type Script struct {
+	src  string
+	expr *scriptExpr
+}
+
+func (s *Script) String() string {
+	return s.src
+}
+
+// ParsePredicate parses the script returning a boolean, see Script.
+func ParsePredicate(src string) (*Script, error) {
+	return parseScript(src, scriptBool)
+}
+
+// ParseTransform parses the script returning the new text, see Script.
+func ParseTransform(src string) (*Script, error) {
+	return parseScript(src, scriptString)
+}
+
+// The types of the expressions.
+const (
+	scriptString = iota
+	scriptNumber
+	scriptBool
+)
+
+var scriptTypes = []string{"string", "number", "bool"}
+
+// The values the scripts are evaluated with.
+type scriptEnv struct {
+	text, orig, frame, chain, charset string
+	goodness, score                   float64
+}
+
+// Make the values of the candidate of the field.
+func newScriptEnv(res *Field, c *Candidate) *scriptEnv {
+	return &scriptEnv{text: c.Text, orig: res.Orig, frame: res.Key, chain: c.Chain, charset: c.Charset, goodness: c.Goodness, score: c.Score}
+}
+
+// A compiled expression: the function of its type is set.
+type scriptExpr struct {
+	typ int
+	s   func(*scriptEnv) string
+	n   func(*scriptEnv) float64
+	b   func(*scriptEnv) bool
+	lit *string // the value of the string literal, for the regular expressions
+}
+
+func (s *Script) test(env *scriptEnv) bool {
+	return s.expr.b(env)
+}
+
+func (s *Script) apply(env *scriptEnv) string {
+	return s.expr.s(env)
+}
+
+func parseScript(src string, typ int) (*Script, error) {
+	toks, err := scanScript(src)
+	if err == nil {
+		p := &scriptParser{toks: toks, end: len(src)}
+		var e *scriptExpr
+		e, err = p.ternary()
+		if err == nil && p.pos < len(p.toks) {
+			err = p.errorf(p.toks[p.pos].pos, "unexpected %q", p.toks[p.pos].text)
+		}
+		if err == nil && e.typ != typ {
+			err = p.errorf(0, "the result is %s, must be %s", scriptTypes[e.typ], scriptTypes[typ])
+		}
+		if err == nil {
+			return &Script{src: src, expr: e}, nil
+		}
+	}
+	var se *scriptError
+	if errors.As(err, &se) {
+		return nil, fmt.Errorf("script %q: col %d: %s", src, utf8.RuneCountInString(src[:se.pos])+1, se.msg)
+	}
+	return nil, fmt.Errorf("script %q: %v", src, err)
+}
+
+// The error in the script at the byte offset.
+type scriptError struct {
+	pos int
+	msg string
+}
+
+func (e *scriptError) Error() string {
+	return e.msg
+}
+
+// The kinds of the tokens.
+const (
+	tokIdent = iota
+	tokNumber
+	tokString
+	tokOp
+)
+
+type scriptToken struct {
+	kind int
+	text string
+	pos  int // the byte offset in the script
+}
+
+// The operators, the longer ones first.
+var scriptOps = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "+", "-", "*", "/", "%", "!", "(", ")", ",", "?", ":"}
+
+func scanScript(src string) ([]scriptToken, error) {
+	var out []scriptToken
+	for i := 0; i < len(src); {
+		c, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(c):
+			i += size
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) {
+				c, size := utf8.DecodeRuneInString(src[j:])
+				if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+					break
+				}
+				j += size
+			}
+			out = append(out, scriptToken{tokIdent, src[i:j], i})
+			i = j
+		case c >= '0' && c <= '9' || c == '.':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			out = append(out, scriptToken{tokNumber, src[i:j], i})
+			i = j
+		case c == '"' || c == '`':
+			j := i + 1
+			for j < len(src) && src[j] != byte(c) {
+				if c == '"' && src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) {
+				return nil, &scriptError{i, "unterminated string"}
+			}
+			s, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, &scriptError{i, fmt.Sprintf("invalid string %s", src[i:j+1])}
+			}
+			out = append(out, scriptToken{tokString, s, i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range scriptOps {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &scriptError{i, fmt.Sprintf("unexpected %q", c)}
+			}
+			out = append(out, scriptToken{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return out, nil
+}
+
+type scriptParser struct {
+	toks []scriptToken
+	pos  int // of the next token
+	end  int // the length of the script
+}
+
+func (p *scriptParser) errorf(pos int, format string, args ...interface{}) error {
+	return &scriptError{pos, fmt.Sprintf(format, args...)}
+}
+
+// Get the offset of the next token, or the end of the script.
+func (p *scriptParser) next() int {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos].pos
+	}
+	return p.end
+}
+
+// Skip the operator if it is next.
+func (p *scriptParser) accept(op string) bool {
+	if p.pos < len(p.toks) && p.toks[p.pos].kind == tokOp && p.toks[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *scriptParser) expect(op string) error {
+	if p.accept(op) {
+		return nil
+	}
+	if p.pos < len(p.toks) {
+		return p.errorf(p.next(), "expected %q, got %q", op, p.toks[p.pos].text)
+	}
+	return p.errorf(p.next(), "expected %q at the end", op)
+}
+
+func (p *scriptParser) ternary() (*scriptExpr, error) {
+	cond, err := p.binary(0)
+	at := p.next()
+	if err != nil || !p.accept("?") {
+		return cond, err
+	}
+	a, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if cond.typ != scriptBool {
+		return nil, p.errorf(at, "the condition of ?: is %s", scriptTypes[cond.typ])
+	}
+	if a.typ != b.typ {
+		return nil, p.errorf(at, "the values of ?: are %s and %s", scriptTypes[a.typ], scriptTypes[b.typ])
+	}
+	c := cond.b
+	e := &scriptExpr{typ: a.typ}
+	switch a.typ {
+	case scriptString:
+		e.s = func(env *scriptEnv) string {
+			if c(env) {
+				return a.s(env)
+			}
+			return b.s(env)
+		}
+	case scriptNumber:
+		e.n = func(env *scriptEnv) float64 {
+			if c(env) {
+				return a.n(env)
+			}
+			return b.n(env)
+		}
+	default:
+		e.b = func(env *scriptEnv) bool {
+			if c(env) {
+				return a.b(env)
+			}
+			return b.b(env)
+		}
+	}
+	return e, nil
+}
+
+// The binary operators by their precedence, the lowest first.
+var scriptLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *scriptParser) binary(level int) (*scriptExpr, error) {
+	if level == len(scriptLevels) {
+		return p.unary()
+	}
+	x, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, at := "", p.next()
+		for _, o := range scriptLevels[level] {
+			if p.accept(o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		if x, err = binaryExpr(op, x, y); err != nil {
+			return nil, p.errorf(at, "%v", err)
+		}
+	}
+}
+
+func binaryExpr(op string, x, y *scriptExpr) (*scriptExpr, error) {
+	bad := fmt.Errorf("%s %s %s", scriptTypes[x.typ], op, scriptTypes[y.typ])
+	if x.typ != y.typ {
+		return nil, bad
+	}
+	switch op {
+	case "||", "&&":
+		if x.typ != scriptBool {
+			return nil, bad
+		}
+		if op == "||" {
+			return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return x.b(env) || y.b(env) }}, nil
+		}
+		return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return x.b(env) && y.b(env) }}, nil
+	case "==", "!=", "<", "<=", ">", ">=":
+		var cmp func(env *scriptEnv) int
+		switch x.typ {
+		case scriptString:
+			cmp = func(env *scriptEnv) int { return strings.Compare(x.s(env), y.s(env)) }
+		case scriptNumber:
+			cmp = func(env *scriptEnv) int {
+				a, b := x.n(env), y.n(env)
+				switch {
+				case a < b:
+					return -1
+				case a > b:
+					return 1
+				}
+				return 0
+			}
+		default:
+			if op != "==" && op != "!=" {
+				return nil, bad
+			}
+			cmp = func(env *scriptEnv) int {
+				if x.b(env) == y.b(env) {
+					return 0
+				}
+				return 1
+			}
+		}
+		var test func(int) bool
+		switch op {
+		case "==":
+			test = func(c int) bool { return c == 0 }
+		case "!=":
+			test = func(c int) bool { return c != 0 }
+		case "<":
+			test = func(c int) bool { return c < 0 }
+		case "<=":
+			test = func(c int) bool { return c <= 0 }
+		case ">":
+			test = func(c int) bool { return c > 0 }
+		default:
+			test = func(c int) bool { return c >= 0 }
+		}
+		return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return test(cmp(env)) }}, nil
+	case "+":
+		if x.typ == scriptString {
+			return &scriptExpr{typ: scriptString, s: func(env *scriptEnv) string { return x.s(env) + y.s(env) }}, nil
+		}
+	}
+	if x.typ != scriptNumber {
+		return nil, bad
+	}
+	var f func(a, b float64) float64
+	switch op {
+	case "+":
+		f = func(a, b float64) float64 { return a + b }
+	case "-":
+		f = func(a, b float64) float64 { return a - b }
+	case "*":
+		f = func(a, b float64) float64 { return a * b }
+	case "/":
+		f = func(a, b float64) float64 { return a / b }
+	default:
+		f = math.Mod
+	}
+	return &scriptExpr{typ: scriptNumber, n: func(env *scriptEnv) float64 { return f(x.n(env), y.n(env)) }}, nil
+}
+
+func (p *scriptParser) unary() (*scriptExpr, error) {
+	at := p.next()
+	switch {
+	case p.accept("!"):
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ != scriptBool {
+			return nil, p.errorf(at, "!%s", scriptTypes[x.typ])
+		}
+		return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return !x.b(env) }}, nil
+	case p.accept("-"):
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		if x.typ != scriptNumber {
+			return nil, p.errorf(at, "-%s", scriptTypes[x.typ])
+		}
+		return &scriptExpr{typ: scriptNumber, n: func(env *scriptEnv) float64 { return -x.n(env) }}, nil
+	}
+	return p.primary()
+}
+
+// The variables of the scripts.
+var scriptVars = map[string]*scriptExpr{
+	"text":     {typ: scriptString, s: func(env *scriptEnv) string { return env.text }},
+	"orig":     {typ: scriptString, s: func(env *scriptEnv) string { return env.orig }},
+	"frame":    {typ: scriptString, s: func(env *scriptEnv) string { return env.frame }},
+	"chain":    {typ: scriptString, s: func(env *scriptEnv) string { return env.chain }},
+	"charset":  {typ: scriptString, s: func(env *scriptEnv) string { return env.charset }},
+	"goodness": {typ: scriptNumber, n: func(env *scriptEnv) float64 { return env.goodness }},
+	"score":    {typ: scriptNumber, n: func(env *scriptEnv) float64 { return env.score }},
+	"true":     {typ: scriptBool, b: func(*scriptEnv) bool { return true }},
+	"false":    {typ: scriptBool, b: func(*scriptEnv) bool { return false }},
+}
+
+func (p *scriptParser) primary() (*scriptExpr, error) {
+	if p.pos == len(p.toks) {
+		return nil, p.errorf(p.end, "unexpected end")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t.pos, "invalid number %q", t.text)
+		}
+		return &scriptExpr{typ: scriptNumber, n: func(*scriptEnv) float64 { return v }}, nil
+	case tokString:
+		v := t.text
+		return &scriptExpr{typ: scriptString, s: func(*scriptEnv) string { return v }, lit: &v}, nil
+	case tokIdent:
+		if !p.accept("(") {
+			if v, ok := scriptVars[t.text]; ok {
+				return v, nil
+			}
+			return nil, p.errorf(t.pos, "unknown variable %q", t.text)
+		}
+		var args []*scriptExpr
+		for !p.accept(")") {
+			if len(args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			a, err := p.ternary()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		e, err := callExpr(t.text, args)
+		if err != nil {
+			return nil, p.errorf(t.pos, "%v", err)
+		}
+		return e, nil
+	}
+	if t.text == "(" {
+		e, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	return nil, p.errorf(t.pos, "unexpected %q", t.text)
+}
+
+// The number of the string arguments of the functions.
+var scriptFuncs = map[string]int{
+	"len": 1, "contains": 2, "hasPrefix": 2, "hasSuffix": 2, "lower": 1, "upper": 1,
+	"trim": 1, "title": 1, "replace": 3, "matches": 2, "sub": 3,
+}
+
+func callExpr(name string, args []*scriptExpr) (*scriptExpr, error) {
+	n, ok := scriptFuncs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s takes %d arguments", name, n)
+	}
+	for _, a := range args {
+		if a.typ != scriptString {
+			return nil, fmt.Errorf("the arguments of %s are strings", name)
+		}
+	}
+	x := args[0].s
+	var re *regexp.Regexp
+	if name == "matches" || name == "sub" {
+		if args[1].lit == nil {
+			return nil, fmt.Errorf("the regular expression of %s must be a string literal", name)
+		}
+		var err error
+		if re, err = regexp.Compile(*args[1].lit); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	str := func(f func(string) string) *scriptExpr {
+		return &scriptExpr{typ: scriptString, s: func(env *scriptEnv) string { return f(x(env)) }}
+	}
+	test := func(f func(a, b string) bool) *scriptExpr {
+		y := args[1].s
+		return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return f(x(env), y(env)) }}
+	}
+	switch name {
+	case "len":
+		return &scriptExpr{typ: scriptNumber, n: func(env *scriptEnv) float64 { return float64(utf8.RuneCountInString(x(env))) }}, nil
+	case "contains":
+		return test(strings.Contains), nil
+	case "hasPrefix":
+		return test(strings.HasPrefix), nil
+	case "hasSuffix":
+		return test(strings.HasSuffix), nil
+	case "lower":
+		return str(strings.ToLower), nil
+	case "upper":
+		return str(strings.ToUpper), nil
+	case "trim":
+		return str(strings.TrimSpace), nil
+	case "title":
+		return str(titleCase), nil
+	case "replace":
+		y, z := args[1].s, args[2].s
+		return &scriptExpr{typ: scriptString, s: func(env *scriptEnv) string { return strings.ReplaceAll(x(env), y(env), z(env)) }}, nil
+	case "matches":
+		return &scriptExpr{typ: scriptBool, b: func(env *scriptEnv) bool { return re.MatchString(x(env)) }}, nil
+	}
+	z := args[2].s
+	return &scriptExpr{typ: scriptString, s: func(env *scriptEnv) string { return re.ReplaceAllString(x(env), z(env)) }}, nil
+}
+
+// Capitalize the first letter of every word.
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(c rune) rune {
+		first := !unicode.IsLetter(prev) && prev != '\''
+		prev = c
+		if first {
+			return unicode.ToTitle(c)
+		}
+		return c
+	}, s)
+}
+
+// Check whether the candidate of the field satisfies every predicate.
+func (f *Fixer) acceptable(res *Field, c *Candidate) bool {
+	if len(f.opts.Accept) == 0 {
+		return true
+	}
+	env := newScriptEnv(res, c)
+	for _, s := range f.opts.Accept {
+		if !s.test(env) {
+			f.log.Printf(slog.LevelDebug, "failed (rejected by %q)", s)
+			return false
+		}
+	}
+	return true
+}
+
+// Apply the transforms to the converted fields.  The text is kept if
+// nothing would be left of it.
+func (f *Fixer) transformFields(res *Result) {
+	if len(f.opts.Transform) == 0 {
+		return
+	}
+	for _, field := range res.Fields() {
+		if field.Action != ActionConverted || field.Field != FieldText {
+			continue
+		}
+		text := field.Winner.Text
+		for _, s := range f.opts.Transform {
+			text = s.apply(newScriptEnv(field, &Candidate{Chain: field.Winner.Chain, Charset: field.Winner.Charset, Text: text, Goodness: field.Winner.Goodness, Score: field.Winner.Score}))
+		}
+		if text == field.Winner.Text || strings.TrimSpace(text) == "" {
+			continue
+		}
+		f.log.Printf(slog.LevelDebug, "frame %q transformed into %q", field.Name(), text)
+		field.Winner.Text = text
+	}
+}
@@ -0,0 +1,162 @@
+package fixtag
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bogem/id3v2"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestScriptPredicate(t *testing.T) {
+	env := &scriptEnv{text: "Кино", orig: "Êèíî", frame: "TPE1", chain: "iso-win", charset: CharsetCP1251, goodness: 0.5, score: 2}
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		// Precedence and associativity.
+		{`1 + 2 * 3 == 7`, true},
+		{`(1 + 2) * 3 == 9`, true},
+		{`10 - 4 - 3 == 3`, true},
+		{`7 % 4 == 3 && 7 / 2 == 3.5`, true},
+		{`-2 * -3 == 6`, true},
+		{`true || false && false`, true},
+		{`(true || false) && false`, false},
+		{`!false && false`, false},
+		{`!(false && false)`, true},
+		{`false ? false : true ? true : false`, true},
+		{`1 < 2 == true`, true},
+		// Strings.
+		{`text == "Кино"`, true},
+		{`text != orig`, true},
+		{`"abc" < "abd" && "b" >= "a"`, true},
+		{`text + "!" == "Кино!"`, true},
+		{`len(text) == 4`, true},
+		{`contains(text, "ин") && hasPrefix(text, "К") && hasSuffix(text, "но")`, true},
+		{`matches(frame, "^TPE[12]$")`, true},
+		{`lower(text) == "кино" && upper(text) == "КИНО"`, true},
+		{`trim("  Кино ") == text`, true},
+		// Numbers.
+		{`goodness * 4 == score`, true},
+		{`goodness >= 0.5 && goodness < 1`, true},
+		{`score > goodness`, true},
+		{`chain == "iso-win" && charset == "cp1251"`, true},
+	}
+	for _, tt := range tests {
+		s, err := ParsePredicate(tt.src)
+		if err != nil {
+			t.Errorf("ParsePredicate(%q): %v", tt.src, err)
+			continue
+		}
+		if got := s.test(env); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestScriptTransform(t *testing.T) {
+	tests := []struct {
+		src  string
+		text string
+		want string
+	}{
+		{`text`, "Кино", "Кино"},
+		{`title(lower(text))`, "ГРУППА КРОВИ", "Группа Крови"},
+		{`title(text)`, "rock'n'roll мёртв", "Rock'n'roll Мёртв"},
+		{`replace(text, "ё", "е")`, "Ёлка ёлка", "Ёлка елка"},
+		{`sub(text, ` + "`" + `\s*\(www\..*\)$` + "`" + `, "")`, "Кино (www.example.com)", "Кино"},
+		{`sub(text, "(\\w+) (\\w+)", "$2 $1")`, "Viktor Tsoi", "Tsoi Viktor"},
+		{`frame == "TPE1" ? upper(text) : text`, "Кино", "КИНО"},
+		{`len(text) > 3 ? "long" : "short"`, "Кино", "long"},
+	}
+	for _, tt := range tests {
+		s, err := ParseTransform(tt.src)
+		if err != nil {
+			t.Errorf("ParseTransform(%q): %v", tt.src, err)
+			continue
+		}
+		if got := s.apply(&scriptEnv{text: tt.text, frame: "TPE1"}); got != tt.want {
+			t.Errorf("%s on %q = %q, want %q", tt.src, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestScriptErrors(t *testing.T) {
+	tests := []struct {
+		src       string
+		transform bool
+		want      string
+	}{
+		{`textt == "a"`, false, `col 1: unknown variable "textt"`},
+		{`foo(text) == "a"`, false, `col 1: unknown function "foo"`},
+		{`len(text, orig) > 1`, false, "col 1: len takes 1 arguments"},
+		{`text == `, false, "col 9: unexpected end"},
+		{`(text == "a"`, false, `col 13: expected ")" at the end`},
+		{`text == "a")`, false, `col 12: unexpected ")"`},
+		{`len(text ; 1)`, false, `col 10: unexpected ';'`},
+		{`"abc == text`, false, "col 1: unterminated string"},
+		{`text + 1 == "a"`, false, "col 6: string + number"},
+		{`goodness && true`, false, "col 10: number && bool"},
+		{`goodness ? 1 : 2`, true, "col 10: the condition of ?: is number"},
+		{`true ? text : 1`, true, "col 6: the values of ?: are string and number"},
+		{`len(text) > 1 ? "a" "b"`, true, `col 21: expected ":", got "b"`},
+		{`!text`, false, "col 1: !string"},
+		{`-"a" == "b"`, false, "col 1: -string"},
+		{`1..2 == 1`, false, `col 1: invalid number "1..2"`},
+		{`matches(text, orig)`, false, "col 1: the regular expression of matches must be a string literal"},
+		{`matches(text, "(")`, false, "col 1: matches: error parsing regexp"},
+		{`text`, false, "col 1: the result is string, must be bool"},
+		{`len(text) > 1`, true, "col 1: the result is bool, must be string"},
+		{`"Кино" == текст`, false, `col 11: unknown variable "текст"`},
+	}
+	for _, tt := range tests {
+		parse := ParsePredicate
+		if tt.transform {
+			parse = ParseTransform
+		}
+		_, err := parse(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parsing %s: error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestPlanScripts(t *testing.T) {
+	path := writeMP3(t, id3v2.EncodingISO, mojibake(t, "Группа крови", charmap.Windows1251))
+	accept, err := ParsePredicate(`matches(text, "^[А-Я][а-я ]+$")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transform, err := ParseTransform(`upper(text)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixer, err := New(Options{Accept: []*Script{accept}, Transform: []*Script{transform}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := fixer.Plan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := res.Fields()[0]
+	if field.Action != ActionConverted || field.Winner == nil {
+		t.Fatalf("Plan() action = %s, candidates %d", field.Action, len(field.Candidates))
+	}
+	if field.Winner.Text != "ГРУППА КРОВИ" || field.Winner.Chain != "iso-win" {
+		t.Errorf("Plan() = %q by %s, want %q by iso-win", field.Winner.Text, field.Winner.Chain, "ГРУППА КРОВИ")
+	}
+	// The KOI8-R reading is as good, but rejected.
+	tried := false
+	for _, a := range field.Attempts {
+		tried = tried || a.Chain == "iso-koi" && a.Goodness == 1
+	}
+	if !tried {
+		t.Error("iso-koi is not tried")
+	}
+	for _, c := range field.Candidates {
+		if c.Chain == "iso-koi" {
+			t.Errorf("the candidate %q by iso-koi is accepted", c.Text)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package fixtag
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ChainSegments marks the fields converted in parts, see Options.
+const ChainSegments = "segments"
+
+// A part of the text: a run of the words either in Latin-1, i.e. possibly
+// the mojibake, or in the other scripts, with the separators after them.
+type segment struct {
+	text  string
+	latin bool
+}
+
+// Check whether the character may be a part of a word, the mojibake included.
+func wordChar(c rune) bool {
+	return unicode.IsLetter(c) || c >= 0x80 && c <= 0xff
+}
+
+// Split the text into the words with the separators after them.
+func splitWords(s string) []string {
+	var out []string
+	start := 0
+	inWord := false
+	for i, c := range s {
+		w := wordChar(c)
+		if w && !inWord && i > start {
+			out = append(out, s[start:i])
+			start = i
+		}
+		inWord = w
+	}
+	return append(out, s[start:])
+}
+
+// Check whether the word is in Latin-1.
+func latinWord(w string) bool {
+	for _, c := range w {
+		if c > 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// Split the text on the boundaries of the scripts.
+func splitScripts(s string) []segment {
+	var out []segment
+	for _, w := range splitWords(s) {
+		latin := latinWord(w)
+		if n := len(out); n > 0 && out[n-1].latin == latin {
+			out[n-1].text += w
+			continue
+		}
+		out = append(out, segment{text: w, latin: latin})
+	}
+	return out
+}
+
+// Check whether the Unicode text mixes the letters of other scripts with
+// a word of the Latin-1 letters which looks like the mojibake, e.g.
+// "Кино - Ãðóïïà êðîâè".
+func mixedScripts(s string) bool {
+	other, latin := false, false
+	for _, w := range strings.FieldsFunc(s, func(c rune) bool { return !wordChar(c) }) {
+		n := 0
+		for _, c := range w {
+			if c >= 0xc0 && c <= 0xff {
+				n++
+			}
+		}
+		if n == utf8.RuneCountInString(w) && n > 1 {
+			latin = true
+		} else if !latinWord(w) {
+			other = true
+		}
+	}
+	return other && latin
+}
+
+// Make the candidate of the text, scored.
+func (f *Fixer) newCandidate(chain, charset, text string) *Candidate {
+	c := &Candidate{Chain: chain, Charset: charset, Text: text, Goodness: f.alpha.Goodness(text), mixed: countMixedCase(text), length: utf8.RuneCountInString(text)}
+	f.score(c)
+	return c
+}
+
+// Convert the text with the best of the combinations, nil if none is good
+// enough for the frame or the best ones are ambiguous.
+func (f *Fixer) convertText(combinations []combination, key, text string) *Candidate {
+	cands := f.textCandidates(combinations, key, text)
+	if len(cands) == 0 || len(cands) > 1 && !cands[0].betterThan(cands[1]) {
+		return nil
+	}
+	return cands[0]
+}
+
+// Get the conversions of the text good enough for the frame, the best first.
+func (f *Fixer) textCandidates(combinations []combination, key, text string) []*Candidate {
+	var cands []*Candidate
+	for _, cmb := range combinations {
+		val, err := decode(nopLogger{}, text, cmb.tlist...)
+		if err != nil {
+			continue
+		}
+		if c := f.newCandidate(cmb.name, cmb.charset, val); c.Goodness >= f.threshold(key) {
+			cands = addCandidate(cands, c)
+		}
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].betterThan(cands[j]) })
+	return cands
+}
+
+// Convert the text in Latin-1 as a whole or, if it is ambiguous or not
+// converted into the proper case, as two halves converted differently,
+// whichever is best.  The text not converted at all is not split, since
+// its halves are hardly any better.
+func (f *Fixer) convertHalves(combinations []combination, key, text string) *Candidate {
+	cands := f.textCandidates(combinations, key, text)
+	if len(cands) == 0 {
+		return nil
+	}
+	var best *Candidate
+	if len(cands) == 1 || cands[0].betterThan(cands[1]) {
+		if best = cands[0]; best.mixed == 0 {
+			return best
+		}
+	}
+	words := splitWords(text)
+	for i := 1; i < len(words); i++ {
+		left := f.convertText(combinations, key, strings.Join(words[:i], ""))
+		if left == nil {
+			continue
+		}
+		right := f.convertText(combinations, key, strings.Join(words[i:], ""))
+		if right == nil || right.Chain == left.Chain {
+			continue
+		}
+		c := f.newCandidate(left.Chain+"+"+right.Chain, left.Charset, left.Text+right.Text)
+		if best == nil || c.betterThan(best) {
+			best = c
+		}
+	}
+	return best
+}
+
+// Convert the parts of the text in different scripts, or broken
+// differently, independently, if the field is not converted as a whole, or
+// not into the proper case.  It returns false if the field is not changed.
+func (f *Fixer) convertSegments(combinations []combination, res *Field) bool {
+	if res.Action == ActionConverted && res.Winner.mixed == 0 {
+		return false
+	}
+	var text, chains []string
+	for _, s := range splitScripts(strings.TrimSpace(res.Orig)) {
+		if !s.latin {
+			text = append(text, s.text)
+			continue
+		}
+		c := f.convertHalves(combinations, res.Key, s.text)
+		if c == nil {
+			return false
+		}
+		text = append(text, c.Text)
+		chains = append(chains, c.Chain)
+	}
+	c := f.newCandidate(ChainSegments, "", strings.Join(text, ""))
+	if c.Goodness < f.threshold(res.Key) || res.Winner != nil && !c.betterThan(res.Winner) || !f.acceptable(res, c) {
+		return false
+	}
+	res.Attempts = append(res.Attempts, Attempt{Candidate: *c})
+	res.Candidates = []*Candidate{c}
+	res.Winner = c
+	if c.Goodness > res.Best {
+		res.Best = c.Goodness
+	}
+	res.Action = ActionConverted
+	f.log.Printf(slog.LevelInfo, "frame %q converted in parts with %s: %q", res.Name(), strings.Join(chains, ", "), c.Text)
+	return true
+}
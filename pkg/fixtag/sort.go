@@ -0,0 +1,85 @@
+package fixtag
+
+import (
+	"github.com/bogem/id3v2"
+)
+
+// SourceSort marks the sort frames made by the transliteration, see SortFrames.
+const SourceSort = "sort"
+
+// The keys of the sort frames by the keys of the frames sorted, by the names
+// of the backends, see tagInfoKeys.  Other formats have no sort frames.
+var sortKeys = map[string]map[string]string{
+	"":     {"TPE1": "TSOP", "TALB": "TSOA", "TIT2": "TSOT"},
+	"flac": vorbisSortKeys,
+	"ogg":  vorbisSortKeys,
+}
+
+var vorbisSortKeys = map[string]string{"ARTIST": "ARTISTSORT", "ALBUM": "ALBUMSORT", "TITLE": "TITLESORT"}
+
+// SortFrames adds the sort frames of the artist, the album and the title
+// planned in Cyrillic, with their text transliterated into Latin, if set in
+// the options, so that the players sort them among the Latin ones.  The sort
+// frames already in the file are kept, unless they are planned too, then
+// they are transliterated.
+func (f *Fixer) SortFrames(p *FilePlan) error {
+	if !f.opts.SortFrames {
+		return nil
+	}
+	name := ""
+	b := backendFor(p.File)
+	if b != nil {
+		name = b.Name()
+	}
+	keys := sortKeys[name]
+	if keys == nil {
+		return nil
+	}
+	sorted := make(map[string]bool)
+	for _, key := range keys {
+		sorted[key] = true
+	}
+
+	// Get the sort frames of the file.
+	current := make(map[string]bool)
+	if b != nil {
+		fields, err := readFields(b, p.File)
+		if err != nil {
+			return err
+		}
+		for _, field := range fields {
+			current[field.Name] = true
+		}
+	} else {
+		tag, err := openTag(p.File)
+		if err != nil {
+			return err
+		}
+		defer tag.Close()
+		for _, key := range keys {
+			current[key] = len(tag.GetFrames(key)) > 0
+		}
+	}
+
+	var added []FramePlan
+	for i := range p.Frames {
+		fp := &p.Frames[i]
+		if sorted[fp.ID] {
+			fp.Text = f.translit.apply(fp.Text, false)
+			continue
+		}
+		key, ok := keys[fp.ID]
+		if !ok || fp.Index > 0 || current[key] || !f.translit.changes(fp.Text) {
+			continue
+		}
+		current[key] = true
+		added = append(added, FramePlan{
+			ID:       key,
+			Original: NewFrameData(TextFrame{Encoding: id3v2.EncodingUTF8}),
+			Text:     f.translit.apply(fp.Text, false),
+			Source:   SourceSort,
+		})
+	}
+	p.Frames = append(p.Frames, added...)
+	return nil
+}
@@ -0,0 +1,67 @@
+package fixtag
+
+import (
+	"sync"
+	"time"
+)
+
+// Throttle limits the reading and the writing of the files, e.g. so that a
+// long run does not saturate a NAS, see Options.
+type Throttle interface {
+	// Wait is called with the number of bytes of every read of a file, once
+	// read, and of every write, before writing; it blocks as long as the
+	// limits require.
+	Wait(n int64)
+}
+
+// NewThrottle makes the throttle allowing at most the given number of bytes
+// and of operations a second on average; no limit if 0.
+func NewThrottle(bytesPerSec, opsPerSec float64) Throttle {
+	return &throttle{bytesPerSec: bytesPerSec, opsPerSec: opsPerSec}
+}
+
+// The operations are scheduled one after another, each taking the time of
+// its bytes and of a single operation at the limits.
+type throttle struct {
+	bytesPerSec float64
+	opsPerSec   float64
+	mu          sync.Mutex
+	next        time.Time // when the next operation may start
+}
+
+func (t *throttle) Wait(n int64) {
+	var d time.Duration
+	if t.bytesPerSec > 0 {
+		d = time.Duration(float64(n) / t.bytesPerSec * float64(time.Second))
+	}
+	if t.opsPerSec > 0 {
+		if op := time.Duration(float64(time.Second) / t.opsPerSec); op > d {
+			d = op
+		}
+	}
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	start := t.next
+	t.next = t.next.Add(d)
+	t.mu.Unlock()
+	time.Sleep(time.Until(start))
+}
+
+// Wait for the throttle, if any, after reading the bytes of a file.
+func (f *Fixer) wait(n int64) {
+	if f.opts.Throttle != nil {
+		f.opts.Throttle.Wait(n)
+	}
+}
+
+// Get the number of bytes of the fields as stored, roughly.
+func fieldsSize(fields []TagField) int64 {
+	var n int64
+	for _, field := range fields {
+		n += int64(len(field.Name) + len(field.Value))
+	}
+	return n
+}
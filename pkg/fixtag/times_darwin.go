@@ -0,0 +1,15 @@
+package fixtag
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Get the access time of the file, or the modification time if unknown.
+func atime(st os.FileInfo) time.Time {
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(s.Atimespec.Unix())
+	}
+	return st.ModTime()
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package fixtag
+
+import (
+	"os"
+	"time"
+)
+
+// The access time is not known on this system, the modification time is used instead.
+func atime(st os.FileInfo) time.Time {
+	return st.ModTime()
+}
@@ -0,0 +1,112 @@
+package fixtag
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// The schemes of the transliteration into Latin, see Options.
+const (
+	TranslitInformal = "informal" // the common one, e.g. "Кухня" is "Kukhnya"
+	TranslitGOST     = "gost"     // GOST R 52535.1-2006, as in the passports, e.g. "Kukhnia"
+)
+
+// The transliteration of the lower case Cyrillic letters.
+type translitScheme map[rune]string
+
+var translitSchemes = map[string]translitScheme{
+	TranslitInformal: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+		'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+		'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+		'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+		'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+		'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	},
+	TranslitGOST: {
+		'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+		'з': "z", 'и': "i", 'й': "i", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+		'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "tc",
+		'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "iu",
+		'я': "ia", 'і': "i", 'ї': "i", 'є': "ie", 'ґ': "g", 'ў': "u", 'ђ': "dj", 'ј': "j",
+		'љ': "lj", 'њ': "nj", 'ћ': "c", 'џ': "dz",
+	},
+}
+
+// Get the scheme by its name, the informal one if the name is empty.
+func translitByName(name string) (translitScheme, error) {
+	if name == "" {
+		name = TranslitInformal
+	}
+	s, ok := translitSchemes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown transliteration %q, must be %s or %s", name, TranslitInformal, TranslitGOST)
+	}
+	return s, nil
+}
+
+// Transliterate the text.  Other characters are kept, or replaced with "?"
+// if strict, so that the text is in ASCII.
+func (s translitScheme) apply(text string, strict bool) string {
+	var b strings.Builder
+	for _, c := range text {
+		if c < 0x80 {
+			b.WriteRune(c)
+			continue
+		}
+		lower := unicode.ToLower(c)
+		t, ok := s[lower]
+		switch {
+		case !ok && strict:
+			b.WriteByte('?')
+			continue
+		case !ok:
+			b.WriteRune(c)
+			continue
+		}
+		if c != lower && t != "" {
+			t = strings.ToUpper(t[:1]) + t[1:]
+		}
+		b.WriteString(t)
+	}
+	return b.String()
+}
+
+// Transliterate writes the planned text of the frames in Latin, if the
+// transliteration is set in the options.  With the transliteration, the
+// frames already correct in Cyrillic are planned too, see translitField.
+func (f *Fixer) Transliterate(p *FilePlan) {
+	for i := range p.Frames {
+		p.Frames[i].Text = f.translitText(p.Frames[i].Text)
+	}
+}
+
+// Get the text to write, transliterated if set in the options.
+func (f *Fixer) translitText(text string) string {
+	if f.opts.Translit == "" {
+		return text
+	}
+	return f.translit.apply(text, false)
+}
+
+// Check whether the scheme changes the text, i.e. it has Cyrillic letters.
+func (s translitScheme) changes(text string) bool {
+	for _, c := range text {
+		if _, ok := s[unicode.ToLower(c)]; ok && c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// Mark the correct field to be written as is, to be transliterated in the
+// plan.  It returns false if the field need not be written.
+func (f *Fixer) translitField(res *Field) bool {
+	if f.opts.Translit == "" || !f.translit.changes(res.Orig) {
+		return false
+	}
+	res.Winner = &Candidate{Chain: "translit", Charset: "utf-8", Text: res.Orig, Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
@@ -0,0 +1,100 @@
+package fixtag
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bogem/id3v2"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// The ways to fix the frames declared in Unicode.
+const (
+	fixNone     = iota // the frame is in ISO-8859-1, try all combinations
+	fixRaw             // the frame contains the raw bytes of a legacy charset
+	fixDouble          // the frame contains the double encoded UTF-8
+	fixSwap            // the UTF-16 frame has the wrong byte order
+	fixMixed           // the text mixes the correct letters and the mojibake, see convertSegments
+	fixEntities        // the text has the HTML entities, see convertEntities
+	fixPercent         // the text has the percent-encoded bytes, see newPercentCombinations
+	fixLost            // the text was destroyed before, see lostText, and is not converted
+)
+
+// The charsets the UTF-8 text is commonly mis-decoded with, so that
+// "Привет" becomes "ÐŸÑ€Ð¸Ð²ÐµÑ‚" or "РџСЂРёРІРµС‚".
+var doubleCharsets = []*charmap.Charmap{charmap.Windows1252, charmap.Windows1251}
+
+// Check whether the Unicode text looks like the double encoded UTF-8,
+// i.e. it becomes another valid UTF-8 text being encoded back.  The garbage
+// is stripped, see decode, or with replace a few characters which cannot be
+// encoded are replaced, see decodeReplacing.
+func doubleEncoded(text string, replace bool) bool {
+	text = trimGarbage(text)
+	for _, cm := range doubleCharsets {
+		b, err := decode(nopLogger{}, text, cm.NewEncoder())
+		if err != nil && replace {
+			if b, err = decodeReplacing(nopLogger{}, text, cm.NewEncoder()); strings.Count(b, string(utf8.RuneError)) > maxGarbage {
+				continue
+			}
+		}
+		if err == nil && b != text && utf8.ValidString(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build the combinations reversing the double UTF-8 encoding of the frames
+// declared in Unicode.  The transformers are stateful, see newCombinations.
+func newDoubleCombinations() []combination {
+	return []combination{
+		{"dbl-1252", CharsetUTF8, []StringTrans{charmap.Windows1252.NewEncoder()}},
+		{"dbl-1251", CharsetUTF8, []StringTrans{charmap.Windows1251.NewEncoder()}},
+	}
+}
+
+// Build the combination swapping the bytes of the UTF-16 text, which was
+// decoded with the wrong byte order, e.g. because of the missing BOM.
+func newSwapCombination() combination {
+	be := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	return combination{"swap16", CharsetUTF16LE, []StringTrans{be.NewEncoder(), le.NewDecoder()}}
+}
+
+// Find out how to fix the text of the frame declared in the Unicode encoding.
+// It fails if the text looks fine or cannot be fixed.
+func (f *Fixer) unicodeFix(key string, enc id3v2.Encoding, text string) (int, bool) {
+	switch {
+	case !utf8.ValidString(text):
+		// The frame declared as UTF-8 has the raw bytes of a legacy charset.
+		return fixRaw, true
+	case doubleEncoded(text, f.opts.ReplaceInvalid):
+		return fixDouble, true
+	case enc.Equals(id3v2.EncodingUTF16) || enc.Equals(id3v2.EncodingUTF16BE):
+		cmb := newSwapCombination()
+		swapped, err := decode(nopLogger{}, text, cmb.tlist...)
+		if err == nil && f.alpha.Goodness(swapped) >= f.threshold(key) {
+			return fixSwap, true
+		}
+	}
+	if f.opts.Segments && mixedScripts(text) {
+		return fixMixed, true
+	}
+	return fixNone, false
+}
+
+// Find out how to fix the text of the field of a tag stored in UTF-8, e.g.
+// a Vorbis comment.  Beside the broken Unicode, the legacy text mis-decoded
+// as Latin-1 is converted, as in the ISO frames.
+func (f *Fixer) utf8Fix(key, text string) (int, bool) {
+	if fix, ok := f.unicodeFix(key, id3v2.EncodingUTF8, text); ok {
+		return fix, true
+	}
+	for _, c := range text {
+		if c > 0xff {
+			return fixNone, false
+		}
+	}
+	return fixNone, true
+}
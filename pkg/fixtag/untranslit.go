@@ -0,0 +1,185 @@
+package fixtag
+
+import (
+	_ "embed" // for the dictionary
+	"log/slog"
+	"strings"
+	"unicode"
+)
+
+//go:embed ru-words.txt
+var ruWords string
+
+// The chain of the fields transliterated back into Cyrillic, see Options.
+const ChainUntranslit = "untranslit"
+
+// The Cyrillic letters of the Latin letters and their combinations, in all
+// the common schemes.  The soft and the hard signs are usually dropped, so
+// they are ignored in the dictionary.
+var untranslitLetters = map[string][]string{
+	"a": {"а"}, "b": {"б"}, "c": {"ц", "к"}, "d": {"д"}, "e": {"е", "э"}, "f": {"ф"},
+	"g": {"г"}, "h": {"х"}, "i": {"и", "й"}, "j": {"й"}, "k": {"к"}, "l": {"л"},
+	"m": {"м"}, "n": {"н"}, "o": {"о"}, "p": {"п"}, "r": {"р"}, "s": {"с"},
+	"t": {"т"}, "u": {"у"}, "v": {"в"}, "w": {"в"}, "x": {"кс"}, "y": {"ы", "й"}, "z": {"з"},
+
+	"ch": {"ч"}, "sh": {"ш"}, "zh": {"ж"}, "kh": {"х"}, "ts": {"ц"}, "tc": {"ц"},
+	"ya": {"я"}, "ia": {"я"}, "ja": {"я"}, "yu": {"ю"}, "iu": {"ю"}, "ju": {"ю"},
+	"yo": {"е"}, "jo": {"е"}, "ye": {"е"}, "ie": {"е"}, "sch": {"щ"}, "shch": {"щ"},
+}
+
+// The longest Latin combination of untranslitLetters.
+const untranslitLongest = 4
+
+// The maximal number of the spellings of a word tried.
+const untranslitSpellings = 1024
+
+// The minimal number of the letters of the text transliterated back.
+const untranslitMinLetters = 4
+
+// The dictionary of the words by their normalized spelling, see dictKey.
+type dictionary map[string][]string
+
+// Normalize the spelling of the word: lower case, without the soft and the
+// hard signs, ё as е.
+func dictKey(word string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(word) {
+		switch c {
+		case 'ь', 'ъ':
+		case 'ё':
+			b.WriteRune('е')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// Make the dictionary of the words, one per line, "#" starts a comment.
+func newDictionary(lists ...string) dictionary {
+	d := make(dictionary)
+	for _, list := range lists {
+		for _, line := range strings.Split(list, "\n") {
+			word := strings.ToLower(strings.TrimSpace(line))
+			if word == "" || strings.HasPrefix(word, "#") {
+				continue
+			}
+			key := dictKey(word)
+			known := false
+			for _, w := range d[key] {
+				known = known || w == word
+			}
+			if !known {
+				d[key] = append(d[key], word)
+			}
+		}
+	}
+	return d
+}
+
+// Get all Cyrillic spellings of the Latin word in lower case, without the
+// soft and the hard signs.
+func untranslitSpell(word string) []string {
+	// The spellings of every prefix of the word.
+	prefixes := make([][]string, len(word)+1)
+	prefixes[0] = []string{""}
+	for i := 0; i < len(word); i++ {
+		if len(prefixes[i]) == 0 {
+			continue
+		}
+		for n := 1; n <= untranslitLongest && i+n <= len(word); n++ {
+			for _, c := range untranslitLetters[word[i:i+n]] {
+				for _, p := range prefixes[i] {
+					if len(prefixes[i+n]) >= untranslitSpellings {
+						break
+					}
+					prefixes[i+n] = append(prefixes[i+n], p+c)
+				}
+			}
+		}
+	}
+	return prefixes[len(word)]
+}
+
+// Get the only word of the dictionary spelled in Latin, if any.
+func (d dictionary) untranslitWord(word string) (string, bool) {
+	found := ""
+	for _, s := range untranslitSpell(strings.ToLower(word)) {
+		words := d[s]
+		if len(words) > 1 || len(words) == 1 && found != "" && found != words[0] {
+			return "", false
+		}
+		if len(words) == 1 {
+			found = words[0]
+		}
+	}
+	if found == "" {
+		return "", false
+	}
+	// Keep the case of the word.
+	switch r := []rune(found); {
+	case len(word) > 1 && strings.ToUpper(word) == word:
+		found = strings.ToUpper(found)
+	case unicode.IsUpper(rune(word[0])):
+		r[0] = unicode.ToUpper(r[0])
+		found = string(r)
+	}
+	return found, true
+}
+
+// Transliterate the Latin text back into Cyrillic, if every word of it is
+// the only word of the dictionary with the spelling.
+func (d dictionary) untranslit(text string) (string, bool) {
+	var b strings.Builder
+	letters := 0
+	start := -1
+	for i := 0; i <= len(text); i++ {
+		var c byte
+		if i < len(text) {
+			c = text[i]
+			if c >= 0x80 {
+				return "", false
+			}
+		}
+		letter := 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+		if letter && start < 0 {
+			start = i
+		}
+		if letter {
+			continue
+		}
+		if start >= 0 {
+			word, ok := d.untranslitWord(text[start:i])
+			if !ok {
+				return "", false
+			}
+			letters += i - start
+			b.WriteString(word)
+			start = -1
+		}
+		if i < len(text) {
+			b.WriteByte(c)
+		}
+	}
+	if letters < untranslitMinLetters {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// Mark the field in Latin to be transliterated back into Cyrillic, if it is
+// set in the options and every word of the text is known.  It returns false
+// if the field need not be written.
+func (f *Fixer) untranslitField(res *Field) bool {
+	if f.words == nil {
+		return false
+	}
+	text, ok := f.words.untranslit(res.Orig)
+	if !ok {
+		return false
+	}
+	f.log.Printf(slog.LevelInfo, "frame %q transliterated back: %q", res.Name(), text)
+	res.Winner = &Candidate{Chain: ChainUntranslit, Charset: "translit", Text: text, Goodness: 1}
+	res.Action = ActionConverted
+	return true
+}
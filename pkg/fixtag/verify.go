@@ -0,0 +1,44 @@
+package fixtag
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrVerify is wrapped by the error of the file not reading back as written,
+// see Options.
+var ErrVerify = errors.New("verification failed")
+
+// Read the frames of the written file back and check that they are the same
+// as written: none is changed, lost or added.
+func verifyFrames(path string, want []FrameInfo) error {
+	got, err := ReadFrames(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrVerify, err)
+	}
+	type frameKey struct {
+		id    string
+		index int
+	}
+	read := make(map[frameKey]FrameInfo)
+	for _, fi := range got {
+		read[frameKey{fi.ID, fi.Index}] = fi
+	}
+	for _, fi := range want {
+		k := frameKey{fi.ID, fi.Index}
+		r, ok := read[k]
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: frame %s[%d] is lost", ErrVerify, fi.ID, fi.Index)
+		case r != fi:
+			return fmt.Errorf("%w: frame %s[%d] reads back as %q instead of %q", ErrVerify, fi.ID, fi.Index, r.Text, fi.Text)
+		}
+		delete(read, k)
+	}
+	for _, fi := range got {
+		if _, ok := read[frameKey{fi.ID, fi.Index}]; ok {
+			return fmt.Errorf("%w: frame %s[%d] is not written, but read back", ErrVerify, fi.ID, fi.Index)
+		}
+	}
+	return nil
+}
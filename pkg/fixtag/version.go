@@ -0,0 +1,122 @@
+package fixtag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bogem/id3v2"
+)
+
+// ParseVersion parses the ID3v2 version, "2.3" or "2.4".
+// The ID3v2.2 tags cannot be read, so they cannot be written either.
+func ParseVersion(s string) (byte, error) {
+	switch s {
+	case "2.3", "3":
+		return 3, nil
+	case "2.4", "4":
+		return 4, nil
+	}
+	return 0, fmt.Errorf("unsupported ID3v2 version %q, must be 2.3 or 2.4", s)
+}
+
+// Convert the frames of the tag to the given version and set the version.
+// The date frames are mapped to their equivalents in the version.  ID3v2.3
+// does not support UTF-8, so such frames are written in UTF-16 there.
+func setVersion(tag *id3v2.Tag, version byte) {
+	if tag.Version() == version {
+		return
+	}
+	switch version {
+	case 3:
+		downgradeDates(tag)
+		reencodeUTF8(tag)
+	case 4:
+		upgradeDates(tag)
+	}
+	tag.SetVersion(version)
+}
+
+// Get the first text frame with the key and delete all frames with it.
+func takeTextFrame(tag *id3v2.Tag, key string) (TextFrame, bool) {
+	framers := tag.GetFrames(key)
+	tag.DeleteFrames(key)
+	if len(framers) == 0 {
+		return TextFrame{}, false
+	}
+	return ToTextFrame(framers[0])
+}
+
+// Map TYER, TDAT, TIME and TORY of ID3v2.3 to TDRC and TDOR of ID3v2.4.
+// The deprecated TRDA and TSIZ are dropped.
+func upgradeDates(tag *id3v2.Tag) {
+	year, ok := takeTextFrame(tag, "TYER")
+	date, _ := takeTextFrame(tag, "TDAT")
+	tm, _ := takeTextFrame(tag, "TIME")
+	if ok && year.Text != "" {
+		ts := year.Text
+		// TDAT is DDMM and TIME is HHMM.
+		if len(date.Text) == 4 {
+			ts += "-" + date.Text[2:] + "-" + date.Text[:2]
+			if len(tm.Text) == 4 {
+				ts += "T" + tm.Text[:2] + ":" + tm.Text[2:]
+			}
+		}
+		year.Text = ts
+		tag.AddFrame("TDRC", year.Framer("TDRC"))
+	}
+	if orig, ok := takeTextFrame(tag, "TORY"); ok && orig.Text != "" {
+		tag.AddFrame("TDOR", orig.Framer("TDOR"))
+	}
+	tag.DeleteFrames("TRDA")
+	tag.DeleteFrames("TSIZ")
+}
+
+// Map TDRC and TDOR of ID3v2.4 to TYER, TDAT, TIME and TORY of ID3v2.3.
+// The timestamps are "yyyy-MM-ddTHH:mm:ss", with any part after the year optional.
+func downgradeDates(tag *id3v2.Tag) {
+	if rec, ok := takeTextFrame(tag, "TDRC"); ok && len(rec.Text) >= 4 {
+		ts := rec.Text
+		add := func(key, text string) {
+			tf := rec
+			tf.Text = text
+			tag.AddFrame(key, tf.Framer(key))
+		}
+		add("TYER", ts[:4])
+		if len(ts) >= 10 {
+			add("TDAT", ts[8:10]+ts[5:7])
+		}
+		if len(ts) >= 16 {
+			add("TIME", strings.Replace(ts[11:16], ":", "", 1))
+		}
+	}
+	if orig, ok := takeTextFrame(tag, "TDOR"); ok && len(orig.Text) >= 4 {
+		orig.Text = orig.Text[:4]
+		tag.AddFrame("TORY", orig.Framer("TORY"))
+	}
+}
+
+// Change the encoding of the UTF-8 frames to UTF-16.
+func reencodeUTF8(tag *id3v2.Tag) {
+	for key, framers := range tag.AllFrames() {
+		changed := false
+		for i, f := range framers {
+			if pic, ok := f.(id3v2.PictureFrame); ok && pic.Encoding.Equals(id3v2.EncodingUTF8) {
+				pic.Encoding = id3v2.EncodingUTF16
+				framers[i] = pic
+				changed = true
+			}
+			if tf, ok := ToTextFrame(f); ok && tf.Encoding.Equals(id3v2.EncodingUTF8) {
+				tf.Encoding = id3v2.EncodingUTF16
+				framers[i] = tf.Framer(key)
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		tag.DeleteFrames(key)
+		for _, f := range framers {
+			tag.AddFrame(key, f)
+		}
+	}
+}
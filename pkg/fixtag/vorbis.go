@@ -0,0 +1,64 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errBadComment = errors.New("malformed Vorbis comment")
+
+// Parse the Vorbis comment: the vendor string and the "NAME=value" fields,
+// all prefixed with the 32-bit little-endian length.
+func parseVorbisComment(data []byte) (string, []TagField, error) {
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, errBadComment
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return nil, errBadComment
+		}
+		s := data[4 : 4+n]
+		data = data[4+n:]
+		return s, nil
+	}
+	vendor, err := next()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(data) < 4 {
+		return "", nil, errBadComment
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var fields []TagField
+	for i := uint32(0); i < count; i++ {
+		s, err := next()
+		if err != nil {
+			return "", nil, err
+		}
+		name, value, ok := strings.Cut(string(s), "=")
+		if !ok {
+			return "", nil, errBadComment
+		}
+		fields = append(fields, TagField{Name: name, Value: value})
+	}
+	return string(vendor), fields, nil
+}
+
+// Make the Vorbis comment, see parseVorbisComment.
+func makeVorbisComment(vendor string, fields []TagField) []byte {
+	var buf bytes.Buffer
+	put := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	put(vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(fields)))
+	for _, f := range fields {
+		put(f.Name + "=" + f.Value)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,96 @@
+package fixtag
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The WAV files, with the tag in the LIST chunk of the INFO type, e.g.
+// "INAM" for the title.  The values have no charset, they are written in UTF-8.
+type wavBackend struct{}
+
+// Read the chunks of the WAV file and find the INFO list, -1 if none.
+func readWAV(f *os.File) (*iffFile, int, error) {
+	ff, err := readIFF(f, "RIFF", binary.LittleEndian)
+	if err != nil {
+		return nil, 0, err
+	}
+	if ff.form != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	for i, c := range ff.chunks {
+		if c.id != "LIST" || c.size < 4 {
+			continue
+		}
+		typ := make([]byte, 4)
+		if _, err := f.ReadAt(typ, c.data); err != nil {
+			return nil, 0, err
+		}
+		if string(typ) == "INFO" {
+			return ff, i, nil
+		}
+	}
+	return ff, -1, nil
+}
+
+func (wavBackend) Name() string {
+	return "wav"
+}
+
+func (wavBackend) Extensions() []string {
+	return []string{".wav"}
+}
+
+func (wavBackend) ReadFields(f *os.File) ([]TagField, error) {
+	ff, i, err := readWAV(f)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+	data, err := ff.read(f, i)
+	if err != nil {
+		return nil, err
+	}
+	var fields []TagField
+	for data = data[4:]; len(data) >= 8; {
+		n := int64(binary.LittleEndian.Uint32(data[4:]))
+		if n > int64(len(data)-8) {
+			return nil, fmt.Errorf("%v %q in INFO", errBadChunk, data[:4])
+		}
+		value := bytes.TrimRight(data[8:8+n], "\x00")
+		fields = append(fields, TagField{Name: string(data[:4]), Value: string(value)})
+		n += n & 1
+		if n > int64(len(data)-8) {
+			n = int64(len(data) - 8)
+		}
+		data = data[8+n:]
+	}
+	return fields, nil
+}
+
+func (wavBackend) CopyWithFields(w io.Writer, f *os.File, fields []TagField) error {
+	ff, i, err := readWAV(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("INFO")
+	for _, field := range fields {
+		if len(field.Name) != 4 {
+			return fmt.Errorf("invalid INFO field %q", field.Name)
+		}
+		// The value is zero-terminated and padded to the even size.
+		n := len(field.Value) + 1
+		buf.WriteString(field.Name)
+		binary.Write(&buf, binary.LittleEndian, uint32(n))
+		buf.WriteString(field.Value)
+		buf.WriteByte(0)
+		if n&1 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	return ff.copyWithChunk(w, f, i, "LIST", buf.Bytes())
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The plan keeps the changes proposed in the dry-run mode, so that they can be
+// reviewed, edited and written later without converting the files again.
+type plan struct {
+	mu      sync.Mutex
+	entries []*fixtag.FilePlan
+}
+
+func newPlan() *plan {
+	return &plan{entries: []*fixtag.FilePlan{}}
+}
+
+// Add the planned changes of a file.
+func (p *plan) add(fp *fixtag.FilePlan) {
+	p.mu.Lock()
+	p.entries = append(p.entries, fp)
+	p.mu.Unlock()
+}
+
+// Save the plan into the file, sorted by the file path.
+func (p *plan) save(path string) error {
+	sort.Slice(p.entries, func(i, j int) bool { return p.entries[i].File < p.entries[j].File })
+	data, err := json.MarshalIndent(p.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func readPlan(path string) ([]*fixtag.FilePlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*fixtag.FilePlan
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Queue the files of the plan for processing.
+func queuePlan(entries []*fixtag.FilePlan, queue chan<- job) {
+	defer close(queue)
+	for _, fp := range entries {
+		queue <- job{path: fp.File, plan: fp}
+	}
+}
+
+// Write the planned changes into the file.  Nothing is written if any
+// of the frames has changed since the plan was made.
+func applyPlan(ctx context.Context, log *logger, cfg *config, fp *fixtag.FilePlan) ([]*fixtag.Field, error) {
+	log.Headf(slog.LevelInfo, "applying the plan to %q...", fp.File)
+	results := fp.Fields()
+	logPlan(log, cfg, fp)
+	written, err := writePlan(ctx, log, cfg, fp)
+	if err != nil {
+		log.Errorf("failed %q: %s", fp.File, err.Error())
+	}
+	setWritten(results, written, err)
+	return results, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The width of the progress bar, in characters.
+const progressWidth = 30
+
+// How often the progress is redrawn.
+const progressInterval = 100 * time.Millisecond
+
+// The progress of processing the files, shown on a terminal in a single line.
+type progress struct {
+	w       io.Writer
+	start   time.Time
+	mu      sync.Mutex
+	total   int  // the files queued so far
+	counted bool // all files are queued
+	done    int
+	shown   bool      // the line is on the screen
+	drawn   time.Time // when the line was drawn
+}
+
+func newProgress(w io.Writer) *progress {
+	return &progress{w: w, start: time.Now()}
+}
+
+// Check whether the file is a terminal.
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// Count the jobs passing through.  The jobs are read from the source as fast
+// as possible, so that the total is known long before the files are processed.
+func (p *progress) count(in <-chan job) <-chan job {
+	out := make(chan job)
+	go func() {
+		defer close(out)
+		var pending []job
+		for in != nil || len(pending) > 0 {
+			var send chan<- job
+			var next job
+			if len(pending) > 0 {
+				send = out
+				next = pending[0]
+			}
+			select {
+			case j, ok := <-in:
+				p.mu.Lock()
+				if ok {
+					pending = append(pending, j)
+					p.total++
+				} else {
+					in = nil
+					p.counted = true
+				}
+				p.mu.Unlock()
+			case send <- next:
+				pending = pending[1:]
+			}
+		}
+	}()
+	return out
+}
+
+// Clear the progress line, e.g. before printing the output of a file.
+func (p *progress) clear() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// Count the processed file and redraw the progress line, unless it is on
+// the screen and has been drawn very recently.
+func (p *progress) step() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	now := time.Now()
+	if p.shown && now.Sub(p.drawn) < progressInterval {
+		return
+	}
+	p.shown = true
+	p.drawn = now
+	total := p.total
+	if total < p.done {
+		total = p.done
+	}
+	filled := 0
+	if total > 0 {
+		filled = progressWidth * p.done / total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+	if !p.counted {
+		fmt.Fprintf(p.w, "\r\033[K[%s] %d/%d+", bar, p.done, total)
+		return
+	}
+	fmt.Fprintf(p.w, "\r\033[K[%s] %d/%d %d%%", bar, p.done, total, 100*p.done/total)
+	if p.done < total {
+		elapsed := now.Sub(p.start)
+		eta := elapsed * time.Duration(total-p.done) / time.Duration(p.done)
+		fmt.Fprintf(p.w, " ETA %v", eta.Round(time.Second))
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// A file system on another machine.  The files are read through fs.FS and
+// written back whole, so they are processed in local copies, see queueRemote.
+// It is closed once the run ends, see fileSource.cleanup.
+type remoteFS interface {
+	fs.ReadDirFS
+	fs.StatFS
+	io.Closer
+	// WriteFile replaces the contents of the file with the data from r.
+	// With the version of the file, see versionedFile, it fails with
+	// fixtag.ErrStale if the file is of another version by now.
+	WriteFile(name string, r io.Reader, version string) error
+}
+
+// A remote file being read, which has the version, e.g. the ETag of the
+// WebDAV file, to check that it has not changed when it is written back.
+type versionedFile interface {
+	Version() string
+}
+
+// The URL schemes of the remote file systems.
+var remoteSchemes = []string{"webdav://", "webdavs://", "sftp://"}
+
+// Check whether the argument is the URL of remote files, e.g.
+// "webdav://nas/music/**.mp3" or "sftp://nas/music/**.mp3".
+func isRemote(arg string) bool {
+	for _, scheme := range remoteSchemes {
+		if strings.HasPrefix(arg, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether any of the arguments is remote.
+func hasRemote(args []string) bool {
+	for _, arg := range args {
+		if isRemote(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open the file system of the URL, returning the path of the files in it.
+func openRemote(arg string) (remoteFS, string, error) {
+	u, err := url.Parse(arg)
+	if err != nil {
+		return nil, "", err
+	}
+	name := strings.Trim(path.Clean("/"+u.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	switch u.Scheme {
+	case "webdav", "webdavs":
+		fsys, err := newWebDAV(u)
+		if err != nil {
+			return nil, "", err
+		}
+		return fsys, name, nil
+	case "sftp":
+		fsys, err := dialSFTP(u)
+		if err != nil {
+			return nil, "", err
+		}
+		return fsys, name, nil
+	}
+	return nil, "", fmt.Errorf("unknown scheme %q", u.Scheme)
+}
+
+// A remote file with its local copy.
+type remoteFile struct {
+	fsys remoteFS
+	name string // in fsys
+	url  string // for the output
+	hash string // of the local copy when it was made, see hashFile
+	// The version of the original when it was copied, empty if unknown,
+	// see versionedFile.
+	version string
+}
+
+func (r *remoteFile) String() string {
+	return r.url
+}
+
+// Copy the remote file into the local path, unless it is copied already.
+func (r *remoteFile) fetch(local string) error {
+	if r.hash != "" {
+		return nil
+	}
+	src, err := r.fsys.Open(r.name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if v, ok := src.(versionedFile); ok {
+		r.version = v.Version()
+	}
+	if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	r.hash, err = hashFile(local)
+	return err
+}
+
+func (r *remoteFile) store(local string) (bool, error) {
+	if r.hash == "" {
+		// Not copied, e.g. the run has been interrupted.
+		return false, nil
+	}
+	defer os.Remove(local)
+	hash, err := hashFile(local)
+	if err != nil {
+		return false, err
+	}
+	if hash == r.hash {
+		return false, nil
+	}
+	f, err := os.Open(local)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return true, r.fsys.WriteFile(r.name, f, r.version)
+}
+
+// Get the name of the temporary file the file is written into before it is
+// replaced, hidden next to it.
+func tempName(name string) string {
+	return path.Join(path.Dir(name), "."+path.Base(name)+".fix-mp3-tag")
+}
+
+// Queue the remote files of the argument, to be copied into the staging
+// directory when processed, see job.fetch.  The argument is a file, a glob
+// pattern or, in the recursive mode, a directory, like the local ones.
+func (s *fileSource) queueRemote(arg string, queue chan<- job) {
+	if s.stagedErr != nil {
+		queue <- job{path: arg, err: s.stagedErr}
+		return
+	}
+	fsys, name, err := openRemote(arg)
+	if err != nil {
+		queue <- job{path: arg, err: err}
+		return
+	}
+	s.mu.Lock()
+	s.remotes = append(s.remotes, fsys)
+	s.mu.Unlock()
+	u, _ := url.Parse(arg)
+	base := u.Scheme + "://" + u.Host + "/"
+	if s.stageDir == "" {
+		if s.stageDir, err = os.MkdirTemp("", "fix-mp3-tag-"); err != nil {
+			queue <- job{path: arg, err: err}
+			return
+		}
+	}
+	local := filepath.Join(s.stageDir, u.Host)
+	stage := func(name, root string) {
+		r := &remoteFile{fsys: fsys, name: name, url: base + name}
+		p := filepath.Join(local, filepath.FromSlash(name))
+		queue <- job{path: p, root: filepath.Join(local, filepath.FromSlash(root)), staged: r}
+	}
+	byExt := func(p string) bool { return hasExtension(p, s.extensions) }
+	// Walk the directory, queueing the matching files, and walking the
+	// matching directories in the recursive mode.
+	var walk func(root string, match func(string) bool) bool
+	walk = func(root string, match func(string) bool) bool {
+		found := false
+		fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				found = true
+				queue <- job{path: base + p, err: err}
+				return nil
+			}
+			if s.excluded(base + p) {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if p == root || !match(p) {
+					return nil
+				}
+				found = true
+				if s.recursive {
+					walk(p, byExt)
+				}
+				return fs.SkipDir
+			}
+			if match(p) {
+				found = true
+				stage(p, root)
+			}
+			return nil
+		})
+		return found
+	}
+	if !hasMeta(name) {
+		st, err := fsys.Stat(name)
+		switch {
+		case err != nil:
+			queue <- job{path: arg, err: err}
+		case !st.IsDir():
+			stage(name, path.Dir(name))
+		case !s.recursive:
+			queue <- job{path: arg, err: errors.New("is a directory, use -r")}
+		default:
+			walk(name, byExt)
+		}
+		return
+	}
+	segs := strings.Split(name, "/")
+	n := 0
+	for n < len(segs)-1 && !hasMeta(segs[n]) {
+		n++
+	}
+	root := strings.Join(segs[:n], "/")
+	if root == "" {
+		root = "."
+	}
+	if !walk(root, func(p string) bool { return matchGlob(name, p) }) {
+		queue <- job{path: arg, err: errNoMatch}
+	}
+}
+
+// Rewrite the archives with the files processed if they are not finished,
+// e.g. on the interrupt, close the remote file systems, and remove the local
+// copies of the remote and archived files.  The first error of the
+// rewriting is returned.
+func (s *fileSource) cleanup() error {
+	s.mu.Lock()
+	archives, remotes := s.archives, s.remotes
+	s.mu.Unlock()
+	var first error
+	for _, a := range archives {
+		a.mu.Lock()
+		if _, err := a.finish(); err != nil && first == nil {
+			first = fmt.Errorf("%s: cannot rewrite the archive: %v", a.path, err)
+		}
+		a.mu.Unlock()
+	}
+	for _, fsys := range remotes {
+		fsys.Close()
+	}
+	if s.stageDir != "" {
+		os.RemoveAll(s.stageDir)
+	}
+	return first
+}
+
+// A WebDAV share, e.g. of a NAS.  The user name and the password are taken
+// from the URL, the password from $WEBDAV_PASSWORD if not there.
+type webDAV struct {
+	base   url.URL // without the path
+	client *http.Client
+}
+
+// The environment variable allowing to send the password over plain HTTP.
+const webDAVInsecureEnv = "WEBDAV_INSECURE"
+
+// Make the share of the URL.  The password is sent in the clear over plain
+// HTTP, so with the user it is refused unless $WEBDAV_INSECURE is set,
+// e.g. for a NAS at home.
+func newWebDAV(u *url.URL) (*webDAV, error) {
+	w := &webDAV{base: url.URL{Scheme: "http", Host: u.Host, User: u.User}, client: http.DefaultClient}
+	if u.Scheme == "webdavs" {
+		w.base.Scheme = "https"
+	} else if u.User != nil && os.Getenv(webDAVInsecureEnv) == "" {
+		return nil, fmt.Errorf("refusing to send the password over plain HTTP, use webdavs:// or set $%s=1", webDAVInsecureEnv)
+	}
+	return w, nil
+}
+
+// Get the URL of the file or directory, without the user.
+func (w *webDAV) url(name string) string {
+	u := w.base
+	u.User = nil
+	u.Path = "/"
+	if name != "." {
+		u.Path += name
+	}
+	return u.String()
+}
+
+// Make the request for the file or directory.
+func (w *webDAV) request(method, name string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, w.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	if user := w.base.User; user != nil {
+		pass, ok := user.Password()
+		if !ok {
+			pass = os.Getenv("WEBDAV_PASSWORD")
+		}
+		req.SetBasicAuth(user.Username(), pass)
+	}
+	return req, nil
+}
+
+// Get the error of the response which is not successful.
+func statusError(op, name string, resp *http.Response) error {
+	err := errors.New(resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		err = fs.ErrNotExist
+	case http.StatusPreconditionFailed:
+		err = fixtag.ErrStale
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// Close closes the idle connections to the share.
+func (w *webDAV) Close() error {
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+func (w *webDAV) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	req, err := w.request(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, statusError("open", name, resp)
+	}
+	info := &remoteInfo{name: path.Base(name), size: resp.ContentLength, etag: resp.Header.Get("ETag")}
+	info.mtime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &remoteReader{ReadCloser: resp.Body, info: info}, nil
+}
+
+func (w *webDAV) Stat(name string) (fs.FileInfo, error) {
+	self, _, err := w.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if self == nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	self.name = path.Base(name)
+	return self, nil
+}
+
+func (w *webDAV) ReadDir(name string) ([]fs.DirEntry, error) {
+	_, infos, err := w.propfind(name, "1")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].name < infos[j].name })
+	var out []fs.DirEntry
+	for _, info := range infos {
+		out = append(out, fs.FileInfoToDirEntry(info))
+	}
+	return out, nil
+}
+
+// WriteFile uploads the data into a temporary file next to the file, and
+// moves it over the file, so that the file is never left half written.
+// With the ETag of the file as the version, the data is put over the file
+// with If-Match instead, so that the server only replaces it if it has not
+// changed; the servers write the data into a temporary file themselves.
+func (w *webDAV) WriteFile(name string, r io.Reader, version string) error {
+	if version != "" {
+		return w.do(http.MethodPut, name, r, http.Header{"If-Match": {version}})
+	}
+	tmp := tempName(name)
+	if err := w.do(http.MethodPut, tmp, r, nil); err != nil {
+		return err
+	}
+	err := w.do("MOVE", tmp, nil, http.Header{"Destination": {w.url(name)}, "Overwrite": {"T"}})
+	if err != nil {
+		w.do(http.MethodDelete, tmp, nil, nil)
+	}
+	return err
+}
+
+// Send the request changing the file, failing unless it is successful.
+func (w *webDAV) do(method, name string, body io.Reader, header http.Header) error {
+	req, err := w.request(method, name, body)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError("write", name, resp)
+	}
+	return nil
+}
+
+// The properties of the files requested by PROPFIND.
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+// The response to PROPFIND.
+type multistatus struct {
+	Responses []struct {
+		Href string `xml:"DAV: href"`
+		Prop struct {
+			Collection *struct{} `xml:"DAV: resourcetype>collection"`
+			Length     string    `xml:"DAV: getcontentlength"`
+			Modified   string    `xml:"DAV: getlastmodified"`
+		} `xml:"DAV: propstat>prop"`
+	} `xml:"DAV: response"`
+}
+
+// Get the properties of the file or directory, and of the files in the
+// directory with the depth "1".
+func (w *webDAV) propfind(name, depth string) (*remoteInfo, []*remoteInfo, error) {
+	if depth != "0" && name != "." {
+		// Some servers redirect to the directory with the trailing slash.
+		name += "/"
+	}
+	req, err := w.request("PROPFIND", name, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		op := "readdir"
+		if depth == "0" {
+			op = "stat"
+		}
+		return nil, nil, statusError(op, name, resp)
+	}
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var self *remoteInfo
+	var out []*remoteInfo
+	dir := strings.Trim(req.URL.Path, "/")
+	for _, r := range ms.Responses {
+		href := r.Href
+		if u, err := url.Parse(href); err == nil {
+			href = u.Path
+		}
+		href = strings.Trim(href, "/")
+		info := &remoteInfo{name: path.Base(href), dir: r.Prop.Collection != nil}
+		info.size, _ = strconv.ParseInt(r.Prop.Length, 10, 64)
+		info.mtime, _ = http.ParseTime(r.Prop.Modified)
+		if href == dir {
+			self = info
+			continue
+		}
+		out = append(out, info)
+	}
+	return self, out, nil
+}
+
+// A remote file being read.
+type remoteReader struct {
+	io.ReadCloser
+	info *remoteInfo
+}
+
+func (f *remoteReader) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// Version gets the ETag of the file, unless it is weak: If-Match never
+// matches the weak ones.
+func (f *remoteReader) Version() string {
+	if strings.HasPrefix(f.info.etag, "W/") {
+		return ""
+	}
+	return f.info.etag
+}
+
+// The properties of a remote file.
+type remoteInfo struct {
+	name  string
+	size  int64
+	mtime time.Time
+	dir   bool
+	etag  string // of the file opened, if any
+}
+
+func (i *remoteInfo) Name() string       { return i.name }
+func (i *remoteInfo) Size() int64        { return i.size }
+func (i *remoteInfo) ModTime() time.Time { return i.mtime }
+func (i *remoteInfo) IsDir() bool        { return i.dir }
+func (i *remoteInfo) Sys() interface{}   { return nil }
+
+func (i *remoteInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
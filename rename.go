@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The renamer fixes the broken names of the processed files and of their
+// directories below the walked roots, after the tags are fixed.  With the
+// template, the files are moved to the paths made of their tags instead.
+type renamer struct {
+	mu       sync.Mutex
+	paths    map[string]bool
+	template *nameTemplate     // nil if the names are fixed
+	moves    map[string]string // the new paths by the old ones, see template
+}
+
+func newRenamer(template *nameTemplate) *renamer {
+	return &renamer{paths: make(map[string]bool), template: template, moves: make(map[string]string)}
+}
+
+// Add the processed file.  The names of the file and of its directories up to
+// the root are fixed, the root itself is not renamed, as it is given by the user.
+// With the template, the new path of the file is made of its tag, the converted
+// fields of the result being used in the dry-run mode, and is relative to the root.
+func (r *renamer) add(log *logger, path, root string, res *fixtag.Result) {
+	if r.template != nil {
+		r.addMove(log, path, root, res)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rel, err := filepath.Rel(root, path)
+	if root == "" || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		r.paths[path] = true
+		return
+	}
+	for p := path; rel != "."; p, rel = filepath.Dir(p), filepath.Dir(rel) {
+		r.paths[p] = true
+	}
+}
+
+func (r *renamer) addMove(log *logger, path, root string, res *fixtag.Result) {
+	info, err := fixtag.ReadTagInfo(path)
+	if err != nil {
+		log.Printf(slog.LevelWarn, "cannot read the tag to rename: %v", err)
+		return
+	}
+	if res != nil {
+		info.Update(res)
+	}
+	name, err := r.template.expand(info, filepath.Ext(path))
+	if err != nil {
+		log.Printf(slog.LevelWarn, "not renamed: %v", err)
+		return
+	}
+	if root == "" {
+		root = filepath.Dir(path)
+	}
+	if target := filepath.Join(root, name); target != filepath.Clean(path) {
+		r.mu.Lock()
+		r.moves[path] = target
+		r.mu.Unlock()
+	}
+}
+
+// Get the paths, the deepest first, so that the names of the files are
+// fixed before their directories are renamed.
+func (r *renamer) sorted() []string {
+	out := make([]string, 0, len(r.paths))
+	for p := range r.paths {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		di := strings.Count(out[i], string(filepath.Separator))
+		dj := strings.Count(out[j], string(filepath.Separator))
+		if di != dj {
+			return di > dj
+		}
+		return out[i] < out[j]
+	})
+	return out
+}
+
+// Fix the names, renaming the files in the write mode.  An existing file
+// is never overwritten.  The renames are recorded in the journal first.
+func (r *renamer) run(cfg *config, logOut io.Writer, st *stats) {
+	taken := make(map[string]bool)
+	if r.template != nil {
+		r.move(cfg, logOut, st, taken)
+		return
+	}
+	for _, path := range r.sorted() {
+		log := newLogger(cfg.level, cfg.format, path)
+		name, field := cfg.fixer.WithLogger(log).FixName(filepath.Base(path))
+		switch {
+		case name != "":
+			target := filepath.Join(filepath.Dir(path), name)
+			log.Headf(slog.LevelInfo, "name to fix: %q => %q", path, target)
+			if err := renameFile(cfg, path, target, taken); err != nil {
+				log.Errorf("failed to rename %q: %s", path, err.Error())
+				st.Errors++
+			} else {
+				st.Renamed++
+			}
+		case field == nil:
+		case field.Action == fixtag.ActionAmbiguous:
+			log.Headf(slog.LevelWarn, "name %q is ambiguous, not renamed", path)
+		default:
+			log.Headf(slog.LevelInfo, "cannot convert name %q", path)
+		}
+		log.flush(logOut)
+	}
+}
+
+// Move the files to the paths made by the template, in the order of the paths.
+func (r *renamer) move(cfg *config, logOut io.Writer, st *stats, taken map[string]bool) {
+	paths := make([]string, 0, len(r.moves))
+	for p := range r.moves {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		log := newLogger(cfg.level, cfg.format, path)
+		log.Headf(slog.LevelInfo, "file to move: %q => %q", path, r.moves[path])
+		if err := renameFile(cfg, path, r.moves[path], taken); err != nil {
+			log.Errorf("failed to move %q: %s", path, err.Error())
+			st.Errors++
+		} else {
+			st.Renamed++
+		}
+		log.flush(logOut)
+	}
+}
+
+// Rename the file unless the target exists or is taken by another rename.
+// In the dry-run mode, the target is only checked.
+func renameFile(cfg *config, path, target string, taken map[string]bool) error {
+	if taken[target] {
+		return fmt.Errorf("%q is the new name of another file", target)
+	}
+	if _, err := os.Lstat(target); err == nil {
+		return fmt.Errorf("%q already exists", target)
+	}
+	taken[target] = true
+	if !cfg.write {
+		return nil
+	}
+	if cfg.journal != nil {
+		if err := cfg.journal.recordRename(path, target); err != nil {
+			return fmt.Errorf("journal: %v", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	return os.Rename(path, target)
+}
+
+// The template of the path of the file, e.g. "{artist}/{album}/{track:02d} - {title}",
+// see fixtag.TagInfoNames.  The value may have the format after the colon:
+// "d" for the number, e.g. of the track "3/12", or "s" for the text.
+type nameTemplate struct {
+	parts []templatePart
+}
+
+// A part of the template, either the text or the value.
+type templatePart struct {
+	text   string
+	name   string
+	format string
+}
+
+var templateFormat = regexp.MustCompile(`^-?0?[0-9]*[ds]$`)
+
+func parseTemplate(s string) (*nameTemplate, error) {
+	if s == "" || filepath.IsAbs(s) || strings.HasPrefix(s, "/") {
+		return nil, fmt.Errorf("the template %q must be a relative path", s)
+	}
+	for _, seg := range strings.Split(filepath.ToSlash(s), "/") {
+		if seg == ".." {
+			return nil, fmt.Errorf("the template %q must not go up", s)
+		}
+	}
+	t := &nameTemplate{}
+	for s != "" {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			t.parts = append(t.parts, templatePart{text: s})
+			break
+		}
+		if i > 0 {
+			t.parts = append(t.parts, templatePart{text: s[:i]})
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			return nil, fmt.Errorf("unclosed %q in the template", s[i:])
+		}
+		name, format, _ := strings.Cut(s[i+1:i+j], ":")
+		if !slices.Contains(fixtag.TagInfoNames(), name) {
+			return nil, fmt.Errorf("unknown value {%s} in the template, must be one of %s", name, strings.Join(fixtag.TagInfoNames(), ", "))
+		}
+		if format != "" && !templateFormat.MatchString(format) {
+			return nil, fmt.Errorf("invalid format %q of {%s} in the template", format, name)
+		}
+		t.parts = append(t.parts, templatePart{name: name, format: format})
+		s = s[i+j+1:]
+	}
+	return t, nil
+}
+
+// Make the path of the file with the tag, adding the extension if the template
+// has no such.  The separators in the values are replaced.
+func (t *nameTemplate) expand(info fixtag.TagInfo, ext string) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.name == "" {
+			b.WriteString(p.text)
+			continue
+		}
+		v := info[p.name]
+		if v == "" {
+			return "", fmt.Errorf("no %s in the tag", p.name)
+		}
+		if p.name == "year" && len(v) > 4 {
+			// The recording time, e.g. "1988-05-01".
+			v = v[:4]
+		}
+		switch {
+		case strings.HasSuffix(p.format, "d"):
+			// The number may have the total, e.g. "3/12".
+			num, _, _ := strings.Cut(v, "/")
+			n, err := strconv.Atoi(strings.TrimSpace(num))
+			if err != nil {
+				return "", fmt.Errorf("the %s %q is not a number", p.name, v)
+			}
+			v = fmt.Sprintf("%"+p.format, n)
+		case p.format != "":
+			v = fmt.Sprintf("%"+p.format, v)
+		}
+		v = strings.Map(func(c rune) rune {
+			if c == '/' || c == '\\' || c == 0 {
+				return '_'
+			}
+			return c
+		}, v)
+		if v == "." || v == ".." {
+			v = "_"
+		}
+		b.WriteString(v)
+	}
+	name := filepath.Clean(filepath.FromSlash(b.String()))
+	if !strings.EqualFold(filepath.Ext(name), ext) {
+		name += ext
+	}
+	return name, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// The format of the JSON report.
+type reportFormat string
+
+const (
+	reportArray  reportFormat = "array"
+	reportNDJSON reportFormat = "ndjson"
+	reportCSV    reportFormat = "csv"  // see -csv
+	reportHTML   reportFormat = "html" // see -html
+)
+
+func (f *reportFormat) String() string {
+	return string(*f)
+}
+
+func (f *reportFormat) Set(value string) error {
+	switch value {
+	case "true", string(reportArray):
+		*f = reportArray
+	case string(reportNDJSON):
+		*f = reportNDJSON
+	case "false":
+		*f = ""
+	default:
+		return fmt.Errorf("unknown report format %q", value)
+	}
+	return nil
+}
+
+// Allow -json without a value.
+func (f *reportFormat) IsBoolFlag() bool {
+	return true
+}
+
+// A single entry of the report, one per frame.
+type reportEntry struct {
+	File     string  `json:"file"`
+	Frame    string  `json:"frame"`
+	Index    int     `json:"index"` // among the frames with the same id
+	Field    string  `json:"field"`
+	Original string  `json:"original"` // hex of the original bytes
+	Text     string  `json:"text,omitempty"`
+	Chain    string  `json:"chain,omitempty"`
+	Charset  string  `json:"charset,omitempty"`
+	Goodness float64 `json:"goodness"`
+	Action   string  `json:"action"`
+	Orig     string  `json:"-"` // the original text, for the HTML report
+}
+
+// The columns of the CSV report, as the fields of reportEntry.
+var csvColumns = []string{"file", "frame", "index", "field", "original", "text", "chain", "charset", "goodness", "action"}
+
+// The report of frame conversions in JSON or CSV.
+// The array is written at once when the report is closed,
+// while NDJSON entries and CSV rows are written as soon as they are added.
+type report struct {
+	w       io.Writer
+	format  reportFormat
+	entries []reportEntry
+	csv     *csv.Writer // for reportCSV
+	err     error
+}
+
+func newReport(w io.Writer, format reportFormat) *report {
+	r := &report{w: w, format: format, entries: []reportEntry{}}
+	if format == reportCSV {
+		r.csv = csv.NewWriter(w)
+		r.err = r.csv.Write(csvColumns)
+	}
+	return r
+}
+
+// Restore the original bytes of the frame text, as the ISO-8859-1 decoding is reversible.
+func originalBytes(text string) []byte {
+	b, err := charmap.ISO8859_1.NewEncoder().Bytes([]byte(text))
+	if err != nil {
+		return []byte(text)
+	}
+	return b
+}
+
+// Add the results for the file to the report.
+func (r *report) add(path string, results []*fixtag.Field) {
+	for _, res := range results {
+		e := reportEntry{
+			File:     path,
+			Frame:    res.Key,
+			Index:    res.Index,
+			Field:    res.Field,
+			Original: hex.EncodeToString(originalBytes(res.Orig)),
+			Goodness: res.Best,
+			Action:   res.Action,
+			Orig:     res.Orig,
+		}
+		if res.Winner != nil {
+			e.Text = res.Winner.Text
+			e.Chain = res.Winner.Chain
+			e.Charset = res.Winner.Charset
+			e.Goodness = res.Winner.Goodness
+		}
+		switch r.format {
+		case reportNDJSON:
+			if err := json.NewEncoder(r.w).Encode(e); err != nil && r.err == nil {
+				r.err = err
+			}
+		case reportCSV:
+			row := []string{e.File, e.Frame, strconv.Itoa(e.Index), e.Field, e.Original,
+				e.Text, e.Chain, e.Charset, strconv.FormatFloat(e.Goodness, 'f', 3, 64), e.Action}
+			if err := r.csv.Write(row); err != nil && r.err == nil {
+				r.err = err
+			}
+		default:
+			r.entries = append(r.entries, e)
+		}
+	}
+}
+
+// Finish the report, returning the first error encountered.
+func (r *report) close() error {
+	if r.csv != nil {
+		r.csv.Flush()
+		if r.err == nil {
+			r.err = r.csv.Error()
+		}
+	}
+	if r.format == reportHTML && r.err == nil {
+		return htmlTemplate.Execute(r.w, htmlDirs(r.entries))
+	}
+	if r.err != nil || r.format != reportArray {
+		return r.err
+	}
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.entries)
+}
+
+//go:embed report.html
+var htmlPage string
+
+var htmlTemplate = template.Must(template.New("report").Parse(htmlPage))
+
+// The entries of the HTML report in a directory.
+type htmlDir struct {
+	Dir     string
+	Entries []htmlEntry
+}
+
+// The entry of the HTML report, with the name of the file and the class of
+// its row by the confidence of the conversion.
+type htmlEntry struct {
+	reportEntry
+	Name  string
+	Class string
+}
+
+// Group the entries of the HTML report by the directories, sorted by name.
+func htmlDirs(entries []reportEntry) []htmlDir {
+	byDir := make(map[string][]htmlEntry)
+	for _, e := range entries {
+		he := htmlEntry{reportEntry: e, Name: filepath.Base(e.File)}
+		switch {
+		case e.Action == fixtag.ActionAmbiguous:
+			he.Class = "ambiguous"
+		case e.Action == fixtag.ActionLost:
+			he.Class = "lost"
+		case e.Action != fixtag.ActionConverted && e.Action != fixtag.ActionWritten:
+			he.Class = "bad"
+		case e.Goodness < 1:
+			he.Class = "fair"
+		default:
+			he.Class = "good"
+		}
+		dir := filepath.Dir(e.File)
+		byDir[dir] = append(byDir[dir], he)
+	}
+	out := make([]htmlDir, 0, len(byDir))
+	for dir, entries := range byDir {
+		out = append(out, htmlDir{Dir: dir, Entries: entries})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Dir < out[j].Dir })
+	return out
+}
+
+// The reports written at once, e.g. JSON to stdout and CSV into a file.
+type reports []*report
+
+// Add the results for the file to every report.
+func (rs reports) add(path string, results []*fixtag.Field) {
+	for _, r := range rs {
+		r.add(path, results)
+	}
+}
+
+// Finish every report, returning the first error encountered.
+func (rs reports) close() error {
+	var first error
+	for _, r := range rs {
+		if err := r.close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
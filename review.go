@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+//go:embed review.html
+var reviewPage string
+
+var reviewTemplate = template.Must(template.New("review").Parse(reviewPage))
+
+// The number of the conversions shown for the field not converted.
+const reviewAttempts = 8
+
+// The review server lists the fields which could not be converted for sure,
+// i.e. ambiguous or below the threshold, with their candidates side by side,
+// and writes the candidate chosen by the user.
+type reviewServer struct {
+	mu     sync.Mutex
+	cfg    *config
+	logOut io.Writer
+	files  map[string]*fixtag.Result
+	// The token of the forms of the page, so that the other sites cannot
+	// make the browser write the files.
+	token string
+}
+
+func newReviewServer(cfg *config, logOut io.Writer) *reviewServer {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return &reviewServer{cfg: cfg, logOut: logOut, files: make(map[string]*fixtag.Result), token: hex.EncodeToString(b)}
+}
+
+// Keep the result of the file if it has fields to review, the previous result
+// of the file is replaced, e.g. after the file is written.
+func (s *reviewServer) add(res *fixtag.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, res.File)
+	for _, field := range res.Fields() {
+		if len(reviewCandidates(field)) > 0 {
+			s.files[res.File] = res
+			return
+		}
+	}
+}
+
+// Get the candidates of the field to review: all of them if the conversion
+// is ambiguous, or the best conversions tried if none is good enough.
+func reviewCandidates(field *fixtag.Field) []*fixtag.Candidate {
+	switch field.Action {
+	case fixtag.ActionAmbiguous:
+		return field.Candidates
+	case fixtag.ActionFailed:
+		var out []*fixtag.Candidate
+		for i := range field.Attempts {
+			if field.Attempts[i].Err == nil && field.Attempts[i].Text != "" {
+				out = append(out, &field.Attempts[i].Candidate)
+			}
+		}
+		sort.SliceStable(out, func(i, j int) bool { return out[i].Goodness > out[j].Goodness })
+		if len(out) > reviewAttempts {
+			out = out[:reviewAttempts]
+		}
+		return out
+	}
+	return nil
+}
+
+// The data of the page.
+type reviewPageData struct {
+	Token string
+	Files []reviewFile
+}
+
+type reviewFile struct {
+	File   string
+	Fields []reviewField
+}
+
+type reviewField struct {
+	Name       string
+	Orig       string
+	Action     string
+	Candidates []*fixtag.Candidate
+}
+
+func (s *reviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		s.list(w)
+	case r.URL.Path == "/apply" && r.Method == http.MethodPost:
+		if !s.sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		if err := s.apply(r.FormValue("file"), r.FormValue("field"), r.FormValue("chain")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Check that the form is posted from the page of the server: the token of
+// the page is given, and the origin, if sent by the browser, is the server.
+func (s *reviewServer) sameOrigin(r *http.Request) bool {
+	if subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(s.token)) != 1 {
+		return false
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// Show the page with the fields to review, sorted by the file.
+func (s *reviewServer) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	var files []reviewFile
+	for path, res := range s.files {
+		rf := reviewFile{File: path}
+		for _, field := range res.Fields() {
+			if cands := reviewCandidates(field); len(cands) > 0 {
+				rf.Fields = append(rf.Fields, reviewField{Name: field.Name(), Orig: fixtag.Dump(field.Orig), Action: field.Action, Candidates: cands})
+			}
+		}
+		files = append(files, rf)
+	}
+	s.mu.Unlock()
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := reviewTemplate.Execute(w, reviewPageData{Token: s.token, Files: files}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Choose the candidate of the field, and write the frame once all its fields
+// are chosen.  The frame is not written if the file has changed since.
+func (s *reviewServer) apply(path, name, chain string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, ok := s.files[path]
+	if !ok {
+		return fmt.Errorf("no file %q to review", path)
+	}
+	for _, fi := range res.Frames {
+		for _, field := range fi.Fields {
+			if field.Name() != name {
+				continue
+			}
+			for _, c := range reviewCandidates(field) {
+				if c.Chain != chain {
+					continue
+				}
+				field.Winner = c
+				field.Action = fixtag.ActionConverted
+				for _, other := range fi.Fields {
+					if other.Action != fixtag.ActionConverted {
+						// The other fields of the frame are still to be chosen.
+						return nil
+					}
+				}
+				fp := (&fixtag.Result{File: path, Frames: []*fixtag.Frame{fi}}).Plan()
+				if err := s.cfg.fixer.SortFrames(fp); err != nil {
+					return err
+				}
+				s.cfg.fixer.Transliterate(fp)
+				log := newLogger(s.cfg.level, s.cfg.format, path)
+				log.Headf(slog.LevelInfo, "reviewed file %q:", path)
+				logPlan(log, s.cfg, fp)
+				written, err := writePlan(context.Background(), log, s.cfg, fp)
+				// Only the fields of the frame are written, the other frames
+				// of the file are kept as they are.
+				setWritten(fi.Fields, written, err)
+				s.logOut.Write(log.buf.Bytes())
+				return err
+			}
+			return fmt.Errorf("no candidate %q of %s", chain, name)
+		}
+	}
+	return fmt.Errorf("no field %s in %q", name, path)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bogem/id3v2"
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// Write the MP3 with the comment of the description and the text given.
+func writeCommentMP3(t *testing.T, path, desc, text string) {
+	t.Helper()
+	tag := id3v2.NewEmptyTag()
+	tag.SetVersion(3)
+	tag.AddCommentFrame(id3v2.CommentFrame{Encoding: id3v2.EncodingISO, Language: "rus", Description: desc, Text: text})
+	var b strings.Builder
+	if _, err := tag.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	b.WriteString(strings.Repeat("\xff\xfb\x90\x00", 64))
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readComment(t *testing.T, path string) id3v2.CommentFrame {
+	t.Helper()
+	tag, err := id3v2.Open(path, id3v2.Options{Parse: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tag.Close()
+	frames := tag.GetFrames("COMM")
+	if len(frames) != 1 {
+		t.Fatalf("%d comments", len(frames))
+	}
+	return frames[0].(id3v2.CommentFrame)
+}
+
+func TestReviewServer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	// "Звезда" and "Группа крови" in cp1251 read as Latin-1.
+	writeCommentMP3(t, path, "Çâåçäà", "Ãðóïïà êðîâè")
+	fixer, err := fixtag.New(fixtag.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := fixer.Plan(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var comm *fixtag.Frame
+	for _, fi := range res.Frames {
+		if fi.Key == "COMM" {
+			comm = fi
+		}
+	}
+	if comm == nil || len(comm.Fields) != 2 {
+		t.Fatalf("comment frame to convert = %+v", comm)
+	}
+	// Both fields of the frame are to be chosen by hand.
+	for _, field := range comm.Fields {
+		field.Action = fixtag.ActionAmbiguous
+		field.Candidates = []*fixtag.Candidate{{Chain: "a", Text: field.Field + " a"}, {Chain: "b", Text: field.Field + " b"}}
+	}
+	cfg := &config{level: slog.LevelWarn, format: logPlain, fixer: fixer, write: true}
+	s := newReviewServer(cfg, io.Discard)
+	s.add(res)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	page, err := client.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(page.Body)
+	page.Body.Close()
+	if !strings.Contains(string(body), `name="token" value="`+s.token+`"`) {
+		t.Fatalf("the page has no token:\n%s", body)
+	}
+
+	post := func(field, chain, token, origin string) int {
+		t.Helper()
+		form := url.Values{"file": {path}, "field": {field}, "chain": {chain}, "token": {token}}
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/apply", strings.NewReader(form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	for _, tt := range []struct {
+		name, token, origin string
+	}{
+		{"no token", "", ""},
+		{"wrong token", "0123", ""},
+		{"other site", s.token, "http://example.com"},
+	} {
+		if code := post("COMM", "a", tt.token, tt.origin); code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want %d", tt.name, code, http.StatusForbidden)
+		}
+	}
+	if comm.Fields[1].Action != fixtag.ActionAmbiguous {
+		t.Fatalf("the refused request chose the field: %s", comm.Fields[1].Action)
+	}
+
+	if code := post("COMM/description", "a", s.token, srv.URL); code != http.StatusSeeOther {
+		t.Fatalf("first field: status %d", code)
+	}
+	if c := readComment(t, path); c.Description != "Çâåçäà" {
+		t.Fatalf("the frame is written before all its fields are chosen: %q", c.Description)
+	}
+	if code := post("COMM", "b", s.token, ""); code != http.StatusSeeOther {
+		t.Fatalf("second field: status %d", code)
+	}
+	if c := readComment(t, path); c.Description != "description a" || c.Text != "text b" {
+		t.Errorf("written comment = %q, %q", c.Description, c.Text)
+	}
+	for _, field := range comm.Fields {
+		if field.Action != fixtag.ActionWritten {
+			t.Errorf("field %s: action %s", field.Name(), field.Action)
+		}
+	}
+	for _, fi := range res.Frames {
+		for _, field := range fi.Fields {
+			if fi != comm && field.Action == fixtag.ActionWritten {
+				t.Errorf("field %s of another frame is marked written", field.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// A file needing fixing in the JSON output of the scan subcommand.  It is
+// accepted by -files-from like the report.
+type scannedFile struct {
+	File   string   `json:"file"`
+	Frames []string `json:"frames"`
+}
+
+// List the files which may need fixing, see fixtag.SuspectFrames.
+func runScan(args []string) {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "Scan directories recursively")
+	asJSON := fs.Bool("json", false, "Print the files with their frames in JSON")
+	exts := fs.String("ext", strings.Join(fixtag.Extensions(), ","), "Comma-separated list of file extensions to scan in recursive mode")
+	var excludes patternList
+	fs.Var(&excludes, "exclude", "Skip files and directories matching the pattern, e.g. \"*/Podcasts/*\"; may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s scan [flags] <file or directory>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	src := &fileSource{
+		recursive:  *recursive,
+		extensions: parseExtensions(*exts),
+		excludes:   excludes,
+	}
+	queue := make(chan job)
+	go src.queueFiles(fs.Args(), queue)
+	failed := false
+	out := []scannedFile{}
+	for j := range queue {
+		j.fetch()
+		if j.err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", j.path, j.err)
+			failed = true
+			continue
+		}
+		keys, err := fixtag.SuspectFrames(j.path)
+		name := j.path
+		if j.staged != nil {
+			name = j.staged.String()
+			j.staged.store(j.path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed: %v\n", name, err)
+			failed = true
+			continue
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		if *asJSON {
+			out = append(out, scannedFile{File: name, Frames: keys})
+			continue
+		}
+		fmt.Println(name)
+	}
+	src.cleanup()
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
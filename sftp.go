@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// An SFTP server, e.g. of a NAS.  The paths of the URLs are absolute, e.g.
+// "sftp://nas/music" is "/music" on the server.  The connection is kept
+// until the run ends, see Close.
+type sftpFS struct {
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// Connect to the SFTP server of the URL.  The host key is checked with
+// ~/.ssh/known_hosts.  The user is authenticated with the SSH agent, the
+// keys in ~/.ssh without a passphrase, or the password from the URL or
+// $SFTP_PASSWORD.
+func dialSFTP(u *url.URL) (*sftpFS, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("cannot check the host key: %v", err)
+	}
+	cfg := &ssh.ClientConfig{User: os.Getenv("USER"), HostKeyCallback: hostKeys, Timeout: 30 * time.Second}
+	if u.User != nil {
+		cfg.User = u.User.Username()
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			cfg.Auth = append(cfg.Auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		cfg.Auth = append(cfg.Auth, ssh.PublicKeys(signers...))
+	}
+	pass, ok := "", false
+	if u.User != nil {
+		pass, ok = u.User.Password()
+	}
+	if !ok {
+		pass, ok = os.LookupEnv("SFTP_PASSWORD")
+	}
+	if ok {
+		cfg.Auth = append(cfg.Auth, ssh.Password(pass))
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return newSFTP(host, cfg)
+}
+
+// Connect to the SFTP subsystem of the SSH server.
+func newSFTP(host string, cfg *ssh.ClientConfig) (*sftpFS, error) {
+	conn, err := ssh.Dial("tcp", host, cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &sftpFS{conn: conn, client: client}, nil
+}
+
+// Close closes the SFTP session and the SSH connection.
+func (s *sftpFS) Close() error {
+	err := s.client.Close()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Get the absolute path of the file on the server.
+func sftpPath(name string) string {
+	if name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+// Get the error of the operation with the name of the file in fsys, rather
+// than its path on the server.
+func sftpError(op, name string, err error) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (s *sftpFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := s.client.Open(sftpPath(name))
+	if err != nil {
+		return nil, sftpError("open", name, err)
+	}
+	return f, nil
+}
+
+func (s *sftpFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	st, err := s.client.Stat(sftpPath(name))
+	if err != nil {
+		return nil, sftpError("stat", name, err)
+	}
+	return st, nil
+}
+
+func (s *sftpFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	infos, err := s.client.ReadDir(sftpPath(name))
+	if err != nil {
+		return nil, sftpError("readdir", name, err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	out := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		out[i] = fs.FileInfoToDirEntry(info)
+	}
+	return out, nil
+}
+
+// WriteFile writes the data into a temporary file next to the file, with
+// the permissions of the file, and renames it over the file, so that the
+// file is never left half written.  The files opened have no version, so
+// there is none to check.
+func (s *sftpFS) WriteFile(name string, r io.Reader, version string) error {
+	if !fs.ValidPath(name) || name == "." {
+		return &fs.PathError{Op: "write", Path: name, Err: fs.ErrInvalid}
+	}
+	tmp := sftpPath(tempName(name))
+	f, err := s.client.Create(tmp)
+	if err != nil {
+		return sftpError("write", name, err)
+	}
+	if st, err := s.client.Stat(sftpPath(name)); err == nil {
+		err = f.Chmod(st.Mode().Perm())
+	}
+	if err == nil {
+		_, err = io.Copy(f, r)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = s.replace(tmp, sftpPath(name))
+	}
+	if err != nil {
+		s.client.Remove(tmp)
+		return sftpError("write", name, err)
+	}
+	return nil
+}
+
+// Rename the file over the other one, atomically if the server supports
+// the POSIX rename extension.
+func (s *sftpFS) replace(from, to string) error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(from, to)
+	}
+	if err := s.client.Remove(to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return s.client.Rename(from, to)
+}
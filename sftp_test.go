@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Start the SSH server with the SFTP subsystem of the local file system,
+// and connect to it.
+func startSFTP(t *testing.T) *sftpFS {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &ssh.ServerConfig{PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+		if c.User() == "me" && string(pass) == "secret" {
+			return nil, nil
+		}
+		return nil, errors.New("denied")
+	}}
+	cfg.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for nc := range chans {
+			ch, reqs, err := nc.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				for req := range reqs {
+					ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+					req.Reply(ok, nil)
+					if ok {
+						go func() {
+							if srv, err := sftp.NewServer(ch); err == nil {
+								srv.Serve()
+							}
+							ch.Close()
+						}()
+					}
+				}
+			}()
+		}
+	}()
+	s, err := newSFTP(l.Addr().String(), &ssh.ClientConfig{
+		User:            "me",
+		Auth:            []ssh.AuthMethod{ssh.Password("secret")},
+		HostKeyCallback: ssh.FixedHostKey(signer.PublicKey()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSFTP(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "music", "album"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	local := filepath.Join(root, "music", "album", "song.mp3")
+	song := strings.Repeat("ID3 data ", 10000)
+	if err := os.WriteFile(local, []byte(song), 0640); err != nil {
+		t.Fatal(err)
+	}
+	s := startSFTP(t)
+	// The names are relative to the root of the server.
+	dir := strings.TrimPrefix(filepath.ToSlash(root), "/")
+	name := dir + "/music/album/song.mp3"
+
+	var files []string
+	err := fs.WalkDir(s, dir, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, p)
+		}
+		return err
+	})
+	if err != nil || len(files) != 1 || files[0] != name {
+		t.Fatalf("WalkDir() = %v, %v", files, err)
+	}
+	data, err := fs.ReadFile(s, name)
+	if err != nil || string(data) != song {
+		t.Fatalf("ReadFile() = %d bytes, %v", len(data), err)
+	}
+	if _, err := s.Stat(dir + "/music/missing.mp3"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat() of the missing file error = %v", err)
+	}
+	if err := s.WriteFile(name, strings.NewReader("fixed"), ""); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(local)
+	if err != nil || string(data) != "fixed" {
+		t.Errorf("written file = %q, %v", data, err)
+	}
+	st, err := os.Stat(local)
+	if err != nil || st.Mode().Perm() != 0640 {
+		t.Errorf("written file mode = %v, %v", st.Mode(), err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(local))
+	if err != nil || len(entries) != 1 {
+		t.Errorf("the temporary file is left: %v, %v", entries, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat(name); err == nil {
+		t.Error("Stat() after Close() has succeeded")
+	}
+}
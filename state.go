@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The state database keeps the size, the modification time and the hash of
+// the results of every processed file, so that the files not changed since
+// are skipped by the next runs with the same options.  It is a file of JSON
+// lines appended to after every file, the later lines overriding the earlier
+// ones; it is compacted when opened.  The start and the end of every batch
+// are recorded too, so that the interrupted batch can be resumed, see
+// -resume.
+//
+// The appended records are not synced: the state only saves work, and the
+// records lost by a crash merely make their files processed again.
+type stateDB struct {
+	mu      sync.Mutex
+	f       *os.File
+	options string // the fingerprint of the options of the run, see optionsHash
+	// The fingerprint of the .fixtagrc files of the file, see
+	// dirFixers.options, or nil.
+	dirOptions func(path string) string
+	files      map[string]stateEntry
+	run        *stateRun       // the last batch if not finished, nil otherwise
+	batch      map[string]bool // the files processed by the run
+}
+
+// A batch of files processed from the command line.
+type stateRun struct {
+	Dir     string    `json:"dir"`  // the working directory
+	Args    []string  `json:"args"` // the command line, without the program
+	Started time.Time `json:"started"`
+}
+
+// A state record for a single file.
+type stateEntry struct {
+	File journalPath `json:"file"` // absolute
+	fileStamp
+	// Hash of the results of the conversion, see resultHash.
+	Hash string `json:"hash"`
+	// The fingerprint of the options the file was processed with, see
+	// optionsHash.
+	Options string `json:"options"`
+	// Some fixes were not written, e.g. in the dry-run mode, or some frames
+	// could not be converted for sure, so the file is processed again by the
+	// next run.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// A state record for the start or the end of a batch.
+type stateMark struct {
+	Run      *stateRun `json:"run,omitempty"`
+	Finished bool      `json:"finished,omitempty"`
+}
+
+// Open the state database of the run with the options of the fingerprint,
+// creating it and its directory if missing.
+func openState(path, options string) (*stateDB, error) {
+	db := &stateDB{options: options, files: make(map[string]stateEntry)}
+	if err := db.load(path); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Rewrite the file with a single line per file, atomically.
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	// The files of the unfinished batch follow its start.
+	var keys, batch []string
+	for key := range db.files {
+		if db.batch[key] {
+			batch = append(batch, key)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	sort.Strings(batch)
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, key := range keys {
+		enc.Encode(db.files[key])
+	}
+	if db.run != nil {
+		enc.Encode(stateMark{Run: db.run})
+		for _, key := range batch {
+			enc.Encode(db.files[key])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// The state is not lost if the system crashes after the rename.
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	if d, err := os.Open(filepath.Dir(path)); err == nil {
+		// Not all systems can sync a directory.
+		d.Sync()
+		d.Close()
+	}
+	db.f, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Read the records of the file, the missing file is an empty database.
+// The last line may be cut short by a crash, and is ignored.
+func (db *stateDB) load(path string) error {
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	var bad error
+	for n := 1; sc.Scan(); n++ {
+		if bad != nil {
+			return bad
+		}
+		var e struct {
+			stateEntry
+			stateMark
+		}
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			bad = fmt.Errorf("%s:%d: %v", path, n, err)
+			continue
+		}
+		switch {
+		case e.Run != nil:
+			db.run = e.Run
+			db.batch = make(map[string]bool)
+		case e.Finished:
+			db.run = nil
+			db.batch = nil
+		default:
+			db.files[string(e.File)] = e.stateEntry
+			if db.run != nil {
+				db.batch[string(e.File)] = true
+			}
+		}
+	}
+	return sc.Err()
+}
+
+// Check whether the file has been processed with the same options with
+// nothing left to fix, and has not changed since.
+func (db *stateDB) unchanged(path string) bool {
+	key, stamp, err := stampOf(path)
+	if err != nil {
+		// Let the processing report the error.
+		return false
+	}
+	options := db.optionsOf(path)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	e, ok := db.files[key]
+	return ok && !e.Pending && e.Options == options && e.Size == stamp.Size && e.ModTime.Equal(stamp.ModTime)
+}
+
+// Get the fingerprint of the options the file is processed with: the ones
+// of the run and the ones of the .fixtagrc files of its directories.
+func (db *stateDB) optionsOf(path string) string {
+	if db.dirOptions == nil {
+		return db.options
+	}
+	if dir := db.dirOptions(path); dir != "" {
+		return db.options + "+" + dir
+	}
+	return db.options
+}
+
+// Record the processed file with the results of its conversion.
+func (db *stateDB) record(path string, results []*fixtag.Field) error {
+	key, stamp, err := stampOf(path)
+	if err != nil {
+		return err
+	}
+	e := stateEntry{File: journalPath(key), fileStamp: stamp, Hash: resultHash(results), Options: db.optionsOf(path), Pending: pending(results)}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.files[key] = e
+	if db.run != nil {
+		db.batch[key] = true
+	}
+	return db.write(e)
+}
+
+// Record the start of a batch with its command line.
+func (db *stateDB) begin(args []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	run := &stateRun{Dir: dir, Args: args, Started: time.Now().UTC()}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.run = run
+	db.batch = make(map[string]bool)
+	return db.write(stateMark{Run: run})
+}
+
+// Record the end of the batch, which is not to be resumed then.
+func (db *stateDB) finish() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.run = nil
+	db.batch = nil
+	return db.write(stateMark{Finished: true})
+}
+
+// Check whether the file has been processed by the unfinished batch.
+func (db *stateDB) processed(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.batch[abs]
+}
+
+// Append the record to the file.
+func (db *stateDB) write(e interface{}) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = db.f.Write(append(line, '\n'))
+	return err
+}
+
+func (db *stateDB) close() error {
+	return db.f.Close()
+}
+
+// A flag taking any value, for looking at the command line before parsing it.
+type scratchFlag struct {
+	value  string
+	isBool bool
+}
+
+func (f *scratchFlag) String() string     { return f.value }
+func (f *scratchFlag) Set(v string) error { f.value = v; return nil }
+func (f *scratchFlag) IsBoolFlag() bool   { return f.isBool }
+
+// Get the command line to parse the flags from: the one given, or with
+// -resume the command line of the unfinished batch recorded in the state,
+// and the working directory of the batch to run it in.  The flags are
+// parsed once, so that the ones which may be repeated, e.g. -exclude, are
+// not given twice.
+func resumeArgs(args []string) ([]string, string, error) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flag.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		fs.Var(&scratchFlag{value: f.DefValue, isBool: ok && b.IsBoolFlag()}, f.Name, "")
+	})
+	if err := fs.Parse(args); err != nil || fs.Lookup("resume").Value.String() != "true" {
+		// The errors are reported by parsing the flags.
+		return args, "", nil
+	}
+	path := fs.Lookup("state").Value.String()
+	if path == "" {
+		return nil, "", errors.New("-resume needs -state")
+	}
+	if fs.NArg() > 0 {
+		return nil, "", errors.New("the files of the interrupted batch are processed, no others can be given")
+	}
+	var other error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "resume" && f.Name != "state" && other == nil {
+			other = fmt.Errorf("the flags of the interrupted batch are used, -%s cannot be given", f.Name)
+		}
+	})
+	if other != nil {
+		return nil, "", other
+	}
+	db := &stateDB{files: make(map[string]stateEntry)}
+	if err := db.load(path); err != nil {
+		return nil, "", err
+	}
+	if db.run == nil {
+		return nil, "", fmt.Errorf("no interrupted batch in %s", path)
+	}
+	return append([]string{"-resume"}, db.run.Args...), db.run.Dir, nil
+}
+
+// Mark the queued files not changed since they were processed, so that they
+// are counted but not processed again.  The files of a plan are always
+// processed.  When resuming, the files processed by the interrupted batch are
+// skipped without being looked at.
+func (db *stateDB) mark(in <-chan job, resume bool) <-chan job {
+	out := make(chan job, cap(in))
+	go func() {
+		defer close(out)
+		for j := range in {
+			if j.err == nil && j.plan == nil && (resume && db.processed(j.path) || db.unchanged(j.path)) {
+				j.unchanged = true
+			}
+			out <- j
+		}
+	}()
+	return out
+}
+
+// Check whether some fixes of the file are not written, or some frames are
+// not converted for sure, e.g. to be tried with a lower threshold.
+func pending(results []*fixtag.Field) bool {
+	for _, res := range results {
+		switch res.Action {
+		case fixtag.ActionConverted, fixtag.ActionWriteFailed, fixtag.ActionFailed, fixtag.ActionAmbiguous, fixtag.ActionSkipped:
+			return true
+		}
+	}
+	return false
+}
+
+// The flags which change neither the conversion nor the files written, so
+// that the files processed before are not processed again for them.
+var stateNeutralFlags = map[string]bool{
+	"v": true, "log-level": true, "log-file": true, "log-format": true, "q": true,
+	"csv": true, "html": true, "json": true, "summary-json": true, "show-candidates": true,
+	"r": true, "files-from": true, "exclude": true, "ext": true, "config": true,
+	"state": true, "resume": true, "journal": true, "backup": true, "plan": true,
+	"j": true, "max-read-mbps": true, "max-iops": true, "nice": true, "timeout": true, "file-timeout": true,
+}
+
+// Get the short fingerprint of the options of the run: the flags set on the
+// command line or by the config file, but the ones in stateNeutralFlags, and
+// the contents of the files of the rules, the replacements, the words and
+// the models, which may be edited between the runs.
+func optionsHash() string {
+	var opts []string
+	flag.Visit(func(f *flag.Flag) {
+		if !stateNeutralFlags[f.Name] {
+			opts = append(opts, f.Name+"="+f.Value.String())
+		}
+	})
+	sort.Strings(opts)
+	h := sha256.New()
+	for _, opt := range opts {
+		fmt.Fprintf(h, "%s\x00", opt)
+	}
+	files := append([]string{rulesFile(*rulesPath), *replFile, *wordsPath}, parseList(*ngramPath)...)
+	for _, path := range files {
+		if path == "" {
+			continue
+		}
+		// The missing file fails the run anyway.
+		sum, _ := hashFile(path)
+		fmt.Fprintf(h, "%s\x00%s\x00", path, sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Get the short hash of the results of the conversion of a file: the names,
+// the original and the converted texts, and the actions of the fields.
+func resultHash(results []*fixtag.Field) string {
+	h := sha256.New()
+	for _, res := range results {
+		text := ""
+		if res.Winner != nil {
+			text = res.Winner.Text
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s\x00", res.Name(), res.Orig, text, res.Action)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
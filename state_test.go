@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+func TestStateUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	song := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(song, []byte("ID3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(dir, "state.db")
+	field := func(action string) []*fixtag.Field {
+		return []*fixtag.Field{{Key: "TIT2", Orig: "Çâåçäà", Winner: &fixtag.Candidate{Text: "Звезда"}, Action: action}}
+	}
+	for _, tt := range []struct {
+		action  string
+		options string // of the next run
+		want    bool
+	}{
+		{fixtag.ActionWritten, "a", true},
+		{fixtag.ActionWritten, "b", false},
+		{fixtag.ActionConverted, "a", false},
+		{fixtag.ActionWriteFailed, "a", false},
+		{fixtag.ActionAmbiguous, "a", false},
+		{fixtag.ActionFailed, "a", false},
+	} {
+		os.Remove(dbPath)
+		db, err := openState(dbPath, "a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.record(song, field(tt.action)); err != nil {
+			t.Fatal(err)
+		}
+		db.close()
+		// The database is compacted on the next run.
+		db, err = openState(dbPath, tt.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := db.unchanged(song); got != tt.want {
+			t.Errorf("%s, options %q: unchanged() = %v, want %v", tt.action, tt.options, got, tt.want)
+		}
+		db.close()
+	}
+}
+
+func TestResumeArgs(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "state.db")
+	db, err := openState(dbPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := []string{"-r", "-exclude", "*/Podcasts/*", "-state", dbPath, "music"}
+	if err := db.begin(batch); err != nil {
+		t.Fatal(err)
+	}
+	db.close()
+
+	args, wd, err := resumeArgs([]string{"-state", dbPath, "-resume"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]string{"-resume"}, batch...); strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Errorf("resumeArgs() = %q, want %q", args, want)
+	}
+	if wd != cwd {
+		t.Errorf("resumeArgs() directory = %q, want %q", wd, cwd)
+	}
+	for _, given := range [][]string{
+		{"-state", dbPath, "-resume", "-exclude", "*/Podcasts/*"},
+		{"-state", dbPath, "-resume", "music"},
+		{"-resume"},
+	} {
+		if args, _, err := resumeArgs(given); err == nil {
+			t.Errorf("resumeArgs(%q) = %q, want an error", given, args)
+		}
+	}
+	args = []string{"-r", "-state", dbPath, "music"}
+	if got, wd, err := resumeArgs(args); err != nil || len(got) != len(args) || wd != "" {
+		t.Errorf("resumeArgs() without -resume = %q, %q, %v", got, wd, err)
+	}
+}
+
+func TestStateOptionsFiles(t *testing.T) {
+	dir := t.TempDir()
+	song := filepath.Join(dir, "album", "song.mp3")
+	if err := os.MkdirAll(filepath.Dir(song), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(song, []byte("ID3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules := filepath.Join(dir, "rules.yaml")
+	rc := filepath.Join(dir, dirConfig)
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(rules, "- frame: TIT2\n")
+	write(rc, "t = 0.8\n")
+	defer func(old string) { *rulesPath = old }(*rulesPath)
+	*rulesPath = rules
+	dbPath := filepath.Join(dir, "state.db")
+	open := func() *stateDB {
+		t.Helper()
+		db, err := openState(dbPath, optionsHash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.dirOptions = newDirFixers(fixtag.Options{}, nil).options
+		return db
+	}
+	for _, tt := range []struct {
+		name   string
+		change func()
+		want   bool
+	}{
+		{"nothing changed", func() {}, true},
+		{"rules changed", func() { write(rules, "- frame: TALB\n") }, false},
+		{".fixtagrc changed", func() { write(rc, "t = 0.7\n") }, false},
+	} {
+		db := open()
+		if err := db.record(song, []*fixtag.Field{{Key: "TIT2", Action: fixtag.ActionWritten}}); err != nil {
+			t.Fatal(err)
+		}
+		db.close()
+		tt.change()
+		db = open()
+		if got := db.unchanged(song); got != tt.want {
+			t.Errorf("%s: unchanged() = %v, want %v", tt.name, got, tt.want)
+		}
+		db.close()
+	}
+}
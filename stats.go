@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// The totals of the run, for the summary at the end.
+type stats struct {
+	Files      int            `json:"files"`      // scanned
+	Unchanged  int            `json:"unchanged"`  // skipped as not changed since the last run, see -state
+	Modified   int            `json:"modified"`   // written, or would be in the dry-run mode
+	InPlace    int            `json:"in_place"`   // the tags written over the old ones
+	Renamed    int            `json:"renamed"`    // the names fixed, see -fix-filenames
+	Converted  map[string]int `json:"converted"`  // the frames by the chain
+	Untranslit int            `json:"untranslit"` // the frames transliterated back, see -untranslit
+	Correct    int            `json:"correct"`    // the frames which need no conversion
+	Ambiguous  int            `json:"ambiguous"`
+	Failed     int            `json:"failed"` // the frames which could not be converted
+	Lost       int            `json:"lost"`   // the frames with the text lost, see fixtag.ActionLost
+	Errors     int            `json:"errors"` // the files which could not be read or written
+	// The files failed and the files with the frames not converted, by the
+	// kind of the error, see errorKind.
+	Kinds map[string]int `json:"error_kinds,omitempty"`
+	// The frames with the text lost by the names of the files, which need
+	// an external lookup.
+	LostFrames map[string][]string `json:"lost_frames,omitempty"`
+	// The names of the files by the kind of the error, for the summary.
+	failures map[string][]string
+}
+
+func newStats() *stats {
+	return &stats{Converted: make(map[string]int), Kinds: make(map[string]int), LostFrames: make(map[string][]string), failures: make(map[string][]string)}
+}
+
+// The kind of the errors which are none of the known ones.
+const otherErrors = "other errors"
+
+// Get the kind of the error of a file for the summary, e.g. "cannot open".
+func errorKind(err error) string {
+	var fe *fixtag.Error
+	switch {
+	case errors.As(err, &fe):
+		return fe.Kind.Error()
+	case errors.Is(err, errTimeout):
+		return errTimeout.Error()
+	case errors.As(err, new(*fs.PathError)):
+		// E.g. the file not found.
+		return fixtag.ErrOpen.Error()
+	}
+	return otherErrors
+}
+
+// Count the file with the error of the kind.
+func (s *stats) fail(name, kind string) {
+	s.Kinds[kind]++
+	s.failures[kind] = append(s.failures[kind], name)
+}
+
+// Add the results of a single file.  The result is nil if the file was processed
+// according to the plan, or could not be read.
+func (s *stats) add(name string, write bool, res *fixtag.Result, results []*fixtag.Field, err error) {
+	s.Files++
+	if res != nil {
+		s.Correct += res.Correct
+		// Every kind of the errors of the frames is counted once for the file.
+		kinds := make(map[string]bool)
+		for _, ferr := range res.Errors() {
+			kinds[errorKind(ferr)] = true
+		}
+		for _, kind := range []error{fixtag.ErrConvert, fixtag.ErrAmbiguous} {
+			if kinds[kind.Error()] {
+				s.fail(name, kind.Error())
+			}
+		}
+	}
+	modified, failed := false, err != nil
+	for _, f := range results {
+		switch f.Action {
+		case fixtag.ActionWritten:
+			modified = true
+			s.convert(f)
+		case fixtag.ActionConverted:
+			if !write {
+				modified = true
+				s.convert(f)
+			}
+		case fixtag.ActionWriteFailed:
+			failed = true
+		case fixtag.ActionAmbiguous:
+			s.Ambiguous++
+		case fixtag.ActionFailed:
+			s.Failed++
+		case fixtag.ActionLost:
+			s.Lost++
+			s.LostFrames[name] = append(s.LostFrames[name], f.Name())
+		}
+	}
+	if modified {
+		s.Modified++
+	}
+	if failed {
+		s.Errors++
+		kind := fixtag.ErrWrite.Error()
+		switch {
+		case err != nil:
+			kind = errorKind(err)
+		case errors.Is(writeErr(results), fixtag.ErrStale):
+			// The plan is to be made again rather than the file fixed.
+			kind = fixtag.ErrStale.Error()
+		}
+		s.fail(name, kind)
+	}
+}
+
+// Get the error of writing the fields, if any, see fixtag.Field.Err.
+func writeErr(results []*fixtag.Field) error {
+	for _, f := range results {
+		if f.Action == fixtag.ActionWriteFailed && f.Err != nil {
+			return f.Err
+		}
+	}
+	return nil
+}
+
+// Count the converted field, the ones transliterated back separately.
+func (s *stats) convert(f *fixtag.Field) {
+	if f.Winner.Chain == fixtag.ChainUntranslit {
+		s.Untranslit++
+		return
+	}
+	s.Converted[f.Winner.Chain]++
+}
+
+// Get the exit code for the totals.
+func (s *stats) status() int {
+	switch {
+	case s.Errors > 0:
+		return exitFailed
+	case s.Modified > 0 || s.Renamed > 0:
+		return exitFixed
+	}
+	return exitClean
+}
+
+// Print the summary in the human readable form.
+func (s *stats) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "summary:\n")
+	fmt.Fprintf(tw, " files scanned:\t%d\n", s.Files)
+	if s.Unchanged > 0 {
+		fmt.Fprintf(tw, " files unchanged since the last run:\t%d\n", s.Unchanged)
+	}
+	fmt.Fprintf(tw, " files modified:\t%d\n", s.Modified)
+	if s.InPlace > 0 {
+		fmt.Fprintf(tw, " tags rewritten in place:\t%d\n", s.InPlace)
+	}
+	if s.Renamed > 0 {
+		fmt.Fprintf(tw, " names fixed:\t%d\n", s.Renamed)
+	}
+	total := 0
+	chains := make([]string, 0, len(s.Converted))
+	for chain, n := range s.Converted {
+		chains = append(chains, chain)
+		total += n
+	}
+	sort.Strings(chains)
+	fmt.Fprintf(tw, " frames converted:\t%d\n", total)
+	for _, chain := range chains {
+		fmt.Fprintf(tw, "  %s:\t%d\n", chain, s.Converted[chain])
+	}
+	if s.Untranslit > 0 {
+		fmt.Fprintf(tw, " frames transliterated back:\t%d\n", s.Untranslit)
+	}
+	fmt.Fprintf(tw, " frames already correct:\t%d\n", s.Correct)
+	fmt.Fprintf(tw, " frames ambiguous:\t%d\n", s.Ambiguous)
+	fmt.Fprintf(tw, " frames not converted:\t%d\n", s.Failed)
+	if s.Lost > 0 {
+		fmt.Fprintf(tw, " frames with the data lost:\t%d\n", s.Lost)
+	}
+	fmt.Fprintf(tw, " errors:\t%d\n", s.Errors)
+	tw.Flush()
+	s.printFailures(w)
+	s.printLost(w)
+}
+
+// Print the frames with the text lost, which no conversion recovers, by
+// the file: they need an external lookup, e.g. with -acoustid-key.
+func (s *stats) printLost(w io.Writer) {
+	if len(s.LostFrames) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.LostFrames))
+	for name := range s.LostFrames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "data lost, needs external lookup:\n")
+	for _, name := range names {
+		fmt.Fprintf(w, " %s: %s\n", name, strings.Join(s.LostFrames[name], ", "))
+	}
+}
+
+// The number of the files listed for every kind of the errors.
+const failuresListed = 5
+
+// Print the files failed and the files with the frames not converted, by
+// the kind of the error, the first few of every kind.
+func (s *stats) printFailures(w io.Writer) {
+	if len(s.failures) == 0 {
+		return
+	}
+	kinds := make([]string, 0, len(s.failures))
+	for kind := range s.failures {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "errors by kind:\n")
+	for _, kind := range kinds {
+		names := s.failures[kind]
+		sort.Strings(names)
+		list := names
+		if len(list) > failuresListed {
+			list = list[:failuresListed]
+		}
+		fmt.Fprintf(w, " %s (%d): %s", kind, len(names), strings.Join(list, ", "))
+		if len(names) > len(list) {
+			fmt.Fprintf(w, " and %d more", len(names)-len(list))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// Save the summary in JSON, to stdout if the path is "-".
+func (s *stats) save(path string) error {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	return os.WriteFile(path, out, 0644)
+}
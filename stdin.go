@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Copy the MP3 from stdin into a temporary file, to be processed like the
+// others and written to stdout then, see -stdin.
+func readStdin() (string, error) {
+	dir, err := os.MkdirTemp("", "fix-mp3-tag-")
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "stdin.mp3")
+	f, err := os.Create(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	_, err = io.Copy(f, os.Stdin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return path, nil
+}
+
+// Write the processed file to stdout, and remove it.
+func writeStdout(path string) error {
+	defer os.RemoveAll(filepath.Dir(path))
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
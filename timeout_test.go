@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+// A throttle blocking the reads and the writes once armed until released,
+// like a dead network mount.
+type blockingThrottle struct {
+	armed   atomic.Bool
+	release chan struct{}
+}
+
+func (b *blockingThrottle) Wait(int64) {
+	if b.armed.Load() {
+		<-b.release
+	}
+}
+
+func TestWithTimeoutWrites(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		block bool   // the writing of the file, or the processing after it
+		want  string // the title once the processing ends
+	}{
+		{"blocked before writing", true, "Çâåçäà"},
+		{"blocked after writing", false, "Звезда"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.mp3")
+			writeTitleMP3(t, path, "Çâåçäà")
+			thr := &blockingThrottle{release: make(chan struct{})}
+			fixer, err := fixtag.New(fixtag.Options{Throttle: thr})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg := &config{ctx: context.Background(), fileTimeout: 50 * time.Millisecond, fixer: fixer, write: true}
+			finished := make(chan struct{})
+			timer := time.AfterFunc(200*time.Millisecond, func() { close(thr.release) })
+			defer timer.Stop()
+			err = cfg.withTimeout(func(ctx context.Context) error {
+				defer close(finished)
+				res, err := fixer.WithContext(ctx).Plan(path)
+				if err != nil {
+					return err
+				}
+				thr.armed.Store(tt.block)
+				if _, err := processFile(ctx, newLogger(slog.LevelInfo, logPlain, path), cfg, res); err != nil {
+					return err
+				}
+				if !tt.block {
+					<-thr.release
+				}
+				return nil
+			})
+			if tt.block != errors.Is(err, errTimeout) {
+				t.Errorf("withTimeout() error = %v", err)
+			}
+			<-finished
+			if tf := readTitleFrame(t, path); tf.Text != tt.want {
+				t.Errorf("the title = %q, want %q", tf.Text, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A list of directories given with a repeated flag.
+type dirList []string
+
+func (d *dirList) String() string {
+	return strings.Join(*d, ",")
+}
+
+func (d *dirList) Set(value string) error {
+	*d = append(*d, value)
+	return nil
+}
+
+// The state of the watch mode: the size and the modification time of every
+// processed file, so that the file is not processed again unless it changes,
+// e.g. after a restart or being written by the program itself.
+type watchState struct {
+	mu    sync.Mutex
+	path  string               // the file of the state, empty if it is not saved
+	Files map[string]fileStamp `json:"files"`
+}
+
+type fileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// Load the state from the file, the missing file is an empty state.
+func loadState(path string) (*watchState, error) {
+	s := &watchState{path: path, Files: make(map[string]fileStamp)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]fileStamp)
+	}
+	return s, nil
+}
+
+// Get the key and the stamp of the file.
+func stampOf(path string) (string, fileStamp, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fileStamp{}, err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", fileStamp{}, err
+	}
+	return abs, fileStamp{Size: st.Size(), ModTime: st.ModTime().UTC()}, nil
+}
+
+// Check whether the file is new or modified since it was processed.
+func (s *watchState) changed(path string) bool {
+	key, stamp, err := stampOf(path)
+	if err != nil {
+		// Let the processing report the error.
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.Files[key]
+	return !ok || old.Size != stamp.Size || !old.ModTime.Equal(stamp.ModTime)
+}
+
+// Record the file as processed, and save the state.
+func (s *watchState) done(path string) error {
+	key, stamp, err := stampOf(path)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Files[key] = stamp
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Replace the file atomically, so that it is never half written.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// The watcher queues the new and modified files of the watched directories
+// and their subdirectories, once the files are not written for the delay.
+type watcher struct {
+	src     *fileSource
+	state   *watchState
+	delay   time.Duration
+	fsw     *fsnotify.Watcher
+	pending map[string]time.Time // the files to queue by the time of the last change
+}
+
+func newWatcher(src *fileSource, state *watchState, delay time.Duration) (*watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{src: src, state: state, delay: delay, fsw: fsw, pending: make(map[string]time.Time)}, nil
+}
+
+// Watch the directory and its subdirectories, the files already there
+// are queued unless they were processed before.
+func (w *watcher) add(root string, queue chan<- job) {
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			queue <- job{path: path, err: err}
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if w.src.excluded(path) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := w.fsw.Add(path); err != nil {
+				queue <- job{path: path, err: err}
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if hasExtension(path, w.src.extensions) {
+			w.pending[path] = time.Time{}
+		}
+		return nil
+	})
+}
+
+// The shortest interval of checking the pending files, whatever the delay.
+const minWatchTick = 10 * time.Millisecond
+
+// Watch the directories until the context is done, then close the queue.
+func (w *watcher) run(ctx context.Context, dirs []string, queue chan<- job) {
+	defer close(queue)
+	defer w.fsw.Close()
+	for _, dir := range dirs {
+		w.add(dir, queue)
+	}
+	interval := w.delay / 4
+	if interval < minWatchTick {
+		interval = minWatchTick
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "watch: failed: %v\n", err)
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.event(ev, queue)
+		case now := <-tick.C:
+			for path, t := range w.pending {
+				if now.Sub(t) < w.delay {
+					continue
+				}
+				delete(w.pending, path)
+				if w.state.changed(path) {
+					queue <- job{path: path}
+				}
+			}
+		}
+	}
+}
+
+// Handle the change of the file or the directory.
+func (w *watcher) event(ev fsnotify.Event, queue chan<- job) {
+	path := ev.Name
+	if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+		// The new name comes with its own event.
+		delete(w.pending, path)
+		return
+	}
+	if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) || w.src.excluded(path) {
+		return
+	}
+	st, err := os.Lstat(path)
+	if err != nil {
+		return
+	}
+	switch {
+	case st.IsDir() && ev.Has(fsnotify.Create):
+		// A new or moved directory may have the files already.
+		w.add(path, queue)
+	case st.Mode().IsRegular() && hasExtension(path, w.src.extensions):
+		w.pending[path] = time.Now()
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bukind/fix-mp3-tag/pkg/fixtag"
+)
+
+func TestWatchStateDone(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name  string
+		write bool
+		data  string // of the file, a tag with the title in garbage if empty
+		done  bool
+	}{
+		{"written", true, "", true},
+		{"not written", false, "", false},
+		{"broken", true, "ID3\x04\x00\x00\xff\xff\xff\xff", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".mp3")
+			if tt.data == "" {
+				writeTitleMP3(t, path, "Çâåçäà")
+			} else if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			fixer, err := fixtag.New(fixtag.Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			state := &watchState{Files: make(map[string]fileStamp)}
+			cfg := &config{ctx: context.Background(), fixer: fixer, dirs: newDirFixers(fixtag.Options{}, fixer), write: tt.write, state: state}
+			queue := make(chan job, 1)
+			queue <- job{path: path}
+			close(queue)
+			processFiles(cfg, 1, queue, io.Discard, nil, nil)
+			if got := !state.changed(path); got != tt.done {
+				t.Errorf("the file is recorded as done: %v, want %v", got, tt.done)
+			}
+		})
+	}
+}